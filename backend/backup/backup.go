@@ -0,0 +1,425 @@
+// Package backup dumps all tables of the database to the archive and
+// restores the archive to any backend. The archive is JSON lines: the
+// header, the records of the tables ordered by id, owners before the owned
+// records, the history of the tables, see ifaces.Revision, and the trailer
+// counting the records and the revisions, so the truncated archive is
+// detected:
+//
+//	{"format":"mesap-backup","version":2,"schema":2,"created":"..."}
+//	{"table":"users","record":{...}}
+//	...
+//	{"table":"users","revision":{...}}
+//	...
+//	{"end":true,"records":42,"revisions":84}
+//
+// Archives of version 1 have no history.
+//
+// Records of the encrypted database are archived sealed, as stored, and
+// restored so to the encrypted database only, see Header.Sealed.
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+const (
+	Format = "mesap-backup"
+	// Version of the archive format
+	Version = 2
+
+	ContentType = "application/x-ndjson"
+
+	// pageSize is the number of the records read by the query at once
+	pageSize = 500
+)
+
+var (
+	ErrBadArchive = errors.New("Bad backup archive!")
+	ErrNotEmpty   = errors.New("Database is not empty!")
+	ErrNotSealed  = errors.New("Database is not encrypted!")
+)
+
+// Header is the first line of the archive.
+type Header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Schema is the version of the data schema, see ifaces.Tx.SchemaVersion
+	Schema  int       `json:"schema"`
+	Created time.Time `json:"created"`
+	// Sealed is set if the archive has the records of the encrypted
+	// database sealed
+	Sealed bool `json:"sealed,omitempty"`
+}
+
+// sealer is implemented by the databases encrypting the records, see
+// encrypted_database.EncryptedDatabase.
+type sealer interface {
+	// Sealed returns the database of the sealed records
+	Sealed() ifaces.Database
+}
+
+// unwrapper is implemented by the databases wrapping the other ones.
+type unwrapper interface {
+	Unwrap() ifaces.Database
+}
+
+// sealed returns the database of the sealed records of db, false if db is
+// not encrypted.
+func sealed(db ifaces.Database) (ifaces.Database, bool) {
+	for {
+		if s, ok := db.(sealer); ok {
+			return s.Sealed(), true
+		}
+		wrapper, ok := db.(unwrapper)
+		if !ok {
+			return nil, false
+		}
+		db = wrapper.Unwrap()
+	}
+}
+
+// line is the record or the revision of the table, or the trailer.
+type line struct {
+	Table     string          `json:"table,omitempty"`
+	Record    json.RawMessage `json:"record,omitempty"`
+	Revision  json.RawMessage `json:"revision,omitempty"`
+	End       bool            `json:"end,omitempty"`
+	Records   *int            `json:"records,omitempty"`
+	Revisions *int            `json:"revisions,omitempty"`
+}
+
+// Report describes the dumped or restored archive.
+type Report struct {
+	Header
+	// Records are the numbers of the records by table
+	Records map[string]int
+	// Revisions are the numbers of the revisions by table
+	Revisions map[string]int
+}
+
+func newReport() Report {
+	return Report{Records: make(map[string]int), Revisions: make(map[string]int)}
+}
+
+func sum(counts map[string]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
+
+// Total returns the number of the records of all tables.
+func (r Report) Total() int {
+	return sum(r.Records)
+}
+
+// TotalRevisions returns the number of the revisions of all tables.
+func (r Report) TotalRevisions() int {
+	return sum(r.Revisions)
+}
+
+func (r Report) String() string {
+	var counts []string
+	for _, table := range tables {
+		counts = append(counts, fmt.Sprintf("%s %d", table.name, r.Records[table.name]))
+	}
+	return fmt.Sprintf("Backup of schema version %d created at %s, %d records: %s; %d revisions",
+		r.Schema, r.Created.Format(time.RFC3339), r.Total(), strings.Join(counts, ", "), r.TotalRevisions())
+}
+
+// table dumps and restores the table of the model.
+type table struct {
+	name string
+	// dump writes the records ordered by id
+	dump func(ctx context.Context, tx ifaces.Tx, write func(record any) error) error
+	// history writes the revisions ordered by id and version
+	history func(ctx context.Context, tx ifaces.Tx, write func(revision any) error) error
+	// importer returns importer of the table. Returns ErrNotEmpty if the
+	// table has records.
+	importer func(ctx context.Context, tx ifaces.Tx) (importer, error)
+}
+
+// importer imports the lines of the table.
+type importer struct {
+	// record imports the record, see ifaces.Table.Import
+	record func(data json.RawMessage) error
+	// revision imports the revision after the records, so it replaces the
+	// revision written by the import, see ifaces.Table.ImportHistory
+	revision func(data json.RawMessage) error
+}
+
+func newTable[M ifaces.Models](name string, open func(ifaces.Tx) (ifaces.Table[M], error)) table {
+	return table{
+		name: name,
+		dump: func(ctx context.Context, tx ifaces.Tx, write func(record any) error) error {
+			T, err := open(tx)
+			if err != nil {
+				return err
+			}
+			query := ifaces.NewQuery().Limit(pageSize)
+			for {
+				page, err := T.QueryContext(ctx, query)
+				if err != nil {
+					return err
+				}
+				for _, record := range page.Records {
+					if err := write(record); err != nil {
+						return err
+					}
+				}
+				if page.Next == "" {
+					return nil
+				}
+				query.After(page.Next)
+			}
+		},
+		history: func(ctx context.Context, tx ifaces.Tx, write func(revision any) error) error {
+			T, err := open(tx)
+			if err != nil {
+				return err
+			}
+			var writeErr error
+			err = T.RevisionsContext(ctx, func(revision ifaces.Revision[M]) bool {
+				writeErr = write(revision)
+				return writeErr == nil
+			})
+			if writeErr != nil {
+				return writeErr
+			}
+			return err
+		},
+		importer: func(ctx context.Context, tx ifaces.Tx) (importer, error) {
+			T, err := open(tx)
+			if err != nil {
+				return importer{}, err
+			}
+			err = T.EachContext(ctx, func(M) bool { return false })
+			if err == nil {
+				return importer{}, fmt.Errorf("%w Table '%s' has records", ErrNotEmpty, name)
+			}
+			if !errors.Is(err, ifaces.ErrEmptyTable) {
+				return importer{}, err
+			}
+
+			return importer{
+				record: func(data json.RawMessage) error {
+					var record M
+					if err := json.Unmarshal(data, &record); err != nil {
+						return fmt.Errorf("%w Table '%s': %s", ErrBadArchive, name, err)
+					}
+					if err := T.ImportContext(ctx, record); err != nil {
+						var i interface{} = &record
+						return fmt.Errorf("Table '%s', record %d: %w", name, i.(ifaces.Id).GetId(), err)
+					}
+					return nil
+				},
+				revision: func(data json.RawMessage) error {
+					var revision ifaces.Revision[M]
+					if err := json.Unmarshal(data, &revision); err != nil {
+						return fmt.Errorf("%w Table '%s': %s", ErrBadArchive, name, err)
+					}
+					if err := T.ImportHistoryContext(ctx, []ifaces.Revision[M]{revision}); err != nil {
+						return fmt.Errorf("Table '%s', revision %d.%d: %w", name, revision.Id, revision.Version, err)
+					}
+					return nil
+				},
+			}, nil
+		},
+	}
+}
+
+// tables of the archive in order of the restore: owners before the owned
+// records.
+var tables = []table{
+	newTable[models.User]("users", ifaces.Tx.Users),
+	newTable[models.Role]("roles", ifaces.Tx.Roles),
+	newTable[models.People]("peoples", ifaces.Tx.Peoples),
+	newTable[models.Phone]("phones", ifaces.Tx.Phones),
+	newTable[models.Address]("addresses", ifaces.Tx.Addresses),
+	newTable[models.Email]("emails", ifaces.Tx.Emails),
+	newTable[models.BankAccount]("bank_accounts", ifaces.Tx.BankAccounts),
+}
+
+// Dump writes archive of all tables to w. The tables are read in the
+// single transaction, so the archive is consistent at the point in time.
+// The archive is spooled to the temporary file and copied to w after the
+// transaction is finished, so the slow reader does not hold the database.
+func Dump(ctx context.Context, db ifaces.Database, w io.Writer) (Report, error) {
+	spool, err := os.CreateTemp("", "mesap-backup-*")
+	if err != nil {
+		return Report{}, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	report, err := dumpTx(ctx, db, spool)
+	if err != nil {
+		return report, err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return report, err
+	}
+	_, err = io.Copy(w, spool)
+	return report, err
+}
+
+// dumpTx writes archive of all tables to w in the single transaction.
+func dumpTx(ctx context.Context, db ifaces.Database, w io.Writer) (Report, error) {
+	report := newReport()
+
+	storage, isSealed := sealed(db)
+	if isSealed {
+		db = storage
+	}
+
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+
+	err := db.Tx(ifaces.WithSnapshot(ctx), func(tx ifaces.Tx) error {
+		schema, err := tx.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		report.Header = Header{Format: Format, Version: Version, Schema: schema, Created: time.Now().UTC(), Sealed: isSealed}
+		if err := enc.Encode(report.Header); err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			err := table.dump(ctx, tx, func(record any) error {
+				data, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				report.Records[table.name]++
+				return enc.Encode(line{Table: table.name, Record: data})
+			})
+			if err != nil {
+				return fmt.Errorf("Table '%s': %w", table.name, err)
+			}
+		}
+
+		for _, table := range tables {
+			err := table.history(ctx, tx, func(revision any) error {
+				data, err := json.Marshal(revision)
+				if err != nil {
+					return err
+				}
+				report.Revisions[table.name]++
+				return enc.Encode(line{Table: table.name, Revision: data})
+			})
+			if err != nil {
+				return fmt.Errorf("Table '%s' history: %w", table.name, err)
+			}
+		}
+
+		records, revisions := report.Total(), report.TotalRevisions()
+		return enc.Encode(line{End: true, Records: &records, Revisions: &revisions})
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, out.Flush()
+}
+
+// Restore loads the archive to the empty database in the single
+// transaction, so the database is left empty if the archive is broken.
+// Records keep their ids, versions and history; the database gets the
+// schema version of the archive, so the older archive is migrated as
+// usual. Sealed archive is restored to the database of the sealed records
+// of db; returns ErrNotSealed if db is not encrypted. The others are
+// restored through the encryption of db, if any.
+func Restore(ctx context.Context, db ifaces.Database, r io.Reader) (Report, error) {
+	report := newReport()
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	if err := dec.Decode(&report.Header); err != nil {
+		return report, fmt.Errorf("%w Header: %s", ErrBadArchive, err)
+	}
+	if report.Format != Format {
+		return report, fmt.Errorf("%w Format: '%s'", ErrBadArchive, report.Format)
+	}
+	if report.Version < 1 || report.Version > Version {
+		return report, fmt.Errorf("%w Unsupported version: %d", ErrBadArchive, report.Version)
+	}
+	if report.Sealed {
+		storage, ok := sealed(db)
+		if !ok {
+			return report, fmt.Errorf("%w Archive is sealed", ErrNotSealed)
+		}
+		db = storage
+	}
+
+	err := db.Tx(ctx, func(tx ifaces.Tx) error {
+		importers := make(map[string]importer)
+		for _, table := range tables {
+			importer, err := table.importer(ctx, tx)
+			if err != nil {
+				return err
+			}
+			importers[table.name] = importer
+		}
+
+		for {
+			var l line
+			if err := dec.Decode(&l); errors.Is(err, io.EOF) {
+				return fmt.Errorf("%w Archive is truncated", ErrBadArchive)
+			} else if err != nil {
+				return fmt.Errorf("%w %s", ErrBadArchive, err)
+			}
+
+			if l.End {
+				if l.Records == nil || *l.Records != report.Total() {
+					return fmt.Errorf("%w Archive has %d records, trailer: %v", ErrBadArchive, report.Total(), l.Records)
+				}
+				// Archives of version 1 have no history
+				if revisions := report.TotalRevisions(); l.Revisions == nil && revisions > 0 ||
+					l.Revisions != nil && *l.Revisions != revisions {
+					return fmt.Errorf("%w Archive has %d revisions, trailer: %v", ErrBadArchive, revisions, l.Revisions)
+				}
+				break
+			}
+
+			importer, ok := importers[l.Table]
+			if !ok {
+				return fmt.Errorf("%w Unknown table: '%s'", ErrBadArchive, l.Table)
+			}
+			if l.Revision != nil {
+				if err := importer.revision(l.Revision); err != nil {
+					return err
+				}
+				report.Revisions[l.Table]++
+				continue
+			}
+			if err := importer.record(l.Record); err != nil {
+				return err
+			}
+			report.Records[l.Table]++
+		}
+
+		if err := dec.Decode(&line{}); !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w Data after the trailer", ErrBadArchive)
+		}
+
+		return tx.SetSchemaVersion(ctx, report.Schema)
+	})
+	if err != nil {
+		report.Records = make(map[string]int)
+		report.Revisions = make(map[string]int)
+	}
+	return report, err
+}
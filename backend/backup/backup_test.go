@@ -0,0 +1,351 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// fixture fills the database, returns the records by table.
+func fixture(t *testing.T, db ifaces.Database) map[string][]any {
+	t.Helper()
+
+	ctx := context.Background()
+	ret := make(map[string][]any)
+
+	err := db.Tx(ctx, func(tx ifaces.Tx) error {
+		if err := tx.SetSchemaVersion(ctx, 2); err != nil {
+			return err
+		}
+
+		users, _ := tx.Users()
+		roles, _ := tx.Roles()
+		peoples, _ := tx.Peoples()
+		phones, _ := tx.Phones()
+
+		for _, login := range []string{"alice", "bob"} {
+			user := models.User{Login: login, Salt: "salt", Verifier: "verifier"}
+			id, err := users.InsertContext(ctx, user)
+			if err != nil {
+				return err
+			}
+			user.SetId(id)
+			user.SetVersion(models.FIRST_VERSION)
+			ret["users"] = append(ret["users"], user)
+		}
+
+		role := models.Role{Name: "admin"}
+		id, err := roles.InsertContext(ctx, role)
+		if err != nil {
+			return err
+		}
+		// Deleted records are not archived
+		if err := roles.DeleteContext(ctx, id); err != nil {
+			return err
+		}
+
+		people := models.People{Name: "John"}
+		id, err = peoples.InsertContext(ctx, people)
+		if err != nil {
+			return err
+		}
+		people.SetId(id)
+		people.SetVersion(models.FIRST_VERSION)
+		// Updated record keeps the version
+		people.Surname = "Doe"
+		if err := peoples.UpdateContext(ctx, people); err != nil {
+			return err
+		}
+		people.SetVersion(models.FIRST_VERSION + 1)
+		ret["peoples"] = append(ret["peoples"], people)
+
+		phone := models.Phone{Phone: "2"}
+		phone.SetOwner(id)
+		id, err = phones.InsertContext(ctx, phone)
+		if err != nil {
+			return err
+		}
+		phone.SetId(id)
+		phone.SetVersion(models.FIRST_VERSION)
+		ret["phones"] = append(ret["phones"], phone)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Fixture error: %s", err)
+	}
+	return ret
+}
+
+func dump(t *testing.T, db ifaces.Database) []byte {
+	t.Helper()
+
+	var archive bytes.Buffer
+	if _, err := Dump(context.Background(), db, &archive); err != nil {
+		t.Fatalf("Dump error: %s", err)
+	}
+	return archive.Bytes()
+}
+
+func expectTable[M ifaces.Models](t *testing.T, table ifaces.Table[M], expected []any) {
+	t.Helper()
+
+	var records []any
+	page, err := table.Query(ifaces.NewQuery())
+	if err != nil {
+		t.Fatalf("Query error: %s", err)
+	}
+	for _, record := range page.Records {
+		records = append(records, record)
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("Records differ:\n got: %+v\nwant: %+v", records, expected)
+	}
+}
+
+func revisions[M ifaces.Models](t *testing.T, table ifaces.Table[M]) []ifaces.Revision[M] {
+	t.Helper()
+
+	var ret []ifaces.Revision[M]
+	err := table.Revisions(func(revision ifaces.Revision[M]) bool {
+		ret = append(ret, revision)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Revisions error: %s", err)
+	}
+	return ret
+}
+
+func TestDumpRestore(t *testing.T) {
+	ctx := context.Background()
+	source := fake_database.NewDatabase()
+	expected := fixture(t, source)
+
+	archive := dump(t, source)
+	lines := strings.Split(strings.TrimSpace(string(archive)), "\n")
+	if len(lines) != 13 || !strings.HasPrefix(lines[0], `{"format":"mesap-backup","version":2,"schema":2,`) ||
+		lines[12] != `{"end":true,"records":4,"revisions":7}` {
+		t.Fatalf("Unexpected archive:\n%s", archive)
+	}
+
+	target := fake_database.NewDatabase()
+	report, err := Restore(ctx, target, bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Restore error: %s", err)
+	}
+	if report.Schema != 2 || report.Total() != 4 || report.Records["users"] != 2 || report.TotalRevisions() != 7 {
+		t.Fatalf("Unexpected report: %s", report)
+	}
+
+	users, _ := target.Users()
+	expectTable(t, users, expected["users"])
+	peoples, _ := target.Peoples()
+	expectTable(t, peoples, expected["peoples"])
+	phones, _ := target.Phones()
+	expectTable(t, phones, expected["phones"])
+	roles, _ := target.Roles()
+	expectTable(t, roles, nil)
+
+	// History is restored with the times and the deleted records
+	sourcePeoples, _ := source.Peoples()
+	if history := revisions(t, peoples); !reflect.DeepEqual(history, revisions(t, sourcePeoples)) {
+		t.Fatalf("History differs: %+v", history)
+	}
+	sourceRoles, _ := source.Roles()
+	if history := revisions(t, roles); !reflect.DeepEqual(history, revisions(t, sourceRoles)) {
+		t.Fatalf("History differs: %+v", history)
+	}
+	people := expected["peoples"][0].(models.People)
+	history, err := peoples.History(people.GetId())
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Unexpected history: %+v, error: %v", history, err)
+	}
+	if first, err := peoples.At(people.GetId(), history[0].Time); err != nil || first.Surname != "" || first.GetVersion() != models.FIRST_VERSION {
+		t.Fatalf("Unexpected state: %+v, error: %v", first, err)
+	}
+
+	err = target.Tx(ctx, func(tx ifaces.Tx) error {
+		schema, err := tx.SchemaVersion(ctx)
+		if err == nil && schema != 2 {
+			t.Errorf("Unexpected schema version: %d", schema)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Tx error: %s", err)
+	}
+
+	// Restored database makes the same archive
+	if again := dump(t, target); !bytes.Equal(again[bytes.IndexByte(again, '\n'):], archive[bytes.IndexByte(archive, '\n'):]) {
+		t.Fatalf("Archives differ:\n%s\n%s", again, archive)
+	}
+
+	// Deleted record is restorable
+	deleted := revisions(t, roles)[0].Id
+	if err := roles.Restore(deleted); err != nil {
+		t.Fatalf("Restore error: %s", err)
+	}
+	if err := roles.Delete(deleted); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+
+	// Database is restored only once
+	_, err = Restore(ctx, target, bytes.NewReader(archive))
+	if !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// Archive of version 1 has no history
+func TestRestoreVersion1(t *testing.T) {
+	source := fake_database.NewDatabase()
+	fixture(t, source)
+	lines := strings.SplitAfter(string(dump(t, source)), "\n")
+	archive := strings.Replace(lines[0], `"version":2`, `"version":1`, 1) + strings.Join(lines[1:5], "") + `{"end":true,"records":4}`
+
+	target := fake_database.NewDatabase()
+	report, err := Restore(context.Background(), target, strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Restore error: %s", err)
+	}
+	if report.Version != 1 || report.Total() != 4 || report.TotalRevisions() != 0 {
+		t.Fatalf("Unexpected report: %s", report)
+	}
+	// Imported records get their first revisions
+	peoples, _ := target.Peoples()
+	if history := revisions(t, peoples); len(history) != 1 || history[0].Version != models.FIRST_VERSION+1 {
+		t.Fatalf("Unexpected history: %+v", history)
+	}
+}
+
+// lockedWriter fails if the database is locked while the archive is
+// written.
+type lockedWriter struct {
+	t  *testing.T
+	db ifaces.Database
+	bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	done := make(chan error, 1)
+	go func() {
+		roles, _ := w.db.Roles()
+		_, err := roles.Insert(models.Role{Name: fmt.Sprintf("role %d", w.Len())})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			w.t.Errorf("Insert error: %s", err)
+		}
+	case <-time.After(time.Second):
+		w.t.Errorf("Database is locked while the archive is written")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestDumpUnlocked(t *testing.T) {
+	db := fake_database.NewDatabase()
+	fixture(t, db)
+	// Archive exceeds the write buffers
+	roles, _ := db.Roles()
+	for i := 0; i < 100; i++ {
+		if _, err := roles.Insert(models.Role{Name: fmt.Sprintf("%0100d", i)}); err != nil {
+			t.Fatalf("Insert error: %s", err)
+		}
+	}
+
+	w := &lockedWriter{t: t, db: db}
+	report, err := Dump(context.Background(), db, w)
+	if err != nil {
+		t.Fatalf("Dump error: %s", err)
+	}
+	if report.Total() != 104 || !bytes.HasSuffix(w.Bytes(), []byte(`{"end":true,"records":104,"revisions":107}`+"\n")) {
+		t.Fatalf("Unexpected archive:\n%s", w.Bytes())
+	}
+}
+
+func TestBadArchive(t *testing.T) {
+	ctx := context.Background()
+	archive := dump(t, func() ifaces.Database {
+		db := fake_database.NewDatabase()
+		fixture(t, db)
+		return db
+	}())
+	lines := strings.SplitAfter(string(archive), "\n")
+
+	for name, broken := range map[string]string{
+		"empty":     "",
+		"format":    `{"format":"other","version":1}`,
+		"version":   `{"format":"mesap-backup","version":3}`,
+		"truncated": strings.Join(lines[:4], ""),
+		"no record": strings.Join(append(append([]string{}, lines[:2]...), lines[3:]...), ""),
+		"history":   strings.Join(append(append([]string{}, lines[:5]...), lines[6:]...), ""),
+		"table":     lines[0] + `{"table":"missing","record":{}}` + "\n" + `{"end":true,"records":1}`,
+		"tail":      string(archive) + lines[1],
+	} {
+		db := fake_database.NewDatabase()
+		_, err := Restore(ctx, db, strings.NewReader(broken))
+		if !errors.Is(err, ErrBadArchive) {
+			t.Fatalf("Archive '%s': unexpected error: %v", name, err)
+		}
+		users, _ := db.Users()
+		expectTable(t, users, nil)
+	}
+}
+
+// sealingDatabase stands for the encrypted database: its records are
+// opened by the transactions, the sealed ones are in the wrapped database.
+type sealingDatabase struct {
+	ifaces.Database
+}
+
+func (d sealingDatabase) Sealed() ifaces.Database {
+	return d.Database
+}
+
+func (d sealingDatabase) Tx(ctx context.Context, callback func(tx ifaces.Tx) error) error {
+	return errors.New("Records are opened")
+}
+
+// wrappingDatabase stands for the databases wrapping the encrypted one.
+type wrappingDatabase struct {
+	ifaces.Database
+}
+
+func (d wrappingDatabase) Unwrap() ifaces.Database {
+	return d.Database
+}
+
+func TestDumpRestoreSealed(t *testing.T) {
+	ctx := context.Background()
+	source := fake_database.NewDatabase()
+	expected := fixture(t, source)
+
+	// Records are read and written sealed
+	archive := dump(t, wrappingDatabase{sealingDatabase{source}})
+	if !bytes.Contains(archive[:bytes.IndexByte(archive, '\n')], []byte(`"sealed":true`)) {
+		t.Fatalf("Unexpected header:\n%s", archive)
+	}
+
+	_, err := Restore(ctx, fake_database.NewDatabase(), bytes.NewReader(archive))
+	if !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	target := fake_database.NewDatabase()
+	if _, err := Restore(ctx, wrappingDatabase{sealingDatabase{target}}, bytes.NewReader(archive)); err != nil {
+		t.Fatalf("Restore error: %s", err)
+	}
+	peoples, _ := target.Peoples()
+	expectTable(t, peoples, expected["peoples"])
+}
@@ -0,0 +1,73 @@
+package cached_database
+
+import (
+	"context"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Operations without context run with context.Background(). Operations
+// not cached are passed to the table.
+
+func (T *CachedTable[M]) Get(id models.IdData) (M, error) {
+	return T.GetContext(context.Background(), id)
+}
+func (T *CachedTable[M]) Lookup(index string, key string) ([]M, error) {
+	return T.LookupContext(context.Background(), index, key)
+}
+func (T *CachedTable[M]) Insert(record M) (models.IdData, error) {
+	return T.InsertContext(context.Background(), record)
+}
+func (T *CachedTable[M]) Update(record M) error {
+	return T.UpdateContext(context.Background(), record)
+}
+func (T *CachedTable[M]) Delete(id models.IdData) error {
+	return T.DeleteContext(context.Background(), id)
+}
+func (T *CachedTable[M]) Restore(id models.IdData) error {
+	return T.RestoreContext(context.Background(), id)
+}
+func (T *CachedTable[M]) Import(record M) error {
+	return T.ImportContext(context.Background(), record)
+}
+func (T *CachedTable[M]) Replace(record M) error {
+	return T.ReplaceContext(context.Background(), record)
+}
+func (T *CachedTable[M]) InsertMany(records []M) ([]ifaces.Result, error) {
+	return T.InsertManyContext(context.Background(), records)
+}
+func (T *CachedTable[M]) UpdateMany(records []M) ([]ifaces.Result, error) {
+	return T.UpdateManyContext(context.Background(), records)
+}
+func (T *CachedTable[M]) DeleteWhere(query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.DeleteWhereContext(context.Background(), query)
+}
+
+func (T *txTable[M]) Insert(record M) (models.IdData, error) {
+	return T.InsertContext(context.Background(), record)
+}
+func (T *txTable[M]) Update(record M) error {
+	return T.UpdateContext(context.Background(), record)
+}
+func (T *txTable[M]) Delete(id models.IdData) error {
+	return T.DeleteContext(context.Background(), id)
+}
+func (T *txTable[M]) Restore(id models.IdData) error {
+	return T.RestoreContext(context.Background(), id)
+}
+func (T *txTable[M]) Import(record M) error {
+	return T.ImportContext(context.Background(), record)
+}
+func (T *txTable[M]) Replace(record M) error {
+	return T.ReplaceContext(context.Background(), record)
+}
+func (T *txTable[M]) InsertMany(records []M) ([]ifaces.Result, error) {
+	return T.InsertManyContext(context.Background(), records)
+}
+func (T *txTable[M]) UpdateMany(records []M) ([]ifaces.Result, error) {
+	return T.UpdateManyContext(context.Background(), records)
+}
+func (T *txTable[M]) DeleteWhere(query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.DeleteWhereContext(context.Background(), query)
+}
@@ -0,0 +1,321 @@
+// Package cached_database caches the records and the index lookups of the
+// tables in front of any database backend, see CachedTable. The caches
+// are created on Open and follow the change feeds of the tables, if the
+// backend has them, so the changes of the other instances are seen too.
+package cached_database
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+type CachedDatabase struct {
+	db     ifaces.Database
+	config Config
+
+	// Tables are set by Open
+	cancel       context.CancelFunc
+	users        *CachedTable[models.User]
+	peoples      *CachedTable[models.People]
+	roles        *CachedTable[models.Role]
+	phones       *CachedTable[models.Phone]
+	addresses    *CachedTable[models.Address]
+	emails       *CachedTable[models.Email]
+	bankAccounts *CachedTable[models.BankAccount]
+}
+
+// NewDatabase returns db caching the tables by config.
+func NewDatabase(db ifaces.Database, config Config) *CachedDatabase {
+	return &CachedDatabase{db: db, config: config}
+}
+
+// Unwrap returns the cached database.
+func (d *CachedDatabase) Unwrap() ifaces.Database {
+	return d.db
+}
+
+// SetIdStrategies sets id strategies of the database, if it supports them.
+func (d *CachedDatabase) SetIdStrategies(strategies ifaces.IdStrategies) error {
+	setter, ok := d.db.(interface {
+		SetIdStrategies(strategies ifaces.IdStrategies) error
+	})
+	if !ok {
+		return ifaces.ErrNotSupported
+	}
+	return setter.SetIdStrategies(strategies)
+}
+
+func (d *CachedDatabase) Open() error {
+	return d.OpenContext(context.Background())
+}
+
+func (d *CachedDatabase) OpenContext(ctx context.Context) error {
+	if err := d.db.OpenContext(ctx); err != nil {
+		return err
+	}
+
+	var follow context.Context
+	follow, d.cancel = context.WithCancel(context.Background())
+
+	var err error
+	if d.users, err = newTable(follow, d.db.Users, d.config); err != nil {
+		return d.fail(err)
+	}
+	if d.peoples, err = newTable(follow, d.db.Peoples, d.config); err != nil {
+		return d.fail(err)
+	}
+	if d.roles, err = newTable(follow, d.db.Roles, d.config); err != nil {
+		return d.fail(err)
+	}
+	if d.phones, err = newTable(follow, d.db.Phones, d.config); err != nil {
+		return d.fail(err)
+	}
+	if d.addresses, err = newTable(follow, d.db.Addresses, d.config); err != nil {
+		return d.fail(err)
+	}
+	if d.emails, err = newTable(follow, d.db.Emails, d.config); err != nil {
+		return d.fail(err)
+	}
+	if d.bankAccounts, err = newTable(follow, d.db.BankAccounts, d.config); err != nil {
+		return d.fail(err)
+	}
+
+	// Deleting the people deletes the records it owns
+	d.peoples.cascade = []func(){d.phones.flush, d.addresses.flush, d.emails.flush, d.bankAccounts.flush}
+	return nil
+}
+
+func (d *CachedDatabase) fail(err error) error {
+	d.cancel()
+	d.db.Close()
+	return err
+}
+
+// newTable returns the cache of the table following its change feed.
+func newTable[M ifaces.Models](ctx context.Context, open func() (ifaces.Table[M], error), config Config) (*CachedTable[M], error) {
+	table, err := open()
+	if err != nil {
+		return nil, err
+	}
+	cached := NewTable(table, config)
+	if err := cached.Follow(ctx); err != nil && !errors.Is(err, ifaces.ErrNotSupported) {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func (d *CachedDatabase) Close() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.db.Close()
+}
+
+// Stats returns statistics of the table caches by table name, nil if the
+// database is not open.
+func (d *CachedDatabase) Stats() map[string]Stats {
+	if d.users == nil {
+		return nil
+	}
+	return map[string]Stats{
+		"users":         d.users.Stats(),
+		"peoples":       d.peoples.Stats(),
+		"roles":         d.roles.Stats(),
+		"phones":        d.phones.Stats(),
+		"addresses":     d.addresses.Stats(),
+		"emails":        d.emails.Stats(),
+		"bank_accounts": d.bankAccounts.Stats(),
+	}
+}
+
+// table returns the cached table, or the table of the backend if the
+// database is not open.
+func table[M ifaces.Models](cached *CachedTable[M], open func() (ifaces.Table[M], error)) (ifaces.Table[M], error) {
+	if cached == nil {
+		return open()
+	}
+	return cached, nil
+}
+
+func (d *CachedDatabase) Users() (ifaces.Table[models.User], error) {
+	return table(d.users, d.db.Users)
+}
+
+func (d *CachedDatabase) Peoples() (ifaces.Table[models.People], error) {
+	return table(d.peoples, d.db.Peoples)
+}
+
+func (d *CachedDatabase) Roles() (ifaces.Table[models.Role], error) {
+	return table(d.roles, d.db.Roles)
+}
+
+func (d *CachedDatabase) Phones() (ifaces.Table[models.Phone], error) {
+	return table(d.phones, d.db.Phones)
+}
+
+func (d *CachedDatabase) Addresses() (ifaces.Table[models.Address], error) {
+	return table(d.addresses, d.db.Addresses)
+}
+
+func (d *CachedDatabase) Emails() (ifaces.Table[models.Email], error) {
+	return table(d.emails, d.db.Emails)
+}
+
+func (d *CachedDatabase) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return table(d.bankAccounts, d.db.BankAccounts)
+}
+
+// Tx reads the tables of the backend transaction, bypassing the caches.
+// Records written by the transaction are invalidated when it is finished,
+// as the concurrent reads may cache them until the commit.
+func (d *CachedDatabase) Tx(ctx context.Context, callback func(tx ifaces.Tx) error) error {
+	tx := &cachedTx{d: d}
+	defer tx.invalidate()
+
+	return d.db.Tx(ctx, func(inner ifaces.Tx) error {
+		tx.tx = inner
+		return callback(tx)
+	})
+}
+
+type cachedTx struct {
+	tx ifaces.Tx
+	d  *CachedDatabase
+
+	mutex sync.Mutex
+	// invalidations of the written records
+	invalidations []func()
+}
+
+func (t *cachedTx) written(invalidate func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.invalidations = append(t.invalidations, invalidate)
+}
+
+func (t *cachedTx) invalidate() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, invalidate := range t.invalidations {
+		invalidate()
+	}
+}
+
+// txTable records the writes of the transaction.
+type txTable[M ifaces.Models] struct {
+	ifaces.Table[M]
+	tx     *cachedTx
+	cached *CachedTable[M]
+}
+
+func newTxTable[M ifaces.Models](t *cachedTx, open func() (ifaces.Table[M], error), cached *CachedTable[M]) (ifaces.Table[M], error) {
+	table, err := open()
+	if err != nil || cached == nil {
+		return table, err
+	}
+	return &txTable[M]{Table: table, tx: t, cached: cached}, nil
+}
+
+func (T *txTable[M]) written(id models.IdData) {
+	T.tx.written(func() { T.cached.invalidate(id) })
+}
+
+func (T *txTable[M]) InsertContext(ctx context.Context, record M) (models.IdData, error) {
+	id, err := T.Table.InsertContext(ctx, record)
+	T.written(id)
+	return id, err
+}
+
+func (T *txTable[M]) UpdateContext(ctx context.Context, record M) error {
+	T.written(getId(&record))
+	return T.Table.UpdateContext(ctx, record)
+}
+
+func (T *txTable[M]) DeleteContext(ctx context.Context, id models.IdData) error {
+	T.tx.written(func() { T.cached.deleted(id) })
+	return T.Table.DeleteContext(ctx, id)
+}
+
+func (T *txTable[M]) InsertManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	results, err := T.Table.InsertManyContext(ctx, records)
+	for _, result := range results {
+		T.written(result.Id)
+	}
+	return results, err
+}
+
+func (T *txTable[M]) UpdateManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	for i := range records {
+		T.written(getId(&records[i]))
+	}
+	return T.Table.UpdateManyContext(ctx, records)
+}
+
+func (T *txTable[M]) DeleteWhereContext(ctx context.Context, query *ifaces.Query) ([]ifaces.Result, error) {
+	results, err := T.Table.DeleteWhereContext(ctx, query)
+	if err != nil {
+		T.tx.written(func() {
+			T.cached.flush()
+			T.cached.cascaded()
+		})
+		return results, err
+	}
+	ids := resultIds(results)
+	T.tx.written(func() { T.cached.deleted(ids...) })
+	return results, err
+}
+
+func (T *txTable[M]) RestoreContext(ctx context.Context, id models.IdData) error {
+	T.written(id)
+	return T.Table.RestoreContext(ctx, id)
+}
+
+func (T *txTable[M]) ImportContext(ctx context.Context, record M) error {
+	T.written(getId(&record))
+	return T.Table.ImportContext(ctx, record)
+}
+
+func (T *txTable[M]) ReplaceContext(ctx context.Context, record M) error {
+	T.written(getId(&record))
+	return T.Table.ReplaceContext(ctx, record)
+}
+
+func (t *cachedTx) Users() (ifaces.Table[models.User], error) {
+	return newTxTable(t, t.tx.Users, t.d.users)
+}
+
+func (t *cachedTx) Peoples() (ifaces.Table[models.People], error) {
+	return newTxTable(t, t.tx.Peoples, t.d.peoples)
+}
+
+func (t *cachedTx) Roles() (ifaces.Table[models.Role], error) {
+	return newTxTable(t, t.tx.Roles, t.d.roles)
+}
+
+func (t *cachedTx) Phones() (ifaces.Table[models.Phone], error) {
+	return newTxTable(t, t.tx.Phones, t.d.phones)
+}
+
+func (t *cachedTx) Addresses() (ifaces.Table[models.Address], error) {
+	return newTxTable(t, t.tx.Addresses, t.d.addresses)
+}
+
+func (t *cachedTx) Emails() (ifaces.Table[models.Email], error) {
+	return newTxTable(t, t.tx.Emails, t.d.emails)
+}
+
+func (t *cachedTx) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return newTxTable(t, t.tx.BankAccounts, t.d.bankAccounts)
+}
+
+func (t *cachedTx) SchemaVersion(ctx context.Context) (int, error) {
+	return t.tx.SchemaVersion(ctx)
+}
+
+func (t *cachedTx) SetSchemaVersion(ctx context.Context, version int) error {
+	return t.tx.SetSchemaVersion(ctx, version)
+}
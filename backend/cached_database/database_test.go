@@ -0,0 +1,224 @@
+package cached_database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/dbtest"
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) ifaces.Database {
+		return NewDatabase(fake_database.NewDatabase(), Config{Size: 100, TTL: time.Minute})
+	})
+}
+
+// openTest returns the cached database and its backend.
+func openTest(t *testing.T, config Config) (*CachedDatabase, ifaces.Database) {
+	t.Helper()
+
+	backend := fake_database.NewDatabase()
+	db := NewDatabase(backend, config)
+	if err := db.Open(); err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	t.Cleanup(db.Close)
+	return db, backend
+}
+
+func insertUser(t *testing.T, table ifaces.Table[models.User], login string) models.User {
+	t.Helper()
+
+	user := models.User{Login: login, Salt: "salt", Verifier: "verifier"}
+	id, err := table.Insert(user)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	user.SetId(id)
+	user.SetVersion(models.FIRST_VERSION)
+	return user
+}
+
+func expectStats(t *testing.T, db *CachedDatabase, hits uint64, misses uint64) {
+	t.Helper()
+
+	stats := db.Stats()["users"]
+	if stats.Hits != hits || stats.Misses != misses {
+		t.Fatalf("Unexpected stats: %+v, want %d hits, %d misses", stats, hits, misses)
+	}
+}
+
+func expectLogin(t *testing.T, users ifaces.Table[models.User], login string, expected *models.User) {
+	t.Helper()
+
+	user, err := ifaces.GetBy(users, models.UserLoginIndex, login)
+	if expected == nil {
+		if err != ifaces.ErrNoSuchRecord {
+			t.Fatalf("Unexpected user '%s': %+v, %v", login, user, err)
+		}
+		return
+	}
+	if err != nil || user != *expected {
+		t.Fatalf("Unexpected user '%s': %+v, %v", login, user, err)
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	db, _ := openTest(t, Config{Size: 10})
+	users, _ := db.Users()
+
+	alice := insertUser(t, users, "alice")
+
+	expectLogin(t, users, "alice", &alice)
+	expectLogin(t, users, "alice", &alice)
+	expectLogin(t, users, "bob", nil)
+	expectLogin(t, users, "bob", nil)
+	expectStats(t, db, 2, 2)
+
+	if _, err := users.Get(alice.GetId()); err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if _, err := users.Get(alice.GetId()); err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	expectStats(t, db, 3, 3)
+
+	// Inserted record may match the cached lookups
+	bob := insertUser(t, users, "bob")
+	expectLogin(t, users, "bob", &bob)
+
+	// Updated record moves between the keys
+	alice.Login = "carol"
+	if err := users.Update(alice); err != nil {
+		t.Fatalf("Update error: %s", err)
+	}
+	alice.SetVersion(alice.GetVersion() + 1)
+	expectLogin(t, users, "alice", nil)
+	expectLogin(t, users, "carol", &alice)
+	if user, err := users.Get(alice.GetId()); err != nil || user != alice {
+		t.Fatalf("Unexpected user: %+v, %v", user, err)
+	}
+
+	if err := users.Delete(bob.GetId()); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+	expectLogin(t, users, "bob", nil)
+}
+
+func TestLookupCopies(t *testing.T) {
+	db, _ := openTest(t, Config{Size: 10})
+	users, _ := db.Users()
+
+	alice := insertUser(t, users, "alice")
+
+	// Both the missed and the hit lookups return the copies
+	for i := 0; i < 2; i++ {
+		records, err := users.Lookup(models.UserLoginIndex, "alice")
+		if err != nil || len(records) != 1 {
+			t.Fatalf("Unexpected lookup: %+v, %v", records, err)
+		}
+		records[0].Login = "mallory"
+		records[0].Salt = ""
+	}
+	expectLogin(t, users, "alice", &alice)
+	expectStats(t, db, 2, 1)
+}
+
+func TestLimits(t *testing.T) {
+	db, _ := openTest(t, Config{Size: 2, TTL: 50 * time.Millisecond})
+	users, _ := db.Users()
+
+	for _, login := range []string{"a", "b", "c"} {
+		expectLogin(t, users, login, nil)
+	}
+	stats := db.Stats()["users"]
+	if stats.Entries != 2 || stats.Evictions != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	expectLogin(t, users, "c", nil)
+	expectStats(t, db, 0, 4)
+}
+
+func TestTx(t *testing.T) {
+	db, _ := openTest(t, Config{Size: 10})
+	users, _ := db.Users()
+
+	alice := insertUser(t, users, "alice")
+	expectLogin(t, users, "alice", &alice)
+
+	ctx := context.Background()
+	err := db.Tx(ctx, func(tx ifaces.Tx) error {
+		users, err := tx.Users()
+		if err != nil {
+			return err
+		}
+		alice.Salt = "new salt"
+		if err := users.UpdateContext(ctx, alice); err != nil {
+			return err
+		}
+		alice.SetVersion(alice.GetVersion() + 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Tx error: %s", err)
+	}
+	expectLogin(t, users, "alice", &alice)
+}
+
+func TestFollow(t *testing.T) {
+	db, backend := openTest(t, Config{Size: 10})
+	users, _ := db.Users()
+
+	alice := insertUser(t, users, "alice")
+	expectLogin(t, users, "alice", &alice)
+
+	// Change of the other instance
+	other, _ := backend.Users()
+	if err := other.Delete(alice.GetId()); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := ifaces.GetBy(users, models.UserLoginIndex, "alice"); err == ifaces.ErrNoSuchRecord {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Change is not seen")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCascade(t *testing.T) {
+	db, _ := openTest(t, Config{Size: 10})
+	peoples, _ := db.Peoples()
+	phones, _ := db.Phones()
+
+	owner, err := peoples.Insert(models.People{Name: "John"})
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	phone := models.Phone{Phone: "1"}
+	phone.SetOwner(owner)
+	id, err := phones.Insert(phone)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	if _, err := phones.Get(id); err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+
+	if err := peoples.Delete(owner); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+	if _, err := phones.Get(id); err != ifaces.ErrNoSuchRecord {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
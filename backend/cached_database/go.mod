@@ -0,0 +1,18 @@
+module github.com/diakovliev/mesap/backend/cached_database
+
+go 1.23
+
+require (
+	github.com/diakovliev/mesap/backend/dbtest v0.0.1
+	github.com/diakovliev/mesap/backend/fake_database v0.0.1
+	github.com/diakovliev/mesap/backend/ifaces v0.0.1
+	github.com/diakovliev/mesap/backend/models v0.0.1
+)
+
+replace github.com/diakovliev/mesap/backend/models v0.0.1 => ../models
+
+replace github.com/diakovliev/mesap/backend/ifaces v0.0.1 => ../ifaces
+
+replace github.com/diakovliev/mesap/backend/dbtest v0.0.1 => ../dbtest
+
+replace github.com/diakovliev/mesap/backend/fake_database v0.0.1 => ../fake_database
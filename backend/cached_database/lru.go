@@ -0,0 +1,101 @@
+package cached_database
+
+import (
+	"container/list"
+	"time"
+)
+
+// Stats are the statistics of the table cache.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Evictions are the entries dropped by the size limit
+	Evictions uint64 `json:"evictions"`
+	// Invalidations are the writes and the change feed events dropping
+	// the entries
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+}
+
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+	// lookups of the entry, see lru.lookups
+	lookups uint64
+}
+
+// lru is the least recently used cache limited by the number of the
+// entries. Entries of the lookups are invalidated all at once, as the
+// changed record may move between the keys.
+type lru struct {
+	size int
+	ttl  time.Duration
+
+	items map[string]*list.Element
+	order *list.List
+	// lookups is the generation of the lookup entries
+	lookups uint64
+	stats   Stats
+}
+
+func newLru(size int, ttl time.Duration) *lru {
+	return &lru{size: size, ttl: ttl, items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *lru) get(key string, lookup bool) (any, bool) {
+	elem, ok := c.items[key]
+	if ok {
+		e := elem.Value.(*entry)
+		if c.ttl > 0 && time.Now().After(e.expires) || lookup && e.lookups != c.lookups {
+			c.remove(elem)
+			ok = false
+		} else {
+			c.order.MoveToFront(elem)
+			c.stats.Hits++
+			return e.value, true
+		}
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+func (c *lru) put(key string, value any) {
+	e := &entry{key: key, value: value, lookups: c.lookups}
+	if c.ttl > 0 {
+		e.expires = time.Now().Add(c.ttl)
+	}
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value = e
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(e)
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the entry of the key and all lookups.
+func (c *lru) invalidate(key string) {
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	c.lookups++
+	c.stats.Invalidations++
+}
+
+func (c *lru) flush() {
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.lookups++
+	c.stats.Invalidations++
+}
+
+func (c *lru) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*entry).key)
+}
@@ -0,0 +1,257 @@
+package cached_database
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// followRetry is the delay of the watch restart after the error.
+const followRetry = time.Second
+
+// Config of the table caches.
+type Config struct {
+	// Size is the maximal number of the cached records and lookups of the
+	// table.
+	Size int
+	// TTL limits the time the entry is cached, 0 is unlimited. The
+	// changes made by the other instances are seen after TTL if the
+	// backend has no change feed.
+	TTL time.Duration
+}
+
+// CachedTable is the read-through cache of Get and Lookup in front of the
+// table. Writes through the table invalidate the written records and all
+// lookups; other operations are passed to the table as is.
+type CachedTable[M ifaces.Models] struct {
+	ifaces.Table[M]
+
+	mutex sync.Mutex
+	cache *lru
+	// generation is incremented by the invalidations, so the record read
+	// before the invalidation is not cached after it
+	generation uint64
+	// cascade flushes the caches of the records deleted with the record
+	cascade []func()
+}
+
+func NewTable[M ifaces.Models](table ifaces.Table[M], config Config) *CachedTable[M] {
+	return &CachedTable[M]{Table: table, cache: newLru(config.Size, config.TTL)}
+}
+
+// Stats returns statistics of the cache.
+func (T *CachedTable[M]) Stats() Stats {
+	T.mutex.Lock()
+	defer T.mutex.Unlock()
+
+	stats := T.cache.stats
+	stats.Entries = T.cache.order.Len()
+	return stats
+}
+
+// Follow invalidates the records changed by the other instances, reported
+// by the change feed of the table, until ctx is done. Returns
+// ifaces.ErrNotSupported if the table has no change feed. The cache is
+// flushed if the watch is lost, as the changes may be missed.
+func (T *CachedTable[M]) Follow(ctx context.Context) error {
+	watcher, err := T.Table.Watch(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			for event := range watcher.Events() {
+				T.invalidate(event.Id)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			T.flush()
+
+			for {
+				watcher, err = T.Table.Watch(ctx, 0)
+				if err == nil {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(followRetry):
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func idKey(id models.IdData) string {
+	return "i" + strconv.FormatInt(int64(id), 10)
+}
+
+func lookupKey(index string, key string) string {
+	return "l" + index + "\x00" + key
+}
+
+func getId[M ifaces.Models](record *M) models.IdData {
+	var i interface{} = record
+	return i.(ifaces.Id).GetId()
+}
+
+func (T *CachedTable[M]) get(key string, lookup bool) (any, uint64, bool) {
+	T.mutex.Lock()
+	defer T.mutex.Unlock()
+
+	value, ok := T.cache.get(key, lookup)
+	return value, T.generation, ok
+}
+
+func (T *CachedTable[M]) put(key string, generation uint64, value any) {
+	T.mutex.Lock()
+	defer T.mutex.Unlock()
+
+	if generation == T.generation {
+		T.cache.put(key, value)
+	}
+}
+
+func (T *CachedTable[M]) invalidate(id models.IdData) {
+	T.mutex.Lock()
+	defer T.mutex.Unlock()
+
+	T.cache.invalidate(idKey(id))
+	T.generation++
+}
+
+func (T *CachedTable[M]) deleted(ids ...models.IdData) {
+	for _, id := range ids {
+		T.invalidate(id)
+	}
+	if len(ids) > 0 {
+		T.cascaded()
+	}
+}
+
+// cascaded flushes the caches of the records deleted with the records of
+// the table.
+func (T *CachedTable[M]) cascaded() {
+	for _, flush := range T.cascade {
+		flush()
+	}
+}
+
+func resultIds(results []ifaces.Result) []models.IdData {
+	ids := make([]models.IdData, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.Id)
+	}
+	return ids
+}
+
+// flush drops all entries of the cache.
+func (T *CachedTable[M]) flush() {
+	T.mutex.Lock()
+	defer T.mutex.Unlock()
+
+	T.cache.flush()
+	T.generation++
+}
+
+func (T *CachedTable[M]) GetContext(ctx context.Context, id models.IdData) (M, error) {
+	key := idKey(id)
+	value, generation, ok := T.get(key, false)
+	if ok {
+		return value.(M), nil
+	}
+
+	record, err := T.Table.GetContext(ctx, id)
+	if err == nil {
+		T.put(key, generation, record)
+	}
+	return record, err
+}
+
+func (T *CachedTable[M]) LookupContext(ctx context.Context, index string, key string) ([]M, error) {
+	cacheKey := lookupKey(index, key)
+	value, generation, ok := T.get(cacheKey, true)
+	if ok {
+		// Caller may change the records, e.g. the encrypted table opens
+		// them in place
+		return slices.Clone(value.([]M)), nil
+	}
+
+	records, err := T.Table.LookupContext(ctx, index, key)
+	if err == nil {
+		T.put(cacheKey, generation, slices.Clone(records))
+	}
+	return records, err
+}
+
+// Writes invalidate the record even if they fail, as the failure may be
+// caused by the stale record, e.g. ifaces.ErrConflict.
+
+func (T *CachedTable[M]) InsertContext(ctx context.Context, record M) (models.IdData, error) {
+	id, err := T.Table.InsertContext(ctx, record)
+	T.invalidate(id)
+	return id, err
+}
+
+func (T *CachedTable[M]) UpdateContext(ctx context.Context, record M) error {
+	defer T.invalidate(getId(&record))
+	return T.Table.UpdateContext(ctx, record)
+}
+
+func (T *CachedTable[M]) DeleteContext(ctx context.Context, id models.IdData) error {
+	defer T.deleted(id)
+	return T.Table.DeleteContext(ctx, id)
+}
+
+func (T *CachedTable[M]) RestoreContext(ctx context.Context, id models.IdData) error {
+	defer T.invalidate(id)
+	return T.Table.RestoreContext(ctx, id)
+}
+
+func (T *CachedTable[M]) ImportContext(ctx context.Context, record M) error {
+	defer T.invalidate(getId(&record))
+	return T.Table.ImportContext(ctx, record)
+}
+
+func (T *CachedTable[M]) ReplaceContext(ctx context.Context, record M) error {
+	defer T.invalidate(getId(&record))
+	return T.Table.ReplaceContext(ctx, record)
+}
+
+func (T *CachedTable[M]) InsertManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	results, err := T.Table.InsertManyContext(ctx, records)
+	for _, result := range results {
+		T.invalidate(result.Id)
+	}
+	return results, err
+}
+
+func (T *CachedTable[M]) UpdateManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	defer func() {
+		for i := range records {
+			T.invalidate(getId(&records[i]))
+		}
+	}()
+	return T.Table.UpdateManyContext(ctx, records)
+}
+
+// DeleteWhereContext flushes the cache if it fails, as the records
+// selected are not known.
+func (T *CachedTable[M]) DeleteWhereContext(ctx context.Context, query *ifaces.Query) ([]ifaces.Result, error) {
+	results, err := T.Table.DeleteWhereContext(ctx, query)
+	if err != nil {
+		T.flush()
+		T.cascaded()
+		return results, err
+	}
+	T.deleted(resultIds(results)...)
+	return results, err
+}
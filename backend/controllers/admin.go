@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/diakovliev/mesap/backend/backup"
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+// metricsContentType is the Prometheus text format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// RestoreResponse describes the restored backup.
+type RestoreResponse struct {
+	Schema    int            `json:"schema"`
+	Created   time.Time      `json:"created"`
+	Records   map[string]int `json:"records"`
+	Revisions map[string]int `json:"revisions"`
+}
+
+// RotateKeysResponse is the number of the records re-wrapped by the
+// current master key.
+type RotateKeysResponse struct {
+	Rewrapped int `json:"rewrapped"`
+}
+
+// Admin serves the database maintenance endpoints to the clients
+// authorized by the admin bearer tokens. The endpoints are disabled if
+// there are no tokens.
+type Admin struct {
+	db         ifaces.Database
+	tokens     bearerTokens
+	restored   func(ctx context.Context, db ifaces.Database) error
+	rotateKeys func(ctx context.Context) (int, error)
+	metrics    func(w io.Writer) error
+}
+
+// NewAdminController returns the admin controller of config.AdminTokens,
+// config.Restored, config.RotateKeys and config.Metrics.
+func NewAdminController(db ifaces.Database, config APIConfig) *Admin {
+	return &Admin{
+		db:         db,
+		tokens:     newBearerTokens(config.AdminTokens),
+		restored:   config.Restored,
+		rotateKeys: config.RotateKeys,
+		metrics:    config.Metrics,
+	}
+}
+
+func (a *Admin) Routes() []Route {
+	return []Route{
+		{
+			Method:  http.MethodGet,
+			Pattern: "/backup",
+			Name:    "backup",
+			Summary: "Stream backup archive of the whole database as JSON lines",
+			Handler: a.tokens.authorized(a.GetBackup),
+			Errors:  []int{http.StatusUnauthorized, http.StatusInternalServerError},
+		},
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/restore",
+			Name:     "restore",
+			Summary:  "Restore backup archive to the empty database",
+			Handler:  a.tokens.authorized(a.PostRestore),
+			Response: RestoreResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusConflict,
+				http.StatusInternalServerError},
+			// Archive is as large as the database
+			BodyLimit: -1,
+		},
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/rotate-keys",
+			Name:     "rotateKeys",
+			Summary:  "Reload master keys and re-wrap data keys of the encrypted records by the current one",
+			Handler:  a.tokens.authorized(a.PostRotateKeys),
+			Response: RotateKeysResponse{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusNotImplemented, http.StatusInternalServerError},
+		},
+		{
+			Method:  http.MethodGet,
+			Pattern: "/metrics",
+			Name:    "metrics",
+			Summary: "Database call counts, errors and latency histograms in Prometheus text format",
+			Handler: a.tokens.authorized(a.GetMetrics),
+			Errors:  []int{http.StatusUnauthorized, http.StatusNotImplemented},
+		},
+	}
+}
+
+func (a *Admin) Controller() chi.Router {
+	return NewRouter(a.Routes())
+}
+
+// sentWriter remembers whether the response is started.
+type sentWriter struct {
+	w    io.Writer
+	sent bool
+}
+
+func (sw *sentWriter) Write(p []byte) (int, error) {
+	sw.sent = true
+	return sw.w.Write(p)
+}
+
+func (a *Admin) GetBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", backup.ContentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="mesap-%s.jsonl"`, time.Now().UTC().Format("20060102T150405Z")))
+
+	out := &sentWriter{w: w}
+	report, err := backup.Dump(r.Context(), a.db, out)
+	if err != nil {
+		logger().Error("Backup error", slog.Any("error", err))
+		// Client sees the archive without the trailer otherwise
+		if !out.sent {
+			WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		}
+		return
+	}
+
+	logger().Info("Backup is made", slog.Int("schema", report.Schema), slog.Int("records", report.Total()),
+		slog.Int("revisions", report.TotalRevisions()))
+}
+
+func (a *Admin) PostRestore(w http.ResponseWriter, r *http.Request) {
+	report, err := backup.Restore(r.Context(), a.db, r.Body)
+	switch {
+	case errors.Is(err, backup.ErrNotEmpty):
+		WriteError(w, r, http.StatusConflict, ErrorNotEmpty, err.Error())
+		return
+	case errors.Is(err, backup.ErrBadArchive), errors.Is(err, backup.ErrNotSealed), errors.Is(err, ifaces.ErrConflict),
+		errors.Is(err, ifaces.ErrUniqueViolation), errors.Is(err, ifaces.ErrNoOwner),
+		errors.Is(err, ifaces.ErrWrongRecord):
+		logger().Info("Bad backup archive", slog.Any("error", err))
+		WriteError(w, r, http.StatusBadRequest, ErrorBadRequest, err.Error())
+		return
+	case err != nil:
+		logger().Error("Restore error", slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return
+	}
+
+	logger().Info("Backup is restored", slog.Int("schema", report.Schema), slog.Int("records", report.Total()),
+		slog.Int("revisions", report.TotalRevisions()))
+
+	if a.restored != nil {
+		if err := a.restored(r.Context(), a.db); err != nil {
+			logger().Error("Restored database error", slog.Any("error", err))
+			WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	err = json.NewEncoder(w).Encode(RestoreResponse{Schema: report.Schema, Created: report.Created, Records: report.Records, Revisions: report.Revisions})
+	if err != nil {
+		logger().Error("Can't write restore response", slog.Any("error", err))
+	}
+}
+
+func (a *Admin) PostRotateKeys(w http.ResponseWriter, r *http.Request) {
+	if a.rotateKeys == nil {
+		WriteError(w, r, http.StatusNotImplemented, ErrorNotSupported, "Database is not encrypted")
+		return
+	}
+
+	rewrapped, err := a.rotateKeys(r.Context())
+	if err != nil {
+		logger().Error("Keys rotation error", slog.Int("rewrapped", rewrapped), slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return
+	}
+
+	logger().Info("Keys are rotated", slog.Int("rewrapped", rewrapped))
+
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(RotateKeysResponse{Rewrapped: rewrapped}); err != nil {
+		logger().Error("Can't write rotate keys response", slog.Any("error", err))
+	}
+}
+
+func (a *Admin) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	if a.metrics == nil {
+		WriteError(w, r, http.StatusNotImplemented, ErrorNotSupported, "Database is not instrumented")
+		return
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	if err := a.metrics(w); err != nil {
+		logger().Error("Can't write metrics", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/backup"
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+const testAdminToken = "admin-secret"
+
+func adminRequest(t *testing.T, method string, url string, token string, body io.Reader) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAdminBackupRestore(t *testing.T) {
+	source := fake_database.NewDatabase()
+	peoples, _ := source.Peoples()
+	id, err := peoples.Insert(models.People{Name: "John"})
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+
+	config := APIConfig{AdminTokens: []string{testAdminToken}}
+	src := httptest.NewServer(NewAPIRouter(source, config))
+	defer src.Close()
+
+	resp := adminRequest(t, http.MethodGet, src.URL+"/v1/admin/backup", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+
+	resp = adminRequest(t, http.MethodGet, src.URL+"/v1/admin/backup", testAdminToken, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != backup.ContentType {
+		t.Fatalf("Unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	archive, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Read error: %s", err)
+	}
+
+	restored := false
+	config.Restored = func(ctx context.Context, db ifaces.Database) error {
+		restored = true
+		return nil
+	}
+	target := fake_database.NewDatabase()
+	dst := httptest.NewServer(NewAPIRouter(target, config))
+	defer dst.Close()
+
+	resp = adminRequest(t, http.MethodPost, dst.URL+"/v1/admin/restore", testAdminToken, bytes.NewReader(archive))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+	var report RestoreResponse
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("Decode error: %s", err)
+	}
+	if report.Records["peoples"] != 1 || !restored {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	peoples, _ = target.Peoples()
+	if people, err := peoples.Get(id); err != nil || people.Name != "John" {
+		t.Fatalf("Unexpected record: %+v, %v", people, err)
+	}
+
+	resp = adminRequest(t, http.MethodPost, dst.URL+"/v1/admin/restore", testAdminToken, bytes.NewReader(archive))
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+
+	resp = adminRequest(t, http.MethodPost, dst.URL+"/v1/admin/restore", testAdminToken, bytes.NewReader([]byte("{}")))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+}
+
+func TestAdminRotateKeys(t *testing.T) {
+	db := fake_database.NewDatabase()
+	ts := httptest.NewServer(NewAPIRouter(db, APIConfig{AdminTokens: []string{testAdminToken}}))
+	defer ts.Close()
+
+	resp := adminRequest(t, http.MethodPost, ts.URL+"/v1/admin/rotate-keys", testAdminToken, nil)
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+
+	rotated := httptest.NewServer(NewAPIRouter(db, APIConfig{
+		AdminTokens: []string{testAdminToken},
+		RotateKeys:  func(ctx context.Context) (int, error) { return 3, nil },
+	}))
+	defer rotated.Close()
+
+	resp = adminRequest(t, http.MethodPost, rotated.URL+"/v1/admin/rotate-keys", testAdminToken, nil)
+	var report RotateKeysResponse
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&report) != nil || report.Rewrapped != 3 {
+		t.Fatalf("Unexpected response: %d %+v", resp.StatusCode, report)
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+	db := fake_database.NewDatabase()
+	ts := httptest.NewServer(NewAPIRouter(db, APIConfig{
+		AdminTokens: []string{testAdminToken},
+		Metrics: func(w io.Writer) error {
+			_, err := io.WriteString(w, "mesap_db_calls_total 1\n")
+			return err
+		},
+	}))
+	defer ts.Close()
+
+	resp := adminRequest(t, http.MethodGet, ts.URL+"/v1/admin/metrics", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+
+	resp = adminRequest(t, http.MethodGet, ts.URL+"/v1/admin/metrics", testAdminToken, nil)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "mesap_db_calls_total 1\n" {
+		t.Fatalf("Unexpected response: %d %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+const (
+	APITitle = "mesap API"
+	// APIVersion is the current version of the API, see V2Routes
+	APIVersion = "v2"
+	// APIVersion1 is the previous version of the API, ids are numbers
+	APIVersion1 = "v1"
+)
+
+// APIConfig is the configuration of the API. Bearer tokens are "token" or
+// "name token" entries; the name, or the fingerprint of the unnamed token,
+// is the actor of the changes made with the token, see ifaces.WithActor.
+type APIConfig struct {
+	// WatchTokens are bearer tokens of the change feed clients. Change
+	// feeds are disabled if there are no tokens.
+	WatchTokens []string
+	// AdminTokens are bearer tokens of the administrators. Admin
+	// endpoints and the peoples records are disabled if there are no
+	// tokens.
+	AdminTokens []string
+	// Restored is called after the backup is restored, e.g. to migrate
+	// the restored data; may be nil.
+	Restored func(ctx context.Context, db ifaces.Database) error
+	// RotateKeys reloads the master keys of the encrypted database and
+	// re-wraps the data keys of the records by the current one, returns
+	// the number of the records updated; nil if the database is not
+	// encrypted.
+	RotateKeys func(ctx context.Context) (int, error)
+	// Metrics writes the database metrics in the Prometheus text format;
+	// nil if the database is not instrumented.
+	Metrics func(w io.Writer) error
+}
+
+// NewAPIRouter returns router serving all API versions. It is expected
+// to be mounted at /api.
+func NewAPIRouter(db ifaces.Database, config APIConfig) chi.Router {
+	auth := NewAuthController(db)
+	peoples := NewPeoplesController(db, config.AdminTokens)
+	watch := NewWatchController(db, config.WatchTokens)
+	admin := NewAdminController(db, config)
+
+	r := chi.NewRouter()
+	r.NotFound(NotFound)
+	r.MethodNotAllowed(MethodNotAllowed)
+
+	spec := NewOpenAPI(APITitle, APIVersion)
+
+	versions := []struct {
+		name   string
+		routes func([]Route) []Route
+	}{
+		{APIVersion, V2Routes},
+		{APIVersion1, V1Routes},
+	}
+	for _, version := range versions {
+		r.Route("/"+version.name, func(r chi.Router) {
+			for _, tag := range []struct {
+				name   string
+				routes []Route
+			}{
+				{"auth", auth.Routes()},
+				{"peoples", peoples.Routes()},
+				{"watch", watch.Routes()},
+				{"admin", admin.Routes()},
+			} {
+				routes := version.routes(tag.routes)
+				r.Mount("/"+tag.name, NewRouter(routes))
+				spec.AddRoutes("/api/"+version.name+"/"+tag.name, tag.name, false, routes)
+			}
+		})
+	}
+
+	// Deprecated: unversioned routes, kept as alias of the v1 API with
+	// the legacy wire format.
+	r.With(Deprecated("/api/"+APIVersion+"/auth")).Mount("/auth", auth.LegacyController())
+	spec.AddRoutes("/api/auth", "auth", true, auth.LegacyRoutes())
+
+	specRoutes := []Route{
+		{
+			Method:  http.MethodGet,
+			Pattern: "/openapi.json",
+			Name:    "openapi",
+			Summary: "OpenAPI specification of this API",
+			Handler: spec.ServeHTTP,
+		},
+	}
+	r.Mount("/", NewRouter(specRoutes))
+	spec.AddRoutes("/api", "spec", false, specRoutes)
+
+	return r
+}
+
+// Deprecated marks responses of the deprecated routes and logs their usage.
+func Deprecated(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger().Warn("Deprecated API route used",
+				slog.String("path", r.URL.Path),
+				slog.String("successor", successor),
+				slog.String("user_agent", r.UserAgent()),
+			)
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kong/go-srp"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+)
+
+// Pins the wire format of the API. Changing any of these is a breaking
+// change and requires new API version.
+func TestWireFormat(t *testing.T) {
+	tests := []struct {
+		value any
+		wire  string
+	}{
+		{RegisterRequestData{Login: "l", Salt: "s", Verifier: "v"}, `{"login":"l","salt":"s","verifier":"v"}`},
+		{RegisterResponseData{UserId: 42}, `{"userId":42}`},
+		{RegisterResponseData{UserId: 42}.v2(), `{"userId":"id-000000000001A"}`},
+		{LoginRequestData{Login: "l", Secret1: "A"}, `{"login":"l","secret1":"A"}`},
+		{LoginResponseData{Server: "s", Secret2: "B"}, `{"server":"s","secret2":"B"}`},
+		{Login2RequestData{Server: "s", Secret3: "M1"}, `{"server":"s","secret3":"M1"}`},
+		{Login2ResponseData{Secret4: "M2"}, `{"secret4":"M2"}`},
+		{LogoutRequestData{Token: "t"}, `{"token":"t"}`},
+		{FieldError{Field: "f", Problem: "p"}, `{"field":"f","problem":"p"}`},
+		{
+			ErrorResponse{Code: ErrorValidation, Message: "m", Fields: ValidationError{{Field: "f", Problem: "p"}}, RequestId: "r"},
+			`{"code":"validation_failed","message":"m","fields":[{"field":"f","problem":"p"}],"requestId":"r"}`,
+		},
+		{ErrorResponse{Code: ErrorInternal, Message: "m"}, `{"code":"internal_error","message":"m"}`},
+	}
+
+	for _, test := range tests {
+		encoded, err := json.Marshal(test.value)
+		if err != nil {
+			t.Fatalf("%T: encoding error: %s", test.value, err)
+		}
+		if string(encoded) != test.wire {
+			t.Fatalf("%T: wire format changed:\n got: %s\nwant: %s", test.value, encoded, test.wire)
+		}
+
+		decoded := reflect.New(reflect.TypeOf(test.value))
+		if err := json.Unmarshal([]byte(test.wire), decoded.Interface()); err != nil {
+			t.Fatalf("%T: decoding error: %s", test.value, err)
+		}
+		if !reflect.DeepEqual(decoded.Elem().Interface(), test.value) {
+			t.Fatalf("%T: decoded value differs: %v", test.value, decoded.Elem().Interface())
+		}
+	}
+}
+
+func TestDeprecatedAlias(t *testing.T) {
+	ts := httptest.NewServer(NewAPIRouter(fake_database.NewDatabase(), APIConfig{}))
+	defer ts.Close()
+
+	for _, path := range []string{"/auth/login2", "/" + APIVersion + "/auth/login2"} {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("%s: request error: %s", path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: bad status code: %d", path, resp.StatusCode)
+		}
+
+		deprecated := resp.Header.Get("Deprecation") == "true"
+		if deprecated != !strings.HasPrefix(path, "/"+APIVersion) {
+			t.Fatalf("%s: unexpected Deprecation header: '%s'", path, resp.Header.Get("Deprecation"))
+		}
+	}
+
+	// Alias keeps the legacy wire format of the responses
+	tests := []struct {
+		prefix   string
+		login    string
+		register []string
+		keys     []string
+	}{
+		{"/auth", "alice", []string{"UserId"}, []string{"Secret2", "Server"}},
+		{"/" + APIVersion + "/auth", "bob", []string{"userId"}, []string{"secret2", "server"}},
+	}
+	for _, test := range tests {
+		login := AuthEncodeBytes([]byte(test.login))
+		verifier := srp.ComputeVerifier(SRP_PARAMS, testSalt, []byte(test.login), testPassword)
+		register := AuthEncodeJson(RegisterRequestData{Login: login, Salt: AuthEncodeBytes(testSalt), Verifier: AuthEncodeBytes(verifier)})
+		expectKeys(t, ts.URL+test.prefix+"/register", register, test.register)
+
+		client := srp.NewClient(SRP_PARAMS, testSalt, []byte(test.login), testPassword, srp.GenKey())
+		loginData := AuthEncodeJson(LoginRequestData{Login: login, Secret1: AuthEncodeBytes(client.ComputeA())})
+		expectKeys(t, ts.URL+test.prefix+"/login", loginData, test.keys)
+	}
+}
+
+// expectKeys posts the request and checks the sorted keys of the response.
+func expectKeys(t *testing.T, url string, body io.Reader, keys []string) {
+	t.Helper()
+
+	resp, err := http.Post(url, "application/json", body)
+	if err != nil {
+		t.Fatalf("%s: request error: %s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: bad status code: %d", url, resp.StatusCode)
+	}
+
+	var response map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("%s: can't decode response: %s", url, err)
+	}
+	got := make([]string, 0, len(response))
+	for key := range response {
+		got = append(got, key)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, keys) {
+		t.Fatalf("%s: expected keys %v, got %v", url, keys, got)
+	}
+}
+
+// Fails when the served routes and the OpenAPI specification diverge.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	router := NewAPIRouter(fake_database.NewDatabase(), APIConfig{})
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var spec OpenAPI
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatalf("Can't decode OpenAPI document: %s", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method, operation := range item {
+			documented[strings.ToUpper(method)+" "+path] = true
+
+			if operation.RequestBody != nil {
+				ensureSchemaRefs(t, &spec, operation.RequestBody.Content[jsonContentType].Schema)
+			}
+			for _, response := range operation.Responses {
+				if content, ok := response.Content[jsonContentType]; ok {
+					ensureSchemaRefs(t, &spec, content.Schema)
+				}
+			}
+		}
+	}
+
+	// Sealed data and legacy contacts are internal to the database
+	for _, name := range []string{"Phones", "Sealed"} {
+		if _, ok := spec.Components.Schemas["People"].Properties[name]; ok {
+			t.Errorf("People schema has property %s", name)
+		}
+	}
+
+	if _, ok := spec.Paths["/api/"+APIVersion+"/peoples/{id}"]["delete"].Responses["204"]; !ok {
+		t.Errorf("Delete of the people record is not documented as 204")
+	}
+
+	served := make(map[string]bool)
+	err = chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		served[method+" "+OpenAPIPath("/api"+route)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk error: %s", err)
+	}
+
+	for route := range served {
+		if !documented[route] {
+			t.Errorf("Route is not documented: %s", route)
+		}
+	}
+	for route := range documented {
+		if !served[route] {
+			t.Errorf("Documented route is not served: %s", route)
+		}
+	}
+}
+
+func ensureSchemaRefs(t *testing.T, spec *OpenAPI, schema *OpenAPISchema) {
+	if schema == nil {
+		t.Fatalf("Missing schema")
+	}
+	if len(schema.Ref) > 0 {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Fatalf("Dangling schema reference: %s", schema.Ref)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package controllers
 
 import (
+	"context"
 	"crypto"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
@@ -22,6 +25,8 @@ const (
 
 var (
 	SRP_PARAMS = srp.GetParams(4096)
+
+	ErrWrongServerContext = errors.New("Wrong SRP server context!")
 )
 
 type AuthServer struct {
@@ -35,76 +40,228 @@ type Auth struct {
 	servers map[string]AuthServer
 }
 
+// Wire format of the auth API. All binary values are base64 encoded.
+// Field names are camelCase; decoding is case insensitive, so requests
+// of the deprecated unversioned API are accepted as well.
+
 type RegisterRequestData struct {
-	Login    string `json: "login"`
-	Salt     string `json: "salt"`
-	Verifier string `json: "verifier"`
+	Login    string `json:"login"`
+	Salt     string `json:"salt"`
+	Verifier string `json:"verifier"`
 
 	// TODO: User extended info
 }
 
 func (rrd *RegisterRequestData) String() string {
-	return fmt.Sprintf("Login: '%s' Salt: '%s' Verifier: '%s'", rrd.Login, rrd.Salt, rrd.Verifier)
+	return fmt.Sprintf("Login: '%s' Salt: '%s' Verifier: '%s'", rrd.Login, Secret(rrd.Salt), Secret(rrd.Verifier))
+}
+
+func (rrd RegisterRequestData) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("login", rrd.Login),
+		slog.Any("salt", Secret(rrd.Salt)),
+		slog.Any("verifier", Secret(rrd.Verifier)),
+	)
 }
 
 type RegisterResponseData struct {
-	UserId models.IdData
+	UserId models.IdData `json:"userId"`
 }
 
 func (rrd *RegisterResponseData) String() string {
 	return fmt.Sprintf("UserId: '%d'", rrd.UserId)
 }
 
+func (rrd RegisterResponseData) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("user_id", int64(rrd.UserId)))
+}
+
 type LoginRequestData struct {
-	Login   string
-	Secret1 string
+	Login   string `json:"login"`
+	Secret1 string `json:"secret1"` // A
 }
 
 func (lrd *LoginRequestData) String() string {
-	return fmt.Sprintf("Login: '%s' Secret1: '%s'", lrd.Login, lrd.Secret1)
+	return fmt.Sprintf("Login: '%s' Secret1: '%s'", lrd.Login, Secret(lrd.Secret1))
+}
+
+func (lrd LoginRequestData) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("login", lrd.Login),
+		slog.Any("secret1", Secret(lrd.Secret1)),
+	)
 }
 
 type LoginResponseData struct {
-	Server  string
-	Secret2 string
+	Server  string `json:"server"`  // login session id
+	Secret2 string `json:"secret2"` // B
 }
 
 func (lrd *LoginResponseData) String() string {
-	return fmt.Sprintf("Server: '%s' Secret2: '%s'", lrd.Server, lrd.Secret2)
+	return fmt.Sprintf("Server: '%s' Secret2: '%s'", Secret(lrd.Server), Secret(lrd.Secret2))
+}
+
+func (lrd LoginResponseData) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("server", Secret(lrd.Server)),
+		slog.Any("secret2", Secret(lrd.Secret2)),
+	)
 }
 
 type Login2RequestData struct {
-	Server  string
-	Secret3 string
+	Server  string `json:"server"`  // login session id
+	Secret3 string `json:"secret3"` // M1
 }
 
 func (l2rd *Login2RequestData) String() string {
-	return fmt.Sprintf("Server: '%s' Secret3: '%s'", l2rd.Server, l2rd.Secret3)
+	return fmt.Sprintf("Server: '%s' Secret3: '%s'", Secret(l2rd.Server), Secret(l2rd.Secret3))
+}
+
+func (l2rd Login2RequestData) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("server", Secret(l2rd.Server)),
+		slog.Any("secret3", Secret(l2rd.Secret3)),
+	)
 }
 
 type Login2ResponseData struct {
-	Secret4 string
+	Secret4 string `json:"secret4"` // M2
 }
 
 func (l2r *Login2ResponseData) String() string {
-	return fmt.Sprintf("Secret4: '%s'", l2r.Secret4)
+	return fmt.Sprintf("Secret4: '%s'", Secret(l2r.Secret4))
+}
+
+func (l2r Login2ResponseData) LogValue() slog.Value {
+	return slog.GroupValue(slog.Any("secret4", Secret(l2r.Secret4)))
 }
 
 type LogoutRequestData struct {
-	Token string
+	Token string `json:"token"`
 }
 
 func NewAuthController(db ifaces.Database) *Auth {
 	return &Auth{db: db, servers: make(map[string]AuthServer)}
 }
 
+// Legacy wire format of the responses of the deprecated unversioned API,
+// field names are PascalCase and ids are numbers.
+
+type LegacyRegisterResponseData struct {
+	UserId int64
+}
+
+type LegacyLoginResponseData struct {
+	Server  string
+	Secret2 string
+}
+
+type LegacyLogin2ResponseData struct {
+	Secret4 string
+}
+
+func (rrd RegisterResponseData) legacy() any {
+	return LegacyRegisterResponseData{UserId: int64(rrd.UserId)}
+}
+
+func (lrd LoginResponseData) legacy() any {
+	return LegacyLoginResponseData{Server: lrd.Server, Secret2: lrd.Secret2}
+}
+
+func (l2r Login2ResponseData) legacy() any {
+	return LegacyLogin2ResponseData{Secret4: l2r.Secret4}
+}
+
+type legacyKey struct{}
+
+// legacyFormat makes the handler write responses in the legacy wire
+// format, see writeAuthResponse.
+func legacyFormat(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), legacyKey{}, true)))
+	}
+}
+
+// writeAuthResponse writes response in the wire format of the route.
+func writeAuthResponse[D AuthJsonEncoded](w http.ResponseWriter, r *http.Request, response D) {
+	legacy, _ := r.Context().Value(legacyKey{}).(bool)
+	if converter, ok := any(response).(interface{ legacy() any }); ok && legacy {
+		if err := json.NewEncoder(w).Encode(converter.legacy()); err != nil {
+			logger().Error("Can't write auth response", slog.Any("error", err))
+		}
+		return
+	}
+	if converter, ok := any(response).(interface{ v2() any }); ok && isV2(r) {
+		if err := json.NewEncoder(w).Encode(converter.v2()); err != nil {
+			logger().Error("Can't write auth response", slog.Any("error", err))
+		}
+		return
+	}
+	AuthEncodeAndWriteJson(w, response)
+}
+
+func (a *Auth) Routes() []Route {
+	return []Route{
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/register",
+			Name:     "register",
+			Summary:  "Register new user with SRP-6a salt and verifier",
+			Handler:  a.PostRegister,
+			Request:  RegisterRequestData{},
+			Response: RegisterResponseData{},
+			Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusInternalServerError},
+		},
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/login",
+			Name:     "login",
+			Summary:  "First SRP-6a login step: exchange A for B",
+			Handler:  a.PostLogin,
+			Request:  LoginRequestData{},
+			Response: LoginResponseData{},
+			Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge, http.StatusInternalServerError},
+		},
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/login2",
+			Name:     "login2",
+			Summary:  "Second SRP-6a login step: exchange M1 for M2",
+			Handler:  a.PostLogin2,
+			Request:  Login2RequestData{},
+			Response: Login2ResponseData{},
+			Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge, http.StatusInternalServerError},
+		},
+		{
+			Method:  http.MethodPost,
+			Pattern: "/logout",
+			Name:    "logout",
+			Summary: "Logout (not implemented yet)",
+			Handler: a.PostLogout,
+			Request: LogoutRequestData{},
+		},
+	}
+}
+
 func (a *Auth) Controller() chi.Router {
-	r := chi.NewRouter()
-	r.Post("/register", a.PostRegister)
-	r.Post("/login", a.PostLogin)
-	r.Post("/login2", a.PostLogin2)
-	r.Post("/logout", a.PostLogout)
-	return r
+	return NewRouter(a.Routes())
+}
+
+// LegacyRoutes are the routes of the deprecated unversioned API, their
+// responses keep the PascalCase field names the old clients read.
+func (a *Auth) LegacyRoutes() []Route {
+	routes := a.Routes()
+	for i := range routes {
+		routes[i].Handler = legacyFormat(routes[i].Handler)
+		if converter, ok := routes[i].Response.(interface{ legacy() any }); ok {
+			routes[i].Response = converter.legacy()
+		}
+	}
+	return routes
+}
+
+func (a *Auth) LegacyController() chi.Router {
+	return NewRouter(a.LegacyRoutes())
 }
 
 func (a *Auth) addServer(content string, record models.User) string {
@@ -131,27 +288,34 @@ func encodeServer(key []byte, verifier []byte, A []byte) string {
 	)
 }
 
-func decodeServer(input string) *srp.SRPServer {
+func decodeServer(input string) (*srp.SRPServer, error) {
 	e := strings.Split(input, ":")
-	if len(e) < 3 {
-		panic("Not expected elements count!")
+	if len(e) != 3 {
+		return nil, ErrWrongServerContext
+	}
+
+	decoded := make([][]byte, 0, len(e))
+	for _, element := range e {
+		value, err := AuthDecodeHexString(element)
+		if err != nil || len(value) == 0 {
+			return nil, ErrWrongServerContext
+		}
+		decoded = append(decoded, value)
 	}
 
-	key := AuthDecodeHexString(e[0])
-	verifier := AuthDecodeHexString(e[1])
-	A := AuthDecodeHexString(e[2])
+	key, verifier, A := decoded[0], decoded[1], decoded[2]
 
 	srv := srp.NewServer(SRP_PARAMS, verifier, key)
 	srv.SetA(A)
 
-	return srv
+	return srv, nil
 }
 
 func LogStringChecksum(name string, content string) {
 	checksum := HASH.New()
 	checksum.Write([]byte(content))
 
-	log.Printf("%s: %x", name, checksum.Sum(nil))
+	logger().Debug("checksum", slog.String("name", name), slog.String("sum", fmt.Sprintf("%x", checksum.Sum(nil))))
 }
 
 func (a *Auth) forgetServer(key string) {
@@ -179,32 +343,22 @@ func (a *Auth) PostRegister(w http.ResponseWriter, r *http.Request) {
 	var responseData RegisterResponseData
 
 	requestData := AuthDecodeJson[RegisterRequestData](r.Body, func(err error) {
-		log.Printf("Register request decoding error: %s", err)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		logger().Info("Register request decoding error", slog.Any("error", err))
+		WriteRequestError(w, r, err)
 	})
 	if requestData == nil {
 		return
 	}
 
+	logger().Debug("Register request", slog.Any("request", *requestData))
+
 	users, err := a.db.Users()
 	if err != nil {
-		log.Printf("Can't access to 'users' table: %s", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-
-	_, err = users.Find(func(record models.User) bool {
-		log.Printf(" --- %s", record.Login)
-		return record.Login == requestData.Login
-	})
-	if err == nil {
-		log.Printf("User with login '%s' already registered!", requestData.Login)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		logger().Error("Can't access to 'users' table", slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
 		return
 	}
 
-	log.Printf("Register data: %s", requestData.String())
-
 	// Table contains base64 encoded data
 	user := models.User{
 		Login:    requestData.Login,
@@ -212,20 +366,24 @@ func (a *Auth) PostRegister(w http.ResponseWriter, r *http.Request) {
 		Verifier: requestData.Verifier,
 	}
 
-	log.Printf("Register user: Login: '%s' Salt: '%s' Verifier: '%s'", user.Login, user.Salt, user.Verifier)
-
-	userId, err := users.Insert(user)
+	// Unique login index rejects concurrent registrations of the same login
+	userId, err := users.InsertContext(r.Context(), user)
+	if errors.Is(err, ifaces.ErrUniqueViolation) {
+		logger().Info("User already registered", slog.String("login", requestData.Login))
+		WriteError(w, r, http.StatusConflict, ErrorLoginTaken, "Login already taken")
+		return
+	}
 	if err != nil {
-		log.Printf("Can't insert data into database: %s", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		logger().Error("Can't insert data into database", slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
 		return
 	}
 
 	responseData.UserId = userId
 
-	log.Printf("Register response: %s", responseData.String())
+	logger().Info("User registered", slog.String("login", user.Login), slog.Int64("user_id", int64(userId)))
 
-	AuthEncodeAndWriteJson(w, responseData)
+	writeAuthResponse(w, r, responseData)
 }
 
 func (a *Auth) PostLogin(w http.ResponseWriter, r *http.Request) {
@@ -233,49 +391,54 @@ func (a *Auth) PostLogin(w http.ResponseWriter, r *http.Request) {
 	var responseData LoginResponseData
 
 	requestData := AuthDecodeJson[LoginRequestData](r.Body, func(err error) {
-		log.Printf("Login request decoding error: %s", err)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		logger().Info("Login request decoding error", slog.Any("error", err))
+		WriteRequestError(w, r, err)
 	})
 	if requestData == nil {
 		return
 	}
 
-	log.Printf("Login data: %s", requestData.String())
+	logger().Debug("Login request", slog.Any("request", *requestData))
 
 	users, err := a.db.Users()
 	if err != nil {
-		log.Printf("Can't access to 'users' table: %s", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		logger().Error("Can't access to 'users' table", slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
 		return
 	}
 
-	record, err := users.Find(func(u models.User) bool {
-		return u.Login == requestData.Login
-	})
+	record, err := ifaces.GetByContext(r.Context(), users, models.UserLoginIndex, requestData.Login)
 	if err != nil {
-		log.Printf("Can't find user record: %s", err)
-		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		logger().Info("Can't find user record", slog.String("login", requestData.Login), slog.Any("error", err))
+		WriteError(w, r, http.StatusForbidden, ErrorLoginFailed, "Wrong login or password")
 		return
 	}
 
-	log.Printf("record Salt: '%s' Verifier: '%s'", record.Salt, record.Verifier)
-
-	//salt := AuthDecodeString(record.Salt)
-	verifier := AuthDecodeString(record.Verifier)
+	logger().Debug("User record",
+		slog.Int64("user_id", int64(record.GetId())),
+		slog.Any("salt", Secret(record.Salt)),
+		slog.Any("verifier", Secret(record.Verifier)),
+	)
 
-	//log.Printf("salt: '%s' verifier: '%s'", salt, verifier)
+	verifier, err := AuthDecodeString(record.Verifier)
+	if err != nil {
+		logger().Error("Broken user verifier", slog.Int64("user_id", int64(record.GetId())), slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return
+	}
 
 	key := srp.GenKey()
-	A := AuthDecodeString(requestData.Secret1)
+	// Validated by LoginRequestData.Validate
+	A, _ := AuthDecodeString(requestData.Secret1)
 
 	srv := srp.NewServer(SRP_PARAMS, verifier, key)
 
 	responseData.Server = a.addServer(encodeServer(key, verifier, A), record)
 	responseData.Secret2 = AuthEncodeBytes(srv.ComputeB())
 
-	log.Printf("Login response: %s", responseData.String())
+	logger().Debug("Login response", slog.Any("response", responseData))
 
-	AuthEncodeAndWriteJson(w, responseData)
+	writeAuthResponse(w, r, responseData)
 }
 
 func (a *Auth) PostLogin2(w http.ResponseWriter, r *http.Request) {
@@ -283,42 +446,49 @@ func (a *Auth) PostLogin2(w http.ResponseWriter, r *http.Request) {
 	var responseData Login2ResponseData
 
 	requestData := AuthDecodeJson[Login2RequestData](r.Body, func(err error) {
-		log.Printf("Login request decoding error: %s", err)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		logger().Info("Login2 request decoding error", slog.Any("error", err))
+		WriteRequestError(w, r, err)
 	})
 	if requestData == nil {
 		return
 	}
 
-	log.Printf("Login2 data: %s", requestData.String())
+	logger().Debug("Login2 request", slog.Any("request", *requestData))
 
 	server, ok := a.getServer(requestData.Server)
 	if !ok {
-		log.Printf("Unknown srp server id: %s", requestData.Server)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		logger().Info("Unknown srp server id", slog.Any("server", Secret(requestData.Server)))
+		WriteError(w, r, http.StatusBadRequest, ErrorUnknownSession, "Unknown or expired login session")
 		return
 	}
 	// Forget SRP context
 	defer a.forgetServer(requestData.Server)
 
-	//log.Printf("Server to decode: %s", server.server)
+	srv, err := decodeServer(server.server)
+	if err != nil {
+		logger().Error("Can't restore srp server", slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return
+	}
 
-	srv := decodeServer(server.server)
+	// Validated by Login2RequestData.Validate
+	M1, _ := AuthDecodeString(requestData.Secret3)
 
-	serverM2, err := srv.CheckM1(AuthDecodeString(requestData.Secret3))
+	serverM2, err := srv.CheckM1(M1)
 	if err != nil {
-		log.Printf("Server M1 err: %s", err)
-		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		logger().Info("Login failed", slog.String("login", server.user.Login), slog.Any("error", err))
+		WriteError(w, r, http.StatusForbidden, ErrorLoginFailed, "Wrong login or password")
 		return
 	}
 
 	responseData.Secret4 = AuthEncodeBytes(serverM2)
 
-	log.Printf("Login2 response: %s", responseData.String())
+	logger().Debug("Login2 response", slog.Any("response", responseData))
+	logger().Debug("Session key", slog.Any("K", SecretBytes(srv.ComputeK())))
 
-	log.Printf("Server K: '%s'", AuthEncodeBytes(srv.ComputeK()))
+	logger().Info("User logged in", slog.String("login", server.user.Login), slog.Int64("user_id", int64(server.user.GetId())))
 
-	AuthEncodeAndWriteJson(w, responseData)
+	writeAuthResponse(w, r, responseData)
 }
 
 func (a *Auth) PostLogout(w http.ResponseWriter, r *http.Request) {
@@ -326,8 +496,8 @@ func (a *Auth) PostLogout(w http.ResponseWriter, r *http.Request) {
 
 	// 	decoder := json.NewDecoder(r.Body)
 	// 	if err := decoder.Decode(&requestData); err != nil {
-	// 		log.Printf("Logout request decoding error: %s", err)
-	// 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	// 		logger().Info("Logout request decoding error", slog.Any("error", err))
+	// 		WriteRequestError(w, r, err)
 	// 		return
 	// 	}
 
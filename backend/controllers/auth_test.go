@@ -1,10 +1,13 @@
 package controllers
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -32,6 +35,7 @@ func NewAuthTestServer(db ifaces.Database) *AuthTestServer {
 		r: chi.NewRouter(),
 		a: NewAuthController(db),
 	}
+	ret.r.Use(middleware.RequestID)
 	ret.r.Use(middleware.Logger)
 	ret.r.Mount("/", ret.a.Controller())
 	ret.ts = httptest.NewServer(ret.r)
@@ -123,7 +127,12 @@ func TestRegisterLogin(t *testing.T) {
 
 	t.Logf("Login response: %s", loginResponse.String())
 
-	srpClient.SetB(AuthDecodeString(loginResponse.Secret2))
+	B, err := AuthDecodeString(loginResponse.Secret2)
+	if err != nil {
+		t.Fatalf("Can't decode B! Error: %s", err)
+	}
+
+	srpClient.SetB(B)
 
 	login2Data := Login2RequestData{
 		Server:  loginResponse.Server,
@@ -140,10 +149,154 @@ func TestRegisterLogin(t *testing.T) {
 		return
 	}
 
-	err = srpClient.CheckM2(AuthDecodeString(login2Response.Secret4))
+	M2, err := AuthDecodeString(login2Response.Secret4)
+	if err != nil {
+		t.Fatalf("Can't decode M2! Error: %s", err)
+	}
+
+	err = srpClient.CheckM2(M2)
 	if err != nil {
 		t.Fatalf("Client check M2 err: %s", err)
 	}
 
 	t.Logf("Client K: '%s'", AuthEncodeBytes(srpClient.ComputeK()))
 }
+
+func decodeErrorResponse(t *testing.T, resp *http.Response) ErrorResponse {
+	var response ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Can't decode error response: %s", err)
+	}
+	if len(response.RequestId) == 0 {
+		t.Fatalf("Error response without request id: %v", response)
+	}
+	return response
+}
+
+func TestRegisterLoginTaken(t *testing.T) {
+
+	testServer := NewAuthTestServer(fake_database.NewDatabase())
+	defer testServer.Close()
+
+	testClient := testServer.NewClient("")
+
+	registerData := RegisterRequestData{
+		Login:    AuthEncodeBytes(testLogin),
+		Salt:     AuthEncodeBytes(testSalt),
+		Verifier: AuthEncodeBytes(srp.ComputeVerifier(SRP_PARAMS, testSalt, testLogin, testPassword)),
+	}
+
+	resp, err := testClient._Post("register", AuthEncodeJson(registerData))
+	ensureResponse(t, resp, err)
+
+	resp, err = testClient._Post("register", AuthEncodeJson(registerData))
+	if err != nil {
+		t.Fatalf("Register request error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Bad status code: %d", resp.StatusCode)
+	}
+	if response := decodeErrorResponse(t, resp); response.Code != ErrorLoginTaken {
+		t.Fatalf("Unexpected error code: %s", response.Code)
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+
+	testServer := NewAuthTestServer(fake_database.NewDatabase())
+	defer testServer.Close()
+
+	registerData := RegisterRequestData{
+		Login:    AuthEncodeBytes(testLogin),
+		Salt:     AuthEncodeBytes(testSalt),
+		Verifier: AuthEncodeBytes(srp.ComputeVerifier(SRP_PARAMS, testSalt, testLogin, testPassword)),
+	}
+
+	const clients = 8
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := testServer.NewClient("")._Post("register", AuthEncodeJson(registerData))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	registered := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			registered++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("Unexpected status: %d", status)
+		}
+	}
+	if registered != 1 {
+		t.Fatalf("Login is registered %d times", registered)
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+
+	testServer := NewAuthTestServer(fake_database.NewDatabase())
+	defer testServer.Close()
+
+	testClient := testServer.NewClient("")
+
+	tests := []struct {
+		query  string
+		body   string
+		status int
+		fields []string
+	}{
+		{"register", `{"login": "", "salt": "", "verifier": "!!!"}`, http.StatusBadRequest, []string{"login", "verifier"}},
+		{"register", `{"login": "Ym9i", "salt": "", "verifier": "AA=="}`, http.StatusBadRequest, []string{"verifier"}},
+		{"login", `{"login": "Ym9i", "secret1": "not base64"}`, http.StatusBadRequest, []string{"secret1"}},
+		{"login2", `{"server": "", "secret3": ""}`, http.StatusBadRequest, []string{"server", "secret3"}},
+		{"login", `{"login": "`, http.StatusBadRequest, nil},
+		{"login", `{"login": "` + strings.Repeat("A", MaxRequestBodySize) + `"}`, http.StatusRequestEntityTooLarge, nil},
+	}
+
+	for _, test := range tests {
+		func() {
+			resp, err := testClient._Post(test.query, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("Request error: %s", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				t.Fatalf("%s: expected status %d, got %d", test.query, test.status, resp.StatusCode)
+			}
+			if test.fields == nil {
+				return
+			}
+
+			response := decodeErrorResponse(t, resp)
+			if response.Code != ErrorValidation {
+				t.Fatalf("%s: unexpected error code: %s", test.query, response.Code)
+			}
+			if len(response.Fields) != len(test.fields) {
+				t.Fatalf("%s: expected errors for %v, got %v", test.query, test.fields, response.Fields)
+			}
+			for i, field := range test.fields {
+				if response.Fields[i].Field != field {
+					t.Fatalf("%s: expected error for %s, got %v", test.query, field, response.Fields[i])
+				}
+			}
+		}()
+	}
+}
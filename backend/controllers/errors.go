@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ErrorCode is a machine readable error reason returned to the clients.
+type ErrorCode string
+
+const (
+	ErrorBadRequest           ErrorCode = "bad_request"
+	ErrorValidation           ErrorCode = "validation_failed"
+	ErrorRequestTooLarge      ErrorCode = "request_too_large"
+	ErrorNotFound             ErrorCode = "not_found"
+	ErrorMethodNotAllowed     ErrorCode = "method_not_allowed"
+	ErrorLoginTaken           ErrorCode = "login_taken"
+	ErrorLoginFailed          ErrorCode = "login_failed"
+	ErrorUnknownSession       ErrorCode = "unknown_session"
+	ErrorPreconditionFailed   ErrorCode = "precondition_failed"
+	ErrorPreconditionRequired ErrorCode = "precondition_required"
+	ErrorConflict             ErrorCode = "conflict"
+	ErrorUnauthorized         ErrorCode = "unauthorized"
+	ErrorWatchExpired         ErrorCode = "watch_expired"
+	ErrorNotSupported         ErrorCode = "not_supported"
+	ErrorNotEmpty             ErrorCode = "database_not_empty"
+	ErrorUnknownTenant        ErrorCode = "unknown_tenant"
+	ErrorInternal             ErrorCode = "internal_error"
+)
+
+// ErrorResponse is the envelope of every API error response.
+type ErrorResponse struct {
+	Code      ErrorCode       `json:"code"`
+	Message   string          `json:"message"`
+	Fields    ValidationError `json:"fields,omitempty"`
+	RequestId string          `json:"requestId,omitempty"`
+}
+
+func (er ErrorResponse) Error() string {
+	return er.Message
+}
+
+// WriteError writes error response envelope with given status. If message
+// is empty, the status text is used.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	writeErrorResponse(w, r, status, ErrorResponse{Code: code, Message: message})
+}
+
+// WriteRequestError reports request decoding or validation error.
+func WriteRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	var invalid ValidationError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		WriteError(w, r, http.StatusRequestEntityTooLarge, ErrorRequestTooLarge, "")
+	case errors.As(err, &invalid):
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrorResponse{
+			Code:    ErrorValidation,
+			Message: "Request validation failed",
+			Fields:  invalid,
+		})
+	default:
+		WriteError(w, r, http.StatusBadRequest, ErrorBadRequest, "Malformed request")
+	}
+}
+
+// NotFound and MethodNotAllowed are router handlers producing error envelopes.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, http.StatusNotFound, ErrorNotFound, "")
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, http.StatusMethodNotAllowed, ErrorMethodNotAllowed, "")
+}
+
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, response ErrorResponse) {
+	if len(response.Message) == 0 {
+		response.Message = http.StatusText(status)
+	}
+	response.RequestId = middleware.GetReqID(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger().Error("Can't write error response", "error", err)
+	}
+}
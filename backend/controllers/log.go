@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+const (
+	RedactedValue = "[REDACTED]"
+)
+
+var (
+	currentLogger atomic.Pointer[slog.Logger]
+	protocolTrace atomic.Bool
+)
+
+// Secret is a protocol value (verifier, A, B, M1, M2, K...) which must
+// not reach the logs. It is rendered as RedactedValue unless protocol
+// tracing is enabled by EnableProtocolTrace.
+type Secret string
+
+func SecretBytes(input []byte) Secret {
+	return Secret(AuthEncodeBytes(input))
+}
+
+func (s Secret) String() string {
+	if !protocolTrace.Load() {
+		return RedactedValue
+	}
+	return string(s)
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// SetLogger sets the logger used by all controllers.
+func SetLogger(l *slog.Logger) {
+	currentLogger.Store(l)
+}
+
+// EnableProtocolTrace allows logging of Secret values in plaintext.
+// Development only!
+func EnableProtocolTrace(enable bool) {
+	protocolTrace.Store(enable)
+	if enable {
+		logger().Warn("SRP protocol tracing is ON, secrets will be logged in plaintext!")
+	}
+}
+
+func logger() *slog.Logger {
+	if l := currentLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
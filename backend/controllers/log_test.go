@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSecretsRedacted(t *testing.T) {
+	var buffer bytes.Buffer
+
+	SetLogger(slog.New(slog.NewTextHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SetLogger(nil)
+
+	request := LoginRequestData{Login: "bob", Secret1: "very secret A"}
+
+	logger().Debug("request", slog.Any("request", request))
+	if strings.Contains(buffer.String(), request.Secret1) {
+		t.Fatalf("Secret leaked into the log: %s", buffer.String())
+	}
+	if !strings.Contains(buffer.String(), RedactedValue) {
+		t.Fatalf("Secret is not redacted: %s", buffer.String())
+	}
+
+	buffer.Reset()
+
+	EnableProtocolTrace(true)
+	defer EnableProtocolTrace(false)
+
+	logger().Debug("request", slog.Any("request", request))
+	if !strings.Contains(buffer.String(), request.Secret1) {
+		t.Fatalf("Secret is not traced: %s", buffer.String())
+	}
+}
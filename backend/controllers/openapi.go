@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+const (
+	OpenAPIVersion  = "3.0.3"
+	jsonContentType = "application/json"
+)
+
+var (
+	pathParamRe = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+	timeType    = reflect.TypeOf(time.Time{})
+	bytesType   = reflect.TypeOf([]byte{})
+	sealedType  = reflect.TypeOf(models.Sealed{})
+	legacyType  = reflect.TypeOf(models.LegacyContacts{})
+)
+
+// OpenAPI is an OpenAPI 3 document generated from the controller routes.
+type OpenAPI struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       OpenAPIInfo                `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIPathItem maps lower case http method to operation.
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+type OpenAPIOperation struct {
+	OperationId string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+func NewOpenAPI(title string, version string) *OpenAPI {
+	return &OpenAPI{
+		OpenAPI:    OpenAPIVersion,
+		Info:       OpenAPIInfo{Title: title, Version: version},
+		Paths:      make(map[string]OpenAPIPathItem),
+		Components: OpenAPIComponents{Schemas: make(map[string]*OpenAPISchema)},
+	}
+}
+
+// OpenAPIPath converts chi route pattern to OpenAPI path: regexp
+// constraints of the path parameters are removed.
+func OpenAPIPath(pattern string) string {
+	return pathParamRe.ReplaceAllString(pattern, "{$1}")
+}
+
+// AddRoutes documents routes mounted at prefix.
+func (o *OpenAPI) AddRoutes(prefix string, tag string, deprecated bool, routes []Route) {
+	for _, route := range routes {
+		path := OpenAPIPath(prefix + route.Pattern)
+
+		operation := &OpenAPIOperation{
+			OperationId: operationId(tag, route.Name, deprecated),
+			Summary:     route.Summary,
+			Tags:        []string{tag},
+			Deprecated:  deprecated,
+			Responses:   make(map[string]OpenAPIResponse),
+		}
+
+		for _, match := range pathParamRe.FindAllStringSubmatch(route.Pattern, -1) {
+			operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &OpenAPISchema{Type: "string"},
+			})
+		}
+
+		if route.Request != nil {
+			operation.RequestBody = &OpenAPIRequestBody{
+				Required: true,
+				Content:  jsonContent(o.schema(reflect.TypeOf(route.Request))),
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := OpenAPIResponse{Description: http.StatusText(status)}
+		if route.Response != nil {
+			success.Content = jsonContent(o.schema(reflect.TypeOf(route.Response)))
+		}
+		operation.Responses[strconv.Itoa(status)] = success
+
+		for _, status := range route.Errors {
+			operation.Responses[strconv.Itoa(status)] = OpenAPIResponse{
+				Description: http.StatusText(status),
+				Content:     jsonContent(o.schema(reflect.TypeOf(ErrorResponse{}))),
+			}
+		}
+
+		item, ok := o.Paths[path]
+		if !ok {
+			item = make(OpenAPIPathItem)
+			o.Paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+}
+
+func (o *OpenAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		logger().Error("Can't write OpenAPI document", "error", err)
+	}
+}
+
+func operationId(tag string, name string, deprecated bool) string {
+	id := tag + upperFirst(name)
+	if deprecated {
+		id += "Deprecated"
+	}
+	return id
+}
+
+func upperFirst(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+func jsonContent(schema *OpenAPISchema) map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{jsonContentType: {Schema: schema}}
+}
+
+// schema returns schema of the type t. Named structures are placed to
+// the components and referenced.
+func (o *OpenAPI) schema(t reflect.Type) *OpenAPISchema {
+	switch {
+	case t == timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case t == bytesType:
+		return &OpenAPISchema{Type: "string", Format: "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := *o.schema(t.Elem())
+		if len(schema.Ref) > 0 {
+			// $ref siblings are ignored by OpenAPI 3.0
+			return &schema
+		}
+		schema.Nullable = true
+		return &schema
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &OpenAPISchema{Type: "array", Items: o.schema(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: o.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return o.structSchema(t)
+		}
+		ref := &OpenAPISchema{Ref: "#/components/schemas/" + t.Name()}
+		if _, ok := o.Components.Schemas[t.Name()]; !ok {
+			// Placeholder to stop recursion
+			o.Components.Schemas[t.Name()] = ref
+			o.Components.Schemas[t.Name()] = o.structSchema(t)
+		}
+		return ref
+	}
+	return &OpenAPISchema{}
+}
+
+func (o *OpenAPI) structSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	o.addFields(schema, t)
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// addFields adds properties of the structure fields following
+// encoding/json rules: fields of the embedded structures are shadowed by
+// the fields of the same name of the outer one.
+func (o *OpenAPI) addFields(schema *OpenAPISchema, t reflect.Type) {
+	var embedded []*OpenAPISchema
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		// Legacy contacts are not accepted nor returned by the API
+		if fieldType == legacyType {
+			continue
+		}
+		if field.Anonymous && len(name) == 0 {
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				e := &OpenAPISchema{Properties: make(map[string]*OpenAPISchema)}
+				o.addFields(e, fieldType)
+				embedded = append(embedded, e)
+				continue
+			}
+		}
+		// Sealed data is internal to the database
+		if !field.IsExported() || fieldType == sealedType {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		schema.Properties[name] = o.schema(fieldType)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	for _, e := range embedded {
+		for name, property := range e.Properties {
+			if _, ok := schema.Properties[name]; !ok {
+				schema.Properties[name] = property
+				if slices.Contains(e.Required, name) {
+					schema.Required = append(schema.Required, name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// ImportResult is the outcome of the imported record: id of the created
+// record or the error code of the skipped one.
+type ImportResult struct {
+	Id      *models.IdData `json:"id,omitempty"`
+	Code    ErrorCode      `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+// ImportResponse is the number of the created records and the results in
+// the order of the request records.
+type ImportResponse struct {
+	Created int            `json:"created"`
+	Results []ImportResult `json:"results"`
+}
+
+// Peoples serves the peoples records to the administrators authorized by
+// the bearer tokens, the records are disabled if there are no tokens.
+// Responses carry version of the record in the ETag header; updates and
+// deletes require the ETag the client has seen in the If-Match header, so
+// concurrent editors do not overwrite each other.
+type Peoples struct {
+	db     ifaces.Database
+	tokens bearerTokens
+}
+
+func NewPeoplesController(db ifaces.Database, tokens []string) *Peoples {
+	return &Peoples{db: db, tokens: newBearerTokens(tokens)}
+}
+
+func (p *Peoples) Routes() []Route {
+	return []Route{
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/",
+			Name:     "create",
+			Summary:  "Create people record",
+			Handler:  p.tokens.authorized(p.PostPeople),
+			Request:  models.People{},
+			Response: models.People{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusRequestEntityTooLarge,
+				http.StatusInternalServerError},
+		},
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/import",
+			Name:     "import",
+			Summary:  "Create people records of the array, results are in the order of the records",
+			Handler:  p.tokens.authorized(p.PostImport),
+			Request:  []models.People{},
+			Response: ImportResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusRequestEntityTooLarge,
+				http.StatusInternalServerError},
+			// Records carry photos
+			BodyLimit: MaxImportBodySize,
+		},
+		{
+			Method:   http.MethodGet,
+			Pattern:  "/{id:[0-9A-Za-z-]+}",
+			Name:     "get",
+			Summary:  "Get people record, ETag is the version of the record",
+			Handler:  p.tokens.authorized(p.GetPeople),
+			Response: models.People{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError},
+		},
+		{
+			Method:   http.MethodPut,
+			Pattern:  "/{id:[0-9A-Za-z-]+}",
+			Name:     "update",
+			Summary:  "Update people record of the version given by If-Match",
+			Handler:  p.tokens.authorized(p.PutPeople),
+			Request:  models.People{},
+			Response: models.People{},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusPreconditionFailed,
+				http.StatusPreconditionRequired, http.StatusRequestEntityTooLarge, http.StatusInternalServerError},
+		},
+		{
+			Method:  http.MethodDelete,
+			Pattern: "/{id:[0-9A-Za-z-]+}",
+			Name:    "delete",
+			Summary: "Delete people record of the version given by If-Match",
+			Handler: p.tokens.authorized(p.DeletePeople),
+			Status:  http.StatusNoContent,
+			Errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusPreconditionFailed,
+				http.StatusPreconditionRequired, http.StatusInternalServerError},
+		},
+	}
+}
+
+func (p *Peoples) Controller() chi.Router {
+	return NewRouter(p.Routes())
+}
+
+// ETag returns strong entity tag of the record version.
+func ETag(version models.VersionData) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch returns the version of the single strong entity tag of the
+// If-Match header. present is false if there is no header.
+func ifMatch(r *http.Request) (version models.VersionData, present bool, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if len(header) == 0 {
+		return 0, false, false
+	}
+	tag, err := strconv.Unquote(header)
+	if err != nil || !strings.HasPrefix(header, `"`) {
+		return 0, true, false
+	}
+	version, err = strconv.ParseInt(tag, 10, 64)
+	return version, true, err == nil
+}
+
+// precondition returns version required by the request. Writes error
+// response and returns false if there is no valid If-Match header.
+func precondition(w http.ResponseWriter, r *http.Request) (models.VersionData, bool) {
+	version, present, ok := ifMatch(r)
+	if !present {
+		WriteError(w, r, http.StatusPreconditionRequired, ErrorPreconditionRequired, "If-Match header is required")
+		return 0, false
+	}
+	if !ok {
+		WriteError(w, r, http.StatusPreconditionFailed, ErrorPreconditionFailed, "If-Match must be the single ETag of the record")
+		return 0, false
+	}
+	return version, true
+}
+
+// peopleId returns id of the request URL, see models.FormatId. Writes not
+// found response and returns false if the id is malformed.
+func peopleId(w http.ResponseWriter, r *http.Request) (models.IdData, bool) {
+	id, err := models.ParseId(chi.URLParam(r, "id"))
+	if err != nil {
+		WriteError(w, r, http.StatusNotFound, ErrorNotFound, "")
+		return models.BAD_ID, false
+	}
+	return id, true
+}
+
+func writePeople(w http.ResponseWriter, r *http.Request, record models.People) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("ETag", ETag(record.GetVersion()))
+	if err := json.NewEncoder(w).Encode(wireValue(r, record)); err != nil {
+		logger().Error("Can't write people record", slog.Any("error", err))
+	}
+}
+
+func decodePeople(w http.ResponseWriter, r *http.Request) (models.People, bool) {
+	var record models.People
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		logger().Info("People request decoding error", slog.Any("error", err))
+		WriteRequestError(w, r, err)
+		return record, false
+	}
+	var fv fieldsValidator
+	noContacts(&fv, "", record.LegacyContacts)
+	if err := fv.result(); err != nil {
+		WriteRequestError(w, r, err)
+		return record, false
+	}
+	// Sealed data is written by the database only
+	record.Sealed = nil
+	return record, true
+}
+
+// noContacts fails the contacts of the people record: they are kept in
+// their own tables, not written with the record.
+func noContacts(fv *fieldsValidator, prefix string, contacts models.LegacyContacts) {
+	for _, field := range []struct {
+		name  string
+		count int
+	}{
+		{"Phones", len(contacts.Phones)},
+		{"Addresses", len(contacts.Addresses)},
+		{"Emails", len(contacts.Emails)},
+		{"BankAccounts", len(contacts.BankAccounts)},
+	} {
+		if field.count > 0 {
+			fv.fail(prefix+field.name, "must be empty, contacts are not written with the people record")
+		}
+	}
+}
+
+func decodeImport(w http.ResponseWriter, r *http.Request) ([]models.People, bool) {
+	var records []models.People
+	if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+		logger().Info("Import request decoding error", slog.Any("error", err))
+		WriteRequestError(w, r, err)
+		return nil, false
+	}
+	if len(records) > MaxImportRecords {
+		var fv fieldsValidator
+		fv.fail("records", "must not be more than %d", MaxImportRecords)
+		WriteRequestError(w, r, fv.result())
+		return nil, false
+	}
+	var fv fieldsValidator
+	for i := range records {
+		noContacts(&fv, "records["+strconv.Itoa(i)+"].", records[i].LegacyContacts)
+		records[i].Sealed = nil
+	}
+	if err := fv.result(); err != nil {
+		WriteRequestError(w, r, err)
+		return nil, false
+	}
+	return records, true
+}
+
+func (p *Peoples) table(w http.ResponseWriter, r *http.Request) (ifaces.Table[models.People], bool) {
+	peoples, err := p.db.Peoples()
+	if err != nil {
+		logger().Error("Can't access to 'peoples' table", slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return nil, false
+	}
+	return peoples, true
+}
+
+// writeTableError reports errors of the peoples table operations.
+func writeTableError(w http.ResponseWriter, r *http.Request, id models.IdData, err error) {
+	switch {
+	case errors.Is(err, ifaces.ErrNoSuchRecord):
+		WriteError(w, r, http.StatusNotFound, ErrorNotFound, "")
+	case errors.Is(err, ifaces.ErrConflict):
+		logger().Info("People record version conflict", slog.Int64("people_id", int64(id)))
+		WriteError(w, r, http.StatusPreconditionFailed, ErrorPreconditionFailed, "Record is changed by someone else")
+	default:
+		logger().Error("Peoples table error", slog.Int64("people_id", int64(id)), slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+	}
+}
+
+func (p *Peoples) PostPeople(w http.ResponseWriter, r *http.Request) {
+	record, ok := decodePeople(w, r)
+	if !ok {
+		return
+	}
+
+	peoples, ok := p.table(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := peoples.InsertContext(r.Context(), record)
+	if err != nil {
+		writeTableError(w, r, id, err)
+		return
+	}
+	record.SetId(id)
+	record.SetVersion(models.FIRST_VERSION)
+
+	logger().Info("People record created", slog.Int64("people_id", int64(id)))
+
+	w.Header().Set("Location", path.Join(r.URL.Path, models.FormatId(id)))
+	writePeople(w, r, record)
+}
+
+// importResult converts result of the bulk insert, see
+// ifaces.IsRecordError.
+func importResult(result ifaces.Result) ImportResult {
+	switch {
+	case result.Err == nil:
+		return ImportResult{Id: &result.Id}
+	case errors.Is(result.Err, ifaces.ErrUniqueViolation), errors.Is(result.Err, ifaces.ErrConflict):
+		return ImportResult{Code: ErrorConflict, Message: "Record conflicts with the stored one"}
+	default:
+		return ImportResult{Code: ErrorValidation, Message: "Record is rejected by the database"}
+	}
+}
+
+// PostImport creates the records of the array under the single
+// transaction of the database. Records rejected by the database are
+// skipped and reported in their results, the others are created.
+func (p *Peoples) PostImport(w http.ResponseWriter, r *http.Request) {
+	records, ok := decodeImport(w, r)
+	if !ok {
+		return
+	}
+
+	peoples, ok := p.table(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := peoples.InsertManyContext(r.Context(), records)
+	if err != nil {
+		writeTableError(w, r, models.BAD_ID, err)
+		return
+	}
+	response := ImportResponse{Results: make([]ImportResult, 0, len(results))}
+	for _, result := range results {
+		if result.Err == nil {
+			response.Created++
+		} else {
+			logger().Info("People record is not imported", slog.Any("error", result.Err))
+		}
+		response.Results = append(response.Results, importResult(result))
+	}
+
+	logger().Info("People records imported", slog.Int("created", response.Created), slog.Int("records", len(records)))
+
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(wireValue(r, response)); err != nil {
+		logger().Error("Can't write import response", slog.Any("error", err))
+	}
+}
+
+func (p *Peoples) GetPeople(w http.ResponseWriter, r *http.Request) {
+	peoples, ok := p.table(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := peopleId(w, r)
+	if !ok {
+		return
+	}
+	record, err := peoples.GetContext(r.Context(), id)
+	if err != nil {
+		writeTableError(w, r, id, err)
+		return
+	}
+
+	writePeople(w, r, record)
+}
+
+func (p *Peoples) PutPeople(w http.ResponseWriter, r *http.Request) {
+	version, ok := precondition(w, r)
+	if !ok {
+		return
+	}
+
+	record, ok := decodePeople(w, r)
+	if !ok {
+		return
+	}
+
+	peoples, ok := p.table(w, r)
+	if !ok {
+		return
+	}
+
+	// Id and version of the body are ignored
+	id, ok := peopleId(w, r)
+	if !ok {
+		return
+	}
+	record.SetId(id)
+	record.SetVersion(version)
+
+	if err := peoples.UpdateContext(r.Context(), record); err != nil {
+		writeTableError(w, r, id, err)
+		return
+	}
+	record.SetVersion(version + 1)
+
+	logger().Info("People record updated", slog.Int64("people_id", int64(id)), slog.Int64("version", version+1))
+
+	writePeople(w, r, record)
+}
+
+func (p *Peoples) DeletePeople(w http.ResponseWriter, r *http.Request) {
+	version, ok := precondition(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := peopleId(w, r)
+	if !ok {
+		return
+	}
+	err := p.db.Tx(r.Context(), func(tx ifaces.Tx) error {
+		peoples, err := tx.Peoples()
+		if err != nil {
+			return err
+		}
+		record, err := peoples.GetContext(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if record.GetVersion() != version {
+			return ifaces.ErrConflict
+		}
+		return peoples.DeleteContext(r.Context(), id)
+	})
+	if err != nil {
+		writeTableError(w, r, id, err)
+		return
+	}
+
+	logger().Info("People record deleted", slog.Int64("people_id", int64(id)))
+
+	w.WriteHeader(http.StatusNoContent)
+}
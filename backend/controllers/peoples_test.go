@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// peoplesRequest sends the request of the administrator.
+func peoplesRequest(t *testing.T, method string, url string, etag string, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	if len(etag) > 0 {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func expectStatus(t *testing.T, resp *http.Response, status int) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d", resp.Request.Method, resp.Request.URL.Path, status, resp.StatusCode)
+	}
+}
+
+func decodePeopleResponse(t *testing.T, resp *http.Response) models.People {
+	t.Helper()
+
+	var record models.People
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		t.Fatalf("Can't decode response: %s", err)
+	}
+	if etag := resp.Header.Get("ETag"); etag != ETag(record.GetVersion()) {
+		t.Fatalf("ETag '%s' does not match version %d", etag, record.GetVersion())
+	}
+	return record
+}
+
+func TestPeoplesConditionalUpdate(t *testing.T) {
+	ts := httptest.NewServer(NewAPIRouter(fake_database.NewDatabase(), APIConfig{AdminTokens: []string{testAdminToken}}))
+	defer ts.Close()
+
+	base := ts.URL + "/" + APIVersion + "/peoples"
+
+	resp := peoplesRequest(t, http.MethodPost, base+"/", "", `{"Name":"John"}`)
+	expectStatus(t, resp, http.StatusOK)
+	created := decodePeopleResponse(t, resp)
+	url := ts.URL + resp.Header.Get("Location")
+	if url != base+"/"+models.FormatId(created.GetId()) {
+		t.Fatalf("Unexpected location: '%s'", url)
+	}
+
+	resp = peoplesRequest(t, http.MethodGet, url, "", "")
+	expectStatus(t, resp, http.StatusOK)
+	first := resp.Header.Get("ETag")
+
+	// Decimal ids of the old URLs
+	resp = peoplesRequest(t, http.MethodGet, fmt.Sprintf("%s/%d", base, created.GetId()), "", "")
+	expectStatus(t, resp, http.StatusOK)
+	resp = peoplesRequest(t, http.MethodGet, base+"/ZZZZZZZZZZZZZ", "", "")
+	expectStatus(t, resp, http.StatusNotFound)
+
+	// Both editors have seen the first version, second one loses
+	resp = peoplesRequest(t, http.MethodPut, url, first, `{"Name":"Alice"}`)
+	expectStatus(t, resp, http.StatusOK)
+	updated := decodePeopleResponse(t, resp)
+	if updated.Name != "Alice" || updated.GetVersion() != created.GetVersion()+1 {
+		t.Fatalf("Unexpected record: %+v", updated)
+	}
+
+	resp = peoplesRequest(t, http.MethodPut, url, first, `{"Name":"Bob"}`)
+	expectStatus(t, resp, http.StatusPreconditionFailed)
+
+	resp = peoplesRequest(t, http.MethodGet, url, "", "")
+	expectStatus(t, resp, http.StatusOK)
+	if stored := decodePeopleResponse(t, resp); stored.Name != "Alice" {
+		t.Fatalf("Update is overwritten: %+v", stored)
+	}
+
+	// Unconditional and malformed requests are rejected
+	resp = peoplesRequest(t, http.MethodPut, url, "", `{"Name":"Bob"}`)
+	expectStatus(t, resp, http.StatusPreconditionRequired)
+	resp = peoplesRequest(t, http.MethodPut, url, "*", `{"Name":"Bob"}`)
+	expectStatus(t, resp, http.StatusPreconditionFailed)
+	resp = peoplesRequest(t, http.MethodDelete, url, "", "")
+	expectStatus(t, resp, http.StatusPreconditionRequired)
+
+	resp = peoplesRequest(t, http.MethodDelete, url, first, "")
+	expectStatus(t, resp, http.StatusPreconditionFailed)
+	resp = peoplesRequest(t, http.MethodDelete, url, ETag(updated.GetVersion()), "")
+	expectStatus(t, resp, http.StatusNoContent)
+
+	resp = peoplesRequest(t, http.MethodGet, url, "", "")
+	expectStatus(t, resp, http.StatusNotFound)
+	resp = peoplesRequest(t, http.MethodPut, url, first, `{"Name":"Bob"}`)
+	expectStatus(t, resp, http.StatusNotFound)
+}
+
+func TestPeoplesIdForms(t *testing.T) {
+	ts := httptest.NewServer(NewAPIRouter(fake_database.NewDatabase(), APIConfig{AdminTokens: []string{testAdminToken}}))
+	defer ts.Close()
+
+	resp := peoplesRequest(t, http.MethodPost, ts.URL+"/"+APIVersion+"/peoples/", "", `{"Name":"John"}`)
+	expectStatus(t, resp, http.StatusOK)
+	created := decodePeopleResponse(t, resp)
+
+	tests := []struct {
+		version string
+		id      any
+	}{
+		{APIVersion1, float64(created.GetId())},
+		{APIVersion, models.FormatId(created.GetId())},
+	}
+	for _, test := range tests {
+		resp := peoplesRequest(t, http.MethodGet, fmt.Sprintf("%s/%s/peoples/%d", ts.URL, test.version, created.GetId()), "", "")
+		expectStatus(t, resp, http.StatusOK)
+		var record map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+			t.Fatalf("Can't decode response: %s", err)
+		}
+		if record["Id"] != test.id {
+			t.Fatalf("%s: unexpected id %#v", test.version, record["Id"])
+		}
+	}
+}
+
+func TestPeoplesUnauthorized(t *testing.T) {
+	db := fake_database.NewDatabase()
+	peoples, _ := db.Peoples()
+	id, _ := peoples.Insert(models.People{Name: "John"})
+
+	ts := httptest.NewServer(NewAPIRouter(db, APIConfig{AdminTokens: []string{testAdminToken}}))
+	defer ts.Close()
+	disabled := httptest.NewServer(NewAPIRouter(db, APIConfig{}))
+	defer disabled.Close()
+
+	request := func(method string, url string, token string, body string) *http.Response {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request error: %s", err)
+		}
+		req.Header.Set("If-Match", ETag(models.FIRST_VERSION))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request error: %s", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, server := range []string{ts.URL, disabled.URL} {
+		base := server + "/" + APIVersion + "/peoples/"
+		url := base + models.FormatId(id)
+		for _, token := range []string{"", "wrong", testAdminToken} {
+			if server == ts.URL && token == testAdminToken {
+				continue
+			}
+			expectStatus(t, request(http.MethodPost, base, token, `{"Name":"Bob"}`), http.StatusUnauthorized)
+			expectStatus(t, request(http.MethodGet, url, token, ""), http.StatusUnauthorized)
+			expectStatus(t, request(http.MethodPut, url, token, `{"Name":"Bob"}`), http.StatusUnauthorized)
+			expectStatus(t, request(http.MethodDelete, url, token, ""), http.StatusUnauthorized)
+		}
+	}
+
+	// Nothing is changed
+	record, err := peoples.Get(id)
+	if err != nil || record.Name != "John" || record.GetVersion() != models.FIRST_VERSION {
+		t.Fatalf("Unexpected record: %+v, error: %v", record, err)
+	}
+	if _, err := peoples.Find(func(record models.People) bool { return record.Name == "Bob" }); err == nil {
+		t.Fatalf("Record is created by unauthorized request")
+	}
+}
+
+func TestPeoplesImport(t *testing.T) {
+	db := fake_database.NewDatabase()
+	peoples, _ := db.Peoples()
+	ts := httptest.NewServer(NewAPIRouter(db, APIConfig{AdminTokens: []string{testAdminToken}}))
+	defer ts.Close()
+
+	base := ts.URL + "/" + APIVersion + "/peoples"
+
+	// Contacts are not written with the people records
+	resp := peoplesRequest(t, http.MethodPost, base+"/import", "", `[{"Name":"John"},{"Name":"Alice","Emails":[{"Mail":"a@b.c"}]}]`)
+	expectStatus(t, resp, http.StatusBadRequest)
+	var failure ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil {
+		t.Fatalf("Can't decode response: %s", err)
+	}
+	if len(failure.Fields) != 1 || failure.Fields[0].Field != "records[1].Emails" {
+		t.Fatalf("Unexpected response: %+v", failure)
+	}
+	resp = peoplesRequest(t, http.MethodPost, base+"/", "", `{"Name":"John","Phones":[{"Phone":"1"}]}`)
+	expectStatus(t, resp, http.StatusBadRequest)
+	if err := peoples.Each(func(models.People) bool { return true }); !errors.Is(err, ifaces.ErrEmptyTable) {
+		t.Fatalf("Records are written: %v", err)
+	}
+
+	resp = peoplesRequest(t, http.MethodPost, base+"/import", "", `[{"Name":"John","Phones":[]},{"Name":"Alice","Sealed":"a2V5"}]`)
+	expectStatus(t, resp, http.StatusOK)
+	var response ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Can't decode response: %s", err)
+	}
+	if response.Created != 2 || len(response.Results) != 2 {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	for i, name := range []string{"John", "Alice"} {
+		result := response.Results[i]
+		if len(result.Code) > 0 || result.Id == nil {
+			t.Fatalf("Record %d is not imported: %+v", i, result)
+		}
+		resp = peoplesRequest(t, http.MethodGet, base+"/"+models.FormatId(*result.Id), "", "")
+		expectStatus(t, resp, http.StatusOK)
+		record := decodePeopleResponse(t, resp)
+		if record.Name != name || record.Sealed != nil || !record.LegacyContacts.Empty() {
+			t.Fatalf("Unexpected record: %+v", record)
+		}
+	}
+
+	resp = peoplesRequest(t, http.MethodPost, base+"/import", "", `[]`)
+	expectStatus(t, resp, http.StatusOK)
+
+	for _, token := range []string{"", "wrong"} {
+		resp = adminRequest(t, http.MethodPost, base+"/import", token, strings.NewReader(`[{"Name":"Bob"}]`))
+		expectStatus(t, resp, http.StatusUnauthorized)
+	}
+	imported := 0
+	for record, err := range ifaces.All(context.Background(), peoples) {
+		if err != nil {
+			t.Fatalf("All error: %s", err)
+		}
+		if record.Name == "Bob" {
+			t.Fatalf("Record is imported by unauthorized request")
+		}
+		imported++
+	}
+	if imported != 2 {
+		t.Fatalf("Expected 2 records, got %d", imported)
+	}
+
+	resp = peoplesRequest(t, http.MethodPost, base+"/import", "", `{"Name":"John"}`)
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	resp = peoplesRequest(t, http.MethodPost, base+"/import", "", "["+strings.Repeat(`{},`, MaxImportRecords)+"{}]")
+	expectStatus(t, resp, http.StatusBadRequest)
+	var errResponse ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResponse); err != nil {
+		t.Fatalf("Can't decode response: %s", err)
+	}
+	if errResponse.Code != ErrorValidation {
+		t.Fatalf("Unexpected error code: %s", errResponse.Code)
+	}
+}
+
+func TestPeoplesActor(t *testing.T) {
+	db := fake_database.NewDatabase()
+	peoples, _ := db.Peoples()
+	ts := httptest.NewServer(NewAPIRouter(db, APIConfig{AdminTokens: []string{"alice alice-secret", testAdminToken}}))
+	defer ts.Close()
+
+	resp := adminRequest(t, http.MethodPost, ts.URL+"/"+APIVersion+"/peoples/", "alice-secret", strings.NewReader(`{"Name":"John"}`))
+	expectStatus(t, resp, http.StatusOK)
+	created := decodePeopleResponse(t, resp)
+
+	// Changes made with the unnamed token get its fingerprint
+	resp = peoplesRequest(t, http.MethodPut, ts.URL+resp.Header.Get("Location"), ETag(created.GetVersion()), `{"Name":"Bob"}`)
+	expectStatus(t, resp, http.StatusOK)
+
+	revisions, err := peoples.HistoryContext(context.Background(), created.GetId())
+	if err != nil {
+		t.Fatalf("History error: %s", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, got %d", len(revisions))
+	}
+	if revisions[0].Actor != "alice" {
+		t.Fatalf("Unexpected actor of the insert: '%s'", revisions[0].Actor)
+	}
+	if actor := revisions[1].Actor; !strings.HasPrefix(actor, "token-") || strings.Contains(actor, testAdminToken) {
+		t.Fatalf("Unexpected actor of the update: '%s'", actor)
+	}
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Route describes single API endpoint. Controllers describe their endpoints
+// as routes, the same description is used to build the router and the
+// OpenAPI specification, so they never diverge.
+type Route struct {
+	Method  string
+	Pattern string
+	Name    string
+	Summary string
+	Handler http.HandlerFunc
+
+	// Zero values of the request and response body types, nil if
+	// endpoint has no body.
+	Request  any
+	Response any
+	// Status of the successful response, http.StatusOK if 0.
+	Status int
+
+	// Documented error statuses.
+	Errors []int
+
+	// Limit of the request body size, MaxRequestBodySize if 0, no limit if
+	// negative.
+	BodyLimit int64
+}
+
+// NewRouter builds router serving given routes.
+func NewRouter(routes []Route) chi.Router {
+	r := chi.NewRouter()
+	r.NotFound(NotFound)
+	r.MethodNotAllowed(MethodNotAllowed)
+	for _, route := range routes {
+		r.Method(route.Method, route.Pattern, limitRequestBody(route.BodyLimit, route.Handler))
+	}
+	return r
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/tenants"
+)
+
+// TenantRouter serves the API of the tenant of the request. Every tenant
+// has its own API router over its own database, so the records and the
+// sessions of the tenant are never seen by the others.
+type TenantRouter struct {
+	resolver *tenants.Resolver
+	routers  map[string]http.Handler
+}
+
+// NewTenantRouter returns router of the tenants of the registry resolved
+// by resolver, config returns the API configuration of the tenant. It is
+// expected to be mounted at /api.
+func NewTenantRouter(registry *tenants.Registry, resolver *tenants.Resolver, config func(tenant string, db ifaces.Database) APIConfig) (*TenantRouter, error) {
+	tr := &TenantRouter{resolver: resolver, routers: make(map[string]http.Handler)}
+	for _, tenant := range registry.Tenants() {
+		db, err := registry.Database(tenant)
+		if err != nil {
+			return nil, err
+		}
+		tr.routers[tenant] = NewAPIRouter(db, config(tenant, db))
+	}
+	return tr, nil
+}
+
+func (tr *TenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant, err := tr.resolver.Resolve(r)
+	if err == nil {
+		if router, ok := tr.routers[tenant]; ok {
+			router.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	logger().Info("Unknown tenant", slog.String("host", r.Host), slog.String("path", r.URL.Path))
+	WriteError(w, r, http.StatusNotFound, ErrorUnknownTenant, "")
+}
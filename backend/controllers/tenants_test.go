@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kong/go-srp"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/tenants"
+)
+
+func tenantRequest(t *testing.T, method string, url string, host string, token string, body io.Reader) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	req.Host = host
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestTenantIsolation(t *testing.T) {
+	list := []tenants.Tenant{{Id: "acme", Hosts: []string{"acme.test"}}, {Id: "globex", Hosts: []string{"globex.test"}}}
+	registry, err := tenants.Open(context.Background(), list, func(ctx context.Context, id string) (ifaces.Database, error) {
+		return fake_database.NewDatabase(), nil
+	})
+	if err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	defer registry.Close()
+	resolver, err := tenants.NewResolver(list, "")
+	if err != nil {
+		t.Fatalf("Resolver error: %s", err)
+	}
+	// Every tenant has its own administrators
+	tokens := map[string]string{"acme": "acme-secret", "globex": "globex-secret"}
+	router, err := NewTenantRouter(registry, resolver, func(tenant string, db ifaces.Database) APIConfig {
+		return APIConfig{AdminTokens: []string{tokens[tenant]}}
+	})
+	if err != nil {
+		t.Fatalf("Router error: %s", err)
+	}
+	ts := httptest.NewServer(middleware.RequestID(router))
+	defer ts.Close()
+
+	auth := ts.URL + "/" + APIVersion + "/auth/"
+	register := func() io.Reader {
+		return AuthEncodeJson(RegisterRequestData{
+			Login:    AuthEncodeBytes(testLogin),
+			Salt:     AuthEncodeBytes(testSalt),
+			Verifier: AuthEncodeBytes(srp.ComputeVerifier(SRP_PARAMS, testSalt, testLogin, testPassword)),
+		})
+	}
+	login := func() io.Reader {
+		client := srp.NewClient(SRP_PARAMS, testSalt, testLogin, testPassword, srp.GenKey())
+		return AuthEncodeJson(LoginRequestData{Login: AuthEncodeBytes(testLogin), Secret1: AuthEncodeBytes(client.ComputeA())})
+	}
+
+	// User of one tenant is unknown to the other, and its login is free
+	expectStatus(t, tenantRequest(t, http.MethodPost, auth+"register", "acme.test", tokens["acme"], register()), http.StatusOK)
+	expectStatus(t, tenantRequest(t, http.MethodPost, auth+"login", "acme.test", tokens["acme"], login()), http.StatusOK)
+	expectStatus(t, tenantRequest(t, http.MethodPost, auth+"login", "globex.test", tokens["globex"], login()), http.StatusForbidden)
+	expectStatus(t, tenantRequest(t, http.MethodPost, auth+"register", "globex.test", tokens["globex"], register()), http.StatusOK)
+
+	// People of one tenant is not found by the other
+	peoples := ts.URL + "/" + APIVersion + "/peoples/"
+	resp := tenantRequest(t, http.MethodPost, peoples, "acme.test", tokens["acme"], strings.NewReader(`{"Name":"John"}`))
+	expectStatus(t, resp, http.StatusOK)
+	url := ts.URL + resp.Header.Get("Location")
+	expectStatus(t, tenantRequest(t, http.MethodGet, url, "acme.test", tokens["acme"], nil), http.StatusOK)
+	expectStatus(t, tenantRequest(t, http.MethodGet, url, "globex.test", tokens["globex"], nil), http.StatusNotFound)
+
+	// Token of one tenant is not authorized by the other
+	expectStatus(t, tenantRequest(t, http.MethodGet, url, "globex.test", tokens["acme"], nil), http.StatusUnauthorized)
+	expectStatus(t, tenantRequest(t, http.MethodPost, peoples, "globex.test", tokens["acme"], strings.NewReader(`{"Name":"John"}`)), http.StatusUnauthorized)
+
+	resp = tenantRequest(t, http.MethodGet, peoples+"1", "initech.test", tokens["acme"], nil)
+	expectStatus(t, resp, http.StatusNotFound)
+	if response := decodeErrorResponse(t, resp); response.Code != ErrorUnknownTenant {
+		t.Fatalf("Unexpected error code: %s", response.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+// bearerToken is the token of the client. Name is the actor of the changes
+// made by the client, see ifaces.WithActor.
+type bearerToken struct {
+	name   string
+	secret []byte
+}
+
+// bearerTokens authorize the clients of the service endpoints, e.g.
+// change feeds. The endpoints are disabled if there are no tokens.
+type bearerTokens []bearerToken
+
+// newBearerTokens returns the tokens of the "name token" or "token"
+// entries. The unnamed token is named by its fingerprint, so the actor
+// is known without keeping the secret in the history.
+func newBearerTokens(tokens []string) bearerTokens {
+	var ret bearerTokens
+	for _, token := range tokens {
+		fields := strings.Fields(token)
+		switch len(fields) {
+		case 1:
+			sum := sha256.Sum256([]byte(fields[0]))
+			ret = append(ret, bearerToken{name: "token-" + hex.EncodeToString(sum[:4]), secret: []byte(fields[0])})
+		case 2:
+			ret = append(ret, bearerToken{name: fields[0], secret: []byte(fields[1])})
+		default:
+			logger().Warn("Malformed bearer token entry, ignored")
+		}
+	}
+	return ret
+}
+
+// authorized passes requests with the known bearer token to next, the
+// name of the token is the actor of the request.
+func (bt bearerTokens) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			if name, known := bt.known([]byte(strings.TrimSpace(token))); known {
+				next(w, r.WithContext(ifaces.WithActor(r.Context(), name)))
+				return
+			}
+		}
+		logger().Info("Unauthorized request", slog.String("path", r.URL.Path))
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		WriteError(w, r, http.StatusUnauthorized, ErrorUnauthorized, "")
+	}
+}
+
+// known compares token with all tokens in constant time and returns name
+// of the matching one.
+func (bt bearerTokens) known(token []byte) (string, bool) {
+	name := ""
+	found := 0
+	for _, known := range bt {
+		match := subtle.ConstantTimeCompare(token, known.secret)
+		if match == 1 {
+			name = known.name
+		}
+		found |= match
+	}
+	return name, len(token) > 0 && found == 1
+}
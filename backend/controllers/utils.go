@@ -12,24 +12,16 @@ type AuthJsonEncoded interface {
 	RegisterRequestData | RegisterResponseData | LoginRequestData | LoginResponseData | Login2RequestData | Login2ResponseData
 }
 
-func AuthDecodeString(input string) []byte {
-	ret, err := base64.StdEncoding.DecodeString(input)
-	if err != nil {
-		panic(err)
-	}
-	return ret
+func AuthDecodeString(input string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(input)
 }
 
 func AuthEncodeBytes(input []byte) string {
 	return base64.StdEncoding.EncodeToString(input)
 }
 
-func AuthDecodeHexString(input string) []byte {
-	ret, err := hex.DecodeString(input)
-	if err != nil {
-		panic(err)
-	}
-	return ret
+func AuthDecodeHexString(input string) ([]byte, error) {
+	return hex.DecodeString(input)
 }
 
 func AuthEncodeHexBytes(input []byte) string {
@@ -59,5 +51,11 @@ func AuthDecodeJson[D AuthJsonEncoded](input io.Reader, errCallback func(error))
 		errCallback(err)
 		return nil
 	}
+	if validator, ok := any(&result).(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			errCallback(err)
+			return nil
+		}
+	}
 	return &result
 }
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+const (
+	MaxRequestBodySize = 64 * 1024
+	MaxImportBodySize  = 16 * 1024 * 1024
+	MaxImportRecords   = 1000
+
+	MaxLoginLength  = 256
+	MaxSaltLength   = 256
+	MaxServerLength = 128
+)
+
+// FieldError describes a problem with a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+}
+
+func (fe FieldError) String() string {
+	return fmt.Sprintf("%s: %s", fe.Field, fe.Problem)
+}
+
+// ValidationError is a list of all problems found in a request.
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	problems := make([]string, 0, len(ve))
+	for _, fe := range ve {
+		problems = append(problems, fe.String())
+	}
+	return fmt.Sprintf("Validation failed: %s", strings.Join(problems, "; "))
+}
+
+// Validator is implemented by all request types. Validate returns nil
+// if the request is valid.
+type Validator interface {
+	Validate() ValidationError
+}
+
+type fieldsValidator struct {
+	errors ValidationError
+}
+
+func (fv *fieldsValidator) fail(field string, format string, args ...any) {
+	fv.errors = append(fv.errors, FieldError{Field: field, Problem: fmt.Sprintf(format, args...)})
+}
+
+func (fv *fieldsValidator) result() ValidationError {
+	if len(fv.errors) == 0 {
+		return nil
+	}
+	return fv.errors
+}
+
+func (fv *fieldsValidator) notEmpty(field string, value string) bool {
+	if len(value) == 0 {
+		fv.fail(field, "must not be empty")
+		return false
+	}
+	return true
+}
+
+func (fv *fieldsValidator) maxLength(field string, value string, limit int) bool {
+	if len(value) > limit {
+		fv.fail(field, "must not be longer than %d characters", limit)
+		return false
+	}
+	return true
+}
+
+// base64 decodes value and checks that decoded data is not longer than limit bytes.
+func (fv *fieldsValidator) base64(field string, value string, limit int) ([]byte, bool) {
+	decoded, err := AuthDecodeString(value)
+	if err != nil {
+		fv.fail(field, "must be valid base64")
+		return nil, false
+	}
+	if len(decoded) > limit {
+		fv.fail(field, "must not be longer than %d bytes", limit)
+		return nil, false
+	}
+	return decoded, true
+}
+
+// srpValue checks that value is valid SRP group element: 0 < value mod N.
+func (fv *fieldsValidator) srpValue(field string, value string) {
+	if !fv.notEmpty(field, value) {
+		return
+	}
+	decoded, ok := fv.base64(field, value, SRP_PARAMS.NLengthBits/8)
+	if !ok {
+		return
+	}
+	if new(big.Int).Mod(new(big.Int).SetBytes(decoded), SRP_PARAMS.N).Sign() == 0 {
+		fv.fail(field, "must not be 0 modulo N")
+	}
+}
+
+func (fv *fieldsValidator) login(field string, value string) {
+	if fv.notEmpty(field, value) {
+		fv.base64(field, value, MaxLoginLength)
+	}
+}
+
+func (rrd *RegisterRequestData) Validate() ValidationError {
+	var fv fieldsValidator
+	fv.login("login", rrd.Login)
+	fv.base64("salt", rrd.Salt, MaxSaltLength)
+	fv.srpValue("verifier", rrd.Verifier)
+	return fv.result()
+}
+
+func (lrd *LoginRequestData) Validate() ValidationError {
+	var fv fieldsValidator
+	fv.login("login", lrd.Login)
+	fv.srpValue("secret1", lrd.Secret1)
+	return fv.result()
+}
+
+func (l2rd *Login2RequestData) Validate() ValidationError {
+	var fv fieldsValidator
+	if fv.notEmpty("server", l2rd.Server) {
+		fv.maxLength("server", l2rd.Server, MaxServerLength)
+	}
+	if fv.notEmpty("secret3", l2rd.Secret3) {
+		fv.base64("secret3", l2rd.Secret3, SRP_PARAMS.Hash.Size())
+	}
+	return fv.result()
+}
+
+// limitRequestBody rejects request bodies larger than limit, see
+// Route.BodyLimit.
+func limitRequestBody(limit int64, next http.Handler) http.Handler {
+	if limit < 0 {
+		return next
+	}
+	if limit == 0 {
+		limit = MaxRequestBodySize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
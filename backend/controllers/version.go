@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Wire format of the API version 2: ids are strings of models.FormatId, as
+// they exceed the integers exact in JavaScript; version 1 writes them as
+// numbers. Requests of both versions may have ids of either form.
+
+type V2RegisterResponseData struct {
+	UserId string `json:"userId"`
+}
+
+// V2People is the people record of the version 2.
+type V2People struct {
+	models.People
+	Id string `json:"Id"`
+}
+
+type V2ImportResult struct {
+	Id      string    `json:"id,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+type V2ImportResponse struct {
+	Created int              `json:"created"`
+	Results []V2ImportResult `json:"results"`
+}
+
+// V2WatchUser is the user record of the change feed of the version 2.
+type V2WatchUser struct {
+	WatchUser
+	Id string `json:"Id"`
+}
+
+type V2WatchEvent struct {
+	WatchEvent
+	Id string `json:"id"`
+}
+
+func (rrd RegisterResponseData) v2() any {
+	return V2RegisterResponseData{UserId: models.FormatId(rrd.UserId)}
+}
+
+func (ir ImportResponse) v2() any {
+	ret := V2ImportResponse{Created: ir.Created, Results: make([]V2ImportResult, 0, len(ir.Results))}
+	for _, result := range ir.Results {
+		converted := V2ImportResult{Code: result.Code, Message: result.Message}
+		if result.Id != nil {
+			converted.Id = models.FormatId(*result.Id)
+		}
+		ret.Results = append(ret.Results, converted)
+	}
+	return ret
+}
+
+func (wu WatchUser) v2() any {
+	return V2WatchUser{WatchUser: wu, Id: models.FormatId(wu.GetId())}
+}
+
+func (we WatchEvent) v2() any {
+	we.Record, _ = v2Value(we.Record)
+	return V2WatchEvent{WatchEvent: we, Id: models.FormatId(we.Id)}
+}
+
+// v2Value returns the value in the wire format of the version 2, false if
+// the format is the same as of the version 1.
+func v2Value(value any) (any, bool) {
+	switch v := value.(type) {
+	case models.People:
+		return V2People{People: v, Id: models.FormatId(v.GetId())}, true
+	case []models.People:
+		ret := make([]V2People, 0, len(v))
+		for _, record := range v {
+			ret = append(ret, V2People{People: record, Id: models.FormatId(record.GetId())})
+		}
+		return ret, true
+	case interface{ v2() any }:
+		return v.v2(), true
+	}
+	return value, false
+}
+
+type v2Key struct{}
+
+// v2Format makes the handler write responses in the wire format of the
+// version 2, see wireValue.
+func v2Format(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), v2Key{}, true)))
+	}
+}
+
+// isV2 reports whether the request is of the API version 2.
+func isV2(r *http.Request) bool {
+	v2, _ := r.Context().Value(v2Key{}).(bool)
+	return v2
+}
+
+// wireValue returns the response in the wire format of the API version of
+// the request.
+func wireValue(r *http.Request, value any) any {
+	if isV2(r) {
+		value, _ = v2Value(value)
+	}
+	return value
+}
+
+// V2Routes returns the routes of the API version 2.
+func V2Routes(routes []Route) []Route {
+	ret := make([]Route, 0, len(routes))
+	for _, route := range routes {
+		route.Handler = v2Format(route.Handler)
+		if route.Request != nil {
+			route.Request, _ = v2Value(route.Request)
+		}
+		if route.Response != nil {
+			route.Response, _ = v2Value(route.Response)
+		}
+		ret = append(ret, route)
+	}
+	return ret
+}
+
+// V1Routes returns the routes of the API version 1; their names are
+// suffixed by the version, so the OpenAPI operations differ from the ones
+// of the version 2.
+func V1Routes(routes []Route) []Route {
+	ret := make([]Route, 0, len(routes))
+	for _, route := range routes {
+		route.Name += "V1"
+		ret = append(ret, route)
+	}
+	return ret
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	// watchHeartbeat is the interval of the comments keeping idle event
+	// streams open through proxies.
+	watchHeartbeat = 15 * time.Second
+)
+
+// WatchEvent is data of the server-sent event of the table change. Id of
+// the server-sent event is Seq, client resumes the stream with it in the
+// Last-Event-ID header.
+type WatchEvent struct {
+	Seq     uint64              `json:"seq"`
+	Id      models.IdData       `json:"id"`
+	Version models.VersionData  `json:"version"`
+	Kind    ifaces.RevisionKind `json:"kind"`
+	Actor   string              `json:"actor,omitempty"`
+	Time    time.Time           `json:"time"`
+	Diff    []string            `json:"diff,omitempty"`
+	Record  any                 `json:"record"`
+}
+
+// WatchUser is the user record of the change feed, without SRP secrets.
+type WatchUser struct {
+	models.Id
+	models.Version
+	Login string
+}
+
+// Watch serves change feeds of the tables as server-sent events to the
+// clients authorized by the bearer tokens. Feeds are disabled if there are
+// no tokens.
+type Watch struct {
+	db     ifaces.Database
+	tokens bearerTokens
+}
+
+func NewWatchController(db ifaces.Database, tokens []string) *Watch {
+	return &Watch{db: db, tokens: newBearerTokens(tokens)}
+}
+
+func (wc *Watch) Routes() []Route {
+	errs := []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusGone,
+		http.StatusNotImplemented, http.StatusInternalServerError}
+	return []Route{
+		{
+			Method:  http.MethodGet,
+			Pattern: "/users",
+			Name:    "users",
+			Summary: "Stream changes of the users as server-sent events",
+			Handler: wc.tokens.authorized(func(w http.ResponseWriter, r *http.Request) {
+				serveWatch(w, r, "users", wc.db.Users, func(record models.User) any {
+					return WatchUser{Id: record.Id, Version: record.Version, Login: record.Login}
+				})
+			}),
+			Errors: errs,
+		},
+		{
+			Method:  http.MethodGet,
+			Pattern: "/peoples",
+			Name:    "peoples",
+			Summary: "Stream changes of the peoples as server-sent events",
+			Handler: wc.tokens.authorized(func(w http.ResponseWriter, r *http.Request) {
+				serveWatch(w, r, "peoples", wc.db.Peoples, func(record models.People) any { return record })
+			}),
+			Errors: errs,
+		},
+		{
+			Method:  http.MethodGet,
+			Pattern: "/roles",
+			Name:    "roles",
+			Summary: "Stream changes of the roles as server-sent events",
+			Handler: wc.tokens.authorized(func(w http.ResponseWriter, r *http.Request) {
+				serveWatch(w, r, "roles", wc.db.Roles, func(record models.Role) any { return record })
+			}),
+			Errors: errs,
+		},
+	}
+}
+
+func (wc *Watch) Controller() chi.Router {
+	return NewRouter(wc.Routes())
+}
+
+// watchPosition returns position to resume the watch from: the
+// Last-Event-ID header sent by reconnecting EventSource or the after
+// query parameter.
+func watchPosition(r *http.Request) (uint64, error) {
+	position := r.Header.Get("Last-Event-ID")
+	if len(position) == 0 {
+		position = r.URL.Query().Get("after")
+	}
+	if len(position) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseUint(position, 10, 64)
+}
+
+func serveWatch[M ifaces.Models](w http.ResponseWriter, r *http.Request, name string,
+	open func() (ifaces.Table[M], error), record func(M) any) {
+	after, err := watchPosition(r)
+	if err != nil {
+		WriteError(w, r, http.StatusBadRequest, ErrorBadRequest, "Malformed watch position")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger().Error("Response writer can't stream events")
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return
+	}
+
+	table, err := open()
+	if err != nil {
+		logger().Error("Can't access to table", slog.String("table", name), slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return
+	}
+
+	watcher, err := table.Watch(r.Context(), after)
+	switch {
+	case errors.Is(err, ifaces.ErrWatchExpired):
+		WriteError(w, r, http.StatusGone, ErrorWatchExpired, "Reload the records and watch from the start")
+		return
+	case errors.Is(err, ifaces.ErrNotSupported):
+		WriteError(w, r, http.StatusNotImplemented, ErrorNotSupported, "Database does not support watches")
+		return
+	case err != nil:
+		logger().Error("Can't watch table", slog.String("table", name), slog.Any("error", err))
+		WriteError(w, r, http.StatusInternalServerError, ErrorInternal, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	logger().Info("Watch started", slog.String("table", name), slog.Uint64("after", after))
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events():
+			if !ok {
+				logger().Info("Watch finished", slog.String("table", name), slog.Any("reason", watcher.Err()))
+				return
+			}
+			data, err := json.Marshal(wireValue(r, WatchEvent{
+				Seq:     event.Seq,
+				Id:      event.Id,
+				Version: event.Version,
+				Kind:    event.Kind,
+				Actor:   event.Actor,
+				Time:    event.Time,
+				Diff:    event.Diff,
+				Record:  record(event.Record),
+			}))
+			if err != nil {
+				logger().Error("Can't encode watch event", slog.String("table", name), slog.Any("error", err))
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Kind, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
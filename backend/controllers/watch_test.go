@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+const testWatchToken = "secret"
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+func watchRequest(t *testing.T, url string, token string, lastEventId string) *http.Response {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if len(lastEventId) > 0 {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request error: %s", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readEvent reads the next server-sent event skipping comments.
+func readEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Read error: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case len(line) == 0 && len(event.data) > 0:
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestWatchUsers(t *testing.T) {
+	db := fake_database.NewDatabase()
+	ts := httptest.NewServer(NewAPIRouter(db, APIConfig{WatchTokens: []string{"other", testWatchToken}}))
+	// Closed after the event streams
+	t.Cleanup(ts.Close)
+
+	users, err := db.Users()
+	if err != nil {
+		t.Fatalf("Users error: %s", err)
+	}
+	url := ts.URL + "/" + APIVersion + "/watch/users"
+
+	expectStatus(t, watchRequest(t, url, "", ""), http.StatusUnauthorized)
+	expectStatus(t, watchRequest(t, url, "wrong", ""), http.StatusUnauthorized)
+
+	resp := watchRequest(t, url, testWatchToken, "")
+	expectStatus(t, resp, http.StatusOK)
+	if ct := resp.Header.Get("Content-Type"); ct != eventStreamContentType {
+		t.Fatalf("Unexpected content type: '%s'", ct)
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	for _, login := range []string{"alice", "bob"} {
+		if _, err := users.InsertContext(ifaces.WithActor(context.Background(), "admin"), models.User{Login: login, Salt: "salt", Verifier: "verifier"}); err != nil {
+			t.Fatalf("Insert error: %s", err)
+		}
+	}
+
+	first := readEvent(t, stream)
+	if first.event != string(ifaces.RevisionInsert) || strings.Contains(first.data, "verifier") || strings.Contains(first.data, "salt") {
+		t.Fatalf("Unexpected event: %+v", first)
+	}
+	var data WatchEvent
+	if err := json.Unmarshal([]byte(first.data), &data); err != nil {
+		t.Fatalf("Can't decode event: %s", err)
+	}
+	if strconv.FormatUint(data.Seq, 10) != first.id || data.Actor != "admin" || data.Version != models.FIRST_VERSION {
+		t.Fatalf("Unexpected event data: %+v", data)
+	}
+	second := readEvent(t, stream)
+
+	// Reconnecting client gets the events after the last one received
+	resp = watchRequest(t, url, testWatchToken, first.id)
+	expectStatus(t, resp, http.StatusOK)
+	if resumed := readEvent(t, bufio.NewReader(resp.Body)); resumed != second {
+		t.Fatalf("Unexpected resumed event: %+v, want: %+v", resumed, second)
+	}
+
+	seq, _ := strconv.ParseUint(second.id, 10, 64)
+	resp = watchRequest(t, url, testWatchToken, strconv.FormatUint(seq+1000, 10))
+	expectStatus(t, resp, http.StatusGone)
+
+	resp = watchRequest(t, url, testWatchToken, "last")
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestWatchDisabled(t *testing.T) {
+	ts := httptest.NewServer(NewAPIRouter(fake_database.NewDatabase(), APIConfig{}))
+	defer ts.Close()
+
+	resp := watchRequest(t, ts.URL+"/"+APIVersion+"/watch/roles", " ", "")
+	expectStatus(t, resp, http.StatusUnauthorized)
+}
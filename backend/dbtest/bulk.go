@@ -0,0 +1,181 @@
+package dbtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func expectResults(t *testing.T, operation string, results []ifaces.Result, expected []error) {
+	t.Helper()
+
+	if len(results) != len(expected) {
+		t.Fatalf("%s: expected %d results, got %d", operation, len(expected), len(results))
+	}
+	for i, result := range results {
+		expectErr(t, operation, result.Err, expected[i])
+	}
+}
+
+// runBulk tests the bulk operations and the results of their records.
+func runBulk(t *testing.T, factory Factory) {
+	t.Run("InsertMany", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		existing := insert(t, all.users, SampleUser(0))
+		records := []models.User{SampleUser(1), SampleUser(0), SampleUser(2), SampleUser(1)}
+		results, err := all.users.InsertMany(records)
+		if err != nil {
+			t.Fatalf("InsertMany error: %s", err)
+		}
+		expectResults(t, "InsertMany", results, []error{nil, ifaces.ErrUniqueViolation, nil, ifaces.ErrUniqueViolation})
+		expectRecord(t, all.users, existing)
+		for _, i := range []int{0, 2} {
+			expectRecord(t, all.users, withVersion(withId(records[i], results[i].Id), models.FIRST_VERSION))
+		}
+		if n := count(t, all.users); n != 3 {
+			t.Fatalf("Expected 3 users, got %d", n)
+		}
+
+		results, err = all.phones.InsertMany([]models.Phone{withOwner(SamplePhone(0), 1000000)})
+		if err != nil {
+			t.Fatalf("InsertMany error: %s", err)
+		}
+		expectResults(t, "InsertMany", results, []error{ifaces.ErrNoOwner})
+
+		results, err = all.roles.InsertMany(nil)
+		if err != nil {
+			t.Fatalf("InsertMany error: %s", err)
+		}
+		expectResults(t, "InsertMany", results, nil)
+	})
+
+	t.Run("UpdateMany", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		first := insert(t, all.roles, SampleRole(0))
+		second := insert(t, all.roles, SampleRole(1))
+		stale := update(t, all.roles, insert(t, all.roles, SampleRole(2)))
+
+		first.Name = "first"
+		second.Name = "second"
+		results, err := all.roles.UpdateMany([]models.Role{
+			first,
+			withVersion(stale, models.FIRST_VERSION),
+			withId(SampleRole(3), 1000000),
+			second,
+		})
+		if err != nil {
+			t.Fatalf("UpdateMany error: %s", err)
+		}
+		expectResults(t, "UpdateMany", results, []error{nil, ifaces.ErrConflict, ifaces.ErrNoSuchRecord, nil})
+		if results[0].Id != getId(first) || results[3].Id != getId(second) {
+			t.Fatalf("Unexpected ids of the results: %+v", results)
+		}
+		expectRecord(t, all.roles, withVersion(first, getVersion(first)+1))
+		expectRecord(t, all.roles, withVersion(second, getVersion(second)+1))
+		expectRecord(t, all.roles, stale)
+
+		// Records are updated in order, so the unique index sees the
+		// previous updates
+		first.Name = "second"
+		results, err = all.roles.UpdateMany([]models.Role{withVersion(first, getVersion(first)+1)})
+		if err != nil {
+			t.Fatalf("UpdateMany error: %s", err)
+		}
+		expectResults(t, "UpdateMany", results, []error{ifaces.ErrUniqueViolation})
+	})
+
+	t.Run("DeleteWhere", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		var peoples []models.People
+		for i := 0; i < 6; i++ {
+			record := SamplePeople(i)
+			if i%2 == 0 {
+				record.Surname = "Doe"
+			}
+			peoples = append(peoples, insert(t, all.peoples, record))
+		}
+		records := insertOwned(t, all, getId(peoples[0]), 0)
+
+		results, err := all.peoples.DeleteWhere(ifaces.NewQuery().Where("Surname", ifaces.Eq, "Doe").Limit(2))
+		if err != nil {
+			t.Fatalf("DeleteWhere error: %s", err)
+		}
+		expectResults(t, "DeleteWhere", results, []error{nil, nil})
+		for i, result := range results {
+			if result.Id != getId(peoples[2*i]) {
+				t.Fatalf("Result %d: expected id %d, got %d", i, getId(peoples[2*i]), result.Id)
+			}
+		}
+		expectDeleted(t, all.peoples, peoples[0])
+		expectDeleted(t, all.peoples, peoples[2])
+		expectOwnedDeleted(t, all, records)
+		for _, i := range []int{1, 3, 4, 5} {
+			expectRecord(t, all.peoples, peoples[i])
+		}
+
+		results, err = all.peoples.DeleteWhere(ifaces.NewQuery().Where("Surname", ifaces.Eq, "Smith"))
+		if err != nil {
+			t.Fatalf("DeleteWhere error: %s", err)
+		}
+		expectResults(t, "DeleteWhere", results, nil)
+
+		_, err = all.peoples.DeleteWhere(ifaces.NewQuery().Where("NoSuchField", ifaces.Eq, 1))
+		expectErr(t, "DeleteWhere", err, ifaces.ErrBadQuery)
+
+		results, err = all.peoples.DeleteWhere(ifaces.NewQuery())
+		if err != nil {
+			t.Fatalf("DeleteWhere error: %s", err)
+		}
+		expectResults(t, "DeleteWhere", results, []error{nil, nil, nil, nil})
+		if n := count(t, all.peoples); n != 0 {
+			t.Fatalf("Expected no peoples, got %d", n)
+		}
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+		ctx := context.Background()
+
+		role := insert(t, all.roles, SampleRole(0))
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+			results, err := roles.InsertManyContext(ctx, []models.Role{SampleRole(1), SampleRole(2)})
+			if err != nil {
+				return err
+			}
+			expectResults(t, "InsertMany", results, []error{nil, nil})
+			if _, err := roles.DeleteWhereContext(ctx, ifaces.NewQuery().Where(ifaces.IdField, ifaces.Eq, getId(role))); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+		expectRecord(t, all.roles, role)
+		if n := count(t, all.roles); n != 1 {
+			t.Fatalf("Expected 1 role, got %d", n)
+		}
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		role := insert(t, all.roles, SampleRole(0))
+		_, err := all.roles.InsertManyContext(ctx, []models.Role{SampleRole(1)})
+		expectErr(t, "InsertMany", err, context.Canceled)
+		_, err = all.roles.UpdateManyContext(ctx, []models.Role{role})
+		expectErr(t, "UpdateMany", err, context.Canceled)
+		_, err = all.roles.DeleteWhereContext(ctx, ifaces.NewQuery())
+		expectErr(t, "DeleteWhere", err, context.Canceled)
+		expectRecord(t, all.roles, role)
+		if n := count(t, all.roles); n != 1 {
+			t.Fatalf("Expected 1 role, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package dbtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// runContext tests context variants of the operations.
+func runContext(t *testing.T, factory Factory) {
+	t.Run("Canceled", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		user := insert(t, all.users, SampleUser(0))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := all.users.GetContext(ctx, getId(user))
+		expectErr(t, "GetContext", err, context.Canceled)
+		_, err = all.users.FindContext(ctx, func(models.User) bool { return true })
+		expectErr(t, "FindContext", err, context.Canceled)
+		err = all.users.EachContext(ctx, func(models.User) bool { return true })
+		expectErr(t, "EachContext", err, context.Canceled)
+		_, err = all.users.LookupContext(ctx, models.UserLoginIndex, user.Login)
+		expectErr(t, "LookupContext", err, context.Canceled)
+		_, err = all.users.QueryContext(ctx, nil)
+		expectErr(t, "QueryContext", err, context.Canceled)
+
+		_, err = all.users.InsertContext(ctx, SampleUser(1))
+		expectErr(t, "InsertContext", err, context.Canceled)
+		expectErr(t, "UpdateContext", all.users.UpdateContext(ctx, withId(SampleUser(2), getId(user))), context.Canceled)
+		expectErr(t, "DeleteContext", all.users.DeleteContext(ctx, getId(user)), context.Canceled)
+
+		// Nothing is changed
+		if n := count(t, all.users); n != 1 {
+			t.Fatalf("Expected 1 record, got %d", n)
+		}
+		expectRecord(t, all.users, user)
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		_, err := all.peoples.InsertContext(ctx, SamplePeople(0))
+		expectErr(t, "InsertContext", err, context.DeadlineExceeded)
+		if n := count(t, all.peoples); n != 0 {
+			t.Fatalf("Insert with expired deadline is applied")
+		}
+	})
+
+	t.Run("CanceledEach", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		for i := 0; i < 5; i++ {
+			insert(t, all.roles, SampleRole(i))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		calls := 0
+		err := all.roles.EachContext(ctx, func(models.Role) bool {
+			calls++
+			cancel()
+			return true
+		})
+		expectErr(t, "EachContext", err, context.Canceled)
+		if calls != 1 {
+			t.Fatalf("Callback is called %d times after cancel", calls)
+		}
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			users := tables(t, tx).users
+			cancel()
+			_, err := users.InsertContext(ctx, SampleUser(0))
+			expectErr(t, "InsertContext", err, context.Canceled)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		if n := count(t, all.users); n != 0 {
+			t.Fatalf("Canceled insert is committed")
+		}
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		db := factory(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := db.OpenContext(ctx); err == nil {
+			db.Close()
+			t.Fatalf("Database is opened with canceled context")
+		}
+	})
+}
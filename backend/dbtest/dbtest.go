@@ -0,0 +1,562 @@
+// Package dbtest is a conformance test suite of the ifaces.Database
+// implementations. Every backend must pass it:
+//
+//	func TestConformance(t *testing.T) {
+//		dbtest.Run(t, func(t *testing.T) ifaces.Database {
+//			return NewDatabase()
+//		})
+//	}
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Factory returns new empty database. The suite opens and closes it.
+type Factory func(t *testing.T) ifaces.Database
+
+// tableSuite describes how to test table of the model M.
+type tableSuite[M ifaces.Models] struct {
+	table func(ifaces.Database) (ifaces.Table[M], error)
+	// sample returns i-th test record
+	sample func(i int) M
+	// change returns modified copy of the record
+	change func(record M) M
+	// owned records are inserted with the owner people, see ifaces.Owned
+	owned bool
+}
+
+// Run runs all conformance tests against databases created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("Users", func(t *testing.T) {
+		runTable(t, factory, tableSuite[models.User]{
+			table:  ifaces.Database.Users,
+			sample: SampleUser,
+			change: func(record models.User) models.User {
+				record.Login += " changed"
+				record.Verifier = "new verifier"
+				return record
+			},
+		})
+	})
+	t.Run("Peoples", func(t *testing.T) {
+		runTable(t, factory, tableSuite[models.People]{
+			table:  ifaces.Database.Peoples,
+			sample: SamplePeople,
+			change: func(record models.People) models.People {
+				record.Surname += " changed"
+				record.Works = append(record.Works, &models.WorkingPeriod{})
+				record.Grade = nil
+				return record
+			},
+		})
+	})
+	t.Run("Roles", func(t *testing.T) {
+		runTable(t, factory, tableSuite[models.Role]{
+			table:  ifaces.Database.Roles,
+			sample: SampleRole,
+			change: func(record models.Role) models.Role {
+				record.Name += " changed"
+				return record
+			},
+		})
+	})
+	t.Run("Phones", func(t *testing.T) {
+		runTable(t, factory, tableSuite[models.Phone]{
+			table:  ifaces.Database.Phones,
+			sample: SamplePhone,
+			change: func(record models.Phone) models.Phone {
+				record.Type = models.CityPhone
+				record.Phone += "0"
+				return record
+			},
+			owned: true,
+		})
+	})
+	t.Run("Addresses", func(t *testing.T) {
+		runTable(t, factory, tableSuite[models.Address]{
+			table:  ifaces.Database.Addresses,
+			sample: SampleAddress,
+			change: func(record models.Address) models.Address {
+				record.Street += " changed"
+				record.Appartment = ""
+				return record
+			},
+			owned: true,
+		})
+	})
+	t.Run("Emails", func(t *testing.T) {
+		runTable(t, factory, tableSuite[models.Email]{
+			table:  ifaces.Database.Emails,
+			sample: SampleEmail,
+			change: func(record models.Email) models.Email {
+				record.Mail = "changed." + record.Mail
+				return record
+			},
+			owned: true,
+		})
+	})
+	t.Run("BankAccounts", func(t *testing.T) {
+		runTable(t, factory, tableSuite[models.BankAccount]{
+			table:  ifaces.Database.BankAccounts,
+			sample: SampleBankAccount,
+			change: func(record models.BankAccount) models.BankAccount {
+				record.Active = !record.Active
+				return record
+			},
+			owned: true,
+		})
+	})
+	t.Run("Owners", func(t *testing.T) {
+		runOwners(t, factory)
+	})
+	t.Run("Import", func(t *testing.T) {
+		runImport(t, factory)
+	})
+	t.Run("Each", func(t *testing.T) {
+		runEach(t, factory)
+	})
+	t.Run("Bulk", func(t *testing.T) {
+		runBulk(t, factory)
+	})
+	t.Run("Ids", func(t *testing.T) {
+		runIds(t, factory)
+	})
+	t.Run("Tx", func(t *testing.T) {
+		runTx(t, factory)
+	})
+	t.Run("Indexes", func(t *testing.T) {
+		runIndexes(t, factory)
+	})
+	t.Run("Query", func(t *testing.T) {
+		runQuery(t, factory)
+	})
+	t.Run("Context", func(t *testing.T) {
+		runContext(t, factory)
+	})
+	t.Run("Versions", func(t *testing.T) {
+		runVersions(t, factory)
+	})
+	t.Run("History", func(t *testing.T) {
+		runHistory(t, factory)
+	})
+	t.Run("Watch", func(t *testing.T) {
+		runWatch(t, factory)
+	})
+	t.Run("Schema", func(t *testing.T) {
+		runSchema(t, factory)
+	})
+}
+
+func SampleUser(i int) models.User {
+	return models.User{
+		Login:    fmt.Sprintf("user%d", i),
+		Salt:     fmt.Sprintf("salt%d", i),
+		Verifier: fmt.Sprintf("verifier%d", i),
+	}
+}
+
+func SampleRole(i int) models.Role {
+	return models.Role{Name: fmt.Sprintf("role%d", i)}
+}
+
+// SamplePeople returns record with all kinds of fields filled. Times are
+// in UTC, as backends are not required to keep the location.
+func SamplePeople(i int) models.People {
+	since := time.Date(2020, 1, 1+i%28, 9, 0, 0, 0, time.UTC)
+	position := models.Developer
+	grade := models.Middle
+	return models.People{
+		Name:       fmt.Sprintf("name%d", i),
+		Surname:    fmt.Sprintf("surname%d", i),
+		Patronymic: fmt.Sprintf("patronymic%d", i),
+		Birth:      time.Date(1980+i%20, 2, 3, 0, 0, 0, 0, time.UTC),
+		Photo:      []byte{byte(i), 1, 2},
+		Tax:        []*models.TaxInfo{{RegisterDate: since, Code: fmt.Sprint(i)}},
+		Works:      []*models.WorkingPeriod{{Since: &since}},
+		Position:   &position,
+		Grade:      &grade,
+	}
+}
+
+// SamplePhone, SampleAddress, SampleEmail and SampleBankAccount return the
+// owned records without the owner.
+func SamplePhone(i int) models.Phone {
+	return models.Phone{Active: true, Type: models.MobilePhone, Phone: fmt.Sprintf("+1 555 %04d", i)}
+}
+
+func SampleAddress(i int) models.Address {
+	return models.Address{
+		Active:     true,
+		Postcode:   fmt.Sprintf("%05d", i),
+		Region:     "Region",
+		City:       "City",
+		Street:     "Street",
+		House:      fmt.Sprint(i),
+		Appartment: fmt.Sprint(i % 10),
+	}
+}
+
+func SampleEmail(i int) models.Email {
+	return models.Email{Active: true, Mail: fmt.Sprintf("owner%d@example.com", i)}
+}
+
+func SampleBankAccount(i int) models.BankAccount {
+	return models.BankAccount{Active: i%2 == 0}
+}
+
+func getId[M ifaces.Models](record M) models.IdData {
+	var i interface{} = &record
+	return i.(ifaces.Id).GetId()
+}
+
+func withId[M ifaces.Models](record M, id models.IdData) M {
+	var i interface{} = &record
+	i.(ifaces.Id).SetId(id)
+	return record
+}
+
+func getVersion[M ifaces.Models](record M) models.VersionData {
+	var i interface{} = &record
+	return i.(ifaces.Version).GetVersion()
+}
+
+func withVersion[M ifaces.Models](record M, version models.VersionData) M {
+	var i interface{} = &record
+	i.(ifaces.Version).SetVersion(version)
+	return record
+}
+
+func withOwner[M ifaces.Models](record M, owner models.IdData) M {
+	var i interface{} = &record
+	i.(ifaces.Owned).SetOwner(owner)
+	return record
+}
+
+// open opens the database and returns the table of the suite and the
+// sample function. Samples of the owned records reference the people
+// inserted by open.
+func open[M ifaces.Models](t *testing.T, factory Factory, suite tableSuite[M]) (ifaces.Table[M], func(i int) M) {
+	t.Helper()
+
+	db := factory(t)
+	if err := db.Open(); err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	t.Cleanup(db.Close)
+
+	table, err := suite.table(db)
+	if err != nil {
+		t.Fatalf("Can't access table: %s", err)
+	}
+
+	sample := suite.sample
+	if suite.owned {
+		peoples, err := db.Peoples()
+		if err != nil {
+			t.Fatalf("Can't access table: %s", err)
+		}
+		owner := getId(insert(t, peoples, SamplePeople(0)))
+		sample = func(i int) M {
+			return withOwner(suite.sample(i), owner)
+		}
+	}
+	return table, sample
+}
+
+func insert[M ifaces.Models](t *testing.T, table ifaces.Table[M], record M) M {
+	t.Helper()
+
+	id, err := table.Insert(record)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	return withVersion(withId(record, id), models.FIRST_VERSION)
+}
+
+// update updates the record and returns it with the next version.
+func update[M ifaces.Models](t *testing.T, table ifaces.Table[M], record M) M {
+	t.Helper()
+
+	if err := table.Update(record); err != nil {
+		t.Fatalf("Update error: %s", err)
+	}
+	return withVersion(record, getVersion(record)+1)
+}
+
+func expectErr(t *testing.T, operation string, err error, expected error) {
+	t.Helper()
+
+	if !errors.Is(err, expected) {
+		t.Fatalf("%s: expected error '%v', got '%v'", operation, expected, err)
+	}
+}
+
+func expectRecord[M ifaces.Models](t *testing.T, table ifaces.Table[M], expected M) {
+	t.Helper()
+
+	stored, err := table.Get(getId(expected))
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if !reflect.DeepEqual(stored, expected) {
+		t.Fatalf("Stored record differs:\n got: %+v\nwant: %+v", stored, expected)
+	}
+}
+
+func count[M ifaces.Models](t *testing.T, table ifaces.Table[M]) int {
+	t.Helper()
+
+	ret := 0
+	err := table.Each(func(M) bool {
+		ret++
+		return true
+	})
+	if err != nil && !errors.Is(err, ifaces.ErrEmptyTable) {
+		t.Fatalf("Each error: %s", err)
+	}
+	return ret
+}
+
+func runTable[M ifaces.Models](t *testing.T, factory Factory, suite tableSuite[M]) {
+	const missingId = 1000000
+
+	t.Run("Empty", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		_, err := table.Get(missingId)
+		expectErr(t, "Get", err, ifaces.ErrNoSuchRecord)
+
+		_, err = table.Find(func(M) bool { return true })
+		expectErr(t, "Find", err, ifaces.ErrNoSuchRecord)
+
+		err = table.Each(func(M) bool {
+			t.Fatalf("Callback is called for empty table")
+			return true
+		})
+		expectErr(t, "Each", err, ifaces.ErrEmptyTable)
+
+		expectErr(t, "Update", table.Update(withId(sample(0), missingId)), ifaces.ErrNoSuchRecord)
+		expectErr(t, "Delete", table.Delete(missingId), ifaces.ErrNoSuchRecord)
+	})
+
+	t.Run("InsertGet", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		var records []M
+		ids := make(map[models.IdData]bool)
+		for i := 0; i < 3; i++ {
+			record := insert(t, table, sample(i))
+			if ids[getId(record)] {
+				t.Fatalf("Duplicated id: %d", getId(record))
+			}
+			ids[getId(record)] = true
+			records = append(records, record)
+		}
+
+		for _, record := range records {
+			expectRecord(t, table, record)
+		}
+	})
+
+	t.Run("InsertIgnoresId", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		first := insert(t, table, sample(0))
+		// Insert allocates the id, the id of the record is ignored
+		second := insert(t, table, withId(sample(1), getId(first)))
+		if getId(second) == getId(first) {
+			t.Fatalf("Insert reused id of the existing record: %d", getId(first))
+		}
+		expectRecord(t, table, first)
+		expectRecord(t, table, second)
+	})
+
+	t.Run("IdsAreNotReused", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		first := insert(t, table, sample(0))
+		second := insert(t, table, sample(1))
+		if err := table.Delete(getId(second)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+
+		third := insert(t, table, sample(2))
+		if getId(third) == getId(first) || getId(third) == getId(second) {
+			t.Fatalf("Id %d is reused", getId(third))
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		first := insert(t, table, sample(0))
+		second := insert(t, table, sample(1))
+
+		changed := update(t, table, suite.change(first))
+
+		expectRecord(t, table, changed)
+		expectRecord(t, table, second)
+
+		expectErr(t, "Update", table.Update(withId(sample(2), missingId)), ifaces.ErrNoSuchRecord)
+		if n := count(t, table); n != 2 {
+			t.Fatalf("Update changed records count: %d", n)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		first := insert(t, table, sample(0))
+		second := insert(t, table, sample(1))
+
+		if err := table.Delete(getId(first)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+
+		_, err := table.Get(getId(first))
+		expectErr(t, "Get", err, ifaces.ErrNoSuchRecord)
+		expectErr(t, "Delete", table.Delete(getId(first)), ifaces.ErrNoSuchRecord)
+		expectErr(t, "Update", table.Update(first), ifaces.ErrNoSuchRecord)
+		expectRecord(t, table, second)
+
+		if err := table.Delete(getId(second)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		expectErr(t, "Each", table.Each(func(M) bool { return true }), ifaces.ErrEmptyTable)
+	})
+
+	t.Run("Each", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		expected := make(map[models.IdData]M)
+		for i := 0; i < 5; i++ {
+			record := insert(t, table, sample(i))
+			expected[getId(record)] = record
+		}
+
+		visited := make(map[models.IdData]bool)
+		err := table.Each(func(record M) bool {
+			id := getId(record)
+			if visited[id] {
+				t.Fatalf("Record %d is visited twice", id)
+			}
+			visited[id] = true
+			if !reflect.DeepEqual(record, expected[id]) {
+				t.Fatalf("Record differs:\n got: %+v\nwant: %+v", record, expected[id])
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Each error: %s", err)
+		}
+		if len(visited) != len(expected) {
+			t.Fatalf("Visited %d records of %d", len(visited), len(expected))
+		}
+
+		calls := 0
+		err = table.Each(func(M) bool {
+			calls++
+			return false
+		})
+		if err != nil || calls != 1 {
+			t.Fatalf("Each is not stopped by callback: calls: %d, error: %v", calls, err)
+		}
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		var records []M
+		for i := 0; i < 5; i++ {
+			records = append(records, insert(t, table, sample(i)))
+		}
+
+		target := getId(records[3])
+		found, err := table.Find(func(record M) bool {
+			return getId(record) == target
+		})
+		if err != nil {
+			t.Fatalf("Find error: %s", err)
+		}
+		if !reflect.DeepEqual(found, records[3]) {
+			t.Fatalf("Found record differs:\n got: %+v\nwant: %+v", found, records[3])
+		}
+
+		_, err = table.Find(func(M) bool { return false })
+		expectErr(t, "Find", err, ifaces.ErrNoSuchRecord)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		table, sample := open(t, factory, suite)
+
+		const workers = 8
+		const perWorker = 20
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*perWorker)
+		ids := make(chan models.IdData, workers*perWorker)
+
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					record := sample(w*perWorker + i)
+					id, err := table.Insert(record)
+					if err != nil {
+						errs <- err
+						return
+					}
+					ids <- id
+
+					stored, err := table.Get(id)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if !reflect.DeepEqual(stored, withVersion(withId(record, id), models.FIRST_VERSION)) {
+						errs <- fmt.Errorf("Record %d differs", id)
+						return
+					}
+
+					if i%5 == 0 {
+						if err := table.Update(suite.change(stored)); err != nil {
+							errs <- err
+							return
+						}
+					}
+					table.Each(func(M) bool { return true })
+				}
+			}(w)
+		}
+
+		wg.Wait()
+		close(errs)
+		close(ids)
+
+		for err := range errs {
+			t.Fatalf("Concurrent access error: %s", err)
+		}
+
+		unique := make(map[models.IdData]bool)
+		for id := range ids {
+			if unique[id] {
+				t.Fatalf("Duplicated id: %d", id)
+			}
+			unique[id] = true
+		}
+
+		if n := count(t, table); n != workers*perWorker {
+			t.Fatalf("Expected %d records, got %d", workers*perWorker, n)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package dbtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func eachIds[M ifaces.Models](t *testing.T, table ifaces.Table[M]) []models.IdData {
+	t.Helper()
+
+	var ids []models.IdData
+	for record, err := range ifaces.All(context.Background(), table) {
+		if err != nil {
+			t.Fatalf("All error: %s", err)
+		}
+		ids = append(ids, getId(record))
+	}
+	return ids
+}
+
+func expectIds(t *testing.T, operation string, ids []models.IdData, expected ...models.IdData) {
+	t.Helper()
+
+	if len(ids) != len(expected) {
+		t.Fatalf("%s: expected ids %v, got %v", operation, expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("%s: expected ids %v, got %v", operation, expected, ids)
+		}
+	}
+}
+
+// runEach tests the order and the snapshot of the iteration.
+func runEach(t *testing.T, factory Factory) {
+	t.Run("Order", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		for _, id := range []models.IdData{30, 10, 50, 20, 40} {
+			if err := all.roles.Import(withId(SampleRole(int(id)), id)); err != nil {
+				t.Fatalf("Import error: %s", err)
+			}
+		}
+		expectIds(t, "All", eachIds(t, all.roles), 10, 20, 30, 40, 50)
+
+		found, err := all.roles.Find(func(record models.Role) bool { return getId(record) > 25 })
+		if err != nil {
+			t.Fatalf("Find error: %s", err)
+		}
+		expectIds(t, "Find", []models.IdData{getId(found)}, 30)
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		var roles []models.Role
+		for i := 0; i < 3; i++ {
+			roles = append(roles, insert(t, all.roles, SampleRole(i)))
+		}
+
+		// Callback changes the table and accesses the others
+		var ids []models.IdData
+		err := all.roles.Each(func(record models.Role) bool {
+			ids = append(ids, getId(record))
+			if getId(record) == getId(roles[0]) {
+				insert(t, all.roles, SampleRole(3))
+				if err := all.roles.Delete(getId(roles[2])); err != nil {
+					t.Errorf("Delete error: %s", err)
+				}
+				insert(t, all.users, SampleUser(0))
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Each error: %s", err)
+		}
+		expectIds(t, "Each", ids, getId(roles[0]), getId(roles[1]), getId(roles[2]))
+
+		ids = ids[:0]
+		_, err = all.roles.Find(func(record models.Role) bool {
+			ids = append(ids, getId(record))
+			update(t, all.roles, record)
+			return false
+		})
+		expectErr(t, "Find", err, ifaces.ErrNoSuchRecord)
+		if len(ids) != 3 || count(t, all.users) != 1 {
+			t.Fatalf("Unexpected iteration: %v", ids)
+		}
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+		ctx := context.Background()
+
+		first := insert(t, all.roles, SampleRole(0))
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+			if err := roles.ImportContext(ctx, withId(SampleRole(1), getId(first)+10)); err != nil {
+				return err
+			}
+			if err := roles.ImportContext(ctx, withId(SampleRole(2), getId(first)+5)); err != nil {
+				return err
+			}
+			var ids []models.IdData
+			for record, err := range ifaces.All(ctx, roles) {
+				if err != nil {
+					return err
+				}
+				ids = append(ids, getId(record))
+				if _, err := roles.InsertContext(ctx, SampleRole(len(ids)+2)); err != nil {
+					return err
+				}
+			}
+			expectIds(t, "All", ids, getId(first), getId(first)+5, getId(first)+10)
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+	})
+
+	t.Run("All", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		for record, err := range ifaces.All(context.Background(), all.roles) {
+			t.Fatalf("Unexpected record of the empty table: %+v, %v", record, err)
+		}
+
+		for i := 0; i < 3; i++ {
+			insert(t, all.roles, SampleRole(i))
+		}
+		n := 0
+		for _, err := range ifaces.All(context.Background(), all.roles) {
+			if err != nil {
+				t.Fatalf("All error: %s", err)
+			}
+			n++
+			if n == 2 {
+				break
+			}
+		}
+		if n != 2 {
+			t.Fatalf("Expected 2 records, got %d", n)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		n = 0
+		for _, err := range ifaces.All(ctx, all.roles) {
+			expectErr(t, "All", err, context.Canceled)
+			n++
+		}
+		if n != 1 {
+			t.Fatalf("Expected the single error, got %d", n)
+		}
+	})
+}
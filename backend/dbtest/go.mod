@@ -0,0 +1,12 @@
+module github.com/diakovliev/mesap/backend/dbtest
+
+go 1.23
+
+require (
+	github.com/diakovliev/mesap/backend/ifaces v0.0.1
+	github.com/diakovliev/mesap/backend/models v0.0.1
+)
+
+replace github.com/diakovliev/mesap/backend/models v0.0.1 => ../models
+
+replace github.com/diakovliev/mesap/backend/ifaces v0.0.1 => ../ifaces
@@ -0,0 +1,303 @@
+package dbtest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// tick makes sure that the next change has later revision time.
+func tick() {
+	time.Sleep(2 * time.Millisecond)
+}
+
+func history[M ifaces.Models](t *testing.T, table ifaces.Table[M], id models.IdData) []ifaces.Revision[M] {
+	t.Helper()
+
+	revisions, err := table.History(id)
+	if err != nil {
+		t.Fatalf("History error: %s", err)
+	}
+	return revisions
+}
+
+func expectRevision[M ifaces.Models](t *testing.T, revision ifaces.Revision[M], kind ifaces.RevisionKind, record M, diff ...string) {
+	t.Helper()
+
+	if revision.Kind != kind || revision.Id != getId(record) || revision.Version != getVersion(record) {
+		t.Fatalf("Unexpected revision: %s %d.%d, want: %s %d.%d",
+			revision.Kind, revision.Id, revision.Version, kind, getId(record), getVersion(record))
+	}
+	if !reflect.DeepEqual(revision.Record, record) {
+		t.Fatalf("Revision record differs:\n got: %+v\nwant: %+v", revision.Record, record)
+	}
+	if !reflect.DeepEqual(revision.Diff, diff) {
+		t.Fatalf("Unexpected diff of %s revision: %v, want: %v", kind, revision.Diff, diff)
+	}
+}
+
+func expectAt[M ifaces.Models](t *testing.T, table ifaces.Table[M], id models.IdData, at time.Time, expected *M) {
+	t.Helper()
+
+	record, err := table.At(id, at)
+	if expected == nil {
+		expectErr(t, "At", err, ifaces.ErrNoSuchRecord)
+		return
+	}
+	if err != nil {
+		t.Fatalf("At error: %s", err)
+	}
+	if !reflect.DeepEqual(record, *expected) {
+		t.Fatalf("State at %s differs:\n got: %+v\nwant: %+v", at, record, *expected)
+	}
+}
+
+// runHistory tests revisions, soft delete and restore.
+func runHistory(t *testing.T, factory Factory) {
+	t.Run("Revisions", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		ctx := ifaces.WithActor(context.Background(), "hr")
+
+		before := ifaces.RevisionTime()
+		id, err := all.roles.InsertContext(ctx, SampleRole(0))
+		if err != nil {
+			t.Fatalf("Insert error: %s", err)
+		}
+		inserted := withVersion(withId(SampleRole(0), id), models.FIRST_VERSION)
+
+		updated := withVersion(withId(SampleRole(1), id), models.FIRST_VERSION)
+		if err := all.roles.UpdateContext(ctx, updated); err != nil {
+			t.Fatalf("Update error: %s", err)
+		}
+		updated = withVersion(updated, models.FIRST_VERSION+1)
+
+		if err := all.roles.DeleteContext(ctx, id); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+
+		revisions := history(t, all.roles, id)
+		if len(revisions) != 3 {
+			t.Fatalf("Expected 3 revisions, got %d", len(revisions))
+		}
+		expectRevision(t, revisions[0], ifaces.RevisionInsert, inserted, "Name")
+		expectRevision(t, revisions[1], ifaces.RevisionUpdate, updated, "Name")
+		expectRevision(t, revisions[2], ifaces.RevisionDelete, withVersion(updated, models.FIRST_VERSION+2))
+
+		after := ifaces.RevisionTime()
+		for i, revision := range revisions {
+			if revision.Actor != "hr" {
+				t.Fatalf("Unexpected actor: '%s'", revision.Actor)
+			}
+			if revision.Time.Before(before) || revision.Time.After(after) ||
+				i > 0 && revision.Time.Before(revisions[i-1].Time) {
+				t.Fatalf("Unexpected time of revision %d: %s", i, revision.Time)
+			}
+		}
+
+		// Fields of the embedded structures are compared
+		owner := insert(t, all.peoples, SamplePeople(0))
+		phone := insert(t, all.phones, withOwner(SamplePhone(0), getId(owner)))
+		moved := update(t, all.phones, withOwner(phone, getId(insert(t, all.peoples, SamplePeople(1)))))
+		phoneRevisions := history(t, all.phones, getId(phone))
+		expectRevision(t, phoneRevisions[1], ifaces.RevisionUpdate, moved, "Owner")
+
+		// Changes without actor
+		user := insert(t, all.users, SampleUser(0))
+		if revisions := history(t, all.users, getId(user)); revisions[0].Actor != "" {
+			t.Fatalf("Unexpected actor: '%s'", revisions[0].Actor)
+		}
+
+		_, err = all.roles.History(1000000)
+		expectErr(t, "History", err, ifaces.ErrNoSuchRecord)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		people := insert(t, all.peoples, SamplePeople(0))
+		other := insert(t, all.peoples, SamplePeople(1))
+		expectErr(t, "Restore", all.peoples.Restore(getId(people)), ifaces.ErrNoSuchRecord)
+
+		if err := all.peoples.Delete(getId(people)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		_, err := all.peoples.Get(getId(people))
+		expectErr(t, "Get", err, ifaces.ErrNoSuchRecord)
+		if n := count(t, all.peoples); n != 1 {
+			t.Fatalf("Deleted record is visible: %d records", n)
+		}
+
+		if err := all.peoples.Restore(getId(people)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+		restored := withVersion(people, getVersion(people)+2)
+		expectRecord(t, all.peoples, restored)
+		expectRecord(t, all.peoples, other)
+
+		revisions := history(t, all.peoples, getId(people))
+		expectRevision(t, revisions[len(revisions)-1], ifaces.RevisionRestore, restored)
+
+		// Stale version of the record does not overwrite restored one
+		expectErr(t, "Update", all.peoples.Update(people), ifaces.ErrConflict)
+		expectErr(t, "Restore", all.peoples.Restore(getId(people)), ifaces.ErrNoSuchRecord)
+		expectErr(t, "Restore", all.peoples.Restore(1000000), ifaces.ErrNoSuchRecord)
+	})
+
+	t.Run("RestoreUnique", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		alice := insert(t, all.users, models.User{Login: "alice"})
+		if err := all.users.Delete(getId(alice)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		insert(t, all.users, models.User{Login: "alice"})
+
+		expectUniqueViolation(t, "Restore", all.users.Restore(getId(alice)), models.UserLoginIndex)
+		_, err := all.users.Get(getId(alice))
+		expectErr(t, "Get", err, ifaces.ErrNoSuchRecord)
+	})
+
+	t.Run("At", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		before := ifaces.RevisionTime()
+		tick()
+		people := insert(t, all.peoples, SamplePeople(0))
+		tick()
+		changed := people
+		changed.Works = nil
+		changed.Surname = "changed"
+		changed = update(t, all.peoples, changed)
+		tick()
+		if err := all.peoples.Delete(getId(people)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		tick()
+		if err := all.peoples.Restore(getId(people)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+
+		revisions := history(t, all.peoples, getId(people))
+		if len(revisions) != 4 {
+			t.Fatalf("Expected 4 revisions, got %d", len(revisions))
+		}
+		expectRevision(t, revisions[1], ifaces.RevisionUpdate, changed, "Surname", "Works")
+
+		restored := withVersion(changed, getVersion(changed)+2)
+		expectAt(t, all.peoples, getId(people), before, nil)
+		expectAt(t, all.peoples, getId(people), revisions[0].Time, &people)
+		expectAt(t, all.peoples, getId(people), revisions[1].Time.Add(time.Microsecond/2), &changed)
+		expectAt(t, all.peoples, getId(people), revisions[2].Time, nil)
+		expectAt(t, all.peoples, getId(people), time.Now(), &restored)
+	})
+
+	t.Run("ImportHistory", func(t *testing.T) {
+		_, source := openDatabase(t, factory)
+		ctx := ifaces.WithActor(context.Background(), "hr")
+
+		live := insert(t, source.roles, SampleRole(0))
+		live = update(t, source.roles, withVersion(withId(SampleRole(1), getId(live)), getVersion(live)))
+		deleted := insert(t, source.roles, SampleRole(2))
+		if err := source.roles.DeleteContext(ctx, getId(deleted)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+
+		var revisions []ifaces.Revision[models.Role]
+		err := source.roles.Revisions(func(revision ifaces.Revision[models.Role]) bool {
+			revisions = append(revisions, revision)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Revisions error: %s", err)
+		}
+		expected := append(history(t, source.roles, getId(live)), history(t, source.roles, getId(deleted))...)
+		if !reflect.DeepEqual(revisions, expected) {
+			t.Fatalf("Revisions differ:\n got: %+v\nwant: %+v", revisions, expected)
+		}
+
+		// Imported record gets the revisions of the source
+		db, target := openDatabase(t, factory)
+		tick()
+		err = db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+			if err := roles.Import(live); err != nil {
+				return err
+			}
+			return roles.ImportHistory(revisions)
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		if imported := history(t, target.roles, getId(live)); !reflect.DeepEqual(imported, expected[:2]) {
+			t.Fatalf("History differs:\n got: %+v\nwant: %+v", imported, expected[:2])
+		}
+		if imported := history(t, target.roles, getId(deleted)); !reflect.DeepEqual(imported, expected[2:]) {
+			t.Fatalf("History differs:\n got: %+v\nwant: %+v", imported, expected[2:])
+		}
+		first := withVersion(withId(SampleRole(0), getId(live)), models.FIRST_VERSION)
+		expectAt(t, target.roles, getId(live), expected[0].Time, &first)
+		expectAt(t, target.roles, getId(deleted), expected[3].Time, nil)
+
+		// Deleted record is restored, its id is not reused
+		if err := target.roles.Restore(getId(deleted)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+		expectRecord(t, target.roles, withVersion(deleted, getVersion(deleted)+2))
+		if role := insert(t, target.roles, SampleRole(3)); getId(role) == getId(deleted) {
+			t.Fatalf("Id %d of the deleted record is reused", getId(role))
+		}
+
+		wrong := expected[0]
+		wrong.Version++
+		expectErr(t, "ImportHistory", target.roles.ImportHistory([]ifaces.Revision[models.Role]{wrong}), ifaces.ErrWrongRecord)
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		role := insert(t, all.roles, SampleRole(0))
+
+		var inserted models.Role
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+
+			inserted = insert(t, roles, SampleRole(1))
+			if err := roles.Delete(getId(role)); err != nil {
+				t.Fatalf("Delete error: %s", err)
+			}
+			if err := roles.Restore(getId(role)); err != nil {
+				t.Fatalf("Restore error: %s", err)
+			}
+
+			revisions := history(t, roles, getId(role))
+			expectRevision(t, revisions[2], ifaces.RevisionRestore, withVersion(role, getVersion(role)+2))
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+
+		if revisions := history(t, all.roles, getId(role)); len(revisions) != 1 {
+			t.Fatalf("Revisions of the rolled back transaction are kept: %d revisions", len(revisions))
+		}
+		_, err = all.roles.History(getId(inserted))
+		expectErr(t, "History", err, ifaces.ErrNoSuchRecord)
+
+		err = db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+			inserted = insert(t, roles, SampleRole(1))
+			return roles.Delete(getId(inserted))
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+
+		// Record inserted and deleted by the transaction may be restored
+		if err := all.roles.Restore(getId(inserted)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+		expectRecord(t, all.roles, withVersion(inserted, getVersion(inserted)+2))
+	})
+}
@@ -0,0 +1,127 @@
+package dbtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// rolesTableName is the name of the roles table in all backends.
+const rolesTableName = "roles"
+
+// idStrategies is implemented by the databases with configurable ids.
+type idStrategies interface {
+	SetIdStrategies(strategies ifaces.IdStrategies) error
+}
+
+// openWithIds opens the database with the id strategies. The test is
+// skipped if the database does not support them.
+func openWithIds(t *testing.T, factory Factory, strategies ifaces.IdStrategies) (ifaces.Database, txTables) {
+	t.Helper()
+
+	db := factory(t)
+	setter, ok := db.(idStrategies)
+	if !ok {
+		t.Skip("Id strategies are not supported")
+	}
+	if err := setter.SetIdStrategies(strategies); err != nil {
+		t.Fatalf("SetIdStrategies error: %s", err)
+	}
+	if err := db.Open(); err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	t.Cleanup(db.Close)
+
+	return db, tables(t, db)
+}
+
+// runIds tests the id strategies.
+func runIds(t *testing.T, factory Factory) {
+	t.Run("UnknownTable", func(t *testing.T) {
+		db := factory(t)
+		setter, ok := db.(idStrategies)
+		if !ok {
+			t.Skip("Id strategies are not supported")
+		}
+		err := setter.SetIdStrategies(ifaces.IdStrategies{"missing": ifaces.RandomIds()})
+		expectErr(t, "SetIdStrategies", err, ifaces.ErrNoSuchTable)
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		_, all := openWithIds(t, factory, ifaces.IdStrategies{
+			rolesTableName: ifaces.RandomIds(),
+		})
+
+		ids := make(map[models.IdData]bool)
+		large := false
+		for i := 0; i < 10; i++ {
+			role := insert(t, all.roles, SampleRole(i))
+			id := getId(role)
+			if ids[id] || id < 0 {
+				t.Fatalf("Unexpected id: %d", id)
+			}
+			ids[id] = true
+			large = large || id > 1<<32
+			expectRecord(t, all.roles, role)
+
+			parsed, err := models.ParseId(models.FormatId(id))
+			if err != nil || parsed != id {
+				t.Fatalf("Id %d is parsed as %d, error: %v", id, parsed, err)
+			}
+		}
+		if !large {
+			t.Fatalf("Ids are not random: %v", ids)
+		}
+
+		// Other tables are sequential
+		first := insert(t, all.users, SampleUser(0))
+		second := insert(t, all.users, SampleUser(1))
+		if getId(second) != getId(first)+1 {
+			t.Fatalf("Ids are not sequential: %d, %d", getId(first), getId(second))
+		}
+	})
+
+	t.Run("TimeOrdered", func(t *testing.T) {
+		db, all := openWithIds(t, factory, ifaces.IdStrategies{
+			rolesTableName: ifaces.TimeOrderedIds(),
+		})
+		ctx := context.Background()
+
+		var last models.IdData
+		next := func(id models.IdData) {
+			t.Helper()
+			if id <= last || id < 1<<40 {
+				t.Fatalf("Id %d is not after %d", id, last)
+			}
+			if models.FormatId(id) <= models.FormatId(last) {
+				t.Fatalf("String form of %d is not ordered", id)
+			}
+			last = id
+		}
+
+		for i := 0; i < 5; i++ {
+			next(getId(insert(t, all.roles, SampleRole(i))))
+		}
+		if err := all.roles.Delete(last); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+			for i := 5; i < 8; i++ {
+				id, err := roles.InsertContext(ctx, SampleRole(i))
+				if err != nil {
+					return err
+				}
+				next(id)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		next(getId(insert(t, all.roles, SampleRole(8))))
+	})
+}
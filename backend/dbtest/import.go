@@ -0,0 +1,129 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// runImport tests import of the records with their ids.
+func runImport(t *testing.T, factory Factory) {
+	t.Run("Import", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		imported := withVersion(withId(SamplePeople(0), 100), 5)
+		if err := all.peoples.Import(imported); err != nil {
+			t.Fatalf("Import error: %s", err)
+		}
+		expectRecord(t, all.peoples, imported)
+		revisions := history(t, all.peoples, 100)
+		expectRevision(t, revisions[0], ifaces.RevisionInsert, imported,
+			"Name", "Surname", "Patronymic", "Birth", "Photo", "Tax", "Works", "Position", "Grade")
+
+		// Records stored before the versions get the first one
+		legacy := withId(SamplePeople(1), 50)
+		if err := all.peoples.Import(legacy); err != nil {
+			t.Fatalf("Import error: %s", err)
+		}
+		expectRecord(t, all.peoples, withVersion(legacy, models.FIRST_VERSION))
+
+		// Inserted records follow the imported ones
+		inserted := insert(t, all.peoples, SamplePeople(2))
+		if getId(inserted) <= 100 {
+			t.Fatalf("Id %d is allocated before the imported ones", getId(inserted))
+		}
+
+		expectErr(t, "Import", all.peoples.Import(withId(SamplePeople(3), 100)), ifaces.ErrConflict)
+		if err := all.peoples.Delete(50); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		expectErr(t, "Import", all.peoples.Import(withId(SamplePeople(3), 50)), ifaces.ErrConflict)
+		expectErr(t, "Import", all.peoples.Import(withId(SamplePeople(3), models.BAD_ID)), ifaces.ErrWrongRecord)
+
+		// Imported records are checked as inserted ones
+		expectErr(t, "Import", all.roles.Import(withId(SampleRole(0), 1)), nil)
+		expectErr(t, "Import", all.roles.Import(withId(SampleRole(0), 2)), ifaces.ErrUniqueViolation)
+		expectErr(t, "Import", all.phones.Import(withOwner(withId(SamplePhone(0), 1), 1000000)), ifaces.ErrNoOwner)
+		expectErr(t, "Import", all.phones.Import(withOwner(withId(SamplePhone(0), 1), 100)), nil)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+		ctx := context.Background()
+
+		people := insert(t, all.peoples, SamplePeople(0))
+		replaced := people
+		replaced.Surname = "Replaced"
+		if err := all.peoples.Replace(replaced); err != nil {
+			t.Fatalf("Replace error: %s", err)
+		}
+		// Record keeps its version and history
+		expectRecord(t, all.peoples, replaced)
+		if revisions := history(t, all.peoples, getId(people)); len(revisions) != 1 || !reflect.DeepEqual(revisions[0].Record, people) {
+			t.Fatalf("Unexpected history: %+v", revisions)
+		}
+
+		expectErr(t, "Replace", all.peoples.Replace(withVersion(replaced, getVersion(replaced)+1)), ifaces.ErrConflict)
+		expectErr(t, "Replace", all.peoples.Replace(withId(replaced, 1000000)), ifaces.ErrNoSuchRecord)
+		if err := all.peoples.Delete(getId(people)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		expectErr(t, "Replace", all.peoples.Replace(replaced), ifaces.ErrNoSuchRecord)
+
+		// Replaced records are checked as updated ones
+		insert(t, all.roles, SampleRole(0))
+		role := insert(t, all.roles, SampleRole(1))
+		expectErr(t, "Replace", all.roles.Replace(withVersion(withId(SampleRole(0), getId(role)), getVersion(role))), ifaces.ErrUniqueViolation)
+
+		role.Name = "Replaced"
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			return tables(t, tx).roles.ReplaceContext(ctx, role)
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		expectRecord(t, all.roles, role)
+		if revisions := history(t, all.roles, getId(role)); len(revisions) != 1 {
+			t.Fatalf("Unexpected history: %+v", revisions)
+		}
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+		ctx := ifaces.WithSnapshot(context.Background())
+
+		role := withVersion(withId(SampleRole(0), 10), 2)
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			if err := tables(t, tx).roles.ImportContext(ctx, role); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+		_, err = all.roles.Get(10)
+		expectErr(t, "Get", err, ifaces.ErrNoSuchRecord)
+
+		err = db.Tx(ctx, func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+			if err := roles.ImportContext(ctx, role); err != nil {
+				return err
+			}
+			if err := roles.ImportContext(ctx, role); !errors.Is(err, ifaces.ErrConflict) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			id, err := roles.InsertContext(ctx, SampleRole(1))
+			if err == nil && id <= 10 {
+				t.Errorf("Id %d is allocated before the imported one", id)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		expectRecord(t, all.roles, role)
+	})
+}
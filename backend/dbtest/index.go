@@ -0,0 +1,191 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func expectLookup[M ifaces.Models](t *testing.T, table ifaces.Table[M], index string, key string, expected ...M) {
+	t.Helper()
+
+	records, err := table.Lookup(index, key)
+	if err != nil {
+		t.Fatalf("Lookup error: %s", err)
+	}
+	if len(records) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("Lookup '%s' by '%s':\n got: %+v\nwant: %+v", index, key, records, expected)
+	}
+}
+
+func expectUniqueViolation(t *testing.T, operation string, err error, index string) {
+	t.Helper()
+
+	expectErr(t, operation, err, ifaces.ErrUniqueViolation)
+
+	var violation *ifaces.UniqueViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("%s: error is not UniqueViolationError: %v", operation, err)
+	}
+	if violation.Index != "" && violation.Index != index {
+		t.Fatalf("%s: unexpected index '%s'", operation, violation.Index)
+	}
+}
+
+// runIndexes tests secondary indexes declared by the models.
+func runIndexes(t *testing.T, factory Factory) {
+	t.Run("Unique", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		alice := insert(t, all.users, models.User{Login: "alice", Salt: "1"})
+		bob := insert(t, all.users, models.User{Login: "bob", Salt: "2"})
+
+		_, err := all.users.Insert(models.User{Login: "alice", Salt: "3"})
+		expectUniqueViolation(t, "Insert", err, models.UserLoginIndex)
+		if n := count(t, all.users); n != 2 {
+			t.Fatalf("Duplicated record is inserted: %d records", n)
+		}
+
+		taken := bob
+		taken.Login = "alice"
+		expectUniqueViolation(t, "Update", all.users.Update(taken), models.UserLoginIndex)
+		expectRecord(t, all.users, bob)
+
+		// Record does not conflict with itself
+		alice.Salt = "4"
+		if err := all.users.Update(alice); err != nil {
+			t.Fatalf("Update error: %s", err)
+		}
+
+		// Key is free after delete
+		if err := all.users.Delete(getId(bob)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		insert(t, all.users, models.User{Login: "bob"})
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		alice := insert(t, all.users, models.User{Login: "alice"})
+		bob := insert(t, all.users, models.User{Login: "bob"})
+
+		expectLookup(t, all.users, models.UserLoginIndex, "alice", alice)
+		expectLookup(t, all.users, models.UserLoginIndex, "bob", bob)
+		expectLookup(t, all.users, models.UserLoginIndex, "eve")
+
+		found, err := ifaces.GetBy(all.users, models.UserLoginIndex, "bob")
+		if err != nil || !reflect.DeepEqual(found, bob) {
+			t.Fatalf("GetBy: %+v, error: %v", found, err)
+		}
+		_, err = ifaces.GetBy(all.users, models.UserLoginIndex, "eve")
+		expectErr(t, "GetBy", err, ifaces.ErrNoSuchRecord)
+
+		bob.Login = "robert"
+		bob = update(t, all.users, bob)
+		expectLookup(t, all.users, models.UserLoginIndex, "bob")
+		expectLookup(t, all.users, models.UserLoginIndex, "robert", bob)
+
+		if err := all.users.Delete(getId(alice)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		expectLookup(t, all.users, models.UserLoginIndex, "alice")
+		if err := all.users.Restore(getId(alice)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+		expectLookup(t, all.users, models.UserLoginIndex, "alice", withVersion(alice, getVersion(alice)+2))
+
+		_, err = all.users.Lookup("no such index", "alice")
+		expectErr(t, "Lookup", err, ifaces.ErrNoSuchIndex)
+	})
+
+	t.Run("NonUnique", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		john := getId(insert(t, all.peoples, SamplePeople(0)))
+		jane := getId(insert(t, all.peoples, SamplePeople(1)))
+		family := insert(t, all.emails, withOwner(models.Email{Mail: "family@example.com"}, john))
+		mail := insert(t, all.emails, withOwner(models.Email{Mail: "john@example.com"}, john))
+		other := insert(t, all.emails, withOwner(models.Email{Mail: "family@example.com"}, jane))
+
+		expectLookup(t, all.emails, models.EmailIndex, "family@example.com", family, other)
+		expectLookup(t, all.emails, models.EmailIndex, "john@example.com", mail)
+
+		family.Mail = "john@example.com"
+		family = update(t, all.emails, family)
+		expectLookup(t, all.emails, models.EmailIndex, "family@example.com", other)
+		expectLookup(t, all.emails, models.EmailIndex, "john@example.com", family, mail)
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		alice := insert(t, all.users, models.User{Login: "alice"})
+
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			users := tables(t, tx).users
+
+			_, err := users.Insert(models.User{Login: "alice"})
+			expectUniqueViolation(t, "Insert", err, models.UserLoginIndex)
+
+			// Key of the deleted record may be reused in the same transaction
+			if err := users.Delete(getId(alice)); err != nil {
+				t.Fatalf("Delete error: %s", err)
+			}
+			alice = insert(t, users, models.User{Login: "alice", Salt: "new"})
+			bob := insert(t, users, models.User{Login: "bob"})
+
+			expectLookup(t, users, models.UserLoginIndex, "alice", alice)
+			expectLookup(t, users, models.UserLoginIndex, "bob", bob)
+
+			_, err = users.Insert(models.User{Login: "bob"})
+			expectUniqueViolation(t, "Insert", err, models.UserLoginIndex)
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+
+		expectLookup(t, all.users, models.UserLoginIndex, "bob")
+		if found, err := ifaces.GetBy(all.users, models.UserLoginIndex, "alice"); err != nil || found.Salt != "" {
+			t.Fatalf("Rolled back record is found: %+v, error: %v", found, err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		const workers = 8
+
+		var wg sync.WaitGroup
+		results := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := all.users.Insert(models.User{Login: "alice"})
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		inserted := 0
+		for err := range results {
+			if err == nil {
+				inserted++
+			} else if !errors.Is(err, ifaces.ErrUniqueViolation) {
+				t.Fatalf("Insert error: %s", err)
+			}
+		}
+		if inserted != 1 {
+			t.Fatalf("Expected single insert, got %d", inserted)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package dbtest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// owned are the records of the owner inserted to all owned tables.
+type owned struct {
+	phone       models.Phone
+	address     models.Address
+	email       models.Email
+	bankAccount models.BankAccount
+}
+
+func insertOwned(t *testing.T, all txTables, owner models.IdData, i int) owned {
+	t.Helper()
+
+	return owned{
+		phone:       insert(t, all.phones, withOwner(SamplePhone(i), owner)),
+		address:     insert(t, all.addresses, withOwner(SampleAddress(i), owner)),
+		email:       insert(t, all.emails, withOwner(SampleEmail(i), owner)),
+		bankAccount: insert(t, all.bankAccounts, withOwner(SampleBankAccount(i), owner)),
+	}
+}
+
+func expectOwned(t *testing.T, all txTables, records owned) {
+	t.Helper()
+
+	expectRecord(t, all.phones, records.phone)
+	expectRecord(t, all.addresses, records.address)
+	expectRecord(t, all.emails, records.email)
+	expectRecord(t, all.bankAccounts, records.bankAccount)
+}
+
+// expectDeleted checks that the record is deleted with the revision.
+func expectDeleted[M ifaces.Models](t *testing.T, table ifaces.Table[M], record M) {
+	t.Helper()
+
+	_, err := table.Get(getId(record))
+	expectErr(t, "Get", err, ifaces.ErrNoSuchRecord)
+
+	revisions := history(t, table, getId(record))
+	expectRevision(t, revisions[len(revisions)-1], ifaces.RevisionDelete, withVersion(record, getVersion(record)+1))
+}
+
+func expectOwnedDeleted(t *testing.T, all txTables, records owned) {
+	t.Helper()
+
+	expectDeleted(t, all.phones, records.phone)
+	expectDeleted(t, all.addresses, records.address)
+	expectDeleted(t, all.emails, records.email)
+	expectDeleted(t, all.bankAccounts, records.bankAccount)
+}
+
+// runOwners tests the owner references of the owned records and deletion
+// of the owned records with the owner.
+func runOwners(t *testing.T, factory Factory) {
+	const missingId = 1000000
+
+	t.Run("NoOwner", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		_, err := all.phones.Insert(SamplePhone(0))
+		expectErr(t, "Insert", err, ifaces.ErrNoOwner)
+		_, err = all.emails.Insert(withOwner(SampleEmail(0), missingId))
+		expectErr(t, "Insert", err, ifaces.ErrNoOwner)
+		if n := count(t, all.emails); n != 0 {
+			t.Fatalf("Record without owner is inserted")
+		}
+
+		people := insert(t, all.peoples, SamplePeople(0))
+		address := insert(t, all.addresses, withOwner(SampleAddress(0), getId(people)))
+		expectErr(t, "Update", all.addresses.Update(withOwner(address, missingId)), ifaces.ErrNoOwner)
+		expectRecord(t, all.addresses, address)
+
+		// Owner may be changed to the existing people
+		other := insert(t, all.peoples, SamplePeople(1))
+		address = update(t, all.addresses, withOwner(address, getId(other)))
+		expectRecord(t, all.addresses, address)
+
+		// Owned record is not restored without the owner
+		if err := all.addresses.Delete(getId(address)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		if err := all.peoples.Delete(getId(other)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		expectErr(t, "Restore", all.addresses.Restore(getId(address)), ifaces.ErrNoOwner)
+
+		if err := all.peoples.Restore(getId(other)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+		if err := all.addresses.Restore(getId(address)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+		expectRecord(t, all.addresses, withVersion(address, getVersion(address)+2))
+	})
+
+	t.Run("Cascade", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		people := insert(t, all.peoples, SamplePeople(0))
+		other := insert(t, all.peoples, SamplePeople(1))
+		deleted := insertOwned(t, all, getId(people), 0)
+		kept := insertOwned(t, all, getId(other), 1)
+
+		if err := all.peoples.Delete(getId(people)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		expectDeleted(t, all.peoples, people)
+		expectOwnedDeleted(t, all, deleted)
+		expectOwned(t, all, kept)
+
+		// Restored people does not restore its records
+		if err := all.peoples.Restore(getId(people)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+		if n := count(t, all.phones); n != 1 {
+			t.Fatalf("Unexpected phones count: %d", n)
+		}
+	})
+
+	t.Run("CascadeTx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+		ctx := context.Background()
+
+		people := insert(t, all.peoples, SamplePeople(0))
+		records := insertOwned(t, all, getId(people), 0)
+
+		// Rolled back delete keeps the owned records
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			if err := tables(t, tx).peoples.DeleteContext(ctx, getId(people)); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+		expectRecord(t, all.peoples, people)
+		expectOwned(t, all, records)
+
+		err = db.Tx(ctx, func(tx ifaces.Tx) error {
+			inTx := tables(t, tx)
+			// Record of the people inserted in the transaction is deleted
+			// with it
+			if _, err := inTx.phones.InsertContext(ctx, withOwner(SamplePhone(1), getId(people))); err != nil {
+				return err
+			}
+			return inTx.peoples.DeleteContext(ctx, getId(people))
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		expectOwnedDeleted(t, all, records)
+		if n := count(t, all.phones); n != 0 {
+			t.Fatalf("Unexpected phones count: %d", n)
+		}
+	})
+
+	t.Run("OwnedBy", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		ctx := context.Background()
+
+		people := insert(t, all.peoples, SamplePeople(0))
+		other := insert(t, all.peoples, SamplePeople(1))
+
+		var expected []models.Phone
+		for i := 0; i < 3; i++ {
+			expected = append(expected, insert(t, all.phones, withOwner(SamplePhone(i), getId(people))))
+			insert(t, all.phones, withOwner(SamplePhone(i+10), getId(other)))
+		}
+
+		phones, err := ifaces.OwnedBy(ctx, all.phones, getId(people))
+		if err != nil {
+			t.Fatalf("OwnedBy error: %s", err)
+		}
+		if !reflect.DeepEqual(phones, expected) {
+			t.Fatalf("Unexpected records:\n got: %+v\nwant: %+v", phones, expected)
+		}
+
+		phones, err = ifaces.OwnedBy(ctx, all.phones, missingId)
+		if err != nil || len(phones) != 0 {
+			t.Fatalf("Unexpected records of the missing owner: %+v, error: %v", phones, err)
+		}
+	})
+}
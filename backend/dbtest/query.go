@@ -0,0 +1,221 @@
+package dbtest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+var querySurnames = []string{"Smith", "Doe", "Smith", "Brown", "Doe", "Smith", "Adams", "Doe", "Brown", "Smith"}
+
+// queryPeoples inserts peoples for the query tests. Grade is set for the
+// odd records only.
+func queryPeoples(t *testing.T, table ifaces.Table[models.People]) []models.People {
+	t.Helper()
+
+	var ret []models.People
+	for i, surname := range querySurnames {
+		record := SamplePeople(i)
+		record.Surname = surname
+		if i%2 == 0 {
+			record.Grade = nil
+		}
+		ret = append(ret, insert(t, table, record))
+	}
+	return ret
+}
+
+func query[M ifaces.Models](t *testing.T, table ifaces.Table[M], q *ifaces.Query) ifaces.Page[M] {
+	t.Helper()
+
+	page, err := table.Query(q)
+	if err != nil {
+		t.Fatalf("Query error: %s", err)
+	}
+	return page
+}
+
+// expectPage checks that page contains records with the indexes in
+// records, in this order.
+func expectPage[M ifaces.Models](t *testing.T, page []M, records []M, expected ...int) {
+	t.Helper()
+
+	var got, want []models.IdData
+	for _, record := range page {
+		got = append(got, getId(record))
+	}
+	for _, i := range expected {
+		want = append(want, getId(records[i]))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected page:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+// runQuery tests Table.Query.
+func runQuery(t *testing.T, factory Factory) {
+	t.Run("Filters", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		records := queryPeoples(t, all.peoples)
+
+		page := query(t, all.peoples, ifaces.NewQuery().Where("Surname", ifaces.Eq, "Smith"))
+		expectPage(t, page.Records, records, 0, 2, 5, 9)
+		if page.Next != "" {
+			t.Fatalf("Unexpected next page of the unlimited query")
+		}
+		if !reflect.DeepEqual(page.Records[1], records[2]) {
+			t.Fatalf("Record differs:\n got: %+v\nwant: %+v", page.Records[1], records[2])
+		}
+
+		page = query(t, all.peoples, ifaces.NewQuery().
+			Where("Name", ifaces.Ge, "name5").
+			Where("Grade", ifaces.Eq, nil))
+		expectPage(t, page.Records, records, 6, 8)
+
+		page = query(t, all.peoples, ifaces.NewQuery().Where("Grade", ifaces.Ne, nil).Where("Surname", ifaces.Ne, "Doe"))
+		expectPage(t, page.Records, records, 3, 5, 9)
+
+		page = query(t, all.peoples, ifaces.NewQuery().Where("Name", ifaces.Prefix, "name1"))
+		expectPage(t, page.Records, records, 1)
+
+		page = query(t, all.peoples, ifaces.NewQuery().
+			Where(ifaces.IdField, ifaces.Gt, getId(records[6])).
+			Where("Grade", ifaces.Lt, models.Senjor+1))
+		expectPage(t, page.Records, records)
+
+		page = query(t, all.peoples, ifaces.NewQuery().Where("Grade", ifaces.Eq, models.Middle))
+		expectPage(t, page.Records, records, 1, 3, 5, 7, 9)
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		records := queryPeoples(t, all.peoples)
+
+		// Default order is by id
+		page := query(t, all.peoples, nil)
+		expectPage(t, page.Records, records, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+
+		page = query(t, all.peoples, ifaces.NewQuery().OrderBy("Surname").OrderByDesc("Name"))
+		expectPage(t, page.Records, records, 6, 8, 3, 7, 4, 1, 9, 5, 2, 0)
+
+		// Equal values are ordered by id
+		page = query(t, all.peoples, ifaces.NewQuery().OrderByDesc("Surname"))
+		expectPage(t, page.Records, records, 0, 2, 5, 9, 1, 4, 7, 3, 8, 6)
+
+		page = query(t, all.peoples, ifaces.NewQuery().Offset(2).Limit(2))
+		expectPage(t, page.Records, records, 2, 3)
+
+		page = query(t, all.peoples, ifaces.NewQuery().Offset(8))
+		expectPage(t, page.Records, records, 8, 9)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		records := queryPeoples(t, all.peoples)
+
+		var collected []models.People
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(records) {
+				t.Fatalf("Pagination does not stop")
+			}
+			page := query(t, all.peoples, ifaces.NewQuery().
+				OrderBy("Surname").
+				OrderByDesc("Name").
+				Limit(3).
+				After(cursor))
+			if len(page.Records) > 3 {
+				t.Fatalf("Page is over the limit: %d records", len(page.Records))
+			}
+			collected = append(collected, page.Records...)
+			if page.Next == "" {
+				break
+			}
+			cursor = page.Next
+		}
+		expectPage(t, collected, records, 6, 8, 3, 7, 4, 1, 9, 5, 2, 0)
+	})
+
+	t.Run("StableCursor", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		records := queryPeoples(t, all.peoples)
+
+		q := func(cursor string) *ifaces.Query {
+			return ifaces.NewQuery().OrderBy("Surname").Limit(4).After(cursor)
+		}
+
+		first := query(t, all.peoples, q(""))
+		expectPage(t, first.Records, records, 6, 3, 8, 1)
+
+		// Changes before the cursor do not shift the next page
+		before := SamplePeople(100)
+		before.Surname = "Aaron"
+		insert(t, all.peoples, before)
+		if err := all.peoples.Delete(getId(records[3])); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+
+		second := query(t, all.peoples, q(first.Next))
+		expectPage(t, second.Records, records, 4, 7, 0, 2)
+
+		third := query(t, all.peoples, q(second.Next))
+		expectPage(t, third.Records, records, 5, 9)
+		if third.Next != "" {
+			t.Fatalf("Unexpected next page")
+		}
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+		records := queryPeoples(t, all.peoples)
+
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			peoples := tables(t, tx).peoples
+
+			record := SamplePeople(100)
+			record.Surname = "Adams"
+			records = append(records, insert(t, peoples, record))
+			if err := peoples.Delete(getId(records[6])); err != nil {
+				t.Fatalf("Delete error: %s", err)
+			}
+
+			page := query(t, peoples, ifaces.NewQuery().Where("Surname", ifaces.Le, "Brown").OrderBy("Surname"))
+			expectPage(t, page.Records, records, 10, 3, 8)
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		queryPeoples(t, all.peoples)
+
+		for _, q := range []*ifaces.Query{
+			ifaces.NewQuery().Where("NoSuchField", ifaces.Eq, 1),
+			ifaces.NewQuery().Where("Works", ifaces.Eq, 1),
+			// Encrypted fields
+			ifaces.NewQuery().Where("Birth", ifaces.Ge, time.Date(1985, 1, 1, 0, 0, 0, 0, time.UTC)),
+			ifaces.NewQuery().OrderBy("Birth"),
+			ifaces.NewQuery().Where("Surname", ifaces.Eq, 1),
+			ifaces.NewQuery().Where("Surname", ifaces.Eq, nil),
+			ifaces.NewQuery().Where("Grade", ifaces.Prefix, "1"),
+			ifaces.NewQuery().Where("Surname", "like", "D%"),
+			ifaces.NewQuery().OrderBy("Grade"),
+			ifaces.NewQuery().Limit(-1),
+		} {
+			_, err := all.peoples.Query(q)
+			expectErr(t, "Query", err, ifaces.ErrBadQuery)
+		}
+
+		_, err := all.peoples.Query(ifaces.NewQuery().After("not a cursor"))
+		expectErr(t, "Query", err, ifaces.ErrBadCursor)
+
+		page := query(t, all.peoples, ifaces.NewQuery().Limit(1))
+		_, err = all.peoples.Query(ifaces.NewQuery().OrderBy("Surname").Limit(1).After(page.Next))
+		expectErr(t, "Query", err, ifaces.ErrBadCursor)
+	})
+}
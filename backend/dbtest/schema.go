@@ -0,0 +1,64 @@
+package dbtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+func schemaVersion(t *testing.T, db ifaces.Database) int {
+	t.Helper()
+
+	var version int
+	err := db.Tx(context.Background(), func(tx ifaces.Tx) (err error) {
+		version, err = tx.SchemaVersion(context.Background())
+		return
+	})
+	if err != nil {
+		t.Fatalf("SchemaVersion error: %s", err)
+	}
+	return version
+}
+
+// runSchema tests the schema version of the database.
+func runSchema(t *testing.T, factory Factory) {
+	t.Run("Version", func(t *testing.T) {
+		db, _ := openDatabase(t, factory)
+		ctx := context.Background()
+
+		if version := schemaVersion(t, db); version != 0 {
+			t.Fatalf("Unexpected version of the new database: %d", version)
+		}
+
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			if err := tx.SetSchemaVersion(ctx, 1); err != nil {
+				return err
+			}
+			if err := tx.SetSchemaVersion(ctx, 3); err != nil {
+				return err
+			}
+			if version, err := tx.SchemaVersion(ctx); err != nil || version != 3 {
+				t.Fatalf("Unexpected version in the tx: %d, error: %v", version, err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		if version := schemaVersion(t, db); version != 3 {
+			t.Fatalf("Unexpected version: %d", version)
+		}
+
+		err = db.Tx(ctx, func(tx ifaces.Tx) error {
+			if err := tx.SetSchemaVersion(ctx, 4); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+		if version := schemaVersion(t, db); version != 3 {
+			t.Fatalf("Version of the rolled back transaction is kept: %d", version)
+		}
+	})
+}
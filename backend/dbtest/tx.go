@@ -0,0 +1,251 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+var errRollback = errors.New("Rollback!")
+
+type txTables struct {
+	users   ifaces.Table[models.User]
+	peoples ifaces.Table[models.People]
+	roles   ifaces.Table[models.Role]
+
+	phones       ifaces.Table[models.Phone]
+	addresses    ifaces.Table[models.Address]
+	emails       ifaces.Table[models.Email]
+	bankAccounts ifaces.Table[models.BankAccount]
+}
+
+// tableSource is implemented by both ifaces.Database and ifaces.Tx.
+type tableSource interface {
+	Users() (ifaces.Table[models.User], error)
+	Peoples() (ifaces.Table[models.People], error)
+	Roles() (ifaces.Table[models.Role], error)
+	Phones() (ifaces.Table[models.Phone], error)
+	Addresses() (ifaces.Table[models.Address], error)
+	Emails() (ifaces.Table[models.Email], error)
+	BankAccounts() (ifaces.Table[models.BankAccount], error)
+}
+
+// tables returns tables of the tx or database.
+func tables(t *testing.T, source tableSource) txTables {
+	t.Helper()
+
+	var ret txTables
+	var err error
+	if ret.users, err = source.Users(); err != nil {
+		t.Fatalf("Users error: %s", err)
+	}
+	if ret.peoples, err = source.Peoples(); err != nil {
+		t.Fatalf("Peoples error: %s", err)
+	}
+	if ret.roles, err = source.Roles(); err != nil {
+		t.Fatalf("Roles error: %s", err)
+	}
+	if ret.phones, err = source.Phones(); err != nil {
+		t.Fatalf("Phones error: %s", err)
+	}
+	if ret.addresses, err = source.Addresses(); err != nil {
+		t.Fatalf("Addresses error: %s", err)
+	}
+	if ret.emails, err = source.Emails(); err != nil {
+		t.Fatalf("Emails error: %s", err)
+	}
+	if ret.bankAccounts, err = source.BankAccounts(); err != nil {
+		t.Fatalf("BankAccounts error: %s", err)
+	}
+	return ret
+}
+
+func openDatabase(t *testing.T, factory Factory) (ifaces.Database, txTables) {
+	t.Helper()
+
+	db := factory(t)
+	if err := db.Open(); err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	t.Cleanup(db.Close)
+
+	return db, tables(t, db)
+}
+
+// runTx tests Database.Tx.
+func runTx(t *testing.T, factory Factory) {
+	t.Run("Commit", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		var user models.User
+		var people models.People
+		var role models.Role
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			in := tables(t, tx)
+			user = insert(t, in.users, SampleUser(0))
+			people = insert(t, in.peoples, SamplePeople(0))
+			role = insert(t, in.roles, SampleRole(0))
+
+			// Changes are visible inside of the transaction
+			expectRecord(t, in.users, user)
+			if n := count(t, in.peoples); n != 1 {
+				t.Fatalf("Expected 1 record inside of the transaction, got %d", n)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+
+		expectRecord(t, all.users, user)
+		expectRecord(t, all.peoples, people)
+		expectRecord(t, all.roles, role)
+
+		// Ids allocated by the transaction are not reused
+		next := insert(t, all.users, SampleUser(1))
+		if getId(next) == getId(user) {
+			t.Fatalf("Id %d is reused", getId(next))
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		user := insert(t, all.users, SampleUser(0))
+		role := insert(t, all.roles, SampleRole(0))
+
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			in := tables(t, tx)
+			update(t, in.users, withVersion(withId(SampleUser(1), getId(user)), getVersion(user)))
+			if err := in.roles.Delete(getId(role)); err != nil {
+				t.Fatalf("Delete error: %s", err)
+			}
+			insert(t, in.peoples, SamplePeople(0))
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+
+		expectRecord(t, all.users, user)
+		expectRecord(t, all.roles, role)
+		if n := count(t, all.peoples); n != 0 {
+			t.Fatalf("Rolled back insert is visible: %d records", n)
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Panic is not propagated")
+				}
+			}()
+			db.Tx(context.Background(), func(tx ifaces.Tx) error {
+				insert(t, tables(t, tx).users, SampleUser(0))
+				panic(errRollback)
+			})
+		}()
+
+		// Database is usable after the panic
+		if n := count(t, all.users); n != 0 {
+			t.Fatalf("Insert of the panicked transaction is visible: %d records", n)
+		}
+		insert(t, all.users, SampleUser(1))
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			insert(t, tables(t, tx).users, SampleUser(0))
+			cancel()
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("Transaction is committed with canceled context")
+		}
+		if n := count(t, all.users); n != 0 {
+			t.Fatalf("Insert of the canceled transaction is visible: %d records", n)
+		}
+
+		called := false
+		err = db.Tx(ctx, func(tx ifaces.Tx) error {
+			called = true
+			return nil
+		})
+		if err == nil || called {
+			t.Fatalf("Transaction is started with canceled context")
+		}
+	})
+
+	t.Run("Finished", func(t *testing.T) {
+		db, _ := openDatabase(t, factory)
+
+		var users ifaces.Table[models.User]
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			users = tables(t, tx).users
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+
+		_, err = users.Insert(SampleUser(0))
+		expectErr(t, "Insert", err, ifaces.ErrTxDone)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		const workers = 8
+		const perWorker = 10
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+						users, _ := tx.Users()
+						peoples, _ := tx.Peoples()
+						if _, err := users.Insert(SampleUser(w*perWorker + i)); err != nil {
+							return err
+						}
+						if _, err := peoples.Insert(SamplePeople(w*perWorker + i)); err != nil {
+							return err
+						}
+						if i%2 == 1 {
+							return errRollback
+						}
+						return nil
+					})
+					if err != nil && !errors.Is(err, errRollback) {
+						errs <- err
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatalf("Concurrent transaction error: %s", err)
+		}
+
+		expected := workers * perWorker / 2
+		if users, peoples := count(t, all.users), count(t, all.peoples); users != expected || peoples != expected {
+			t.Fatalf("Expected %d committed records, got %d users and %d peoples", expected, users, peoples)
+		}
+	})
+}
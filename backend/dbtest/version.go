@@ -0,0 +1,103 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// runVersions tests optimistic concurrency control by record versions.
+func runVersions(t *testing.T, factory Factory) {
+	t.Run("Insert", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		// Insert assigns the first version, the version of the record is ignored
+		role := insert(t, all.roles, withVersion(SampleRole(0), 42))
+		expectRecord(t, all.roles, role)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		people := insert(t, all.peoples, SamplePeople(0))
+		stale := people
+
+		people.Name = "changed"
+		people = update(t, all.peoples, people)
+		if getVersion(people) != models.FIRST_VERSION+1 {
+			t.Fatalf("Unexpected version: %d", getVersion(people))
+		}
+		expectRecord(t, all.peoples, people)
+
+		// Second editor of the same version loses
+		stale.Surname = "changed"
+		expectErr(t, "Update", all.peoples.Update(stale), ifaces.ErrConflict)
+		expectErr(t, "Update", all.peoples.Update(withVersion(people, getVersion(people)+1)), ifaces.ErrConflict)
+		expectRecord(t, all.peoples, people)
+
+		people.Surname = "changed"
+		people = update(t, all.peoples, people)
+		expectRecord(t, all.peoples, people)
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+
+		user := insert(t, all.users, SampleUser(0))
+
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			users := tables(t, tx).users
+
+			changed := user
+			changed.Salt = "tx"
+			changed = update(t, users, changed)
+			expectRecord(t, users, changed)
+
+			expectErr(t, "Update", users.Update(user), ifaces.ErrConflict)
+
+			user = update(t, users, changed)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		expectRecord(t, all.users, user)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		const workers = 8
+
+		role := insert(t, all.roles, SampleRole(0))
+
+		var wg sync.WaitGroup
+		results := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- all.roles.Update(role)
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		updated := 0
+		for err := range results {
+			if err == nil {
+				updated++
+			} else if !errors.Is(err, ifaces.ErrConflict) {
+				t.Fatalf("Update error: %s", err)
+			}
+		}
+		if updated != 1 {
+			t.Fatalf("Expected single update, got %d", updated)
+		}
+		expectRecord(t, all.roles, withVersion(role, getVersion(role)+1))
+	})
+}
@@ -0,0 +1,192 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+const watchTimeout = 5 * time.Second
+
+// watch starts the watch finished with the test. Skips the test if the
+// backend does not support watches.
+func watch[M ifaces.Models](t *testing.T, table ifaces.Table[M], after uint64) ifaces.Watcher[M] {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	w, err := table.Watch(ctx, after)
+	if errors.Is(err, ifaces.ErrNotSupported) {
+		t.Skip("Watch is not supported")
+	}
+	if err != nil {
+		t.Fatalf("Watch error: %s", err)
+	}
+	return w
+}
+
+func receive[M ifaces.Models](t *testing.T, w ifaces.Watcher[M]) ifaces.Event[M] {
+	t.Helper()
+
+	select {
+	case event, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("Watch is finished: %v", w.Err())
+		}
+		return event
+	case <-time.After(watchTimeout):
+		t.Fatalf("No event in %s", watchTimeout)
+	}
+	panic("unreachable")
+}
+
+func expectNoEvent[M ifaces.Models](t *testing.T, w ifaces.Watcher[M]) {
+	t.Helper()
+
+	select {
+	case event := <-w.Events():
+		t.Fatalf("Unexpected event: %d %s %d.%d", event.Seq, event.Kind, event.Id, event.Version)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func expectEvent[M ifaces.Models](t *testing.T, w ifaces.Watcher[M], kind ifaces.RevisionKind, record M) ifaces.Event[M] {
+	t.Helper()
+
+	event := receive(t, w)
+	if event.Kind != kind || event.Id != getId(record) || event.Version != getVersion(record) {
+		t.Fatalf("Unexpected event: %s %d.%d, want: %s %d.%d",
+			event.Kind, event.Id, event.Version, kind, getId(record), getVersion(record))
+	}
+	return event
+}
+
+// runWatch tests change feeds of the tables.
+func runWatch(t *testing.T, factory Factory) {
+	t.Run("Events", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		insert(t, all.roles, SampleRole(0))
+		w := watch(t, all.roles, 0)
+		expectNoEvent(t, w)
+
+		role := insert(t, all.roles, SampleRole(1))
+		changed := update(t, all.roles, withVersion(withId(SampleRole(2), getId(role)), getVersion(role)))
+		if err := all.roles.Delete(getId(role)); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+		if err := all.roles.Restore(getId(role)); err != nil {
+			t.Fatalf("Restore error: %s", err)
+		}
+
+		var events []ifaces.Event[models.Role]
+		events = append(events, expectEvent(t, w, ifaces.RevisionInsert, role))
+		events = append(events, expectEvent(t, w, ifaces.RevisionUpdate, changed))
+		events = append(events, expectEvent(t, w, ifaces.RevisionDelete, withVersion(changed, getVersion(changed)+1)))
+		events = append(events, expectEvent(t, w, ifaces.RevisionRestore, withVersion(changed, getVersion(changed)+2)))
+
+		for i, event := range events {
+			if event.Seq == 0 || i > 0 && event.Seq != events[i-1].Seq+1 {
+				t.Fatalf("Unexpected sequence number of event %d: %d", i, event.Seq)
+			}
+		}
+		if events[1].Record != changed {
+			t.Fatalf("Event record differs:\n got: %+v\nwant: %+v", events[1].Record, changed)
+		}
+
+		// Tables have separate feeds
+		insert(t, all.users, SampleUser(0))
+		expectNoEvent(t, w)
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		w := watch(t, all.peoples, 0)
+		first := insert(t, all.peoples, SamplePeople(0))
+		second := insert(t, all.peoples, SamplePeople(1))
+		position := expectEvent(t, w, ifaces.RevisionInsert, first).Seq
+
+		resumed := watch(t, all.peoples, position)
+		expectEvent(t, resumed, ifaces.RevisionInsert, second)
+		expectNoEvent(t, resumed)
+
+		third := insert(t, all.peoples, SamplePeople(2))
+		expectEvent(t, resumed, ifaces.RevisionInsert, third)
+
+		// Watch from the last event delivers the new events only
+		last := expectEvent(t, w, ifaces.RevisionInsert, second)
+		expectEvent(t, w, ifaces.RevisionInsert, third)
+		expectEvent(t, watch(t, all.peoples, last.Seq), ifaces.RevisionInsert, third)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+
+		w := watch(t, all.roles, 0)
+		event := expectEvent(t, w, ifaces.RevisionInsert, insert(t, all.roles, SampleRole(0)))
+
+		// Position of the other table or database is not known
+		_, err := all.roles.Watch(context.Background(), event.Seq+1000)
+		expectErr(t, "Watch", err, ifaces.ErrWatchExpired)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		_, all := openDatabase(t, factory)
+		other := watch(t, all.roles, 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		w, err := all.roles.Watch(ctx, 0)
+		if err != nil {
+			t.Fatalf("Watch error: %s", err)
+		}
+		cancel()
+
+		select {
+		case _, ok := <-w.Events():
+			if ok {
+				t.Fatalf("Unexpected event")
+			}
+		case <-time.After(watchTimeout):
+			t.Fatalf("Watch is not finished in %s", watchTimeout)
+		}
+		expectErr(t, "Watch", w.Err(), context.Canceled)
+
+		// Other watches and changes are not affected
+		expectEvent(t, other, ifaces.RevisionInsert, insert(t, all.roles, SampleRole(0)))
+
+		_, err = all.roles.Watch(ctx, 0)
+		expectErr(t, "Watch", err, context.Canceled)
+	})
+
+	t.Run("Tx", func(t *testing.T) {
+		db, all := openDatabase(t, factory)
+		w := watch(t, all.roles, 0)
+
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			insert(t, tables(t, tx).roles, SampleRole(0))
+			return errRollback
+		})
+		expectErr(t, "Tx", err, errRollback)
+
+		var role models.Role
+		err = db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			roles := tables(t, tx).roles
+			role = insert(t, roles, SampleRole(1))
+			return roles.Delete(getId(role))
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+
+		// Changes of the transaction are delivered after commit
+		expectEvent(t, w, ifaces.RevisionInsert, role)
+		expectEvent(t, w, ifaces.RevisionDelete, withVersion(role, getVersion(role)+1))
+		expectNoEvent(t, w)
+	})
+}
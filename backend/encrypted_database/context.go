@@ -0,0 +1,66 @@
+package encrypted_database
+
+import (
+	"context"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Operations without context run with context.Background().
+
+func (T *EncryptedTable[M]) Get(id models.IdData) (M, error) {
+	return T.GetContext(context.Background(), id)
+}
+func (T *EncryptedTable[M]) Find(callback func(record M) bool) (M, error) {
+	return T.FindContext(context.Background(), callback)
+}
+func (T *EncryptedTable[M]) Each(callback func(record M) bool) error {
+	return T.EachContext(context.Background(), callback)
+}
+func (T *EncryptedTable[M]) Insert(record M) (models.IdData, error) {
+	return T.InsertContext(context.Background(), record)
+}
+func (T *EncryptedTable[M]) Update(record M) error {
+	return T.UpdateContext(context.Background(), record)
+}
+func (T *EncryptedTable[M]) Delete(id models.IdData) error {
+	return T.DeleteContext(context.Background(), id)
+}
+func (T *EncryptedTable[M]) Restore(id models.IdData) error {
+	return T.RestoreContext(context.Background(), id)
+}
+func (T *EncryptedTable[M]) Import(record M) error {
+	return T.ImportContext(context.Background(), record)
+}
+func (T *EncryptedTable[M]) Replace(record M) error {
+	return T.ReplaceContext(context.Background(), record)
+}
+func (T *EncryptedTable[M]) History(id models.IdData) ([]ifaces.Revision[M], error) {
+	return T.HistoryContext(context.Background(), id)
+}
+func (T *EncryptedTable[M]) At(id models.IdData, at time.Time) (M, error) {
+	return T.AtContext(context.Background(), id, at)
+}
+func (T *EncryptedTable[M]) Revisions(callback func(revision ifaces.Revision[M]) bool) error {
+	return T.RevisionsContext(context.Background(), callback)
+}
+func (T *EncryptedTable[M]) ImportHistory(revisions []ifaces.Revision[M]) error {
+	return T.ImportHistoryContext(context.Background(), revisions)
+}
+func (T *EncryptedTable[M]) Lookup(index string, key string) ([]M, error) {
+	return T.LookupContext(context.Background(), index, key)
+}
+func (T *EncryptedTable[M]) Query(query *ifaces.Query) (ifaces.Page[M], error) {
+	return T.QueryContext(context.Background(), query)
+}
+func (T *EncryptedTable[M]) InsertMany(records []M) ([]ifaces.Result, error) {
+	return T.InsertManyContext(context.Background(), records)
+}
+func (T *EncryptedTable[M]) UpdateMany(records []M) ([]ifaces.Result, error) {
+	return T.UpdateManyContext(context.Background(), records)
+}
+func (T *EncryptedTable[M]) DeleteWhere(query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.DeleteWhereContext(context.Background(), query)
+}
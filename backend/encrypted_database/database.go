@@ -0,0 +1,255 @@
+// Package encrypted_database encrypts the model fields tagged by
+// ifaces.EncryptedTag at rest, in front of any database backend. Every
+// record is encrypted by its own AES-GCM data key; the data key is wrapped
+// by the master key and stored with the record in models.Sealed. The
+// backend stores the zero values in the encrypted fields. The encrypted
+// data is authenticated with the record id, so the inserted record is
+// sealed once the backend allocates its id.
+//
+// The master key is rotated without downtime: the new key is set by
+// SetKeyring and wraps the data keys of the records written since, and
+// Rewrap re-wraps the data keys of the stored records.
+//
+// Change feeds leave the encrypted fields out, see Watch.
+package encrypted_database
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+type EncryptedDatabase struct {
+	db   ifaces.Database
+	keys atomic.Pointer[Keyring]
+}
+
+// NewDatabase returns db encrypting the records by the keys.
+func NewDatabase(db ifaces.Database, keys *Keyring) *EncryptedDatabase {
+	d := &EncryptedDatabase{db: db}
+	d.keys.Store(keys)
+	return d
+}
+
+// SetKeyring replaces the keys, e.g. to rotate the master key. The new
+// keyring must have the keys of the stored records.
+func (d *EncryptedDatabase) SetKeyring(keys *Keyring) {
+	d.keys.Store(keys)
+}
+
+func (d *EncryptedDatabase) keyring() *Keyring {
+	return d.keys.Load()
+}
+
+// Unwrap returns the encrypted database.
+func (d *EncryptedDatabase) Unwrap() ifaces.Database {
+	return d.db
+}
+
+// Sealed returns the database of the sealed records: backups read and
+// write them as stored, so the archives keep the encrypted fields sealed.
+func (d *EncryptedDatabase) Sealed() ifaces.Database {
+	return d.db
+}
+
+// SetIdStrategies sets id strategies of the database, if it supports them.
+func (d *EncryptedDatabase) SetIdStrategies(strategies ifaces.IdStrategies) error {
+	setter, ok := d.db.(interface {
+		SetIdStrategies(strategies ifaces.IdStrategies) error
+	})
+	if !ok {
+		return ifaces.ErrNotSupported
+	}
+	return setter.SetIdStrategies(strategies)
+}
+
+func (d *EncryptedDatabase) Open() error {
+	return d.db.Open()
+}
+
+func (d *EncryptedDatabase) OpenContext(ctx context.Context) error {
+	return d.db.OpenContext(ctx)
+}
+
+func (d *EncryptedDatabase) Close() {
+	d.db.Close()
+}
+
+func (d *EncryptedDatabase) Users() (ifaces.Table[models.User], error) {
+	return wrap(d, d.db.Users, ifaces.Tx.Users)
+}
+
+func (d *EncryptedDatabase) Peoples() (ifaces.Table[models.People], error) {
+	return wrap(d, d.db.Peoples, ifaces.Tx.Peoples)
+}
+
+func (d *EncryptedDatabase) Roles() (ifaces.Table[models.Role], error) {
+	return wrap(d, d.db.Roles, ifaces.Tx.Roles)
+}
+
+func (d *EncryptedDatabase) Phones() (ifaces.Table[models.Phone], error) {
+	return wrap(d, d.db.Phones, ifaces.Tx.Phones)
+}
+
+func (d *EncryptedDatabase) Addresses() (ifaces.Table[models.Address], error) {
+	return wrap(d, d.db.Addresses, ifaces.Tx.Addresses)
+}
+
+func (d *EncryptedDatabase) Emails() (ifaces.Table[models.Email], error) {
+	return wrap(d, d.db.Emails, ifaces.Tx.Emails)
+}
+
+func (d *EncryptedDatabase) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return wrap(d, d.db.BankAccounts, ifaces.Tx.BankAccounts)
+}
+
+func (d *EncryptedDatabase) Tx(ctx context.Context, callback func(tx ifaces.Tx) error) error {
+	return d.db.Tx(ctx, func(tx ifaces.Tx) error {
+		return callback(&encryptedTx{tx: tx, d: d})
+	})
+}
+
+// Rewrap wraps the data keys of the stored records by the current master
+// key, the records stored before the encryption are encrypted and the data
+// not bound to the record ids is bound, see boundEnvelope. Returns the
+// number of the re-wrapped records. The records and the revisions of the
+// history are re-wrapped in place: they keep their versions and the
+// watchers are not notified, so the caches of the other instances see the
+// re-wrapped records after their TTL. The previous master keys may be
+// removed after that.
+func (d *EncryptedDatabase) Rewrap(ctx context.Context) (int, error) {
+	peoples, err := d.db.Peoples()
+	if err != nil {
+		return 0, err
+	}
+	count, err := rewrap(ctx, d.keyring(), peoples)
+	if err != nil {
+		return count, err
+	}
+	return count, rewrapHistory(ctx, d.keyring(), peoples)
+}
+
+// rewrap re-wraps the stale records of the table one by one, so the table
+// is not locked for long. The record changed concurrently is re-read.
+func rewrap[M ifaces.Models](ctx context.Context, keys *Keyring, table ifaces.Table[M]) (int, error) {
+	s := newSealer[M]()
+	if s == nil {
+		return 0, nil
+	}
+
+	var stale []models.IdData
+	err := table.EachContext(ctx, func(record M) bool {
+		if s.stale(keys, valueOf(&record)) {
+			var i interface{} = &record
+			stale = append(stale, i.(ifaces.Id).GetId())
+		}
+		return true
+	})
+	if err != nil && !errors.Is(err, ifaces.ErrEmptyTable) {
+		return 0, err
+	}
+
+	count := 0
+	for _, id := range stale {
+		for {
+			record, err := table.GetContext(ctx, id)
+			if errors.Is(err, ifaces.ErrNoSuchRecord) {
+				break
+			}
+			if err != nil {
+				return count, err
+			}
+			changed, err := s.rewrap(keys, valueOf(&record))
+			if err != nil {
+				return count, err
+			}
+			if !changed {
+				break
+			}
+			err = table.ReplaceContext(ctx, record)
+			if errors.Is(err, ifaces.ErrConflict) {
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			count++
+			break
+		}
+	}
+	return count, nil
+}
+
+// rewrapHistory re-wraps the stale revisions of the table one by one, see
+// rewrap.
+func rewrapHistory[M ifaces.Models](ctx context.Context, keys *Keyring, table ifaces.Table[M]) error {
+	s := newSealer[M]()
+	if s == nil {
+		return nil
+	}
+
+	var stale []ifaces.Revision[M]
+	err := table.RevisionsContext(ctx, func(revision ifaces.Revision[M]) bool {
+		if s.stale(keys, valueOf(&revision.Record)) {
+			stale = append(stale, revision)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range stale {
+		if _, err := s.rewrap(keys, valueOf(&revision.Record)); err != nil {
+			return err
+		}
+		if err := table.ImportHistoryContext(ctx, []ifaces.Revision[M]{revision}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type encryptedTx struct {
+	tx ifaces.Tx
+	d  *EncryptedDatabase
+}
+
+func (t *encryptedTx) Users() (ifaces.Table[models.User], error) {
+	return wrap(t.d, t.tx.Users, nil)
+}
+
+func (t *encryptedTx) Peoples() (ifaces.Table[models.People], error) {
+	return wrap(t.d, t.tx.Peoples, nil)
+}
+
+func (t *encryptedTx) Roles() (ifaces.Table[models.Role], error) {
+	return wrap(t.d, t.tx.Roles, nil)
+}
+
+func (t *encryptedTx) Phones() (ifaces.Table[models.Phone], error) {
+	return wrap(t.d, t.tx.Phones, nil)
+}
+
+func (t *encryptedTx) Addresses() (ifaces.Table[models.Address], error) {
+	return wrap(t.d, t.tx.Addresses, nil)
+}
+
+func (t *encryptedTx) Emails() (ifaces.Table[models.Email], error) {
+	return wrap(t.d, t.tx.Emails, nil)
+}
+
+func (t *encryptedTx) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return wrap(t.d, t.tx.BankAccounts, nil)
+}
+
+func (t *encryptedTx) SchemaVersion(ctx context.Context) (int, error) {
+	return t.tx.SchemaVersion(ctx)
+}
+
+func (t *encryptedTx) SetSchemaVersion(ctx context.Context, version int) error {
+	return t.tx.SetSchemaVersion(ctx, version)
+}
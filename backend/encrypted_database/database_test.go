@@ -0,0 +1,330 @@
+package encrypted_database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/dbtest"
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func testKey(id string, b byte) MasterKey {
+	return MasterKey{Id: id, Key: bytes.Repeat([]byte{b}, KeySize)}
+}
+
+func testKeyring(t *testing.T, keys ...MasterKey) *Keyring {
+	t.Helper()
+
+	keyring, err := NewKeyring(keys...)
+	if err != nil {
+		t.Fatalf("Keyring error: %s", err)
+	}
+	return keyring
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) ifaces.Database {
+		return NewDatabase(fake_database.NewDatabase(), testKeyring(t, testKey("test", 1)))
+	})
+}
+
+// openTest returns the encrypted database and its backend.
+func openTest(t *testing.T, keys *Keyring) (*EncryptedDatabase, ifaces.Table[models.People]) {
+	t.Helper()
+
+	backend := fake_database.NewDatabase()
+	db := NewDatabase(backend, keys)
+	if err := db.Open(); err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	t.Cleanup(db.Close)
+
+	raw, err := backend.Peoples()
+	if err != nil {
+		t.Fatalf("Peoples error: %s", err)
+	}
+	return db, raw
+}
+
+func samplePeople() models.People {
+	return models.People{
+		Name:  "John",
+		Birth: time.Date(1980, 2, 3, 0, 0, 0, 0, time.UTC),
+		// Legacy contacts are opened to be moved by the migration
+		LegacyContacts: models.LegacyContacts{BankAccounts: []models.BankAccount{{Active: true}}},
+		Tax:            []*models.TaxInfo{{Code: "1234567890"}},
+	}
+}
+
+func TestSealed(t *testing.T) {
+	db, raw := openTest(t, testKeyring(t, testKey("test", 1)))
+	peoples, _ := db.Peoples()
+
+	people := samplePeople()
+	id, err := peoples.Insert(people)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	people.SetId(id)
+	people.SetVersion(models.FIRST_VERSION)
+
+	stored, err := raw.Get(id)
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if !stored.Birth.IsZero() || stored.BankAccounts != nil || stored.Tax != nil || stored.Name != "John" {
+		t.Fatalf("Encrypted fields are stored: %+v", stored)
+	}
+	if len(stored.Sealed) == 0 || bytes.Contains(stored.Sealed, []byte("1234567890")) {
+		t.Fatalf("Unexpected sealed data: %s", stored.Sealed)
+	}
+
+	got, err := peoples.Get(id)
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if !reflect.DeepEqual(got, people) {
+		t.Fatalf("Record differs:\n got: %+v\nwant: %+v", got, people)
+	}
+
+	// Sealed data of the record is not accepted for the other one
+	otherId, err := peoples.Insert(samplePeople())
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	swapped, _ := raw.Get(otherId)
+	swapped.Sealed = stored.Sealed
+	if err := raw.Replace(swapped); err != nil {
+		t.Fatalf("Replace error: %s", err)
+	}
+	if _, err := peoples.Get(otherId); !errors.Is(err, ErrBadEnvelope) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Data key is unwrapped by the known master key only
+	other := NewDatabase(fake_database.NewDatabase(), testKeyring(t, testKey("other", 2)))
+	otherRaw, _ := other.db.Peoples()
+	if err := otherRaw.Import(stored); err != nil {
+		t.Fatalf("Import error: %s", err)
+	}
+	otherPeoples, _ := other.Peoples()
+	_, err = otherPeoples.Get(id)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestWatchRedacted(t *testing.T) {
+	db, _ := openTest(t, testKeyring(t, testKey("test", 1)))
+	peoples, _ := db.Peoples()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := peoples.Watch(ctx, 0)
+	if err != nil {
+		t.Fatalf("Watch error: %s", err)
+	}
+
+	people := samplePeople()
+	id, err := peoples.Insert(people)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	people.SetId(id)
+	people.SetVersion(models.FIRST_VERSION)
+	people.Tax[0].Code = "42"
+	if err := peoples.Update(people); err != nil {
+		t.Fatalf("Update error: %s", err)
+	}
+
+	// Change of the encrypted fields is not named
+	for _, diff := range [][]string{{"Name"}, {}} {
+		select {
+		case event := <-w.Events():
+			record := event.Record
+			if !record.Birth.IsZero() || record.Tax != nil || record.BankAccounts != nil || record.Sealed != nil {
+				t.Fatalf("Event has encrypted fields: %+v", record)
+			}
+			if !slices.Equal(event.Diff, diff) {
+				t.Fatalf("Unexpected diff: %v, want: %v", event.Diff, diff)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No event: %v", w.Err())
+		}
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old, current := testKey("old", 1), testKey("current", 2)
+	db, raw := openTest(t, testKeyring(t, old))
+	peoples, _ := db.Peoples()
+
+	people := samplePeople()
+	// Record stored before the encryption
+	legacy, err := raw.Insert(people)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	sealed, err := peoples.Insert(people)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	before, _ := raw.Get(sealed)
+	// Record sealed before the envelopes are bound to the ids
+	unbound := people
+	unbound.SetId(100)
+	sealUnbound(t, db.keyring(), &unbound)
+	if err := raw.Import(unbound); err != nil {
+		t.Fatalf("Import error: %s", err)
+	}
+	if _, err := peoples.Get(100); err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+
+	db.SetKeyring(testKeyring(t, current, old))
+	count, err := db.Rewrap(context.Background())
+	if err != nil || count != 3 {
+		t.Fatalf("Rewrap: %d, %v", count, err)
+	}
+	count, err = db.Rewrap(context.Background())
+	if err != nil || count != 0 {
+		t.Fatalf("Second rewrap: %d, %v", count, err)
+	}
+
+	// Records are re-wrapped in place
+	revisions, err := peoples.History(sealed)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("History: %+v, %v", revisions, err)
+	}
+
+	// Old key is not needed to read the records and their history any more
+	db.SetKeyring(testKeyring(t, current))
+	for _, id := range []models.IdData{legacy, sealed, 100} {
+		stored, _ := raw.Get(id)
+		if len(stored.Sealed) == 0 || !stored.Birth.IsZero() {
+			t.Fatalf("Record %d is not sealed: %+v", id, stored)
+		}
+		if env, err := (&sealer{}).envelope(stored.Sealed); err != nil || env.Version != boundEnvelope {
+			t.Fatalf("Record %d is not bound: %+v, %v", id, env, err)
+		}
+		got, err := peoples.Get(id)
+		if err != nil {
+			t.Fatalf("Get error: %s", err)
+		}
+		expected := people
+		expected.SetId(id)
+		expected.SetVersion(models.FIRST_VERSION)
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Record differs:\n got: %+v\nwant: %+v", got, expected)
+		}
+
+		rawRevisions, err := raw.History(id)
+		if err != nil || len(rawRevisions) != 1 {
+			t.Fatalf("History of record %d: %+v, %v", id, rawRevisions, err)
+		}
+		for _, revision := range rawRevisions {
+			if len(revision.Record.Sealed) == 0 || !revision.Record.Birth.IsZero() {
+				t.Fatalf("Revision %d.%d is not sealed: %+v", id, revision.Version, revision.Record)
+			}
+		}
+		history, err := peoples.History(id)
+		if err != nil {
+			t.Fatalf("History error: %s", err)
+		}
+		if !reflect.DeepEqual(history[0].Record, expected) {
+			t.Fatalf("Revision differs:\n got: %+v\nwant: %+v", history[0].Record, expected)
+		}
+		if first, err := peoples.At(id, history[0].Time); err != nil || !reflect.DeepEqual(first, expected) {
+			t.Fatalf("State differs: %+v, error: %v", first, err)
+		}
+	}
+
+	// Data is not encrypted again
+	after, _ := raw.Get(sealed)
+	if !bytes.Equal(envelopeData(t, after.Sealed), envelopeData(t, before.Sealed)) {
+		t.Fatalf("Data is changed by rewrap")
+	}
+}
+
+// sealUnbound seals the record by the envelope of the version 0.
+func sealUnbound(t *testing.T, keys *Keyring, record *models.People) {
+	t.Helper()
+
+	s := newSealer[models.People]()
+	if err := s.seal(keys, valueOf(record)); err != nil {
+		t.Fatalf("Seal error: %s", err)
+	}
+	env, err := s.envelope(record.Sealed)
+	if err != nil {
+		t.Fatalf("Envelope error: %s", err)
+	}
+	dataKey, err := keys.unwrap(env.Key, env.DataKey)
+	if err != nil {
+		t.Fatalf("Unwrap error: %s", err)
+	}
+	plaintext, err := decrypt(dataKey, env.Data, s.additionalData(valueOf(record), env.Version))
+	if err != nil {
+		t.Fatalf("Decrypt error: %s", err)
+	}
+	env.Version = 0
+	if env.Data, err = encrypt(dataKey, plaintext, []byte(s.name)); err != nil {
+		t.Fatalf("Encrypt error: %s", err)
+	}
+	if record.Sealed, err = json.Marshal(env); err != nil {
+		t.Fatalf("Marshal error: %s", err)
+	}
+}
+
+func envelopeData(t *testing.T, sealed models.Sealed) []byte {
+	t.Helper()
+
+	env, err := (&sealer{}).envelope(sealed)
+	if err != nil {
+		t.Fatalf("Envelope error: %s", err)
+	}
+	return env.Data
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# rotated\n" +
+		"new:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=\n" +
+		"\n" +
+		"old: AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Write error: %s", err)
+	}
+
+	keys, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("Load error: %s", err)
+	}
+	if keys.Current() != "new" || len(keys.keys) != 2 || !bytes.Equal(keys.keys["old"], testKey("old", 1).Key) {
+		t.Fatalf("Unexpected keyring: %+v", keys)
+	}
+
+	for name, content := range map[string]string{
+		"empty":     "# no keys\n",
+		"no id":     "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=\n",
+		"size":      "short:AQEB\n",
+		"base64":    "bad:not base64\n",
+		"duplicate": "a:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=\na:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Write error: %s", err)
+		}
+		if _, err := LoadKeyring(path); !errors.Is(err, ErrBadKey) && !errors.Is(err, ErrNoKeys) {
+			t.Fatalf("Keys '%s': unexpected error: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package encrypted_database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of the master keys and of the data keys, AES-256.
+const KeySize = 32
+
+var (
+	ErrNoKeys     = errors.New("No master keys!")
+	ErrBadKey     = errors.New("Bad master key!")
+	ErrUnknownKey = errors.New("Unknown master key!")
+)
+
+// MasterKey wraps the data keys of the records. Id is stored in the
+// envelopes, so the key is found to unwrap them.
+type MasterKey struct {
+	Id  string
+	Key []byte
+}
+
+// Keyring is the set of the master keys. The current key wraps the new
+// data keys, the others unwrap the data keys wrapped before the rotation.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring returns keyring of the keys, the first one is current.
+func NewKeyring(keys ...MasterKey) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	k := &Keyring{current: keys[0].Id, keys: make(map[string][]byte)}
+	for _, key := range keys {
+		if key.Id == "" || strings.ContainsAny(key.Id, ": \t") {
+			return nil, fmt.Errorf("%w Wrong id: '%s'", ErrBadKey, key.Id)
+		}
+		if len(key.Key) != KeySize {
+			return nil, fmt.Errorf("%w Key '%s' size is %d, want %d", ErrBadKey, key.Id, len(key.Key), KeySize)
+		}
+		if _, ok := k.keys[key.Id]; ok {
+			return nil, fmt.Errorf("%w Duplicated id: '%s'", ErrBadKey, key.Id)
+		}
+		k.keys[key.Id] = key.Key
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the keys file: one key per line, the id and base64 of
+// the key separated by ':', e.g. made by
+//
+//	echo "2024-05:$(head -c 32 /dev/urandom | base64)"
+//
+// The first key is current. Empty lines and lines starting with '#' are
+// skipped. The key is rotated by adding the new key at the top; the old
+// keys are kept while the records and their history are wrapped by them.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []MasterKey
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w Line %d: no id", ErrBadKey, n+1)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("%w Line %d: %s", ErrBadKey, n+1, err)
+		}
+		keys = append(keys, MasterKey{Id: strings.TrimSpace(id), Key: key})
+	}
+	return NewKeyring(keys...)
+}
+
+// Current returns id of the current key.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// wrap encrypts the data key by the current key.
+func (k *Keyring) wrap(dataKey []byte) (string, []byte, error) {
+	wrapped, err := encrypt(k.keys[k.current], dataKey, []byte(k.current))
+	return k.current, wrapped, err
+}
+
+// unwrap decrypts the data key wrapped by the key with the id.
+func (k *Keyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownKey, id)
+	}
+	return decrypt(key, wrapped, []byte(id))
+}
+
+// encrypt seals plaintext by AES-GCM with the random nonce prepended to
+// the ciphertext.
+func encrypt(key []byte, plaintext []byte, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, data), nil
+}
+
+func decrypt(key []byte, ciphertext []byte, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w Ciphertext is too short", ErrBadEnvelope)
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s", ErrBadEnvelope, err)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,221 @@
+package encrypted_database
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+var ErrBadEnvelope = errors.New("Bad envelope!")
+
+var sealedType = reflect.TypeOf(models.Sealed{})
+
+// boundEnvelope is the version of the envelopes authenticating the record
+// id with the data, so the sealed data of one record can not be passed for
+// the other one's. Envelopes of the version 0 authenticate the model name
+// only; they are read and re-encrypted by Rewrap.
+const boundEnvelope = 1
+
+// envelope is the models.Sealed data: JSON object of the encrypted fields
+// encrypted by the random data key, and the data key wrapped by the master
+// key. Rotation of the master key re-wraps the data key only.
+type envelope struct {
+	Version int    `json:"v,omitempty"`
+	Key     string `json:"key"`
+	DataKey []byte `json:"dek"`
+	Data    []byte `json:"data"`
+}
+
+// sealer moves the encrypted fields of the model to the models.Sealed field
+// and back.
+type sealer struct {
+	// Model name, authenticated with the data and the record id
+	name   string
+	fields []reflect.StructField
+	sealed reflect.StructField
+}
+
+// newSealer returns sealer of the model, nil if the model has no encrypted
+// fields. Panics if the model has no models.Sealed field, as it is a
+// programming error.
+func newSealer[M ifaces.Models]() *sealer {
+	var record M
+	t := reflect.TypeOf(record)
+
+	s := &sealer{name: t.Name()}
+	hasSealed := false
+	for _, field := range reflect.VisibleFields(t) {
+		switch {
+		case field.Type == sealedType:
+			s.sealed, hasSealed = field, true
+		case ifaces.Encrypted(field):
+			s.fields = append(s.fields, field)
+		}
+	}
+
+	if len(s.fields) == 0 {
+		return nil
+	}
+	if !hasSealed {
+		panic(fmt.Errorf("Model %s has encrypted fields, but no sealed one", t.Name()))
+	}
+	return s
+}
+
+// additionalData returns the data authenticated with the encrypted fields
+// of the record by the envelope of the version.
+func (s *sealer) additionalData(record reflect.Value, version int) []byte {
+	if version < boundEnvelope {
+		return []byte(s.name)
+	}
+	var i interface{} = record.Addr().Interface()
+	return fmt.Appendf(nil, "%s/%d", s.name, i.(ifaces.Id).GetId())
+}
+
+// seal encrypts the fields of the record by the new data key, the record
+// must have its id.
+func (s *sealer) seal(keys *Keyring, record reflect.Value) error {
+	values := make(map[string]json.RawMessage, len(s.fields))
+	for _, f := range s.fields {
+		field := record.FieldByIndex(f.Index)
+		data, err := json.Marshal(field.Interface())
+		if err != nil {
+			return err
+		}
+		values[f.Name] = data
+	}
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	env := envelope{Version: boundEnvelope}
+	if env.Data, err = encrypt(dataKey, plaintext, s.additionalData(record, env.Version)); err != nil {
+		return err
+	}
+	if env.Key, env.DataKey, err = keys.wrap(dataKey); err != nil {
+		return err
+	}
+	sealed, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	s.clear(record)
+	record.FieldByIndex(s.sealed.Index).SetBytes(sealed)
+	return nil
+}
+
+// open decrypts the fields of the record. The record stored before the
+// encryption has the fields in plaintext and is left as is.
+func (s *sealer) open(keys *Keyring, record reflect.Value) error {
+	sealed := record.FieldByIndex(s.sealed.Index)
+	if sealed.Len() == 0 {
+		return nil
+	}
+
+	env, err := s.envelope(sealed.Bytes())
+	if err != nil {
+		return err
+	}
+	dataKey, err := keys.unwrap(env.Key, env.DataKey)
+	if err != nil {
+		return err
+	}
+	plaintext, err := decrypt(dataKey, env.Data, s.additionalData(record, env.Version))
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return fmt.Errorf("%w %s", ErrBadEnvelope, err)
+	}
+
+	for _, f := range s.fields {
+		field := record.FieldByIndex(f.Index)
+		field.Set(reflect.Zero(field.Type()))
+		if data, ok := values[f.Name]; ok {
+			if err := json.Unmarshal(data, field.Addr().Interface()); err != nil {
+				return fmt.Errorf("%w Field '%s': %s", ErrBadEnvelope, f.Name, err)
+			}
+		}
+	}
+	sealed.SetBytes(nil)
+	return nil
+}
+
+// clear sets the encrypted fields and the sealed data of the record to the
+// zero values.
+func (s *sealer) clear(record reflect.Value) {
+	for _, f := range s.fields {
+		field := record.FieldByIndex(f.Index)
+		field.Set(reflect.Zero(field.Type()))
+	}
+	record.FieldByIndex(s.sealed.Index).SetBytes(nil)
+}
+
+// stale returns true if the record is not sealed, its envelope is not
+// bound to the id or its data key is not wrapped by the current key.
+func (s *sealer) stale(keys *Keyring, record reflect.Value) bool {
+	sealed := record.FieldByIndex(s.sealed.Index)
+	if sealed.Len() == 0 {
+		return true
+	}
+	env, err := s.envelope(sealed.Bytes())
+	return err != nil || env.Version != boundEnvelope || env.Key != keys.Current()
+}
+
+// rewrap wraps the data key of the stale record by the current key, the
+// record not sealed yet is sealed and the data not bound to the id is
+// encrypted again. Returns false if the record is not stale.
+func (s *sealer) rewrap(keys *Keyring, record reflect.Value) (bool, error) {
+	sealed := record.FieldByIndex(s.sealed.Index)
+	if sealed.Len() == 0 {
+		return true, s.seal(keys, record)
+	}
+
+	env, err := s.envelope(sealed.Bytes())
+	if err != nil || env.Version == boundEnvelope && env.Key == keys.Current() {
+		return false, err
+	}
+	dataKey, err := keys.unwrap(env.Key, env.DataKey)
+	if err != nil {
+		return false, err
+	}
+	if env.Version != boundEnvelope {
+		plaintext, err := decrypt(dataKey, env.Data, s.additionalData(record, env.Version))
+		if err != nil {
+			return false, err
+		}
+		env.Version = boundEnvelope
+		if env.Data, err = encrypt(dataKey, plaintext, s.additionalData(record, env.Version)); err != nil {
+			return false, err
+		}
+	}
+	if env.Key, env.DataKey, err = keys.wrap(dataKey); err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return false, err
+	}
+	sealed.SetBytes(data)
+	return true, nil
+}
+
+func (s *sealer) envelope(data []byte) (envelope, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, fmt.Errorf("%w %s", ErrBadEnvelope, err)
+	}
+	return env, nil
+}
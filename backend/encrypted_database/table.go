@@ -0,0 +1,321 @@
+package encrypted_database
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// EncryptedTable seals the records written to the table of the backend and
+// opens the records read from it.
+type EncryptedTable[M ifaces.Models] struct {
+	table  ifaces.Table[M]
+	d      *EncryptedDatabase
+	sealer *sealer
+	// inTx opens the table in the transaction of the backend, nil if the
+	// table is of the transaction
+	inTx func(tx ifaces.Tx) (ifaces.Table[M], error)
+}
+
+// wrap returns the encrypted table, or the table of the backend if the
+// model has no encrypted fields.
+func wrap[M ifaces.Models](d *EncryptedDatabase, open func() (ifaces.Table[M], error), inTx func(tx ifaces.Tx) (ifaces.Table[M], error)) (ifaces.Table[M], error) {
+	table, err := open()
+	if err != nil {
+		return nil, err
+	}
+	s := newSealer[M]()
+	if s == nil {
+		return table, nil
+	}
+	return &EncryptedTable[M]{table: table, d: d, sealer: s, inTx: inTx}, nil
+}
+
+// tx calls back with the table of the backend in the transaction.
+func (T *EncryptedTable[M]) tx(ctx context.Context, callback func(table ifaces.Table[M]) error) error {
+	if T.inTx == nil {
+		return callback(T.table)
+	}
+	return T.d.db.Tx(ctx, func(tx ifaces.Tx) error {
+		table, err := T.inTx(tx)
+		if err != nil {
+			return err
+		}
+		return callback(table)
+	})
+}
+
+func valueOf[M ifaces.Models](record *M) reflect.Value {
+	return reflect.ValueOf(record).Elem()
+}
+
+func (T *EncryptedTable[M]) seal(record *M) error {
+	return T.sealer.seal(T.d.keyring(), valueOf(record))
+}
+
+func (T *EncryptedTable[M]) open(record *M) error {
+	return T.sealer.open(T.d.keyring(), valueOf(record))
+}
+
+func (T *EncryptedTable[M]) openAll(records []M) error {
+	for i := range records {
+		if err := T.open(&records[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openRevisions opens the records of the revisions ordered by version.
+// Diff of the backend names the sealed field instead of the encrypted
+// ones, so it is recomputed from the opened records.
+func (T *EncryptedTable[M]) openRevisions(revisions []ifaces.Revision[M]) error {
+	var previous *M
+	for i := range revisions {
+		revision := &revisions[i]
+		if err := T.open(&revision.Record); err != nil {
+			return err
+		}
+		if slices.Contains(revision.Diff, T.sealer.sealed.Name) {
+			revision.Diff = ifaces.Diff(previous, &revision.Record)
+		}
+		previous = &revision.Record
+	}
+	return nil
+}
+
+func (T *EncryptedTable[M]) GetContext(ctx context.Context, id models.IdData) (M, error) {
+	record, err := T.table.GetContext(ctx, id)
+	if err == nil {
+		err = T.open(&record)
+	}
+	return record, err
+}
+
+func (T *EncryptedTable[M]) FindContext(ctx context.Context, callback func(record M) bool) (M, error) {
+	var openErr error
+	record, err := T.table.FindContext(ctx, func(record M) bool {
+		if openErr = T.open(&record); openErr != nil {
+			return true
+		}
+		return callback(record)
+	})
+	if openErr != nil {
+		return record, openErr
+	}
+	if err == nil {
+		err = T.open(&record)
+	}
+	return record, err
+}
+
+func (T *EncryptedTable[M]) EachContext(ctx context.Context, callback func(record M) bool) error {
+	var openErr error
+	err := T.table.EachContext(ctx, func(record M) bool {
+		if openErr = T.open(&record); openErr != nil {
+			return false
+		}
+		return callback(record)
+	})
+	if openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// InsertContext inserts the record without the encrypted fields, as the
+// envelope is bound to the id allocated by the backend, and stores the
+// sealed record in the same transaction, see sealInserted.
+func (T *EncryptedTable[M]) InsertContext(ctx context.Context, record M) (models.IdData, error) {
+	var id models.IdData
+	err := T.tx(ctx, func(table ifaces.Table[M]) error {
+		cleared := record
+		T.sealer.clear(valueOf(&cleared))
+		var err error
+		if id, err = table.InsertContext(ctx, cleared); err != nil {
+			return err
+		}
+		return T.sealInserted(ctx, table, id, record)
+	})
+	if err != nil {
+		return models.BAD_ID, err
+	}
+	return id, nil
+}
+
+// sealInserted seals the record inserted with the id and stores it and its
+// insert revision in place, so the record keeps the first version.
+func (T *EncryptedTable[M]) sealInserted(ctx context.Context, table ifaces.Table[M], id models.IdData, record M) error {
+	var i interface{} = &record
+	i.(ifaces.Id).SetId(id)
+	if err := ifaces.FirstVersion(&record); err != nil {
+		return err
+	}
+	if err := T.seal(&record); err != nil {
+		return err
+	}
+	if err := table.ReplaceContext(ctx, record); err != nil {
+		return err
+	}
+
+	revisions, err := table.HistoryContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	revision := revisions[0]
+	revision.Record = record
+	revision.Diff = ifaces.Diff(nil, &record)
+	return table.ImportHistoryContext(ctx, []ifaces.Revision[M]{revision})
+}
+
+func (T *EncryptedTable[M]) UpdateContext(ctx context.Context, record M) error {
+	if err := T.seal(&record); err != nil {
+		return err
+	}
+	return T.table.UpdateContext(ctx, record)
+}
+
+func (T *EncryptedTable[M]) DeleteContext(ctx context.Context, id models.IdData) error {
+	return T.table.DeleteContext(ctx, id)
+}
+
+func (T *EncryptedTable[M]) RestoreContext(ctx context.Context, id models.IdData) error {
+	return T.table.RestoreContext(ctx, id)
+}
+
+func (T *EncryptedTable[M]) ImportContext(ctx context.Context, record M) error {
+	if err := T.seal(&record); err != nil {
+		return err
+	}
+	return T.table.ImportContext(ctx, record)
+}
+
+func (T *EncryptedTable[M]) ReplaceContext(ctx context.Context, record M) error {
+	if err := T.seal(&record); err != nil {
+		return err
+	}
+	return T.table.ReplaceContext(ctx, record)
+}
+
+func (T *EncryptedTable[M]) HistoryContext(ctx context.Context, id models.IdData) ([]ifaces.Revision[M], error) {
+	revisions, err := T.table.HistoryContext(ctx, id)
+	if err == nil {
+		err = T.openRevisions(revisions)
+	}
+	return revisions, err
+}
+
+func (T *EncryptedTable[M]) AtContext(ctx context.Context, id models.IdData, at time.Time) (M, error) {
+	record, err := T.table.AtContext(ctx, id, at)
+	if err == nil {
+		err = T.open(&record)
+	}
+	return record, err
+}
+
+// RevisionsContext opens the revisions of every record as
+// HistoryContext does.
+func (T *EncryptedTable[M]) RevisionsContext(ctx context.Context, callback func(revision ifaces.Revision[M]) bool) error {
+	var openErr error
+	var previous *M
+	err := T.table.RevisionsContext(ctx, func(revision ifaces.Revision[M]) bool {
+		if previous != nil && ifaces.IdOf(previous) != revision.Id {
+			previous = nil
+		}
+		if openErr = T.open(&revision.Record); openErr != nil {
+			return false
+		}
+		if slices.Contains(revision.Diff, T.sealer.sealed.Name) {
+			revision.Diff = ifaces.Diff(previous, &revision.Record)
+		}
+		previous = &revision.Record
+		return callback(revision)
+	})
+	if openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (T *EncryptedTable[M]) ImportHistoryContext(ctx context.Context, revisions []ifaces.Revision[M]) error {
+	sealed := append([]ifaces.Revision[M](nil), revisions...)
+	for i := range sealed {
+		if err := T.seal(&sealed[i].Record); err != nil {
+			return err
+		}
+	}
+	return T.table.ImportHistoryContext(ctx, sealed)
+}
+
+func (T *EncryptedTable[M]) LookupContext(ctx context.Context, index string, key string) ([]M, error) {
+	records, err := T.table.LookupContext(ctx, index, key)
+	if err == nil {
+		err = T.openAll(records)
+	}
+	return records, err
+}
+
+func (T *EncryptedTable[M]) QueryContext(ctx context.Context, query *ifaces.Query) (ifaces.Page[M], error) {
+	page, err := T.table.QueryContext(ctx, query)
+	if err == nil {
+		err = T.openAll(page.Records)
+	}
+	return page, err
+}
+
+// sealAll returns the sealed copies of the records.
+func (T *EncryptedTable[M]) sealAll(records []M) ([]M, error) {
+	sealed := append([]M(nil), records...)
+	for i := range sealed {
+		if err := T.seal(&sealed[i]); err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// InsertManyContext inserts the records as InsertContext does.
+func (T *EncryptedTable[M]) InsertManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	var results []ifaces.Result
+	err := T.tx(ctx, func(table ifaces.Table[M]) error {
+		cleared := append([]M(nil), records...)
+		for i := range cleared {
+			T.sealer.clear(valueOf(&cleared[i]))
+		}
+		var err error
+		if results, err = table.InsertManyContext(ctx, cleared); err != nil {
+			return err
+		}
+		for i, result := range results {
+			if result.Err != nil {
+				continue
+			}
+			if err := T.sealInserted(ctx, table, result.Id, records[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (T *EncryptedTable[M]) UpdateManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	sealed, err := T.sealAll(records)
+	if err != nil {
+		return nil, err
+	}
+	return T.table.UpdateManyContext(ctx, sealed)
+}
+
+// DeleteWhereContext is passed to the table, as the encrypted fields are
+// not queryable.
+func (T *EncryptedTable[M]) DeleteWhereContext(ctx context.Context, query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.table.DeleteWhereContext(ctx, query)
+}
@@ -0,0 +1,59 @@
+package encrypted_database
+
+import (
+	"context"
+	"slices"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+// watcher redacts the records of the events of the backend watcher.
+type watcher[M ifaces.Models] struct {
+	watcher ifaces.Watcher[M]
+	events  chan ifaces.Event[M]
+	err     error
+}
+
+func (w *watcher[M]) Events() <-chan ifaces.Event[M] {
+	return w.events
+}
+
+// Err is set before Events channel is closed.
+func (w *watcher[M]) Err() error {
+	return w.err
+}
+
+// Watch delivers the events of the backend redacted, see redactEvent.
+func (T *EncryptedTable[M]) Watch(ctx context.Context, after uint64) (ifaces.Watcher[M], error) {
+	source, err := T.table.Watch(ctx, after)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher[M]{watcher: source, events: make(chan ifaces.Event[M])}
+	go func() {
+		defer close(w.events)
+		for event := range source.Events() {
+			T.redactEvent(&event)
+			select {
+			case w.events <- event:
+			case <-ctx.Done():
+				w.err = ctx.Err()
+				return
+			}
+		}
+		w.err = source.Err()
+	}()
+	return w, nil
+}
+
+// redactEvent leaves the encrypted fields out of the event, as the feed
+// is read by the services not trusted with them: the record has the zero
+// values in the encrypted fields and no sealed data, and the diff does not
+// name the sealed field.
+func (T *EncryptedTable[M]) redactEvent(event *ifaces.Event[M]) {
+	T.sealer.clear(valueOf(&event.Record))
+	event.Diff = slices.DeleteFunc(event.Diff, func(name string) bool {
+		return name == T.sealer.sealed.Name
+	})
+}
@@ -0,0 +1,73 @@
+package fake_database
+
+import (
+	"context"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// txTableOf returns the table of the transaction with the records M.
+func txTableOf[M ifaces.Models](tx *FakeTx) *txTable[M] {
+	for _, table := range tx.tables() {
+		if T, ok := table.(*txTable[M]); ok {
+			return T
+		}
+	}
+	panic("No table of the model in the transaction")
+}
+
+// bulk runs the bulk operation in the transaction of the database, so it
+// holds the lock once and its changes are journaled by the single write.
+func (T *FakeTable[M]) bulk(ctx context.Context, op func(T *txTable[M]) ([]ifaces.Result, error)) (results []ifaces.Result, err error) {
+	err = T.parent.(*FakeDatabase).Tx(ctx, func(tx ifaces.Tx) error {
+		results, err = op(txTableOf[M](tx.(*FakeTx)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (T *FakeTable[M]) InsertManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	return T.bulk(ctx, func(tx *txTable[M]) ([]ifaces.Result, error) {
+		return tx.InsertManyContext(ctx, records)
+	})
+}
+
+func (T *FakeTable[M]) UpdateManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	return T.bulk(ctx, func(tx *txTable[M]) ([]ifaces.Result, error) {
+		return tx.UpdateManyContext(ctx, records)
+	})
+}
+
+func (T *FakeTable[M]) DeleteWhereContext(ctx context.Context, query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.bulk(ctx, func(tx *txTable[M]) ([]ifaces.Result, error) {
+		return tx.DeleteWhereContext(ctx, query)
+	})
+}
+
+// Operations of the transaction table change nothing if they fail.
+
+func (T *txTable[M]) InsertManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	return ifaces.Bulk(records, func(record M) (models.IdData, error) {
+		return T.InsertContext(ctx, record)
+	})
+}
+
+func (T *txTable[M]) UpdateManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	return ifaces.Bulk(records, func(record M) (models.IdData, error) {
+		return ifaces.IdOf(&record), T.UpdateContext(ctx, record)
+	})
+}
+
+func (T *txTable[M]) DeleteWhereContext(ctx context.Context, query *ifaces.Query) ([]ifaces.Result, error) {
+	page, err := T.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return ifaces.Bulk(ifaces.Ids(page.Records), func(id models.IdData) (models.IdData, error) {
+		return id, T.DeleteContext(ctx, id)
+	})
+}
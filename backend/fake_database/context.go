@@ -0,0 +1,125 @@
+package fake_database
+
+import (
+	"context"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Operations without context run with context.Background().
+
+func (T *FakeTable[M]) Get(id models.IdData) (M, error) {
+	return T.GetContext(context.Background(), id)
+}
+func (T *FakeTable[M]) Find(callback func(record M) bool) (M, error) {
+	return T.FindContext(context.Background(), callback)
+}
+func (T *FakeTable[M]) Each(callback func(record M) bool) error {
+	return T.EachContext(context.Background(), callback)
+}
+func (T *FakeTable[M]) Insert(record M) (models.IdData, error) {
+	return T.InsertContext(context.Background(), record)
+}
+func (T *FakeTable[M]) Update(record M) error {
+	return T.UpdateContext(context.Background(), record)
+}
+func (T *FakeTable[M]) Delete(id models.IdData) error {
+	return T.DeleteContext(context.Background(), id)
+}
+func (T *FakeTable[M]) Restore(id models.IdData) error {
+	return T.RestoreContext(context.Background(), id)
+}
+func (T *FakeTable[M]) Import(record M) error {
+	return T.ImportContext(context.Background(), record)
+}
+func (T *FakeTable[M]) Replace(record M) error {
+	return T.ReplaceContext(context.Background(), record)
+}
+func (T *FakeTable[M]) History(id models.IdData) ([]ifaces.Revision[M], error) {
+	return T.HistoryContext(context.Background(), id)
+}
+func (T *FakeTable[M]) At(id models.IdData, at time.Time) (M, error) {
+	return T.AtContext(context.Background(), id, at)
+}
+func (T *FakeTable[M]) Revisions(callback func(revision ifaces.Revision[M]) bool) error {
+	return T.RevisionsContext(context.Background(), callback)
+}
+func (T *FakeTable[M]) ImportHistory(revisions []ifaces.Revision[M]) error {
+	return T.ImportHistoryContext(context.Background(), revisions)
+}
+func (T *FakeTable[M]) Lookup(index string, key string) ([]M, error) {
+	return T.LookupContext(context.Background(), index, key)
+}
+func (T *FakeTable[M]) Query(query *ifaces.Query) (ifaces.Page[M], error) {
+	return T.QueryContext(context.Background(), query)
+}
+func (T *FakeTable[M]) InsertMany(records []M) ([]ifaces.Result, error) {
+	return T.InsertManyContext(context.Background(), records)
+}
+func (T *FakeTable[M]) UpdateMany(records []M) ([]ifaces.Result, error) {
+	return T.UpdateManyContext(context.Background(), records)
+}
+func (T *FakeTable[M]) DeleteWhere(query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.DeleteWhereContext(context.Background(), query)
+}
+
+func (T *txTable[M]) Get(id models.IdData) (M, error) {
+	return T.GetContext(context.Background(), id)
+}
+func (T *txTable[M]) Find(callback func(record M) bool) (M, error) {
+	return T.FindContext(context.Background(), callback)
+}
+func (T *txTable[M]) Each(callback func(record M) bool) error {
+	return T.EachContext(context.Background(), callback)
+}
+func (T *txTable[M]) Insert(record M) (models.IdData, error) {
+	return T.InsertContext(context.Background(), record)
+}
+func (T *txTable[M]) Update(record M) error {
+	return T.UpdateContext(context.Background(), record)
+}
+func (T *txTable[M]) Delete(id models.IdData) error {
+	return T.DeleteContext(context.Background(), id)
+}
+func (T *txTable[M]) Restore(id models.IdData) error {
+	return T.RestoreContext(context.Background(), id)
+}
+func (T *txTable[M]) Import(record M) error {
+	return T.ImportContext(context.Background(), record)
+}
+func (T *txTable[M]) Replace(record M) error {
+	return T.ReplaceContext(context.Background(), record)
+}
+func (T *txTable[M]) History(id models.IdData) ([]ifaces.Revision[M], error) {
+	return T.HistoryContext(context.Background(), id)
+}
+func (T *txTable[M]) At(id models.IdData, at time.Time) (M, error) {
+	return T.AtContext(context.Background(), id, at)
+}
+func (T *txTable[M]) Revisions(callback func(revision ifaces.Revision[M]) bool) error {
+	return T.RevisionsContext(context.Background(), callback)
+}
+func (T *txTable[M]) ImportHistory(revisions []ifaces.Revision[M]) error {
+	return T.ImportHistoryContext(context.Background(), revisions)
+}
+func (T *txTable[M]) Lookup(index string, key string) ([]M, error) {
+	return T.LookupContext(context.Background(), index, key)
+}
+func (T *txTable[M]) Query(query *ifaces.Query) (ifaces.Page[M], error) {
+	return T.QueryContext(context.Background(), query)
+}
+func (T *txTable[M]) InsertMany(records []M) ([]ifaces.Result, error) {
+	return T.InsertManyContext(context.Background(), records)
+}
+func (T *txTable[M]) UpdateMany(records []M) ([]ifaces.Result, error) {
+	return T.UpdateManyContext(context.Background(), records)
+}
+func (T *txTable[M]) DeleteWhere(query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.DeleteWhereContext(context.Background(), query)
+}
+
+func (d *FakeDatabase) Open() error {
+	return d.OpenContext(context.Background())
+}
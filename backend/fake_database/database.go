@@ -1,7 +1,9 @@
 package fake_database
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/diakovliev/mesap/backend/ifaces"
@@ -10,16 +12,32 @@ import (
 
 type FakeTable[M ifaces.Models] struct {
 	sync.Mutex
-	parent sync.Locker
-	table  map[models.IdData]*M
-	currId models.IdData
+	parent  sync.Locker
+	name    string
+	journal Journal
+	table   map[models.IdData]*M
+	indexes map[string]*fakeIndex
+	history map[models.IdData][]ifaces.Revision[M]
+	feed    feed[M]
+	currId  models.IdData
+	ids     ifaces.IdStrategy
+
+	// owners reports whether the owner of the owned records exists, nil
+	// if the records are not owned. Called under the database lock.
+	owners func(owner models.IdData) bool
+	// cascade deletes the record together with the records it owns, nil
+	// if the records own nothing.
+	cascade func(ctx context.Context, id models.IdData) error
 }
 
 func makeFakeTable[M ifaces.Models](initialId models.IdData) FakeTable[M] {
 	return FakeTable[M]{
-		parent: nil,
-		table:  make(map[models.IdData]*M),
-		currId: initialId,
+		parent:  nil,
+		table:   make(map[models.IdData]*M),
+		indexes: makeIndexes[M](),
+		history: make(map[models.IdData][]ifaces.Revision[M]),
+		feed:    makeFeed[M](),
+		currId:  initialId,
 	}
 }
 
@@ -28,19 +46,125 @@ func NewFakeTable[M ifaces.Models](initialId models.IdData) *FakeTable[M] {
 	return &res
 }
 
-func (T *FakeTable[M]) nextId() models.IdData {
-	ret := T.currId
-	T.currId += 1
-	return ret
-}
-
-func (T *FakeTable[M]) Insert(record M) (models.IdData, error) {
+// lock locks the database and the table. The operation is not started
+// if ctx is done while waiting for the lock.
+func (T *FakeTable[M]) lock(ctx context.Context) error {
 	T.parent.Lock()
 	T.Mutex.Lock()
-	defer func() {
-		T.Mutex.Unlock()
-		T.parent.Unlock()
-	}()
+	if err := ctx.Err(); err != nil {
+		T.unlock()
+		return err
+	}
+	return nil
+}
+
+func (T *FakeTable[M]) unlock() {
+	T.Mutex.Unlock()
+	T.parent.Unlock()
+}
+
+// newId allocates id of the new record, see ifaces.IdStrategy.
+func (T *FakeTable[M]) newId() models.IdData {
+	return ifaces.AllocateId(T.ids, T.currId, T.used)
+}
+
+// used reports whether the id is taken by the existing or deleted record.
+func (T *FakeTable[M]) used(id models.IdData) bool {
+	_, exists := T.table[id]
+	_, deleted := T.history[id]
+	return exists || deleted
+}
+
+// allocated advances the next sequential id past the inserted one.
+func (T *FakeTable[M]) allocated(id models.IdData) {
+	if id >= T.currId {
+		T.currId = id + 1
+	}
+}
+
+// SetIdStrategy sets strategy of the new record ids, nil is Sequential.
+// Caller must hold the database lock (see FakeDatabase.Locked).
+func (T *FakeTable[M]) SetIdStrategy(strategy ifaces.IdStrategy) {
+	T.ids = strategy
+}
+
+func (T *FakeTable[M]) write(changes ...Change) error {
+	if T.journal == nil {
+		return nil
+	}
+	for i := range changes {
+		changes[i].Table = T.name
+	}
+	return T.journal.Write(changes...)
+}
+
+// Name returns table name.
+func (T *FakeTable[M]) Name() string {
+	return T.name
+}
+
+// TableData is the whole content of the table.
+type TableData[M ifaces.Models] struct {
+	Records []M
+	// Revisions of all records ordered by id and version
+	History []ifaces.Revision[M]
+	// Next id to allocate
+	NextId models.IdData
+}
+
+// Dump returns copy of the table content. Caller must hold the database
+// lock (see FakeDatabase.Locked).
+func (T *FakeTable[M]) Dump() TableData[M] {
+	data := TableData[M]{
+		Records: make([]M, 0, len(T.table)),
+		NextId:  T.currId,
+	}
+	for _, record := range T.table {
+		data.Records = append(data.Records, *record)
+	}
+	for _, revisions := range T.history {
+		data.History = append(data.History, revisions...)
+	}
+	sort.Slice(data.History, func(i, j int) bool {
+		a, b := data.History[i], data.History[j]
+		return a.Id < b.Id || a.Id == b.Id && a.Version < b.Version
+	})
+	return data
+}
+
+// Load replaces table content without journaling. Caller must hold the
+// database lock (see FakeDatabase.Locked).
+func (T *FakeTable[M]) Load(data TableData[M]) error {
+	table := make(map[models.IdData]*M, len(data.Records))
+	for i := range data.Records {
+		var r interface{} = &data.Records[i]
+		id, ok := r.(ifaces.Id)
+		if !ok {
+			return ifaces.ErrWrongRecord
+		}
+		table[id.GetId()] = &data.Records[i]
+	}
+	history := make(map[models.IdData][]ifaces.Revision[M])
+	// Revision replayed twice, e.g. imported to the history, replaces
+	// the previous one
+	for _, revision := range data.History {
+		history[revision.Id] = ifaces.PutRevision(history[revision.Id], revision)
+	}
+	T.table = table
+	T.history = history
+	T.indexes = makeIndexes[M]()
+	for id, record := range table {
+		T.indexAdd(id, record)
+	}
+	T.currId = data.NextId
+	return nil
+}
+
+func (T *FakeTable[M]) InsertContext(ctx context.Context, record M) (models.IdData, error) {
+	if err := T.lock(ctx); err != nil {
+		return models.BAD_ID, err
+	}
+	defer T.unlock()
 
 	var i interface{} = &record
 
@@ -49,25 +173,43 @@ func (T *FakeTable[M]) Insert(record M) (models.IdData, error) {
 		return models.BAD_ID, ifaces.ErrWrongRecord
 	}
 
-	id.SetId(T.nextId())
+	id.SetId(T.newId())
+	if err := ifaces.FirstVersion(&record); err != nil {
+		return models.BAD_ID, err
+	}
 
 	_, ok = T.table[id.GetId()]
 	if ok {
 		panic(fmt.Errorf("Record with id: %d already exist!", id.GetId()))
 	}
 
+	if err := checkOwner(&record, T.owners); err != nil {
+		return models.BAD_ID, err
+	}
+	if err := T.checkUnique(id.GetId(), &record); err != nil {
+		return models.BAD_ID, err
+	}
+
+	revision, err := ifaces.NewRevision(ctx, ifaces.RevisionInsert, nil, &record)
+	if err != nil {
+		return models.BAD_ID, err
+	}
+	if err := T.writeRevision(Change{Kind: ChangePut, Id: id.GetId(), Record: &record}, revision); err != nil {
+		return models.BAD_ID, err
+	}
+
+	T.allocated(id.GetId())
 	T.table[id.GetId()] = &record
+	T.indexAdd(id.GetId(), &record)
 
 	return id.GetId(), nil
 }
 
-func (T *FakeTable[M]) Update(record M) error {
-	T.parent.Lock()
-	T.Mutex.Lock()
-	defer func() {
-		T.Mutex.Unlock()
-		T.parent.Unlock()
-	}()
+func (T *FakeTable[M]) UpdateContext(ctx context.Context, record M) error {
+	if err := T.lock(ctx); err != nil {
+		return err
+	}
+	defer T.unlock()
 
 	var i interface{} = &record
 
@@ -76,43 +218,73 @@ func (T *FakeTable[M]) Update(record M) error {
 		return ifaces.ErrWrongRecord
 	}
 
-	_, ok = T.table[id.GetId()]
+	old, ok := T.table[id.GetId()]
 	if !ok {
-		return fmt.Errorf("Record with id: %d not exist!", id.GetId())
+		return ifaces.ErrNoSuchRecord
+	}
+
+	if err := ifaces.NextVersion(old, &record); err != nil {
+		return err
 	}
 
+	if err := checkOwner(&record, T.owners); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id.GetId(), &record); err != nil {
+		return err
+	}
+
+	revision, err := ifaces.NewRevision(ctx, ifaces.RevisionUpdate, old, &record)
+	if err != nil {
+		return err
+	}
+	if err := T.writeRevision(Change{Kind: ChangePut, Id: id.GetId(), Record: &record}, revision); err != nil {
+		return err
+	}
+
+	T.indexRemove(id.GetId(), old)
 	T.table[id.GetId()] = &record
+	T.indexAdd(id.GetId(), &record)
 	return nil
 }
 
-func (T *FakeTable[M]) Delete(id models.IdData) error {
-	T.parent.Lock()
-	T.Mutex.Lock()
-	defer func() {
-		T.Mutex.Unlock()
-		T.parent.Unlock()
-	}()
+func (T *FakeTable[M]) DeleteContext(ctx context.Context, id models.IdData) error {
+	if T.cascade != nil {
+		return T.cascade(ctx, id)
+	}
+
+	if err := T.lock(ctx); err != nil {
+		return err
+	}
+	defer T.unlock()
 
-	_, ok := T.table[id]
+	old, ok := T.table[id]
 	if !ok {
 		return ifaces.ErrNoSuchRecord
 	}
 
+	revision, err := deleteRevision(ctx, old)
+	if err != nil {
+		return err
+	}
+	if err := T.writeRevision(Change{Kind: ChangeDelete, Id: id}, revision); err != nil {
+		return err
+	}
+
+	T.indexRemove(id, old)
 	delete(T.table, id)
 
 	return nil
 }
 
-func (T *FakeTable[M]) Get(id models.IdData) (M, error) {
-	T.parent.Lock()
-	T.Mutex.Lock()
-	defer func() {
-		T.Mutex.Unlock()
-		T.parent.Unlock()
-	}()
-
+func (T *FakeTable[M]) GetContext(ctx context.Context, id models.IdData) (M, error) {
 	var res M
 
+	if err := T.lock(ctx); err != nil {
+		return res, err
+	}
+	defer T.unlock()
+
 	ret, ok := T.table[id]
 	if !ok {
 		return res, ifaces.ErrNoSuchRecord
@@ -123,45 +295,71 @@ func (T *FakeTable[M]) Get(id models.IdData) (M, error) {
 	return res, nil
 }
 
-func (T *FakeTable[M]) Each(callback func(record M) bool) error {
-	T.parent.Lock()
-	T.Mutex.Lock()
-	defer func() {
-		T.Mutex.Unlock()
-		T.parent.Unlock()
-	}()
-
-	err := ifaces.ErrEmptyTable
+func sortById[M ifaces.Models](records []M) {
+	sort.Slice(records, func(i, j int) bool {
+		return ifaces.IdOf(&records[i]) < ifaces.IdOf(&records[j])
+	})
+}
 
+// snapshot returns copies of the records ordered by id. Callbacks of Each
+// and Find are called with the snapshot after the lock is released, so
+// they may access the database.
+func (T *FakeTable[M]) snapshot(ctx context.Context) ([]M, error) {
+	if err := T.lock(ctx); err != nil {
+		return nil, err
+	}
+	records := make([]M, 0, len(T.table))
 	for _, record := range T.table {
-		err = nil
-		if !callback(*record) {
+		records = append(records, *record)
+	}
+	T.unlock()
+
+	sortById(records)
+	return records, nil
+}
+
+func (T *FakeTable[M]) EachContext(ctx context.Context, callback func(record M) bool) error {
+	records, err := T.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return ifaces.ErrEmptyTable
+	}
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !callback(record) {
 			break
 		}
 	}
 
-	return err
+	return nil
 }
 
-func (T *FakeTable[M]) Find(callback func(record M) bool) (M, error) {
-	T.parent.Lock()
-	T.Mutex.Lock()
-	defer func() {
-		T.Mutex.Unlock()
-		T.parent.Unlock()
-	}()
+func (T *FakeTable[M]) FindContext(ctx context.Context, callback func(record M) bool) (M, error) {
+	var res M
 
-	for _, record := range T.table {
-		if callback(*record) {
-			return *record, nil
+	records, err := T.snapshot(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if callback(record) {
+			return record, nil
 		}
 	}
 
-	var res M
 	return res, ifaces.ErrNoSuchRecord
 }
 
-///////////////////////////////////////////////////////////////////////////////
+// /////////////////////////////////////////////////////////////////////////////
 type FakeUsers struct {
 	FakeTable[models.User]
 }
@@ -171,29 +369,132 @@ type FakePeoples struct {
 type FakeRoles struct {
 	FakeTable[models.Role]
 }
+type FakePhones struct {
+	FakeTable[models.Phone]
+}
+type FakeAddresses struct {
+	FakeTable[models.Address]
+}
+type FakeEmails struct {
+	FakeTable[models.Email]
+}
+type FakeBankAccounts struct {
+	FakeTable[models.BankAccount]
+}
+
+const (
+	UsersTableName        = "users"
+	PeoplesTableName      = "peoples"
+	RolesTableName        = "roles"
+	PhonesTableName       = "phones"
+	AddressesTableName    = "addresses"
+	EmailsTableName       = "emails"
+	BankAccountsTableName = "bank_accounts"
+)
 
 type FakeDatabase struct {
 	sync.Mutex
-	users   *FakeUsers
-	peoples *FakePeoples
-	roles   *FakeRoles
+	journal      Journal
+	users        *FakeUsers
+	peoples      *FakePeoples
+	roles        *FakeRoles
+	phones       *FakePhones
+	addresses    *FakeAddresses
+	emails       *FakeEmails
+	bankAccounts *FakeBankAccounts
+	schema       int
 }
 
-///////////////////////////////////////////////////////////////////////////////
+// /////////////////////////////////////////////////////////////////////////////
 func NewDatabase() ifaces.Database {
+	return NewJournaledDatabase(nil)
+}
+
+// NewJournaledDatabase creates in memory database which writes every
+// change to the journal. Used by persistent backends.
+func NewJournaledDatabase(journal Journal) *FakeDatabase {
 	ret := &FakeDatabase{
-		users:   &FakeUsers{FakeTable: makeFakeTable[models.User](models.FIRST_ID)},
-		peoples: &FakePeoples{FakeTable: makeFakeTable[models.People](models.FIRST_ID)},
-		roles:   &FakeRoles{FakeTable: makeFakeTable[models.Role](models.FIRST_ID)},
+		journal:      journal,
+		users:        &FakeUsers{FakeTable: makeFakeTable[models.User](models.FIRST_ID)},
+		peoples:      &FakePeoples{FakeTable: makeFakeTable[models.People](models.FIRST_ID)},
+		roles:        &FakeRoles{FakeTable: makeFakeTable[models.Role](models.FIRST_ID)},
+		phones:       &FakePhones{FakeTable: makeFakeTable[models.Phone](models.FIRST_ID)},
+		addresses:    &FakeAddresses{FakeTable: makeFakeTable[models.Address](models.FIRST_ID)},
+		emails:       &FakeEmails{FakeTable: makeFakeTable[models.Email](models.FIRST_ID)},
+		bankAccounts: &FakeBankAccounts{FakeTable: makeFakeTable[models.BankAccount](models.FIRST_ID)},
 	}
-	ret.users.parent = ret
-	ret.peoples.parent = ret
-	ret.roles.parent = ret
+	ret.users.parent, ret.users.name, ret.users.journal = ret, UsersTableName, journal
+	ret.peoples.parent, ret.peoples.name, ret.peoples.journal = ret, PeoplesTableName, journal
+	ret.roles.parent, ret.roles.name, ret.roles.journal = ret, RolesTableName, journal
+	ret.phones.parent, ret.phones.name, ret.phones.journal = ret, PhonesTableName, journal
+	ret.addresses.parent, ret.addresses.name, ret.addresses.journal = ret, AddressesTableName, journal
+	ret.emails.parent, ret.emails.name, ret.emails.journal = ret, EmailsTableName, journal
+	ret.bankAccounts.parent, ret.bankAccounts.name, ret.bankAccounts.journal = ret, BankAccountsTableName, journal
+
+	ret.peoples.cascade = ret.deletePeople
+	ret.phones.owners = ret.peopleExists
+	ret.addresses.owners = ret.peopleExists
+	ret.emails.owners = ret.peopleExists
+	ret.bankAccounts.owners = ret.peopleExists
 	return ret
 }
-func (*FakeDatabase) Open() error {
+
+// SetIdStrategies sets id strategies of the tables by name, see
+// ifaces.IdStrategy. Returns ifaces.ErrNoSuchTable for unknown table.
+func (d *FakeDatabase) SetIdStrategies(strategies ifaces.IdStrategies) error {
+	tables := map[string]func(ifaces.IdStrategy){
+		UsersTableName:        d.users.SetIdStrategy,
+		PeoplesTableName:      d.peoples.SetIdStrategy,
+		RolesTableName:        d.roles.SetIdStrategy,
+		PhonesTableName:       d.phones.SetIdStrategy,
+		AddressesTableName:    d.addresses.SetIdStrategy,
+		EmailsTableName:       d.emails.SetIdStrategy,
+		BankAccountsTableName: d.bankAccounts.SetIdStrategy,
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	for name, strategy := range strategies {
+		set, ok := tables[name]
+		if !ok {
+			return fmt.Errorf("%w '%s'", ifaces.ErrNoSuchTable, name)
+		}
+		set(strategy)
+	}
 	return nil
 }
+
+// Locked runs callback with all tables of the database locked.
+func (d *FakeDatabase) Locked(callback func() error) error {
+	d.Lock()
+	defer d.Unlock()
+	return callback()
+}
+
+func (d *FakeDatabase) UsersTable() *FakeTable[models.User] {
+	return &d.users.FakeTable
+}
+func (d *FakeDatabase) PeoplesTable() *FakeTable[models.People] {
+	return &d.peoples.FakeTable
+}
+func (d *FakeDatabase) RolesTable() *FakeTable[models.Role] {
+	return &d.roles.FakeTable
+}
+func (d *FakeDatabase) PhonesTable() *FakeTable[models.Phone] {
+	return &d.phones.FakeTable
+}
+func (d *FakeDatabase) AddressesTable() *FakeTable[models.Address] {
+	return &d.addresses.FakeTable
+}
+func (d *FakeDatabase) EmailsTable() *FakeTable[models.Email] {
+	return &d.emails.FakeTable
+}
+func (d *FakeDatabase) BankAccountsTable() *FakeTable[models.BankAccount] {
+	return &d.bankAccounts.FakeTable
+}
+func (*FakeDatabase) OpenContext(ctx context.Context) error {
+	return ctx.Err()
+}
 func (*FakeDatabase) Close() {
 }
 func (d *FakeDatabase) Users() (ifaces.Table[models.User], error) {
@@ -205,3 +506,15 @@ func (d *FakeDatabase) Peoples() (ifaces.Table[models.People], error) {
 func (d *FakeDatabase) Roles() (ifaces.Table[models.Role], error) {
 	return d.roles, nil
 }
+func (d *FakeDatabase) Phones() (ifaces.Table[models.Phone], error) {
+	return d.phones, nil
+}
+func (d *FakeDatabase) Addresses() (ifaces.Table[models.Address], error) {
+	return d.addresses, nil
+}
+func (d *FakeDatabase) Emails() (ifaces.Table[models.Email], error) {
+	return d.emails, nil
+}
+func (d *FakeDatabase) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return d.bankAccounts, nil
+}
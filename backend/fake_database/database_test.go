@@ -0,0 +1,14 @@
+package fake_database
+
+import (
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/dbtest"
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) ifaces.Database {
+		return NewDatabase()
+	})
+}
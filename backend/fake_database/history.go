@@ -0,0 +1,249 @@
+package fake_database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// deleteRevision returns revision of the delete of the record. The state
+// to restore is the record with the next version.
+func deleteRevision[M ifaces.Models](ctx context.Context, record *M) (ifaces.Revision[M], error) {
+	deleted := *record
+	if err := ifaces.NextVersion(record, &deleted); err != nil {
+		return ifaces.Revision[M]{}, err
+	}
+	return ifaces.NewRevision(ctx, ifaces.RevisionDelete, record, &deleted)
+}
+
+// restoreRevision returns revision of the restore of the record deleted
+// by the last of revisions.
+func restoreRevision[M ifaces.Models](ctx context.Context, revisions []ifaces.Revision[M]) (ifaces.Revision[M], error) {
+	deleted, ok := ifaces.Deleted(revisions)
+	if !ok {
+		return ifaces.Revision[M]{}, ifaces.ErrNoSuchRecord
+	}
+	record := deleted
+	if err := ifaces.NextVersion(&deleted, &record); err != nil {
+		return ifaces.Revision[M]{}, err
+	}
+	return ifaces.NewRevision(ctx, ifaces.RevisionRestore, &deleted, &record)
+}
+
+// writeRevision journals the change together with the revision, adds
+// the revision to the history and publishes it to the watchers.
+func (T *FakeTable[M]) writeRevision(change Change, revision ifaces.Revision[M]) error {
+	if err := T.write(change, Change{Kind: ChangeRevision, Id: revision.Id, Record: &revision}); err != nil {
+		return err
+	}
+	T.history[revision.Id] = append(T.history[revision.Id], revision)
+	T.feed.publish(revision)
+	return nil
+}
+
+func (T *FakeTable[M]) RestoreContext(ctx context.Context, id models.IdData) error {
+	if err := T.lock(ctx); err != nil {
+		return err
+	}
+	defer T.unlock()
+
+	revision, err := restoreRevision(ctx, T.history[id])
+	if err != nil {
+		return err
+	}
+	record := revision.Record
+
+	if err := checkOwner(&record, T.owners); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id, &record); err != nil {
+		return err
+	}
+
+	if err := T.writeRevision(Change{Kind: ChangePut, Id: id, Record: &record}, revision); err != nil {
+		return err
+	}
+
+	T.table[id] = &record
+	T.indexAdd(id, &record)
+	return nil
+}
+
+func (T *FakeTable[M]) HistoryContext(ctx context.Context, id models.IdData) ([]ifaces.Revision[M], error) {
+	if err := T.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer T.unlock()
+
+	revisions, ok := T.history[id]
+	if _, live := T.table[id]; !ok && !live {
+		return nil, ifaces.ErrNoSuchRecord
+	}
+
+	return append([]ifaces.Revision[M](nil), revisions...), nil
+}
+
+func (T *FakeTable[M]) AtContext(ctx context.Context, id models.IdData, at time.Time) (M, error) {
+	if err := T.lock(ctx); err != nil {
+		var res M
+		return res, err
+	}
+	defer T.unlock()
+
+	return ifaces.StateAt(T.history[id], at)
+}
+
+// eachRevision calls back with the revisions ordered by id and version.
+func eachRevision[M ifaces.Models](ctx context.Context, history map[models.IdData][]ifaces.Revision[M], callback func(revision ifaces.Revision[M]) bool) error {
+	ids := make([]models.IdData, 0, len(history))
+	for id := range history {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		for _, revision := range history[id] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !callback(revision) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (T *FakeTable[M]) RevisionsContext(ctx context.Context, callback func(revision ifaces.Revision[M]) bool) error {
+	if err := T.lock(ctx); err != nil {
+		return err
+	}
+	history := make(map[models.IdData][]ifaces.Revision[M], len(T.history))
+	for id, revisions := range T.history {
+		history[id] = append([]ifaces.Revision[M](nil), revisions...)
+	}
+	T.unlock()
+
+	return eachRevision(ctx, history, callback)
+}
+
+func (T *FakeTable[M]) ImportHistoryContext(ctx context.Context, revisions []ifaces.Revision[M]) error {
+	if err := T.lock(ctx); err != nil {
+		return err
+	}
+	defer T.unlock()
+
+	changes := make([]Change, 0, len(revisions))
+	for i := range revisions {
+		if err := ifaces.ImportedRevision(&revisions[i]); err != nil {
+			return err
+		}
+		changes = append(changes, Change{Kind: ChangeRevision, Id: revisions[i].Id, Record: &revisions[i]})
+	}
+	if err := T.write(changes...); err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		T.history[revision.Id] = ifaces.PutRevision(T.history[revision.Id], revision)
+		T.allocated(revision.Id)
+	}
+	return nil
+}
+
+// history returns revisions of the record including revisions of the
+// transaction, the imported ones last.
+func (T *txTable[M]) history(id models.IdData) []ifaces.Revision[M] {
+	ret := append([]ifaces.Revision[M](nil), T.base.history[id]...)
+	for _, revision := range slices.Concat(T.revisions, T.imported) {
+		if revision.Id == id {
+			ret = ifaces.PutRevision(ret, revision)
+		}
+	}
+	return ret
+}
+
+func (T *txTable[M]) RevisionsContext(ctx context.Context, callback func(revision ifaces.Revision[M]) bool) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	history := make(map[models.IdData][]ifaces.Revision[M], len(T.base.history))
+	for id := range T.base.history {
+		history[id] = T.history(id)
+	}
+	for _, revision := range slices.Concat(T.revisions, T.imported) {
+		if _, ok := history[revision.Id]; !ok {
+			history[revision.Id] = T.history(revision.Id)
+		}
+	}
+
+	return eachRevision(ctx, history, callback)
+}
+
+func (T *txTable[M]) ImportHistoryContext(ctx context.Context, revisions []ifaces.Revision[M]) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	for i := range revisions {
+		if err := ifaces.ImportedRevision(&revisions[i]); err != nil {
+			return err
+		}
+	}
+	for _, revision := range revisions {
+		if revision.Id >= T.currId {
+			T.currId = revision.Id + 1
+		}
+		T.imported = append(T.imported, revision)
+	}
+	return nil
+}
+
+func (T *txTable[M]) RestoreContext(ctx context.Context, id models.IdData) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	revision, err := restoreRevision(ctx, T.history(id))
+	if err != nil {
+		return err
+	}
+	record := revision.Record
+
+	if err := checkOwner(&record, T.owners()); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id, &record); err != nil {
+		return err
+	}
+
+	T.changes[id] = &record
+	T.revisions = append(T.revisions, revision)
+	return nil
+}
+
+func (T *txTable[M]) HistoryContext(ctx context.Context, id models.IdData) ([]ifaces.Revision[M], error) {
+	if err := T.check(ctx); err != nil {
+		return nil, err
+	}
+
+	revisions := T.history(id)
+	if _, live := T.lookup(id); len(revisions) == 0 && !live {
+		return nil, ifaces.ErrNoSuchRecord
+	}
+
+	return revisions, nil
+}
+
+func (T *txTable[M]) AtContext(ctx context.Context, id models.IdData, at time.Time) (M, error) {
+	if err := T.check(ctx); err != nil {
+		var res M
+		return res, err
+	}
+
+	return ifaces.StateAt(T.history(id), at)
+}
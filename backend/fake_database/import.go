@@ -0,0 +1,144 @@
+package fake_database
+
+import (
+	"context"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+func (T *FakeTable[M]) ImportContext(ctx context.Context, record M) error {
+	if err := T.lock(ctx); err != nil {
+		return err
+	}
+	defer T.unlock()
+
+	id, err := ifaces.Imported(&record)
+	if err != nil {
+		return err
+	}
+	if T.used(id) {
+		return ifaces.ErrConflict
+	}
+
+	if err := checkOwner(&record, T.owners); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id, &record); err != nil {
+		return err
+	}
+
+	revision, err := ifaces.NewRevision(ctx, ifaces.RevisionInsert, nil, &record)
+	if err != nil {
+		return err
+	}
+	if err := T.writeRevision(Change{Kind: ChangePut, Id: id, Record: &record}, revision); err != nil {
+		return err
+	}
+
+	T.allocated(id)
+	T.table[id] = &record
+	T.indexAdd(id, &record)
+	return nil
+}
+
+func (T *txTable[M]) ImportContext(ctx context.Context, record M) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	id, err := ifaces.Imported(&record)
+	if err != nil {
+		return err
+	}
+	if T.used(id) {
+		return ifaces.ErrConflict
+	}
+
+	if err := checkOwner(&record, T.owners()); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id, &record); err != nil {
+		return err
+	}
+
+	revision, err := ifaces.NewRevision(ctx, ifaces.RevisionInsert, nil, &record)
+	if err != nil {
+		return err
+	}
+
+	if id >= T.currId {
+		T.currId = id + 1
+	}
+	T.changes[id] = &record
+	T.revisions = append(T.revisions, revision)
+	return nil
+}
+
+func (T *FakeTable[M]) ReplaceContext(ctx context.Context, record M) error {
+	if err := T.lock(ctx); err != nil {
+		return err
+	}
+	defer T.unlock()
+
+	var i interface{} = &record
+
+	id, ok := i.(ifaces.Id)
+	if !ok {
+		return ifaces.ErrWrongRecord
+	}
+
+	old, ok := T.table[id.GetId()]
+	if !ok {
+		return ifaces.ErrNoSuchRecord
+	}
+	if err := ifaces.SameVersion(old, &record); err != nil {
+		return err
+	}
+
+	if err := checkOwner(&record, T.owners); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id.GetId(), &record); err != nil {
+		return err
+	}
+
+	if err := T.write(Change{Kind: ChangePut, Id: id.GetId(), Record: &record}); err != nil {
+		return err
+	}
+
+	T.indexRemove(id.GetId(), old)
+	T.table[id.GetId()] = &record
+	T.indexAdd(id.GetId(), &record)
+	return nil
+}
+
+func (T *txTable[M]) ReplaceContext(ctx context.Context, record M) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	var i interface{} = &record
+
+	id, ok := i.(ifaces.Id)
+	if !ok {
+		return ifaces.ErrWrongRecord
+	}
+
+	stored, ok := T.lookup(id.GetId())
+	if !ok {
+		return ifaces.ErrNoSuchRecord
+	}
+	if err := ifaces.SameVersion(stored, &record); err != nil {
+		return err
+	}
+
+	if err := checkOwner(&record, T.owners()); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id.GetId(), &record); err != nil {
+		return err
+	}
+
+	T.changes[id.GetId()] = &record
+	return nil
+}
@@ -0,0 +1,164 @@
+package fake_database
+
+import (
+	"context"
+	"sort"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// fakeIndex maps the index keys to the ids of the records.
+type fakeIndex struct {
+	*ifaces.Index
+	keys map[string]map[models.IdData]struct{}
+}
+
+func makeIndexes[M ifaces.Models]() map[string]*fakeIndex {
+	ret := make(map[string]*fakeIndex)
+	for _, index := range ifaces.Indexes[M]() {
+		ret[index.Name] = &fakeIndex{
+			Index: index,
+			keys:  make(map[string]map[models.IdData]struct{}),
+		}
+	}
+	return ret
+}
+
+func (i *fakeIndex) add(id models.IdData, record any) {
+	for _, key := range i.Keys(record) {
+		ids, ok := i.keys[key]
+		if !ok {
+			ids = make(map[models.IdData]struct{})
+			i.keys[key] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+func (i *fakeIndex) remove(id models.IdData, record any) {
+	for _, key := range i.Keys(record) {
+		delete(i.keys[key], id)
+		if len(i.keys[key]) == 0 {
+			delete(i.keys, key)
+		}
+	}
+}
+
+// ids returns ids of the records having the key, sorted.
+func (i *fakeIndex) ids(key string) []models.IdData {
+	ret := make([]models.IdData, 0, len(i.keys[key]))
+	for id := range i.keys[key] {
+		ret = append(ret, id)
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a] < ret[b]
+	})
+	return ret
+}
+
+// checkUnique returns ifaces.UniqueViolationError if the record with the
+// id breaks unique index. lookup returns ids of the records having the key.
+func checkUnique(indexes map[string]*fakeIndex, id models.IdData, record any, lookup func(index *fakeIndex, key string) []models.IdData) error {
+	for _, index := range indexes {
+		if !index.Unique {
+			continue
+		}
+		for _, key := range index.Keys(record) {
+			for _, other := range lookup(index, key) {
+				if other != id {
+					return &ifaces.UniqueViolationError{Index: index.Name, Key: key}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (T *FakeTable[M]) indexAdd(id models.IdData, record *M) {
+	for _, index := range T.indexes {
+		index.add(id, record)
+	}
+}
+
+func (T *FakeTable[M]) indexRemove(id models.IdData, record *M) {
+	for _, index := range T.indexes {
+		index.remove(id, record)
+	}
+}
+
+func (T *FakeTable[M]) checkUnique(id models.IdData, record *M) error {
+	return checkUnique(T.indexes, id, record, func(index *fakeIndex, key string) []models.IdData {
+		return index.ids(key)
+	})
+}
+
+func (T *FakeTable[M]) LookupContext(ctx context.Context, name string, key string) ([]M, error) {
+	if err := T.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer T.unlock()
+
+	index, ok := T.indexes[name]
+	if !ok {
+		return nil, ifaces.ErrNoSuchIndex
+	}
+
+	ids := index.ids(key)
+	ret := make([]M, 0, len(ids))
+	for _, id := range ids {
+		ret = append(ret, *T.table[id])
+	}
+
+	return ret, nil
+}
+
+// lookupIds returns ids of the records having the key, including changes
+// of the transaction.
+func (T *txTable[M]) lookupIds(index *fakeIndex, key string) []models.IdData {
+	var ret []models.IdData
+	for _, id := range index.ids(key) {
+		if _, changed := T.changes[id]; !changed {
+			ret = append(ret, id)
+		}
+	}
+	for id, record := range T.changes {
+		if record == nil {
+			continue
+		}
+		for _, k := range index.Keys(record) {
+			if k == key {
+				ret = append(ret, id)
+				break
+			}
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a] < ret[b]
+	})
+	return ret
+}
+
+func (T *txTable[M]) checkUnique(id models.IdData, record *M) error {
+	return checkUnique(T.base.indexes, id, record, T.lookupIds)
+}
+
+func (T *txTable[M]) LookupContext(ctx context.Context, name string, key string) ([]M, error) {
+	if err := T.check(ctx); err != nil {
+		return nil, err
+	}
+
+	index, ok := T.base.indexes[name]
+	if !ok {
+		return nil, ifaces.ErrNoSuchIndex
+	}
+
+	ids := T.lookupIds(index, key)
+	ret := make([]M, 0, len(ids))
+	for _, id := range ids {
+		record, _ := T.lookup(id)
+		ret = append(ret, *record)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,47 @@
+package fake_database
+
+import (
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+type ChangeKind int
+
+const (
+	ChangePut ChangeKind = iota
+	ChangeDelete
+	// Revision of the record is added to the history
+	ChangeRevision
+	// Schema version of the database is set
+	ChangeSchema
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "delete"
+	case ChangeRevision:
+		return "revision"
+	case ChangeSchema:
+		return "schema"
+	}
+	return models.UnknownValueString
+}
+
+// Change is a single modification of the table data.
+type Change struct {
+	Table  string
+	Kind   ChangeKind
+	Id     models.IdData
+	Record any // *M for ChangePut, nil for ChangeDelete, *ifaces.Revision[M] for ChangeRevision, int for ChangeSchema
+}
+
+// Journal persists changes of the tables. It is called under the database
+// lock before the change is applied; the change is not applied if Write
+// returns an error. Changes of a single Write are the committed
+// transaction and may belong to several tables: they must be persisted
+// all or none.
+type Journal interface {
+	Write(changes ...Change) error
+}
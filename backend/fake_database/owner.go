@@ -0,0 +1,83 @@
+package fake_database
+
+import (
+	"context"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// checkOwner checks that the owner of the owned record exists. exists is
+// nil for the records which are not owned.
+func checkOwner[M ifaces.Models](record *M, exists func(owner models.IdData) bool) error {
+	if exists == nil {
+		return nil
+	}
+	var i interface{} = record
+	owned, ok := i.(ifaces.Owned)
+	if !ok {
+		return ifaces.ErrWrongRecord
+	}
+	if !exists(owned.GetOwner()) {
+		return ifaces.ErrNoOwner
+	}
+	return nil
+}
+
+func (d *FakeDatabase) peopleExists(id models.IdData) bool {
+	_, ok := d.peoples.table[id]
+	return ok
+}
+
+// deletePeople deletes the people and the records it owns in the single
+// transaction.
+func (d *FakeDatabase) deletePeople(ctx context.Context, id models.IdData) error {
+	return d.Tx(ctx, func(tx ifaces.Tx) error {
+		return tx.(*FakeTx).peoples.DeleteContext(ctx, id)
+	})
+}
+
+// owners returns the owner check of the owned records in the transaction.
+func (T *txTable[M]) owners() func(owner models.IdData) bool {
+	if T.base.owners == nil {
+		return nil
+	}
+	return T.tx.peopleExists
+}
+
+func (tx *FakeTx) peopleExists(id models.IdData) bool {
+	_, ok := tx.peoples.lookup(id)
+	return ok
+}
+
+// deleteOwned deletes the records owned by the people.
+func (tx *FakeTx) deleteOwned(ctx context.Context, owner models.IdData) error {
+	if err := deleteOwned(ctx, tx.phones, owner); err != nil {
+		return err
+	}
+	if err := deleteOwned(ctx, tx.addresses, owner); err != nil {
+		return err
+	}
+	if err := deleteOwned(ctx, tx.emails, owner); err != nil {
+		return err
+	}
+	return deleteOwned(ctx, tx.bankAccounts, owner)
+}
+
+func deleteOwned[M ifaces.Models](ctx context.Context, T *txTable[M], owner models.IdData) error {
+	var ids []models.IdData
+	T.each(func(record M) bool {
+		var i interface{} = &record
+		if i.(ifaces.Owned).GetOwner() == owner {
+			ids = append(ids, i.(ifaces.Id).GetId())
+		}
+		return true
+	})
+
+	for _, id := range ids {
+		if err := T.DeleteContext(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package fake_database
+
+import (
+	"context"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+func (T *FakeTable[M]) QueryContext(ctx context.Context, query *ifaces.Query) (ifaces.Page[M], error) {
+	prepared, err := ifaces.Prepare[M](query)
+	if err != nil {
+		return ifaces.Page[M]{}, err
+	}
+
+	records, err := T.snapshot(ctx)
+	if err != nil {
+		return ifaces.Page[M]{}, err
+	}
+
+	return ifaces.Run(prepared, records), nil
+}
+
+func (T *txTable[M]) QueryContext(ctx context.Context, query *ifaces.Query) (ifaces.Page[M], error) {
+	if err := T.check(ctx); err != nil {
+		return ifaces.Page[M]{}, err
+	}
+
+	prepared, err := ifaces.Prepare[M](query)
+	if err != nil {
+		return ifaces.Page[M]{}, err
+	}
+
+	var records []M
+	T.each(func(record M) bool {
+		records = append(records, record)
+		return true
+	})
+
+	return ifaces.Run(prepared, records), nil
+}
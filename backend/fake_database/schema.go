@@ -0,0 +1,46 @@
+package fake_database
+
+import (
+	"context"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+// SchemaTableName is the table of the ChangeSchema changes.
+const SchemaTableName = "schema"
+
+// SchemaVersion returns the schema version. Caller must hold the database
+// lock (see Locked).
+func (d *FakeDatabase) SchemaVersion() int {
+	return d.schema
+}
+
+// LoadSchemaVersion sets the schema version without journaling. Caller
+// must hold the database lock (see Locked).
+func (d *FakeDatabase) LoadSchemaVersion(version int) {
+	d.schema = version
+}
+
+func (tx *FakeTx) SchemaVersion(ctx context.Context) (int, error) {
+	if tx.done {
+		return 0, ifaces.ErrTxDone
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if tx.schema != nil {
+		return *tx.schema, nil
+	}
+	return tx.db.schema, nil
+}
+
+func (tx *FakeTx) SetSchemaVersion(ctx context.Context, version int) error {
+	if tx.done {
+		return ifaces.ErrTxDone
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx.schema = &version
+	return nil
+}
@@ -0,0 +1,400 @@
+package fake_database
+
+import (
+	"context"
+	"sort"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// txTable is a copy-on-write view of the FakeTable inside of the
+// transaction. The table itself is not touched until commit: changed
+// records are kept aside, nil marks deleted record. Revisions of the
+// changes are kept in order, the imported revisions are kept apart as
+// they are not published.
+type txTable[M ifaces.Models] struct {
+	base      *FakeTable[M]
+	tx        *FakeTx
+	changes   map[models.IdData]*M
+	revisions []ifaces.Revision[M]
+	imported  []ifaces.Revision[M]
+	currId    models.IdData
+}
+
+func newTxTable[M ifaces.Models](base *FakeTable[M], tx *FakeTx) *txTable[M] {
+	return &txTable[M]{
+		base:    base,
+		tx:      tx,
+		changes: make(map[models.IdData]*M),
+		currId:  base.currId,
+	}
+}
+
+// check returns error if the transaction is finished or ctx is done.
+func (T *txTable[M]) check(ctx context.Context) error {
+	if T.tx.done {
+		return ifaces.ErrTxDone
+	}
+	return ctx.Err()
+}
+
+func (T *txTable[M]) lookup(id models.IdData) (*M, bool) {
+	if record, ok := T.changes[id]; ok {
+		return record, record != nil
+	}
+	record, ok := T.base.table[id]
+	return record, ok
+}
+
+// used reports whether the id is taken by the existing or deleted record.
+func (T *txTable[M]) used(id models.IdData) bool {
+	if _, changed := T.changes[id]; changed {
+		return true
+	}
+	for _, revision := range T.imported {
+		if revision.Id == id {
+			return true
+		}
+	}
+	return T.base.used(id)
+}
+
+// each calls callback for the records of the transaction ordered by id.
+// The records are copied before the first call, so the callback may
+// change the table.
+func (T *txTable[M]) each(callback func(record M) bool) bool {
+	records := make([]M, 0, len(T.base.table)+len(T.changes))
+	for id, record := range T.base.table {
+		if _, changed := T.changes[id]; !changed {
+			records = append(records, *record)
+		}
+	}
+	for _, record := range T.changes {
+		if record != nil {
+			records = append(records, *record)
+		}
+	}
+	sortById(records)
+
+	for _, record := range records {
+		if !callback(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// pending returns changes to journal on commit ordered by id, followed
+// by the revisions.
+func (T *txTable[M]) pending() []Change {
+	ret := make([]Change, 0, len(T.changes)+len(T.revisions)+len(T.imported))
+	for id, record := range T.changes {
+		change := Change{Table: T.base.name, Kind: ChangePut, Id: id, Record: record}
+		if record == nil {
+			change = Change{Table: T.base.name, Kind: ChangeDelete, Id: id}
+		}
+		ret = append(ret, change)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Id < ret[j].Id
+	})
+	for i := range T.revisions {
+		ret = append(ret, Change{Table: T.base.name, Kind: ChangeRevision, Id: T.revisions[i].Id, Record: &T.revisions[i]})
+	}
+	for i := range T.imported {
+		ret = append(ret, Change{Table: T.base.name, Kind: ChangeRevision, Id: T.imported[i].Id, Record: &T.imported[i]})
+	}
+	return ret
+}
+
+func (T *txTable[M]) apply() {
+	for id, record := range T.changes {
+		if old, ok := T.base.table[id]; ok {
+			T.base.indexRemove(id, old)
+		}
+		if record == nil {
+			delete(T.base.table, id)
+		} else {
+			T.base.table[id] = record
+			T.base.indexAdd(id, record)
+		}
+	}
+	for _, revision := range T.revisions {
+		T.base.history[revision.Id] = ifaces.PutRevision(T.base.history[revision.Id], revision)
+		T.base.feed.publish(revision)
+	}
+	for _, revision := range T.imported {
+		T.base.history[revision.Id] = ifaces.PutRevision(T.base.history[revision.Id], revision)
+	}
+	T.base.currId = T.currId
+}
+
+func (T *txTable[M]) GetContext(ctx context.Context, id models.IdData) (M, error) {
+	var res M
+
+	if err := T.check(ctx); err != nil {
+		return res, err
+	}
+
+	record, ok := T.lookup(id)
+	if !ok {
+		return res, ifaces.ErrNoSuchRecord
+	}
+
+	return *record, nil
+}
+
+func (T *txTable[M]) FindContext(ctx context.Context, callback func(record M) bool) (M, error) {
+	var res M
+
+	if err := T.check(ctx); err != nil {
+		return res, err
+	}
+
+	err := ifaces.ErrNoSuchRecord
+	T.each(func(record M) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		err = ifaces.ErrNoSuchRecord
+		if callback(record) {
+			res, err = record, nil
+			return false
+		}
+		return true
+	})
+
+	return res, err
+}
+
+func (T *txTable[M]) EachContext(ctx context.Context, callback func(record M) bool) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	err := ifaces.ErrEmptyTable
+	T.each(func(record M) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		return callback(record)
+	})
+
+	return err
+}
+
+func (T *txTable[M]) InsertContext(ctx context.Context, record M) (models.IdData, error) {
+	if err := T.check(ctx); err != nil {
+		return models.BAD_ID, err
+	}
+
+	var i interface{} = &record
+
+	id, ok := i.(ifaces.Id)
+	if !ok {
+		return models.BAD_ID, ifaces.ErrWrongRecord
+	}
+
+	id.SetId(ifaces.AllocateId(T.base.ids, T.currId, T.used))
+	if err := ifaces.FirstVersion(&record); err != nil {
+		return models.BAD_ID, err
+	}
+
+	if err := checkOwner(&record, T.owners()); err != nil {
+		return models.BAD_ID, err
+	}
+	if err := T.checkUnique(id.GetId(), &record); err != nil {
+		return models.BAD_ID, err
+	}
+
+	revision, err := ifaces.NewRevision(ctx, ifaces.RevisionInsert, nil, &record)
+	if err != nil {
+		return models.BAD_ID, err
+	}
+
+	if id.GetId() >= T.currId {
+		T.currId = id.GetId() + 1
+	}
+	T.changes[id.GetId()] = &record
+	T.revisions = append(T.revisions, revision)
+
+	return id.GetId(), nil
+}
+
+func (T *txTable[M]) UpdateContext(ctx context.Context, record M) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	var i interface{} = &record
+
+	id, ok := i.(ifaces.Id)
+	if !ok {
+		return ifaces.ErrWrongRecord
+	}
+
+	stored, ok := T.lookup(id.GetId())
+	if !ok {
+		return ifaces.ErrNoSuchRecord
+	}
+	if err := ifaces.NextVersion(stored, &record); err != nil {
+		return err
+	}
+
+	if err := checkOwner(&record, T.owners()); err != nil {
+		return err
+	}
+	if err := T.checkUnique(id.GetId(), &record); err != nil {
+		return err
+	}
+
+	revision, err := ifaces.NewRevision(ctx, ifaces.RevisionUpdate, stored, &record)
+	if err != nil {
+		return err
+	}
+
+	T.changes[id.GetId()] = &record
+	T.revisions = append(T.revisions, revision)
+	return nil
+}
+
+func (T *txTable[M]) DeleteContext(ctx context.Context, id models.IdData) error {
+	if err := T.check(ctx); err != nil {
+		return err
+	}
+
+	old, ok := T.lookup(id)
+	if !ok {
+		return ifaces.ErrNoSuchRecord
+	}
+
+	revision, err := deleteRevision(ctx, old)
+	if err != nil {
+		return err
+	}
+	T.revisions = append(T.revisions, revision)
+
+	if _, ok := T.base.table[id]; ok {
+		T.changes[id] = nil
+	} else {
+		// Inserted by this transaction
+		delete(T.changes, id)
+	}
+
+	if T.base.cascade != nil {
+		return T.tx.deleteOwned(ctx, id)
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// FakeTx implements ifaces.Tx. The database is locked for the whole
+// transaction, so transactions are serialized.
+type FakeTx struct {
+	done         bool
+	db           *FakeDatabase
+	schema       *int
+	users        *txTable[models.User]
+	peoples      *txTable[models.People]
+	roles        *txTable[models.Role]
+	phones       *txTable[models.Phone]
+	addresses    *txTable[models.Address]
+	emails       *txTable[models.Email]
+	bankAccounts *txTable[models.BankAccount]
+}
+
+func newFakeTx(d *FakeDatabase) *FakeTx {
+	tx := &FakeTx{db: d}
+	tx.users = newTxTable(&d.users.FakeTable, tx)
+	tx.peoples = newTxTable(&d.peoples.FakeTable, tx)
+	tx.roles = newTxTable(&d.roles.FakeTable, tx)
+	tx.phones = newTxTable(&d.phones.FakeTable, tx)
+	tx.addresses = newTxTable(&d.addresses.FakeTable, tx)
+	tx.emails = newTxTable(&d.emails.FakeTable, tx)
+	tx.bankAccounts = newTxTable(&d.bankAccounts.FakeTable, tx)
+	return tx
+}
+
+// pendingTable is the table of the transaction changes.
+type pendingTable interface {
+	pending() []Change
+	apply()
+}
+
+// tables returns all tables of the transaction in the journal order.
+func (tx *FakeTx) tables() []pendingTable {
+	return []pendingTable{tx.users, tx.peoples, tx.roles, tx.phones, tx.addresses, tx.emails, tx.bankAccounts}
+}
+
+func (tx *FakeTx) Users() (ifaces.Table[models.User], error) {
+	return tx.users, nil
+}
+func (tx *FakeTx) Peoples() (ifaces.Table[models.People], error) {
+	return tx.peoples, nil
+}
+func (tx *FakeTx) Roles() (ifaces.Table[models.Role], error) {
+	return tx.roles, nil
+}
+func (tx *FakeTx) Phones() (ifaces.Table[models.Phone], error) {
+	return tx.phones, nil
+}
+func (tx *FakeTx) Addresses() (ifaces.Table[models.Address], error) {
+	return tx.addresses, nil
+}
+func (tx *FakeTx) Emails() (ifaces.Table[models.Email], error) {
+	return tx.emails, nil
+}
+func (tx *FakeTx) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return tx.bankAccounts, nil
+}
+
+// commit journals all changes with a single Write and applies them.
+func (tx *FakeTx) commit(journal Journal) error {
+	var changes []Change
+	for _, table := range tx.tables() {
+		changes = append(changes, table.pending()...)
+	}
+	if tx.schema != nil {
+		changes = append(changes, Change{Table: SchemaTableName, Kind: ChangeSchema, Record: *tx.schema})
+	}
+
+	if journal != nil && len(changes) > 0 {
+		if err := journal.Write(changes...); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tx.tables() {
+		table.apply()
+	}
+	if tx.schema != nil {
+		tx.db.schema = *tx.schema
+	}
+	return nil
+}
+
+func (d *FakeDatabase) Tx(ctx context.Context, callback func(tx ifaces.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	tx := newFakeTx(d)
+	defer func() {
+		tx.done = true
+	}()
+
+	if err := callback(tx); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return tx.commit(d.journal)
+}
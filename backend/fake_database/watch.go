@@ -0,0 +1,130 @@
+package fake_database
+
+import (
+	"context"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+const (
+	// feedRetention is the number of the last events kept to resume the
+	// watch.
+	feedRetention = 1024
+	// watcherBuffer is the number of events not received by the watcher
+	// before it is stopped with ifaces.ErrWatchOverflow.
+	watcherBuffer = 256
+)
+
+type watcher[M ifaces.Models] struct {
+	events  chan ifaces.Event[M]
+	stopped chan struct{}
+	err     error
+}
+
+func (w *watcher[M]) Events() <-chan ifaces.Event[M] {
+	return w.events
+}
+
+// Err is set before Events channel is closed.
+func (w *watcher[M]) Err() error {
+	return w.err
+}
+
+func (w *watcher[M]) stop(err error) {
+	w.err = err
+	close(w.events)
+	close(w.stopped)
+}
+
+// feed is the change feed of the table. It is guarded by the table lock.
+type feed[M ifaces.Models] struct {
+	seq      uint64
+	events   []ifaces.Event[M]
+	watchers map[*watcher[M]]struct{}
+}
+
+// makeFeed starts the sequence from the current time, so positions of the
+// previous runs of the process are reported as expired instead of being
+// mistaken for the new events.
+func makeFeed[M ifaces.Models]() feed[M] {
+	return feed[M]{
+		seq:      uint64(time.Now().UnixNano()),
+		watchers: make(map[*watcher[M]]struct{}),
+	}
+}
+
+// publish delivers the revision to the watchers. Watchers which do not
+// keep up are stopped.
+func (f *feed[M]) publish(revision ifaces.Revision[M]) {
+	f.seq++
+	event := ifaces.Event[M]{Seq: f.seq, Revision: revision}
+
+	f.events = append(f.events, event)
+	if len(f.events) > feedRetention {
+		f.events = append(f.events[:0], f.events[len(f.events)-feedRetention:]...)
+	}
+
+	for w := range f.watchers {
+		select {
+		case w.events <- event:
+		default:
+			delete(f.watchers, w)
+			w.stop(ifaces.ErrWatchOverflow)
+		}
+	}
+}
+
+// since returns the kept events after the position.
+func (f *feed[M]) since(after uint64) ([]ifaces.Event[M], error) {
+	if after == 0 {
+		return nil, nil
+	}
+	first := f.seq + 1 - uint64(len(f.events))
+	if after > f.seq || after+1 < first {
+		return nil, ifaces.ErrWatchExpired
+	}
+	return f.events[after+1-first:], nil
+}
+
+func (T *FakeTable[M]) Watch(ctx context.Context, after uint64) (ifaces.Watcher[M], error) {
+	if err := T.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer T.unlock()
+
+	backlog, err := T.feed.since(after)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher[M]{
+		events:  make(chan ifaces.Event[M], len(backlog)+watcherBuffer),
+		stopped: make(chan struct{}),
+	}
+	for _, event := range backlog {
+		w.events <- event
+	}
+	T.feed.watchers[w] = struct{}{}
+
+	go func() {
+		select {
+		case <-w.stopped:
+		case <-ctx.Done():
+			T.lock(context.Background())
+			defer T.unlock()
+			if _, ok := T.feed.watchers[w]; ok {
+				delete(T.feed.watchers, w)
+				w.stop(ctx.Err())
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// Watch is not supported by the transaction, its changes are delivered by
+// the table after commit.
+func (T *txTable[M]) Watch(ctx context.Context, after uint64) (ifaces.Watcher[M], error) {
+	return nil, ifaces.ErrNotSupported
+}
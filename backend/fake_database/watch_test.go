@@ -0,0 +1,56 @@
+package fake_database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func TestWatchOverflow(t *testing.T) {
+	table := NewJournaledDatabase(nil).RolesTable()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := table.Watch(ctx, 0)
+	if err != nil {
+		t.Fatalf("Watch error: %s", err)
+	}
+	inserted := 0
+	insert := func(n int) {
+		for ; n > 0; n-- {
+			inserted++
+			if _, err := table.Insert(models.Role{Name: fmt.Sprintf("role %d", inserted)}); err != nil {
+				t.Fatalf("Insert error: %s", err)
+			}
+		}
+	}
+
+	insert(watcherBuffer + 10)
+
+	var last uint64
+	for event := range w.Events() {
+		last = event.Seq
+	}
+	if !errors.Is(w.Err(), ifaces.ErrWatchOverflow) {
+		t.Fatalf("Unexpected error: %v", w.Err())
+	}
+
+	// Slow client resumes from the last received event
+	resumed, err := table.Watch(ctx, last)
+	if err != nil {
+		t.Fatalf("Watch error: %s", err)
+	}
+	if n := len(resumed.Events()); n != 10 {
+		t.Fatalf("Expected 10 events, got %d", n)
+	}
+
+	insert(feedRetention)
+	if _, err := table.Watch(ctx, last); !errors.Is(err, ifaces.ErrWatchExpired) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,534 @@
+package file_database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+const (
+	walSuffix      = ".wal"
+	snapshotSuffix = ".snapshot"
+	commitLogName  = "commit" + walSuffix
+	lockName       = "lock"
+)
+
+var (
+	ErrNotOpen = errors.New("Database is not open!")
+	ErrLocked  = errors.New("Database is used by another process!")
+)
+
+type persistedTable interface {
+	name() string
+	open(dir string, sync bool, commits *commitLog) error
+	write(changes []fake_database.Change, tx uint64) error
+	snapshot(dir string, sync bool) error
+	close() error
+}
+
+type snapshotData[M ifaces.Models] struct {
+	NextId  models.IdData        `json:"nextId"`
+	Records []M                  `json:"records"`
+	History []ifaces.Revision[M] `json:"history,omitempty"`
+}
+
+// fileTable persists single fake_database.FakeTable as a snapshot and
+// the WAL of the changes made after the snapshot.
+type fileTable[M ifaces.Models] struct {
+	table *fake_database.FakeTable[M]
+	wal   *wal
+}
+
+func newFileTable[M ifaces.Models](table *fake_database.FakeTable[M]) *fileTable[M] {
+	return &fileTable[M]{table: table}
+}
+
+func (T *fileTable[M]) name() string {
+	return T.table.Name()
+}
+
+// open loads the snapshot and replays the WAL on top of it. Entries of
+// the transactions missing in the commit log are skipped.
+func (T *fileTable[M]) open(dir string, sync bool, commits *commitLog) error {
+	records := make(map[models.IdData]M)
+	var history []ifaces.Revision[M]
+	nextId := models.IdData(models.FIRST_ID)
+
+	data, err := os.ReadFile(filepath.Join(dir, T.name()+snapshotSuffix))
+	if err == nil {
+		var snapshot snapshotData[M]
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("Can't load '%s' snapshot: %w", T.name(), err)
+		}
+		for _, record := range snapshot.Records {
+			var r interface{} = &record
+			records[r.(ifaces.Id).GetId()] = record
+		}
+		history = snapshot.History
+		nextId = snapshot.NextId
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	T.wal, err = openWal(filepath.Join(dir, T.name()+walSuffix), sync)
+	if err != nil {
+		return err
+	}
+
+	err = T.wal.replay(func(entry walEntry) error {
+		if entry.Tx != 0 {
+			commits.seen(entry.Tx)
+			if !commits.committed[entry.Tx] {
+				return nil
+			}
+		}
+		for _, change := range entry.Changes {
+			switch change.Op {
+			case fake_database.ChangePut.String():
+				var record M
+				if err := json.Unmarshal(change.Record, &record); err != nil {
+					return err
+				}
+				records[change.Id] = record
+			case fake_database.ChangeDelete.String():
+				delete(records, change.Id)
+			case fake_database.ChangeRevision.String():
+				var revision ifaces.Revision[M]
+				if err := json.Unmarshal(change.Record, &revision); err != nil {
+					return err
+				}
+				history = append(history, revision)
+			default:
+				return ErrCorruptedEntry
+			}
+			if change.Id >= nextId {
+				nextId = change.Id + 1
+			}
+		}
+		return nil
+	}, func(offset int64, err error) {
+		log.Printf("Table '%s': WAL is corrupted at offset %d (%s), tail is dropped", T.name(), offset, err)
+	})
+	if err != nil {
+		T.wal.close()
+		T.wal = nil
+		return fmt.Errorf("Can't replay '%s' WAL: %w", T.name(), err)
+	}
+
+	loaded := fake_database.TableData[M]{
+		Records: make([]M, 0, len(records)),
+		History: history,
+		NextId:  nextId,
+	}
+	for _, record := range records {
+		loaded.Records = append(loaded.Records, record)
+	}
+	return T.table.Load(loaded)
+}
+
+func (T *fileTable[M]) write(changes []fake_database.Change, tx uint64) error {
+	if T.wal == nil {
+		return ErrNotOpen
+	}
+
+	entry := walEntry{Tx: tx}
+	for _, change := range changes {
+		walChange := walChange{Op: change.Kind.String(), Id: change.Id}
+		if change.Record != nil {
+			data, err := json.Marshal(change.Record)
+			if err != nil {
+				return err
+			}
+			walChange.Record = data
+		}
+		entry.Changes = append(entry.Changes, walChange)
+	}
+
+	return T.wal.append(entry)
+}
+
+// snapshot writes whole table to the snapshot file and truncates the WAL.
+// Caller must hold the database lock.
+func (T *fileTable[M]) snapshot(dir string, sync bool) error {
+	if T.wal == nil {
+		return ErrNotOpen
+	}
+
+	dump := T.table.Dump()
+	data, err := json.Marshal(snapshotData[M]{NextId: dump.NextId, Records: dump.Records, History: dump.History})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, T.name()+snapshotSuffix), data, sync); err != nil {
+		return err
+	}
+
+	return T.wal.truncate()
+}
+
+func (T *fileTable[M]) close() error {
+	if T.wal == nil {
+		return nil
+	}
+	err := T.wal.close()
+	T.wal = nil
+	return err
+}
+
+// commitLog is a WAL of the ids of committed transactions spanning
+// several tables. The transaction is committed when its id is appended to
+// the log after the changes are appended to the WALs of the tables.
+type commitLog struct {
+	wal       *wal
+	committed map[uint64]bool
+	last      uint64
+}
+
+func openCommitLog(dir string, sync bool) (*commitLog, error) {
+	w, err := openWal(filepath.Join(dir, commitLogName), sync)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &commitLog{wal: w, committed: make(map[uint64]bool)}
+	err = w.replay(func(entry walEntry) error {
+		ret.seen(entry.Tx)
+		ret.committed[entry.Tx] = true
+		return nil
+	}, func(offset int64, err error) {
+		log.Printf("Commit log is corrupted at offset %d (%s), tail is dropped", offset, err)
+	})
+	if err != nil {
+		w.close()
+		return nil, fmt.Errorf("Can't replay commit log: %w", err)
+	}
+
+	return ret, nil
+}
+
+// seen makes sure that the transaction ids are not reused.
+func (c *commitLog) seen(tx uint64) {
+	if tx > c.last {
+		c.last = tx
+	}
+}
+
+func (c *commitLog) next() uint64 {
+	c.last++
+	return c.last
+}
+
+func (c *commitLog) commit(tx uint64) error {
+	if err := c.wal.append(walEntry{Tx: tx}); err != nil {
+		return err
+	}
+	c.committed[tx] = true
+	return nil
+}
+
+// truncate is called after all tables are snapshotted.
+func (c *commitLog) truncate() error {
+	if err := c.wal.truncate(); err != nil {
+		return err
+	}
+	c.committed = make(map[uint64]bool)
+	return nil
+}
+
+func (c *commitLog) close() error {
+	return c.wal.close()
+}
+
+// writeFileAtomic replaces file content, so the file contains either old or
+// new data after a crash.
+func writeFileAtomic(path string, data []byte, sync bool) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if sync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if !sync {
+		return nil
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// FileDatabase is a durable ifaces.Database. Data is kept in memory by
+// fake_database tables, every change is appended to the WAL of the table
+// and WALs are periodically compacted into the snapshots.
+type FileDatabase struct {
+	fake *fake_database.FakeDatabase
+
+	dir              string
+	snapshotInterval time.Duration
+	sync             bool
+
+	tables  []persistedTable
+	byName  map[string]persistedTable
+	commits *commitLog
+	lock    *os.File
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewDatabase creates database stored in dir. Snapshots are made every
+// snapshotInterval (if > 0) and on Close.
+func NewDatabase(dir string, snapshotInterval time.Duration) *FileDatabase {
+	ret := &FileDatabase{
+		dir:              dir,
+		snapshotInterval: snapshotInterval,
+		sync:             true,
+		byName:           make(map[string]persistedTable),
+	}
+	ret.fake = fake_database.NewJournaledDatabase(ret)
+	ret.tables = []persistedTable{
+		newFileTable(ret.fake.UsersTable()),
+		newFileTable(ret.fake.PeoplesTable()),
+		newFileTable(ret.fake.RolesTable()),
+		newFileTable(ret.fake.PhonesTable()),
+		newFileTable(ret.fake.AddressesTable()),
+		newFileTable(ret.fake.EmailsTable()),
+		newFileTable(ret.fake.BankAccountsTable()),
+		&schemaTable{db: ret.fake},
+	}
+	for _, table := range ret.tables {
+		ret.byName[table.name()] = table
+	}
+	return ret
+}
+
+// SetSync enables or disables fsync after every write. Enabled by default.
+func (d *FileDatabase) SetSync(sync bool) {
+	d.sync = sync
+}
+
+// SetIdStrategies sets id strategies of the tables by name, see
+// ifaces.IdStrategy. Ids of the records are kept, so strategy may be changed
+// between runs.
+func (d *FileDatabase) SetIdStrategies(strategies ifaces.IdStrategies) error {
+	return d.fake.SetIdStrategies(strategies)
+}
+
+func (d *FileDatabase) Open() error {
+	return d.OpenContext(context.Background())
+}
+
+// OpenContext loads the tables, loading is canceled between the tables if
+// ctx is done. Fails with ErrLocked if the database is open by another
+// process.
+func (d *FileDatabase) OpenContext(ctx context.Context) error {
+	if err := os.MkdirAll(d.dir, 0o700); err != nil {
+		return err
+	}
+
+	err := d.fake.Locked(func() error {
+		var err error
+		if d.lock, err = lockFile(d.dir); err != nil {
+			return err
+		}
+		if d.commits, err = openCommitLog(d.dir, d.sync); err != nil {
+			return err
+		}
+		for _, table := range d.tables {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := table.open(d.dir, d.sync, d.commits); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.closeTables()
+		return err
+	}
+
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go d.snapshotter()
+
+	return nil
+}
+
+func (d *FileDatabase) Close() {
+	d.closeOnce.Do(func() {
+		if d.stop == nil {
+			// Not opened
+			return
+		}
+
+		close(d.stop)
+		<-d.done
+
+		if err := d.Snapshot(); err != nil {
+			log.Printf("Can't snapshot database on close: %s", err)
+		}
+
+		d.closeTables()
+	})
+}
+
+func (d *FileDatabase) closeTables() {
+	d.fake.Locked(func() error {
+		for _, table := range d.tables {
+			if err := table.close(); err != nil {
+				log.Printf("Can't close table '%s': %s", table.name(), err)
+			}
+		}
+		if d.commits != nil {
+			if err := d.commits.close(); err != nil {
+				log.Printf("Can't close commit log: %s", err)
+			}
+			d.commits = nil
+		}
+		if d.lock != nil {
+			// Closing the file releases the lock
+			d.lock.Close()
+			d.lock = nil
+		}
+		return nil
+	})
+}
+
+// Snapshot writes snapshots of all tables and truncates their WALs.
+func (d *FileDatabase) Snapshot() error {
+	return d.fake.Locked(func() error {
+		if d.commits == nil {
+			return ErrNotOpen
+		}
+		for _, table := range d.tables {
+			if err := table.snapshot(d.dir, d.sync); err != nil {
+				return fmt.Errorf("Can't snapshot table '%s': %w", table.name(), err)
+			}
+		}
+		return d.commits.truncate()
+	})
+}
+
+func (d *FileDatabase) snapshotter() {
+	defer close(d.done)
+
+	if d.snapshotInterval <= 0 {
+		<-d.stop
+		return
+	}
+
+	ticker := time.NewTicker(d.snapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			if err := d.Snapshot(); err != nil {
+				log.Printf("Periodic snapshot error: %s", err)
+			}
+		}
+	}
+}
+
+// Write implements fake_database.Journal. Changes of a single table are
+// appended to its WAL as one entry. Changes of several tables are appended
+// as entries of the new transaction which is committed by the commit log.
+func (d *FileDatabase) Write(changes ...fake_database.Change) error {
+	var tables []persistedTable
+	byTable := make(map[string][]fake_database.Change)
+	for _, change := range changes {
+		t, ok := d.byName[change.Table]
+		if !ok {
+			return fmt.Errorf("Unknown table '%s'!", change.Table)
+		}
+		if _, ok := byTable[change.Table]; !ok {
+			tables = append(tables, t)
+		}
+		byTable[change.Table] = append(byTable[change.Table], change)
+	}
+
+	switch len(tables) {
+	case 0:
+		return nil
+	case 1:
+		return tables[0].write(changes, 0)
+	}
+	if d.commits == nil {
+		return ErrNotOpen
+	}
+
+	tx := d.commits.next()
+	for _, t := range tables {
+		if err := t.write(byTable[t.name()], tx); err != nil {
+			return err
+		}
+	}
+	return d.commits.commit(tx)
+}
+
+// Tx runs transaction over the in memory tables, see Write for the
+// persistence.
+func (d *FileDatabase) Tx(ctx context.Context, callback func(tx ifaces.Tx) error) error {
+	return d.fake.Tx(ctx, callback)
+}
+
+func (d *FileDatabase) Users() (ifaces.Table[models.User], error) {
+	return d.fake.Users()
+}
+func (d *FileDatabase) Peoples() (ifaces.Table[models.People], error) {
+	return d.fake.Peoples()
+}
+func (d *FileDatabase) Roles() (ifaces.Table[models.Role], error) {
+	return d.fake.Roles()
+}
+func (d *FileDatabase) Phones() (ifaces.Table[models.Phone], error) {
+	return d.fake.Phones()
+}
+func (d *FileDatabase) Addresses() (ifaces.Table[models.Address], error) {
+	return d.fake.Addresses()
+}
+func (d *FileDatabase) Emails() (ifaces.Table[models.Email], error) {
+	return d.fake.Emails()
+}
+func (d *FileDatabase) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return d.fake.BankAccounts()
+}
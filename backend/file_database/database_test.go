@@ -0,0 +1,358 @@
+package file_database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/dbtest"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func openTestDatabase(t *testing.T, dir string) *FileDatabase {
+	db := NewDatabase(dir, 0)
+	db.SetSync(false)
+	if err := db.Open(); err != nil {
+		t.Fatalf("Can't open database: %s", err)
+	}
+	return db
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDatabase(t, dir)
+
+	users, _ := db.Users()
+	aliceId, _ := users.Insert(models.User{Login: "alice"})
+	bobId, _ := users.Insert(models.User{Login: "bob"})
+	eveId, _ := users.Insert(models.User{Login: "eve"})
+
+	if err := users.Update(models.User{Id: models.MakeId(bobId), Version: models.Version{VersionData: models.FIRST_VERSION}, Login: "robert"}); err != nil {
+		t.Fatalf("Update error: %s", err)
+	}
+	if err := users.Delete(eveId); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+
+	// Simulate crash: WAL is not compacted into the snapshot
+	db.closeTables()
+
+	for i := 0; i < 2; i++ {
+		db = openTestDatabase(t, dir)
+		users, _ = db.Users()
+
+		if alice, err := users.Get(aliceId); err != nil || alice.Login != "alice" {
+			t.Fatalf("Unexpected record: %v, error: %v", alice, err)
+		}
+		if bob, err := users.Get(bobId); err != nil || bob.Login != "robert" {
+			t.Fatalf("Unexpected record: %v, error: %v", bob, err)
+		}
+		if _, err := users.Get(eveId); err == nil {
+			t.Fatalf("Deleted record is restored")
+		}
+
+		// Deleted ids must not be reused
+		id, _ := users.Insert(models.User{Login: "mallory"})
+		if id <= eveId {
+			t.Fatalf("Id %d is reused", id)
+		}
+		users.Delete(id)
+
+		// Compact the WAL
+		db.Close()
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "users"+walSuffix))
+	if err != nil || info.Size() != 0 {
+		t.Fatalf("WAL is not truncated by snapshot: %v %v", info, err)
+	}
+}
+
+func TestTornWal(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDatabase(t, dir)
+	users, _ := db.Users()
+	aliceId, _ := users.Insert(models.User{Login: "alice"})
+	users.Insert(models.User{Login: "bob"})
+	db.closeTables()
+
+	// Cut the last entry in the middle
+	path := filepath.Join(dir, "users"+walSuffix)
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatalf("Truncate error: %s", err)
+	}
+
+	db = openTestDatabase(t, dir)
+	defer db.Close()
+	users, _ = db.Users()
+
+	count := 0
+	users.Each(func(models.User) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Fatalf("Expected 1 record, got %d", count)
+	}
+	if alice, err := users.Get(aliceId); err != nil || alice.Login != "alice" {
+		t.Fatalf("Unexpected record: %v, error: %v", alice, err)
+	}
+
+	// New entries are appended after the last valid one
+	charlieId, _ := users.Insert(models.User{Login: "charlie"})
+	db.closeTables()
+
+	db = openTestDatabase(t, dir)
+	users, _ = db.Users()
+	if charlie, err := users.Get(charlieId); err != nil || charlie.Login != "charlie" {
+		t.Fatalf("Unexpected record: %v, error: %v", charlie, err)
+	}
+}
+
+func TestUncommittedTx(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDatabase(t, dir)
+	err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+		users, _ := tx.Users()
+		peoples, _ := tx.Peoples()
+		users.Insert(models.User{Login: "alice"})
+		peoples.Insert(models.People{Name: "Alice"})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Tx error: %s", err)
+	}
+	db.closeTables()
+
+	// Simulate crash in the middle of the transaction commit: changes are
+	// written to one of the tables, but the commit log has no entry
+	w, err := openWal(filepath.Join(dir, "users"+walSuffix), false)
+	if err != nil {
+		t.Fatalf("Can't open WAL: %s", err)
+	}
+	w.file.Seek(0, io.SeekEnd)
+	w.append(walEntry{Tx: 1000, Changes: []walChange{{Op: "put", Id: 100, Record: []byte(`{"Id":100,"Login":"bob"}`)}}})
+	w.close()
+
+	for i := 0; i < 2; i++ {
+		db = openTestDatabase(t, dir)
+		users, _ := db.Users()
+		peoples, _ := db.Peoples()
+
+		if _, err := users.Get(100); err == nil {
+			t.Fatalf("Uncommitted record is restored")
+		}
+		if _, err := users.Find(func(user models.User) bool { return user.Login == "alice" }); err != nil {
+			t.Fatalf("Committed user is not restored: %s", err)
+		}
+		if _, err := peoples.Find(func(people models.People) bool { return people.Name == "Alice" }); err != nil {
+			t.Fatalf("Committed people is not restored: %s", err)
+		}
+
+		// Transaction id of the uncommitted entry must not be reused
+		err := db.Tx(context.Background(), func(tx ifaces.Tx) error {
+			users, _ := tx.Users()
+			roles, _ := tx.Roles()
+			users.Insert(models.User{Login: "charlie"})
+			roles.Insert(models.Role{Name: "admin"})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Tx error: %s", err)
+		}
+		db.closeTables()
+	}
+}
+
+func TestHistoryPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDatabase(t, dir)
+	roles, _ := db.Roles()
+	id, _ := roles.Insert(models.Role{Name: "admin"})
+	if err := roles.Delete(id); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+	// Revisions are replayed from the WAL
+	db.closeTables()
+
+	db = openTestDatabase(t, dir)
+	roles, _ = db.Roles()
+	if err := roles.Restore(id); err != nil {
+		t.Fatalf("Restore error: %s", err)
+	}
+	// Revisions are loaded from the snapshot
+	db.Close()
+
+	db = openTestDatabase(t, dir)
+	defer db.Close()
+	roles, _ = db.Roles()
+
+	revisions, err := roles.History(id)
+	if err != nil {
+		t.Fatalf("History error: %s", err)
+	}
+	var kinds []ifaces.RevisionKind
+	for _, revision := range revisions {
+		kinds = append(kinds, revision.Kind)
+	}
+	if len(kinds) != 3 || kinds[0] != ifaces.RevisionInsert || kinds[1] != ifaces.RevisionDelete || kinds[2] != ifaces.RevisionRestore {
+		t.Fatalf("Unexpected revisions: %v", kinds)
+	}
+	if role, err := roles.Get(id); err != nil || role.Name != "admin" || role.GetVersion() != models.FIRST_VERSION+2 {
+		t.Fatalf("Unexpected record: %+v, error: %v", role, err)
+	}
+}
+
+func TestImportedHistoryPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDatabase(t, dir)
+	roles, _ := db.Roles()
+	id, _ := roles.Insert(models.Role{Name: "admin"})
+	revisions, err := roles.History(id)
+	if err != nil {
+		t.Fatalf("History error: %s", err)
+	}
+	revisions[0].Actor = "hr"
+	if err := roles.ImportHistory(revisions); err != nil {
+		t.Fatalf("ImportHistory error: %s", err)
+	}
+	// Imported revision replaces the replayed one
+	db.closeTables()
+
+	db = openTestDatabase(t, dir)
+	defer db.Close()
+	roles, _ = db.Roles()
+
+	revisions, err = roles.History(id)
+	if err != nil {
+		t.Fatalf("History error: %s", err)
+	}
+	if len(revisions) != 1 || revisions[0].Actor != "hr" {
+		t.Fatalf("Unexpected revisions: %+v", revisions)
+	}
+}
+
+func TestSchemaVersionPersistence(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	schemaVersion := func(db *FileDatabase) (version int) {
+		t.Helper()
+		err := db.Tx(ctx, func(tx ifaces.Tx) (err error) {
+			version, err = tx.SchemaVersion(ctx)
+			return
+		})
+		if err != nil {
+			t.Fatalf("SchemaVersion error: %s", err)
+		}
+		return
+	}
+
+	db := openTestDatabase(t, dir)
+	// Version is committed together with the migrated data
+	err := db.Tx(ctx, func(tx ifaces.Tx) error {
+		roles, _ := tx.Roles()
+		if _, err := roles.Insert(models.Role{Name: "admin"}); err != nil {
+			return err
+		}
+		return tx.SetSchemaVersion(ctx, 2)
+	})
+	if err != nil {
+		t.Fatalf("Tx error: %s", err)
+	}
+	// Version is replayed from the WAL
+	db.closeTables()
+
+	db = openTestDatabase(t, dir)
+	if version := schemaVersion(db); version != 2 {
+		t.Fatalf("Unexpected schema version after replay: %d", version)
+	}
+	// Version is loaded from the snapshot
+	db.Close()
+
+	db = openTestDatabase(t, dir)
+	defer db.Close()
+	if version := schemaVersion(db); version != 2 {
+		t.Fatalf("Unexpected schema version after snapshot: %d", version)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDatabase(t, dir)
+	other := NewDatabase(dir, 0)
+	if err := other.Open(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	other.Close()
+
+	// Lock is released on close
+	db.Close()
+	db = openTestDatabase(t, dir)
+	db.Close()
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) ifaces.Database {
+		db := NewDatabase(t.TempDir(), 0)
+		db.SetSync(false)
+		return db
+	})
+}
+
+// tornFile fails the write after the first n bytes of the line.
+type tornFile struct {
+	walFile
+	n int
+}
+
+func (f *tornFile) WriteString(s string) (int, error) {
+	n, _ := f.walFile.WriteString(s[:f.n])
+	return n, io.ErrShortWrite
+}
+
+func TestTornAppend(t *testing.T) {
+	w, err := openWal(filepath.Join(t.TempDir(), "table"+walSuffix), false)
+	if err != nil {
+		t.Fatalf("Can't open WAL: %s", err)
+	}
+	defer w.close()
+
+	if err := w.append(walEntry{Tx: 1}); err != nil {
+		t.Fatalf("Append error: %s", err)
+	}
+	file := w.file
+	w.file = &tornFile{walFile: file, n: 5}
+	if err := w.append(walEntry{Tx: 2}); err == nil {
+		t.Fatalf("Torn append succeeded")
+	}
+	w.file = file
+	if err := w.append(walEntry{Tx: 3}); err != nil {
+		t.Fatalf("Append error: %s", err)
+	}
+
+	var txs []uint64
+	err = w.replay(func(entry walEntry) error {
+		txs = append(txs, entry.Tx)
+		return nil
+	}, func(offset int64, err error) {
+		t.Fatalf("Corrupted entry at %d: %s", offset, err)
+	})
+	if err != nil {
+		t.Fatalf("Replay error: %s", err)
+	}
+	if len(txs) != 2 || txs[0] != 1 || txs[1] != 3 {
+		t.Fatalf("Unexpected entries: %v", txs)
+	}
+}
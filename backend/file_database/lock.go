@@ -0,0 +1,29 @@
+//go:build unix
+
+package file_database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile takes the exclusive lock of the database directory, so the
+// commands do not write the database of the running server. The lock is
+// released by the system if the process dies.
+func lockFile(dir string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, lockName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w '%s'", ErrLocked, dir)
+		}
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,117 @@
+package file_database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+)
+
+type schemaSnapshot struct {
+	Version int `json:"version"`
+}
+
+// schemaTable persists the schema version of the fake database like the
+// tables, so the version is committed together with the migrated data.
+type schemaTable struct {
+	db  *fake_database.FakeDatabase
+	wal *wal
+}
+
+func (T *schemaTable) name() string {
+	return fake_database.SchemaTableName
+}
+
+func (T *schemaTable) open(dir string, sync bool, commits *commitLog) error {
+	var version int
+
+	data, err := os.ReadFile(filepath.Join(dir, T.name()+snapshotSuffix))
+	if err == nil {
+		var snapshot schemaSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("Can't load '%s' snapshot: %w", T.name(), err)
+		}
+		version = snapshot.Version
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	T.wal, err = openWal(filepath.Join(dir, T.name()+walSuffix), sync)
+	if err != nil {
+		return err
+	}
+
+	err = T.wal.replay(func(entry walEntry) error {
+		if entry.Tx != 0 {
+			commits.seen(entry.Tx)
+			if !commits.committed[entry.Tx] {
+				return nil
+			}
+		}
+		for _, change := range entry.Changes {
+			if change.Op != fake_database.ChangeSchema.String() {
+				return ErrCorruptedEntry
+			}
+			if err := json.Unmarshal(change.Record, &version); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(offset int64, err error) {
+		log.Printf("Table '%s': WAL is corrupted at offset %d (%s), tail is dropped", T.name(), offset, err)
+	})
+	if err != nil {
+		T.wal.close()
+		T.wal = nil
+		return fmt.Errorf("Can't replay '%s' WAL: %w", T.name(), err)
+	}
+
+	T.db.LoadSchemaVersion(version)
+	return nil
+}
+
+func (T *schemaTable) write(changes []fake_database.Change, tx uint64) error {
+	if T.wal == nil {
+		return ErrNotOpen
+	}
+
+	entry := walEntry{Tx: tx}
+	for _, change := range changes {
+		data, err := json.Marshal(change.Record)
+		if err != nil {
+			return err
+		}
+		entry.Changes = append(entry.Changes, walChange{Op: change.Kind.String(), Record: data})
+	}
+
+	return T.wal.append(entry)
+}
+
+func (T *schemaTable) snapshot(dir string, sync bool) error {
+	if T.wal == nil {
+		return ErrNotOpen
+	}
+
+	data, err := json.Marshal(schemaSnapshot{Version: T.db.SchemaVersion()})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, T.name()+snapshotSuffix), data, sync); err != nil {
+		return err
+	}
+
+	return T.wal.truncate()
+}
+
+func (T *schemaTable) close() error {
+	if T.wal == nil {
+		return nil
+	}
+	err := T.wal.close()
+	T.wal = nil
+	return err
+}
@@ -0,0 +1,175 @@
+package file_database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+var (
+	ErrCorruptedEntry = errors.New("Corrupted WAL entry!")
+)
+
+// walChange is a persisted form of the fake_database.Change.
+type walChange struct {
+	Op     string          `json:"op"`
+	Id     models.IdData   `json:"id"`
+	Record json.RawMessage `json:"record,omitempty"`
+}
+
+// walEntry is a single line of the WAL file. All changes of the entry are
+// applied or dropped together. Entries of the transaction spanning several
+// tables have non zero Tx and are applied only if the commit log has the
+// entry with the same Tx.
+type walEntry struct {
+	Tx      uint64      `json:"tx,omitempty"`
+	Changes []walChange `json:"changes,omitempty"`
+}
+
+// wal is an append-only log of the table changes. Every entry is written as
+// a single line prefixed with CRC32 of the line content:
+//
+//	<crc32 hex> <json>\n
+type wal struct {
+	file walFile
+	sync bool
+}
+
+// walFile is the file of the log, *os.File.
+type walFile interface {
+	io.ReadWriteSeeker
+	io.StringWriter
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+func openWal(path string, sync bool) (*wal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{file: file, sync: sync}, nil
+}
+
+func (w *wal) append(entry walEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	offset, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)
+	_, err = w.file.WriteString(line)
+	if err == nil && w.sync {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		// Torn line would stop the replay before the entries appended
+		// after it
+		return errors.Join(err, w.cut(offset))
+	}
+	return nil
+}
+
+// cut drops the log after offset, the next entry is written there.
+func (w *wal) cut(offset int64) error {
+	if err := w.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := w.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// replay reads all valid entries from the beginning of the log. Torn or
+// corrupted tail (after a crash) is reported by the callback and cut off,
+// so new entries are appended after the last valid one.
+func (w *wal) replay(callback func(walEntry) error, onCorrupted func(offset int64, err error)) error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(w.file)
+
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		entry, decodeErr := decodeWalLine(line)
+		if decodeErr != nil {
+			onCorrupted(offset, decodeErr)
+			if err := w.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+
+		if err := callback(entry); err != nil {
+			return err
+		}
+
+		offset += int64(len(line))
+	}
+
+	_, err := w.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+func decodeWalLine(line []byte) (walEntry, error) {
+	var entry walEntry
+
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		return entry, ErrCorruptedEntry
+	}
+
+	checksum, data, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return entry, ErrCorruptedEntry
+	}
+
+	expected, err := strconv.ParseUint(string(checksum), 16, 32)
+	if err != nil || uint32(expected) != crc32.ChecksumIEEE(data) {
+		return entry, ErrCorruptedEntry
+	}
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+func (w *wal) truncate() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if w.sync {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
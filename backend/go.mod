@@ -1,17 +1,42 @@
 module github.com/diakovliev/mesap/backend
 
-go 1.18
+go 1.23
+
+require (
+	github.com/diakovliev/mesap/backend/backup v0.0.1
+	github.com/diakovliev/mesap/backend/cached_database v0.0.1
+	github.com/diakovliev/mesap/backend/controllers v0.0.1
+	github.com/diakovliev/mesap/backend/encrypted_database v0.0.1
+	github.com/diakovliev/mesap/backend/fake_database v0.0.1
+	github.com/diakovliev/mesap/backend/file_database v0.0.1
+	github.com/diakovliev/mesap/backend/ifaces v0.0.1
+	github.com/diakovliev/mesap/backend/instrumented_database v0.0.1
+	github.com/diakovliev/mesap/backend/migrations v0.0.1
+	github.com/diakovliev/mesap/backend/sql_database v0.0.1
+	github.com/diakovliev/mesap/backend/tenants v0.0.1
+	github.com/go-chi/chi/v5 v5.0.7
+	github.com/lib/pq v1.10.9
+	modernc.org/sqlite v1.29.10
+)
 
 require (
-	github.com/diakovliev/mesap/backend/controllers v0.0.1 // indirect
-	github.com/diakovliev/mesap/backend/fake_database v0.0.1 // indirect
-	github.com/diakovliev/mesap/backend/ifaces v0.0.1 // indirect
 	github.com/diakovliev/mesap/backend/models v0.0.1 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/go-chi/chi v1.5.4 // indirect
-	github.com/go-chi/chi/v5 v5.0.7 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
 	github.com/kong/go-srp v0.0.0-20191210190804-cde1efa3c083 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/crypto v0.0.0-20200109152110-61a87790db17 // indirect
-	golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
+	golang.org/x/sys v0.19.0 // indirect
+	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
+	modernc.org/libc v1.49.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+	modernc.org/strutil v1.2.0 // indirect
+	modernc.org/token v1.1.0 // indirect
 )
 
 replace github.com/diakovliev/mesap/backend/models v0.0.1 => ./models
@@ -20,4 +45,22 @@ replace github.com/diakovliev/mesap/backend/ifaces v0.0.1 => ./ifaces
 
 replace github.com/diakovliev/mesap/backend/fake_database v0.0.1 => ./fake_database
 
+replace github.com/diakovliev/mesap/backend/file_database v0.0.1 => ./file_database
+
+replace github.com/diakovliev/mesap/backend/sql_database v0.0.1 => ./sql_database
+
 replace github.com/diakovliev/mesap/backend/controllers v0.0.1 => ./controllers
+
+replace github.com/diakovliev/mesap/backend/dbtest v0.0.1 => ./dbtest
+
+replace github.com/diakovliev/mesap/backend/migrations v0.0.1 => ./migrations
+
+replace github.com/diakovliev/mesap/backend/backup v0.0.1 => ./backup
+
+replace github.com/diakovliev/mesap/backend/encrypted_database v0.0.1 => ./encrypted_database
+
+replace github.com/diakovliev/mesap/backend/cached_database v0.0.1 => ./cached_database
+
+replace github.com/diakovliev/mesap/backend/instrumented_database v0.0.1 => ./instrumented_database
+
+replace github.com/diakovliev/mesap/backend/tenants v0.0.1 => ./tenants
@@ -0,0 +1,58 @@
+package ifaces
+
+import (
+	"errors"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Result is the outcome of the record of the bulk operation, see
+// Table.InsertMany. Err is the record error, see IsRecordError; the
+// record is skipped if it is set.
+type Result struct {
+	Id  models.IdData
+	Err error
+}
+
+// IsRecordError reports whether err is caused by the record itself, not
+// by the database or ctx. The bulk operations skip the records failed by
+// such errors.
+func IsRecordError(err error) bool {
+	return errors.Is(err, ErrWrongRecord) || errors.Is(err, ErrNoSuchRecord) ||
+		errors.Is(err, ErrConflict) || errors.Is(err, ErrUniqueViolation) ||
+		errors.Is(err, ErrNoOwner)
+}
+
+// Bulk runs op for the items in order and returns their results. The
+// record errors are kept in the results, any other error stops it. op
+// must not change the table if it fails. Used by the backends running
+// it under the single lock or transaction.
+func Bulk[T any](items []T, op func(item T) (models.IdData, error)) ([]Result, error) {
+	results := make([]Result, 0, len(items))
+	for _, item := range items {
+		id, err := op(item)
+		if err != nil && !IsRecordError(err) {
+			return nil, err
+		}
+		results = append(results, Result{Id: id, Err: err})
+	}
+	return results, nil
+}
+
+// IdOf returns id of the record.
+func IdOf[M Models](record *M) models.IdData {
+	var i interface{} = record
+	if id, ok := i.(Id); ok {
+		return id.GetId()
+	}
+	return models.BAD_ID
+}
+
+// Ids returns ids of the records.
+func Ids[M Models](records []M) []models.IdData {
+	ids := make([]models.IdData, 0, len(records))
+	for i := range records {
+		ids = append(ids, IdOf(&records[i]))
+	}
+	return ids
+}
@@ -1,7 +1,9 @@
 package ifaces
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/diakovliev/mesap/backend/models"
 )
@@ -12,29 +14,154 @@ type Id interface {
 }
 
 type Models interface {
-	models.User | models.People | models.Role
+	models.User | models.People | models.Role |
+		models.Phone | models.Address | models.Email | models.BankAccount
 }
 
 type Table[M Models] interface {
 	Get(models.IdData) (M, error)
+	// Find and Each call back with the records ordered by id. The records
+	// are the snapshot of the table taken before the first call and no
+	// locks are held while calling back, so the callback may access the
+	// database; changes it makes are not seen by the iteration. Each
+	// returns ErrEmptyTable if there are no records, see also All.
 	Find(func(record M) bool) (M, error)
 	Each(func(record M) bool) error
+	// Insert allocates the id and assigns models.FIRST_VERSION to the
+	// record; the id and the version of the record are ignored.
 	Insert(record M) (models.IdData, error)
+	// Update replaces the stored record of the same version, the stored
+	// record gets the next version. If the versions differ, Update fails
+	// with ErrConflict.
 	Update(record M) error
+	// Delete is soft: the deleted record is kept in the history and may
+	// be restored.
 	Delete(models.IdData) error
+	// Restore undoes Delete of the record; the restored record gets the
+	// next version. Returns ErrNoSuchRecord if the record is not deleted.
+	Restore(models.IdData) error
+	// Import inserts the record keeping its id and version, e.g. restoring
+	// backups. Returns ErrConflict if the id is taken by the live or
+	// deleted record. The ids allocated by Insert follow the imported ones.
+	Import(record M) error
+	// Replace stores the record as it is in place of the stored record of
+	// the same version, e.g. re-wrapping the keys of the encrypted
+	// records: the record keeps its version, the history is not changed
+	// and the watchers are not notified. Returns ErrNoSuchRecord if there
+	// is no live record with the id, ErrConflict if the versions differ.
+	Replace(record M) error
+
+	// History returns revisions of the record ordered by version. Returns
+	// ErrNoSuchRecord if there is no record with the id, live or deleted.
+	History(models.IdData) ([]Revision[M], error)
+	// At returns state of the record at the time, see StateAt.
+	At(id models.IdData, at time.Time) (M, error)
+	// Revisions calls back with the revisions of all records, live and
+	// deleted, ordered by id and version, e.g. making backups. Revisions
+	// are loaded before the first call, as Each does.
+	Revisions(callback func(revision Revision[M]) bool) error
+	// ImportHistory stores the revisions as they are, e.g. restoring
+	// backups; the revision replaces the stored one of the same id and
+	// version. The records are not changed and the watchers are not
+	// notified. Ids of the revisions are taken, see Import.
+	ImportHistory(revisions []Revision[M]) error
+
+	// Watch subscribes to the changes of the table made after the event
+	// with sequence number after, or after the call if it is 0. Returns
+	// ErrWatchExpired if the events after the position are not kept any
+	// more; the client must reload the records and watch from 0. The
+	// watch is finished when ctx is done.
+	Watch(ctx context.Context, after uint64) (Watcher[M], error)
+
+	// Lookup returns records having the key in the index, ordered by id.
+	Lookup(index string, key string) ([]M, error)
+	// Query returns page of the records, see Query. Errors of the query
+	// match ErrBadQuery or ErrBadCursor.
+	Query(query *Query) (Page[M], error)
+
+	// InsertMany inserts the records as Insert does one by one, under
+	// the single lock or transaction. Results are in the order of the
+	// records; the record failed by the record error (see IsRecordError)
+	// is skipped with the error in its result, the others are inserted.
+	// Any other error fails the whole operation.
+	InsertMany(records []M) ([]Result, error)
+	// UpdateMany updates the records as Update does, see InsertMany.
+	UpdateMany(records []M) ([]Result, error)
+	// DeleteWhere deletes the records the query selects as Query does,
+	// so the limit bounds the number of the deleted records. Results are
+	// in the order of the query, see InsertMany.
+	DeleteWhere(query *Query) ([]Result, error)
+
+	// Context variants of the operations. The operation returns ctx error
+	// if ctx is done before it is finished; changes of such operation are
+	// not applied. Each and Find stop calling back when ctx is done.
+	// Revisions of the changes get the actor of ctx, see WithActor.
+	GetContext(ctx context.Context, id models.IdData) (M, error)
+	FindContext(ctx context.Context, callback func(record M) bool) (M, error)
+	EachContext(ctx context.Context, callback func(record M) bool) error
+	InsertContext(ctx context.Context, record M) (models.IdData, error)
+	UpdateContext(ctx context.Context, record M) error
+	DeleteContext(ctx context.Context, id models.IdData) error
+	RestoreContext(ctx context.Context, id models.IdData) error
+	ImportContext(ctx context.Context, record M) error
+	ReplaceContext(ctx context.Context, record M) error
+	HistoryContext(ctx context.Context, id models.IdData) ([]Revision[M], error)
+	AtContext(ctx context.Context, id models.IdData, at time.Time) (M, error)
+	RevisionsContext(ctx context.Context, callback func(revision Revision[M]) bool) error
+	ImportHistoryContext(ctx context.Context, revisions []Revision[M]) error
+	LookupContext(ctx context.Context, index string, key string) ([]M, error)
+	QueryContext(ctx context.Context, query *Query) (Page[M], error)
+	InsertManyContext(ctx context.Context, records []M) ([]Result, error)
+	UpdateManyContext(ctx context.Context, records []M) ([]Result, error)
+	DeleteWhereContext(ctx context.Context, query *Query) ([]Result, error)
+}
+
+// Tx gives access to the tables inside of the transaction. Tables of the
+// Tx must not be used after the transaction is finished.
+type Tx interface {
+	Users() (Table[models.User], error)
+	Peoples() (Table[models.People], error)
+	Roles() (Table[models.Role], error)
+	Phones() (Table[models.Phone], error)
+	Addresses() (Table[models.Address], error)
+	Emails() (Table[models.Email], error)
+	BankAccounts() (Table[models.BankAccount], error)
+
+	// SchemaVersion returns version of the data schema, 0 if the database
+	// has never been migrated.
+	SchemaVersion(ctx context.Context) (int, error)
+	// SetSchemaVersion records the version of the data schema, it is
+	// committed together with the migrated data.
+	SetSchemaVersion(ctx context.Context, version int) error
 }
 
 type Database interface {
 	Open() error
+	OpenContext(ctx context.Context) error
 	Close()
 
 	Users() (Table[models.User], error)
 	Peoples() (Table[models.People], error)
 	Roles() (Table[models.Role], error)
+
+	// Tables of the records owned by the peoples, see Owned. Deleting the
+	// people deletes the records it owns.
+	Phones() (Table[models.Phone], error)
+	Addresses() (Table[models.Address], error)
+	Emails() (Table[models.Email], error)
+	BankAccounts() (Table[models.BankAccount], error)
+
+	// Tx runs callback in the transaction. All changes made through the tx
+	// are committed together if callback returns nil and rolled back if it
+	// returns an error, panics or ctx is done. The callback must access
+	// the database only through the tx, tables of the Database may block
+	// until the transaction is finished.
+	Tx(ctx context.Context, callback func(tx Tx) error) error
 }
 
 var (
 	ErrWrongRecord  = errors.New("Wrong record!")
 	ErrEmptyTable   = errors.New("Table is empty!")
 	ErrNoSuchRecord = errors.New("No such record!")
+	ErrTxDone       = errors.New("Transaction is already finished!")
 )
@@ -1,3 +1,7 @@
 module github.com/diakovliev/mesap/backend/ifaces
 
-go 1.18
+go 1.23
+
+require github.com/diakovliev/mesap/backend/models v0.0.1
+
+replace github.com/diakovliev/mesap/backend/models v0.0.1 => ../models
@@ -0,0 +1,168 @@
+package ifaces
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+type RevisionKind string
+
+const (
+	RevisionInsert  RevisionKind = "insert"
+	RevisionUpdate  RevisionKind = "update"
+	RevisionDelete  RevisionKind = "delete"
+	RevisionRestore RevisionKind = "restore"
+)
+
+// Revision is an immutable record of a single change of the record. Every
+// Insert, Update, Delete and Restore writes a revision, so the record can
+// be restored after Delete and its state is known at any time since the
+// first revision.
+type Revision[M Models] struct {
+	Id      models.IdData      `json:"id"`
+	Version models.VersionData `json:"version"`
+	Kind    RevisionKind       `json:"kind"`
+	// Actor is the one who made the change, see WithActor.
+	Actor string    `json:"actor,omitempty"`
+	Time  time.Time `json:"time"`
+	// Diff lists names of the fields changed by the revision. Fields of
+	// the inserted record are compared with zero values.
+	Diff []string `json:"diff,omitempty"`
+	// Record is the state of the record after the change. The deleted
+	// record is the state to restore.
+	Record M `json:"record"`
+}
+
+type actorKey struct{}
+
+// WithActor returns ctx of the changes made by actor. The actor is saved
+// in the revisions of the changes.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns actor of ctx, empty if ctx has no actor.
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// RevisionTime returns time of the revision made now. Time is truncated
+// to microseconds, as some databases do not keep more.
+func RevisionTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// NewRevision returns revision of the change of previous state of the
+// record (nil for the new record) to record. Id and version of the
+// revision are the ones of the record.
+func NewRevision[M Models](ctx context.Context, kind RevisionKind, previous *M, record *M) (Revision[M], error) {
+	var i interface{} = record
+
+	id, ok := i.(Id)
+	if !ok {
+		return Revision[M]{}, ErrWrongRecord
+	}
+	version, ok := i.(Version)
+	if !ok {
+		return Revision[M]{}, ErrWrongRecord
+	}
+
+	return Revision[M]{
+		Id:      id.GetId(),
+		Version: version.GetVersion(),
+		Kind:    kind,
+		Actor:   ActorFrom(ctx),
+		Time:    RevisionTime(),
+		Diff:    Diff(previous, record),
+		Record:  *record,
+	}, nil
+}
+
+// ImportedRevision returns ErrWrongRecord if the revision can't be
+// imported to the history, see Table.ImportHistory: the record of the
+// revision must have the id and the version of the revision.
+func ImportedRevision[M Models](revision *Revision[M]) error {
+	var i interface{} = &revision.Record
+
+	id, ok := i.(Id)
+	if !ok || revision.Id < models.FIRST_ID || id.GetId() != revision.Id {
+		return ErrWrongRecord
+	}
+	version, ok := i.(Version)
+	if !ok || revision.Version < models.FIRST_VERSION || version.GetVersion() != revision.Version {
+		return ErrWrongRecord
+	}
+	return nil
+}
+
+// PutRevision returns revisions ordered by version with the revision,
+// which replaces the one of the same version.
+func PutRevision[M Models](revisions []Revision[M], revision Revision[M]) []Revision[M] {
+	i := len(revisions)
+	for i > 0 && revisions[i-1].Version >= revision.Version {
+		i--
+	}
+	if i < len(revisions) && revisions[i].Version == revision.Version {
+		revisions[i] = revision
+		return revisions
+	}
+	return slices.Insert(revisions, i, revision)
+}
+
+// Diff returns names of the fields of record which differ from previous
+// (zero value if nil), the fields of the embedded structures included. Id
+// and version are not compared.
+func Diff[M Models](previous *M, record *M) []string {
+	var zero M
+	if previous == nil {
+		previous = &zero
+	}
+
+	p := reflect.ValueOf(previous).Elem()
+	r := reflect.ValueOf(record).Elem()
+
+	var ret []string
+	for _, field := range reflect.VisibleFields(r.Type()) {
+		if !field.IsExported() || field.Anonymous || field.Name == "IdData" || field.Name == "VersionData" {
+			continue
+		}
+		if !reflect.DeepEqual(p.FieldByIndex(field.Index).Interface(), r.FieldByIndex(field.Index).Interface()) {
+			ret = append(ret, field.Name)
+		}
+	}
+	return ret
+}
+
+// StateAt returns state of the record at time at from its revisions
+// ordered by version. Returns ErrNoSuchRecord if the record did not exist
+// or was deleted at that time.
+func StateAt[M Models](revisions []Revision[M], at time.Time) (M, error) {
+	var res M
+
+	found := false
+	for _, revision := range revisions {
+		if revision.Time.After(at) {
+			break
+		}
+		res, found = revision.Record, revision.Kind != RevisionDelete
+	}
+	if !found {
+		var zero M
+		return zero, ErrNoSuchRecord
+	}
+	return res, nil
+}
+
+// Deleted returns the state to restore if the last revision is delete.
+func Deleted[M Models](revisions []Revision[M]) (M, bool) {
+	var res M
+	if len(revisions) == 0 || revisions[len(revisions)-1].Kind != RevisionDelete {
+		return res, false
+	}
+	return revisions[len(revisions)-1].Record, true
+}
@@ -0,0 +1,166 @@
+package ifaces
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// IdStrategy allocates ids of the new records of the table. Tables without
+// the strategy allocate sequential ids.
+type IdStrategy interface {
+	// NextId returns id of the new record. next is the id following the
+	// ids allocated by the table before, i.e. the sequential one. The id
+	// must not be negative.
+	NextId(next models.IdData) models.IdData
+}
+
+// Names of the id strategies, see NewIdStrategy.
+const (
+	IdSequential  = "sequential"
+	IdRandom      = "random"
+	IdTimeOrdered = "time"
+)
+
+var (
+	ErrUnknownIdStrategy = errors.New("Unknown id strategy!")
+	ErrNoSuchTable       = errors.New("No such table!")
+)
+
+// IdStrategies are the strategies of the tables by table name.
+type IdStrategies map[string]IdStrategy
+
+type sequentialIds struct{}
+
+func (sequentialIds) NextId(next models.IdData) models.IdData {
+	return next
+}
+
+// Sequential allocates ids one by one, the default strategy.
+var Sequential IdStrategy = sequentialIds{}
+
+// randomIdBits are the bits of the random ids. The ids are below 2^62, so
+// the following sequential id does not overflow.
+const randomIdBits = 62
+
+func randomBits(bits uint) models.IdData {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Errorf("Can't read random bytes: %w", err))
+	}
+	return models.IdData(binary.BigEndian.Uint64(b[:]) >> (64 - bits))
+}
+
+type randomIds struct{}
+
+func (randomIds) NextId(models.IdData) models.IdData {
+	for {
+		if id := randomBits(randomIdBits); id > models.FIRST_ID {
+			return id
+		}
+	}
+}
+
+// RandomIds allocates random ids, which reveal neither the number of the
+// records nor the order of insertion. Table retries the id of the existing
+// or deleted record.
+func RandomIds() IdStrategy {
+	return randomIds{}
+}
+
+// Time ordered ids are milliseconds since epoch followed by the sequence
+// starting at the random number in every millisecond. The ids overflow in
+// the year 2109.
+const (
+	sequenceBits = 21
+	// Random start leaves the room for the sequence in the millisecond
+	sequenceStartBits = sequenceBits - 1
+)
+
+type timeOrderedIds struct {
+	sync.Mutex
+	last models.IdData
+	now  func() time.Time
+}
+
+func (s *timeOrderedIds) NextId(next models.IdData) models.IdData {
+	s.Lock()
+	defer s.Unlock()
+
+	id := models.IdData(s.now().UnixMilli())<<sequenceBits | randomBits(sequenceStartBits)
+	// Ids are increasing even if the clock goes back
+	if id <= s.last {
+		id = s.last + 1
+	}
+	if id < next {
+		id = next
+	}
+	s.last = id
+	return id
+}
+
+// TimeOrderedIds allocates ids ordered by time of the insertion like ULID
+// or UUIDv7 but in 63 bits, so the ids of the tables of several instances
+// do not collide and can be merged.
+func TimeOrderedIds() IdStrategy {
+	return &timeOrderedIds{now: time.Now}
+}
+
+// NewIdStrategy returns strategy by name.
+func NewIdStrategy(name string) (IdStrategy, error) {
+	switch name {
+	case IdSequential:
+		return Sequential, nil
+	case IdRandom:
+		return RandomIds(), nil
+	case IdTimeOrdered:
+		return TimeOrderedIds(), nil
+	}
+	return nil, fmt.Errorf("%w '%s'", ErrUnknownIdStrategy, name)
+}
+
+// ParseIdStrategies parses comma separated list of table=strategy pairs,
+// e.g. "peoples=random,users=time".
+func ParseIdStrategies(spec string) (IdStrategies, error) {
+	ret := make(IdStrategies)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		table, name, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w '%s', expected table=strategy", ErrUnknownIdStrategy, item)
+		}
+		strategy, err := NewIdStrategy(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		ret[strings.TrimSpace(table)] = strategy
+	}
+	return ret, nil
+}
+
+// AllocateId returns id of the new record allocated by strategy (nil for
+// Sequential). Ids taken by used, e.g. of the existing or deleted records,
+// are retried.
+func AllocateId(strategy IdStrategy, next models.IdData, used func(id models.IdData) bool) models.IdData {
+	if strategy == nil {
+		strategy = Sequential
+	}
+	for {
+		id := strategy.NextId(next)
+		if !used(id) {
+			return id
+		}
+		if id >= next {
+			next = id + 1
+		}
+	}
+}
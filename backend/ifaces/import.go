@@ -0,0 +1,42 @@
+package ifaces
+
+import (
+	"context"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Imported returns id of the imported record, see Table.Import. Record
+// without the version, e.g. stored before the versions, gets
+// models.FIRST_VERSION.
+func Imported[M Models](record *M) (models.IdData, error) {
+	var i interface{} = record
+
+	id, ok := i.(Id)
+	if !ok || id.GetId() < models.FIRST_ID {
+		return models.BAD_ID, ErrWrongRecord
+	}
+	version, ok := i.(Version)
+	if !ok {
+		return models.BAD_ID, ErrWrongRecord
+	}
+	if version.GetVersion() < models.FIRST_VERSION {
+		version.SetVersion(models.FIRST_VERSION)
+	}
+	return id.GetId(), nil
+}
+
+type snapshotKey struct{}
+
+// WithSnapshot returns ctx of the transaction which must see all tables at
+// the point in time it is started, e.g. making backups. Database.Tx picks
+// the isolation level by it.
+func WithSnapshot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, true)
+}
+
+// IsSnapshot reports whether ctx is made by WithSnapshot.
+func IsSnapshot(ctx context.Context) bool {
+	snapshot, _ := ctx.Value(snapshotKey{}).(bool)
+	return snapshot
+}
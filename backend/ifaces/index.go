@@ -0,0 +1,200 @@
+package ifaces
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IndexTag declares secondary index of the model table on the field:
+//
+//	Login string `index:"login,unique"`
+//
+// Field may belong to the elements of the slice field, then every element
+// adds the key to the record. The slice field tagged "-" is not indexed,
+// e.g. models.LegacyContacts.
+const IndexTag = "index"
+
+var (
+	ErrNoSuchIndex     = errors.New("No such index!")
+	ErrUniqueViolation = errors.New("Unique constraint violation!")
+)
+
+// UniqueViolationError is returned by Insert and Update if other record of
+// the table has the same key in the unique index. It matches
+// ErrUniqueViolation with errors.Is.
+type UniqueViolationError struct {
+	Index string
+	Key   string
+}
+
+func (e *UniqueViolationError) Error() string {
+	if e.Index == "" {
+		return ErrUniqueViolation.Error()
+	}
+	return fmt.Sprintf("%s Index: '%s', key: '%s'", ErrUniqueViolation, e.Index, e.Key)
+}
+
+func (e *UniqueViolationError) Unwrap() error {
+	return ErrUniqueViolation
+}
+
+// Index is a secondary index declared by IndexTag.
+type Index struct {
+	Name   string
+	Unique bool
+	// Fields is the path to the indexed field: single field name, or the
+	// name of the slice field and the name of the element field.
+	Fields []string
+
+	index   []int
+	element []int
+}
+
+// Keys returns index keys of the record, sorted and without duplicates.
+// Nil pointers have no keys.
+func (i *Index) Keys(record any) []string {
+	value := reflect.Indirect(reflect.ValueOf(record)).FieldByIndex(i.index)
+
+	if i.element == nil {
+		if key, ok := indexKey(value); ok {
+			return []string{key}
+		}
+		return nil
+	}
+
+	keys := make([]string, 0, value.Len())
+	for j := 0; j < value.Len(); j++ {
+		elem := reflect.Indirect(value.Index(j))
+		if !elem.IsValid() {
+			continue
+		}
+		if key, ok := indexKey(elem.FieldByIndex(i.element)); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	ret := keys[:0]
+	for j, key := range keys {
+		if j == 0 || key != keys[j-1] {
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+func indexKey(value reflect.Value) (string, bool) {
+	value = reflect.Indirect(value)
+	if !value.IsValid() {
+		return "", false
+	}
+	return fmt.Sprint(value.Interface()), true
+}
+
+var (
+	indexesMutex sync.Mutex
+	indexesCache = make(map[reflect.Type][]*Index)
+)
+
+// Indexes returns indexes declared on the model fields, ordered by name.
+// Panics on wrong declaration, as it is a programming error.
+func Indexes[M Models]() []*Index {
+	var record M
+	t := reflect.TypeOf(record)
+
+	indexesMutex.Lock()
+	defer indexesMutex.Unlock()
+
+	if ret, ok := indexesCache[t]; ok {
+		return ret
+	}
+
+	var ret []*Index
+	add := func(tag string, fields []string, index, element []int) {
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			panic(fmt.Errorf("Unnamed index on %s.%s", t.Name(), strings.Join(fields, ".")))
+		}
+		if Encrypted(t.FieldByIndex(index)) {
+			panic(fmt.Errorf("Index '%s' on encrypted field %s.%s", name, t.Name(), fields[0]))
+		}
+		for _, i := range ret {
+			if i.Name == name {
+				panic(fmt.Errorf("Duplicated index '%s' on %s", name, t.Name()))
+			}
+		}
+		ret = append(ret, &Index{
+			Name:    name,
+			Unique:  options == "unique",
+			Fields:  fields,
+			index:   index,
+			element: element,
+		})
+	}
+
+	for _, field := range reflect.VisibleFields(t) {
+		if tag, ok := field.Tag.Lookup(IndexTag); ok {
+			if tag != "-" {
+				add(tag, []string{field.Name}, field.Index, nil)
+			}
+			continue
+		}
+
+		if field.Type.Kind() != reflect.Slice {
+			continue
+		}
+		elem := field.Type.Elem()
+		if elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			continue
+		}
+		for _, f := range reflect.VisibleFields(elem) {
+			if tag, ok := f.Tag.Lookup(IndexTag); ok {
+				add(tag, []string{field.Name, f.Name}, field.Index, f.Index)
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	indexesCache[t] = ret
+	return ret
+}
+
+// IndexByName returns index of the model table.
+func IndexByName[M Models](name string) (*Index, error) {
+	for _, index := range Indexes[M]() {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrNoSuchIndex, name)
+}
+
+// GetBy returns the record by the key of the unique index.
+func GetBy[M Models](table Table[M], index string, key string) (M, error) {
+	return GetByContext(context.Background(), table, index, key)
+}
+
+func GetByContext[M Models](ctx context.Context, table Table[M], index string, key string) (M, error) {
+	var res M
+
+	records, err := table.LookupContext(ctx, index, key)
+	if err != nil {
+		return res, err
+	}
+	if len(records) == 0 {
+		return res, ErrNoSuchRecord
+	}
+
+	return records[0], nil
+}
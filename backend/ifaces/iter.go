@@ -0,0 +1,32 @@
+package ifaces
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+// All returns the iterator over the records of the table, see Table.Each:
+//
+//	for record, err := range ifaces.All(ctx, peoples) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The error stops the iteration, it is yielded with the zero record. The
+// empty table yields nothing.
+func All[M Models](ctx context.Context, table Table[M]) iter.Seq2[M, error] {
+	return func(yield func(M, error) bool) {
+		stopped := false
+		err := table.EachContext(ctx, func(record M) bool {
+			stopped = !yield(record, nil)
+			return !stopped
+		})
+		if err != nil && !stopped && !errors.Is(err, ErrEmptyTable) {
+			var zero M
+			yield(zero, err)
+		}
+	}
+}
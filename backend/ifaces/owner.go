@@ -0,0 +1,40 @@
+package ifaces
+
+import (
+	"context"
+	"errors"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// OwnerField is the name of the owner reference in the queries.
+const OwnerField = "Owner"
+
+// ErrNoOwner is returned by Insert, Update and Restore of the owned record
+// if its owner People record does not exist.
+var ErrNoOwner = errors.New("No such owner record!")
+
+// Owned is implemented by the records referencing the owner People record,
+// see models.Ownership.
+type Owned interface {
+	SetOwner(models.IdData)
+	GetOwner() models.IdData
+}
+
+// OwnedBy returns the records of the owner ordered by id.
+func OwnedBy[M Models](ctx context.Context, table Table[M], owner models.IdData) ([]M, error) {
+	var ret []M
+
+	query := NewQuery().Where(OwnerField, Eq, owner)
+	for {
+		page, err := table.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, page.Records...)
+		if page.Next == "" {
+			return ret, nil
+		}
+		query.After(page.Next)
+	}
+}
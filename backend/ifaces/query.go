@@ -0,0 +1,484 @@
+package ifaces
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadQuery  = errors.New("Bad query!")
+	ErrBadCursor = errors.New("Bad cursor!")
+)
+
+// IdField is the name of the record id in the queries.
+const IdField = "Id"
+
+type Operator string
+
+const (
+	Eq     Operator = "="
+	Ne     Operator = "!="
+	Lt     Operator = "<"
+	Le     Operator = "<="
+	Gt     Operator = ">"
+	Ge     Operator = ">="
+	Prefix Operator = "prefix"
+)
+
+type predicate struct {
+	field string
+	op    Operator
+	value any
+}
+
+type order struct {
+	field string
+	desc  bool
+}
+
+// Query selects the page of the table records:
+//
+//	page, err := peoples.Query(ifaces.NewQuery().
+//		Where("Surname", ifaces.Eq, "Doe").
+//		OrderBy("Name").
+//		Limit(20).
+//		After(cursor))
+//
+// Fields are the names of the scalar fields of the model. Records are
+// ordered by the sort fields and then by id, so the order is stable and
+// the cursor of the page stays valid while the table changes.
+type Query struct {
+	filters []predicate
+	sort    []order
+	limit   int
+	offset  int
+	cursor  string
+}
+
+// Page is the result of the query. Next is the cursor of the next page,
+// empty for the last page.
+type Page[M Models] struct {
+	Records []M
+	Next    string
+}
+
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// Where adds predicate, all predicates must match. Nil value is allowed
+// with Eq and Ne for the optional (pointer) fields only.
+func (q *Query) Where(field string, op Operator, value any) *Query {
+	q.filters = append(q.filters, predicate{field: field, op: op, value: value})
+	return q
+}
+
+func (q *Query) OrderBy(field string) *Query {
+	q.sort = append(q.sort, order{field: field})
+	return q
+}
+
+func (q *Query) OrderByDesc(field string) *Query {
+	q.sort = append(q.sort, order{field: field, desc: true})
+	return q
+}
+
+// Limit sets maximal number of the records in the page, 0 - no limit.
+func (q *Query) Limit(limit int) *Query {
+	q.limit = limit
+	return q
+}
+
+// Offset skips records, after the cursor if any.
+func (q *Query) Offset(offset int) *Query {
+	q.offset = offset
+	return q
+}
+
+// After continues the query from the Page.Next cursor.
+func (q *Query) After(cursor string) *Query {
+	q.cursor = cursor
+	return q
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// QueryField is the model field available in the queries.
+type QueryField struct {
+	Name  string
+	Index []int
+	// Type of the value, without pointer
+	Type     reflect.Type
+	Nullable bool
+}
+
+// Value returns field value of the record, nil for nil pointer.
+func (f *QueryField) Value(record reflect.Value) any {
+	value := reflect.Indirect(record.FieldByIndex(f.Index))
+	if !value.IsValid() {
+		return nil
+	}
+	return value.Interface()
+}
+
+type PreparedPredicate struct {
+	Field *QueryField
+	Op    Operator
+	// Value of the Field.Type or nil
+	Value any
+}
+
+type PreparedOrder struct {
+	Field *QueryField
+	Desc  bool
+}
+
+// PreparedQuery is the validated Query used by the backends. Sort always
+// ends with id.
+type PreparedQuery struct {
+	Filters []PreparedPredicate
+	Sort    []PreparedOrder
+	Limit   int
+	Offset  int
+	// Sort values of the last record of the previous page, nil if the
+	// query has no cursor
+	After []any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// QueryFields returns fields of the model available in the queries.
+func QueryFields[M Models]() map[string]*QueryField {
+	var record M
+	ret := make(map[string]*QueryField)
+
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(record)) {
+		if field.Name == "IdData" {
+			ret[IdField] = &QueryField{Name: IdField, Index: field.Index, Type: field.Type}
+			continue
+		}
+		if !field.IsExported() || field.Anonymous || Encrypted(field) {
+			continue
+		}
+
+		t := field.Type
+		nullable := false
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+			nullable = true
+		}
+
+		if t != timeType && valueKind(t) == reflect.Invalid {
+			continue
+		}
+
+		ret[field.Name] = &QueryField{Name: field.Name, Index: field.Index, Type: t, Nullable: nullable}
+	}
+
+	return ret
+}
+
+// valueKind returns kind of the comparable values: String, Int, Uint,
+// Float64 or Bool.
+func valueKind(t reflect.Type) reflect.Kind {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool:
+		return t.Kind()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflect.Uint
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return reflect.Invalid
+}
+
+// convertValue converts predicate value to the field type.
+func convertValue(field *QueryField, value any) (any, error) {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if field.Type == timeType {
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.UTC(), nil
+		}
+	} else {
+		from, to := valueKind(v.Type()), valueKind(field.Type)
+		numeric := func(k reflect.Kind) bool {
+			return k == reflect.Int || k == reflect.Uint || k == reflect.Float64
+		}
+		if from == to || numeric(from) && numeric(to) {
+			return v.Convert(field.Type).Interface(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w Value %v is not compatible with the field '%s'", ErrBadQuery, value, field.Name)
+}
+
+// Prepare validates the query for the model table.
+func Prepare[M Models](q *Query) (*PreparedQuery, error) {
+	if q == nil {
+		q = NewQuery()
+	}
+	if q.limit < 0 || q.offset < 0 {
+		return nil, fmt.Errorf("%w Negative limit or offset", ErrBadQuery)
+	}
+
+	fields := QueryFields[M]()
+	field := func(name string) (*QueryField, error) {
+		if f, ok := fields[name]; ok {
+			return f, nil
+		}
+		return nil, fmt.Errorf("%w Unknown field '%s'", ErrBadQuery, name)
+	}
+
+	ret := &PreparedQuery{Limit: q.limit, Offset: q.offset}
+
+	for _, p := range q.filters {
+		f, err := field(p.field)
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.op {
+		case Eq, Ne, Lt, Le, Gt, Ge:
+		case Prefix:
+			if f.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("%w Prefix of the not string field '%s'", ErrBadQuery, f.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%w Unknown operator '%s'", ErrBadQuery, p.op)
+		}
+
+		value, err := convertValue(f, p.value)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil && (!f.Nullable || p.op != Eq && p.op != Ne) {
+			return nil, fmt.Errorf("%w Nil value of the field '%s'", ErrBadQuery, f.Name)
+		}
+
+		ret.Filters = append(ret.Filters, PreparedPredicate{Field: f, Op: p.op, Value: value})
+	}
+
+	for _, o := range q.sort {
+		f, err := field(o.field)
+		if err != nil {
+			return nil, err
+		}
+		if f.Nullable {
+			return nil, fmt.Errorf("%w Sort by optional field '%s'", ErrBadQuery, f.Name)
+		}
+		ret.Sort = append(ret.Sort, PreparedOrder{Field: f, Desc: o.desc})
+	}
+	ret.Sort = append(ret.Sort, PreparedOrder{Field: fields[IdField]})
+
+	if q.cursor != "" {
+		after, err := decodeCursor(q.cursor, ret.Sort)
+		if err != nil {
+			return nil, err
+		}
+		ret.After = after
+	}
+
+	return ret, nil
+}
+
+// Cursor returns cursor pointing after the record.
+func (q *PreparedQuery) Cursor(record reflect.Value) string {
+	values := make([]any, 0, len(q.Sort))
+	for _, o := range q.Sort {
+		values = append(values, o.Field.Value(record))
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string, sort []PreparedOrder) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) != len(sort) {
+		return nil, ErrBadCursor
+	}
+
+	ret := make([]any, 0, len(raw))
+	for i, o := range sort {
+		value := reflect.New(o.Field.Type)
+		if err := json.Unmarshal(raw[i], value.Interface()); err != nil {
+			return nil, ErrBadCursor
+		}
+		ret = append(ret, value.Elem().Interface())
+	}
+	return ret, nil
+}
+
+// CompareValues compares values of the same field type.
+func CompareValues(a, b any) int {
+	if ta, ok := a.(time.Time); ok {
+		tb := b.(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		}
+		return 0
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch valueKind(va.Type()) {
+	case reflect.String:
+		return strings.Compare(va.String(), vb.String())
+	case reflect.Int:
+		return compare(va.Int(), vb.Int())
+	case reflect.Uint:
+		return compare(va.Uint(), vb.Uint())
+	case reflect.Float64:
+		return compare(va.Float(), vb.Float())
+	case reflect.Bool:
+		return compare(boolInt(va.Bool()), boolInt(vb.Bool()))
+	}
+
+	panic(fmt.Errorf("Uncomparable value: %T", a))
+}
+
+func compare[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Match reports whether the record matches all filters.
+func (q *PreparedQuery) Match(record reflect.Value) bool {
+	for _, p := range q.Filters {
+		value := p.Field.Value(record)
+
+		if p.Value == nil {
+			// IS NULL or IS NOT NULL
+			if (value == nil) != (p.Op == Eq) {
+				return false
+			}
+			continue
+		}
+		if value == nil {
+			return false
+		}
+
+		var ok bool
+		switch c := CompareValues(value, p.Value); p.Op {
+		case Eq:
+			ok = c == 0
+		case Ne:
+			ok = c != 0
+		case Lt:
+			ok = c < 0
+		case Le:
+			ok = c <= 0
+		case Gt:
+			ok = c > 0
+		case Ge:
+			ok = c >= 0
+		case Prefix:
+			ok = strings.HasPrefix(reflect.ValueOf(value).String(), reflect.ValueOf(p.Value).String())
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compareRecords compares sort values of the records.
+func (q *PreparedQuery) compareRecords(a, b []any) int {
+	for i, o := range q.Sort {
+		c := CompareValues(a[i], b[i])
+		if o.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Run executes the query over all records of the table. Used by the in
+// memory backends.
+func Run[M Models](q *PreparedQuery, records []M) Page[M] {
+	type entry struct {
+		record M
+		key    []any
+	}
+
+	entries := make([]entry, 0, len(records))
+	for _, record := range records {
+		rv := reflect.ValueOf(&record).Elem()
+		if !q.Match(rv) {
+			continue
+		}
+
+		key := make([]any, 0, len(q.Sort))
+		for _, o := range q.Sort {
+			key = append(key, o.Field.Value(rv))
+		}
+		if q.After != nil && q.compareRecords(key, q.After) <= 0 {
+			continue
+		}
+
+		entries = append(entries, entry{record: record, key: key})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return q.compareRecords(entries[i].key, entries[j].key) < 0
+	})
+
+	if q.Offset >= len(entries) {
+		return Page[M]{}
+	}
+	entries = entries[q.Offset:]
+
+	var page Page[M]
+	more := false
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+		more = true
+	}
+
+	page.Records = make([]M, 0, len(entries))
+	for _, e := range entries {
+		page.Records = append(page.Records, e.record)
+	}
+	if more {
+		page.Next = q.Cursor(reflect.ValueOf(&page.Records[len(page.Records)-1]).Elem())
+	}
+
+	return page
+}
@@ -0,0 +1,17 @@
+package ifaces
+
+import "reflect"
+
+// EncryptedTag marks the sensitive field of the model, the model must have
+// the models.Sealed field:
+//
+//	Birth time.Time `encrypted:"true"`
+//
+// The encrypted database keeps the field value in models.Sealed only, so
+// the field is not available in the queries and can not be indexed.
+const EncryptedTag = "encrypted"
+
+// Encrypted returns true if the field is tagged by EncryptedTag.
+func Encrypted(field reflect.StructField) bool {
+	return field.Tag.Get(EncryptedTag) == "true"
+}
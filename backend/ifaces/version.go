@@ -0,0 +1,59 @@
+package ifaces
+
+import (
+	"errors"
+
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+type Version interface {
+	SetVersion(models.VersionData)
+	GetVersion() models.VersionData
+}
+
+var ErrConflict = errors.New("Record version conflict!")
+
+// FirstVersion assigns models.FIRST_VERSION to the new record.
+func FirstVersion[M Models](record *M) error {
+	var i interface{} = record
+
+	version, ok := i.(Version)
+	if !ok {
+		return ErrWrongRecord
+	}
+	version.SetVersion(models.FIRST_VERSION)
+	return nil
+}
+
+// SameVersion checks that record has the version of the stored record,
+// see Table.Replace. Returns ErrConflict if the versions differ.
+func SameVersion[M Models](stored *M, record *M) error {
+	var s, r interface{} = stored, record
+
+	storedVersion, ok := s.(Version)
+	if !ok {
+		return ErrWrongRecord
+	}
+	version, ok := r.(Version)
+	if !ok {
+		return ErrWrongRecord
+	}
+
+	if version.GetVersion() != storedVersion.GetVersion() {
+		return ErrConflict
+	}
+	return nil
+}
+
+// NextVersion checks that record has the version of the stored record and
+// assigns the next version to the record. Returns ErrConflict if the
+// versions differ.
+func NextVersion[M Models](stored *M, record *M) error {
+	if err := SameVersion(stored, record); err != nil {
+		return err
+	}
+	var r interface{} = record
+	version := r.(Version)
+	version.SetVersion(version.GetVersion() + 1)
+	return nil
+}
@@ -0,0 +1,27 @@
+package ifaces
+
+import "errors"
+
+var (
+	ErrNotSupported  = errors.New("Operation is not supported!")
+	ErrWatchExpired  = errors.New("Watch position is expired!")
+	ErrWatchOverflow = errors.New("Watcher is too slow!")
+)
+
+// Event is a change of the table delivered by Watch: revision of the
+// record with the sequence number. Events of the table are ordered by Seq.
+type Event[M Models] struct {
+	Seq uint64 `json:"seq"`
+	Revision[M]
+}
+
+// Watcher delivers events of the table to the single client.
+type Watcher[M Models] interface {
+	// Events channel is closed when the watch is finished, see Err.
+	Events() <-chan Event[M]
+	// Err returns why the Events channel is closed: the error of the
+	// watch context or ErrWatchOverflow if the client does not keep up
+	// with the changes. Client resumes the watch from the last received
+	// event.
+	Err() error
+}
@@ -0,0 +1,157 @@
+// Package instrumented_database measures the calls of any database
+// backend: the numbers of the calls and the errors, and the latency
+// histograms by table and operation, see Metrics. Every call is traced by
+// the Tracer too.
+package instrumented_database
+
+import (
+	"context"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// database is the table name of the operations of the database itself.
+const database = "database"
+
+type InstrumentedDatabase struct {
+	db       ifaces.Database
+	tracer   Tracer
+	registry *registry
+}
+
+// NewDatabase returns db measuring the calls, the calls are traced by
+// tracer if it is not nil.
+func NewDatabase(db ifaces.Database, tracer Tracer) *InstrumentedDatabase {
+	if tracer == nil {
+		tracer = nopTracer{}
+	}
+	return &InstrumentedDatabase{db: db, tracer: tracer, registry: newRegistry()}
+}
+
+// Unwrap returns the measured database.
+func (d *InstrumentedDatabase) Unwrap() ifaces.Database {
+	return d.db
+}
+
+// Metrics returns the metrics of the operations called so far, ordered by
+// table and operation.
+func (d *InstrumentedDatabase) Metrics() []Metrics {
+	return d.registry.snapshot()
+}
+
+// start starts the call of the operation, the returned function finishes
+// it with the error of the call.
+func (d *InstrumentedDatabase) start(ctx context.Context, table string, operation string) (context.Context, func(err error)) {
+	ctx, span := d.tracer.Start(ctx, table, operation)
+	start := time.Now()
+	return ctx, func(err error) {
+		d.registry.record(table, operation, time.Since(start), err)
+		span.End(err)
+	}
+}
+
+// SetIdStrategies sets id strategies of the database, if it supports them.
+func (d *InstrumentedDatabase) SetIdStrategies(strategies ifaces.IdStrategies) error {
+	setter, ok := d.db.(interface {
+		SetIdStrategies(strategies ifaces.IdStrategies) error
+	})
+	if !ok {
+		return ifaces.ErrNotSupported
+	}
+	return setter.SetIdStrategies(strategies)
+}
+
+func (d *InstrumentedDatabase) Open() error {
+	return d.OpenContext(context.Background())
+}
+
+func (d *InstrumentedDatabase) OpenContext(ctx context.Context) (err error) {
+	ctx, done := d.start(ctx, database, "open")
+	defer func() { done(err) }()
+	return d.db.OpenContext(ctx)
+}
+
+func (d *InstrumentedDatabase) Close() {
+	d.db.Close()
+}
+
+func (d *InstrumentedDatabase) Users() (ifaces.Table[models.User], error) {
+	return wrap(d, "users", d.db.Users)
+}
+
+func (d *InstrumentedDatabase) Peoples() (ifaces.Table[models.People], error) {
+	return wrap(d, "peoples", d.db.Peoples)
+}
+
+func (d *InstrumentedDatabase) Roles() (ifaces.Table[models.Role], error) {
+	return wrap(d, "roles", d.db.Roles)
+}
+
+func (d *InstrumentedDatabase) Phones() (ifaces.Table[models.Phone], error) {
+	return wrap(d, "phones", d.db.Phones)
+}
+
+func (d *InstrumentedDatabase) Addresses() (ifaces.Table[models.Address], error) {
+	return wrap(d, "addresses", d.db.Addresses)
+}
+
+func (d *InstrumentedDatabase) Emails() (ifaces.Table[models.Email], error) {
+	return wrap(d, "emails", d.db.Emails)
+}
+
+func (d *InstrumentedDatabase) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return wrap(d, "bank_accounts", d.db.BankAccounts)
+}
+
+// Tx measures the whole transaction as the "tx" operation, and the calls
+// of the transaction tables as the operations of the tables.
+func (d *InstrumentedDatabase) Tx(ctx context.Context, callback func(tx ifaces.Tx) error) (err error) {
+	ctx, done := d.start(ctx, database, "tx")
+	defer func() { done(err) }()
+	return d.db.Tx(ctx, func(tx ifaces.Tx) error {
+		return callback(&instrumentedTx{tx: tx, d: d})
+	})
+}
+
+type instrumentedTx struct {
+	tx ifaces.Tx
+	d  *InstrumentedDatabase
+}
+
+func (t *instrumentedTx) Users() (ifaces.Table[models.User], error) {
+	return wrap(t.d, "users", t.tx.Users)
+}
+
+func (t *instrumentedTx) Peoples() (ifaces.Table[models.People], error) {
+	return wrap(t.d, "peoples", t.tx.Peoples)
+}
+
+func (t *instrumentedTx) Roles() (ifaces.Table[models.Role], error) {
+	return wrap(t.d, "roles", t.tx.Roles)
+}
+
+func (t *instrumentedTx) Phones() (ifaces.Table[models.Phone], error) {
+	return wrap(t.d, "phones", t.tx.Phones)
+}
+
+func (t *instrumentedTx) Addresses() (ifaces.Table[models.Address], error) {
+	return wrap(t.d, "addresses", t.tx.Addresses)
+}
+
+func (t *instrumentedTx) Emails() (ifaces.Table[models.Email], error) {
+	return wrap(t.d, "emails", t.tx.Emails)
+}
+
+func (t *instrumentedTx) BankAccounts() (ifaces.Table[models.BankAccount], error) {
+	return wrap(t.d, "bank_accounts", t.tx.BankAccounts)
+}
+
+func (t *instrumentedTx) SchemaVersion(ctx context.Context) (int, error) {
+	return t.tx.SchemaVersion(ctx)
+}
+
+func (t *instrumentedTx) SetSchemaVersion(ctx context.Context, version int) error {
+	return t.tx.SetSchemaVersion(ctx, version)
+}
@@ -0,0 +1,131 @@
+package instrumented_database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/dbtest"
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) ifaces.Database {
+		return NewDatabase(fake_database.NewDatabase(), nil)
+	})
+}
+
+type testSpan struct {
+	tracer *testTracer
+	name   string
+}
+
+func (s *testSpan) End(err error) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	if err != nil {
+		s.name += " " + err.Error()
+	}
+	s.tracer.spans = append(s.tracer.spans, s.name)
+}
+
+// testTracer records the names of the finished spans.
+type testTracer struct {
+	mutex sync.Mutex
+	spans []string
+}
+
+func (t *testTracer) Start(ctx context.Context, table string, operation string) (context.Context, Span) {
+	return ctx, &testSpan{tracer: t, name: table + "." + operation}
+}
+
+func findMetrics(t *testing.T, db *InstrumentedDatabase, table string, operation string) Metrics {
+	t.Helper()
+
+	for _, m := range db.Metrics() {
+		if m.Table == table && m.Operation == operation {
+			return m
+		}
+	}
+	t.Fatalf("No metrics of %s.%s", table, operation)
+	return Metrics{}
+}
+
+func TestMetrics(t *testing.T) {
+	tracer := &testTracer{}
+	db := NewDatabase(fake_database.NewDatabase(), tracer)
+	if err := db.Open(); err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	defer db.Close()
+
+	users, _ := db.Users()
+	id, err := users.Insert(models.User{Login: "alice", Salt: "salt", Verifier: "verifier"})
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	if _, err := users.Get(id); err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if _, err := users.Get(id + 1); !errors.Is(err, ifaces.ErrNoSuchRecord) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := users.Update(models.User{Login: "bob"}); err == nil {
+		t.Fatalf("Update of the missing record succeeded")
+	}
+	err = db.Tx(context.Background(), func(tx ifaces.Tx) error {
+		users, err := tx.Users()
+		if err != nil {
+			return err
+		}
+		_, err = users.GetContext(context.Background(), id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Tx error: %s", err)
+	}
+
+	get := findMetrics(t, db, "users", "get")
+	if get.Calls != 3 || get.Errors != 0 {
+		t.Fatalf("Unexpected get metrics: %+v", get)
+	}
+	var count uint64
+	for _, n := range get.Latency {
+		count += n
+	}
+	if count != get.Calls || len(get.Latency) != len(LatencyBuckets)+1 {
+		t.Fatalf("Unexpected latency: %+v", get.Latency)
+	}
+	if update := findMetrics(t, db, "users", "update"); update.Calls != 1 || update.Errors != 1 {
+		t.Fatalf("Unexpected update metrics: %+v", update)
+	}
+	if tx := findMetrics(t, db, database, "tx"); tx.Calls != 1 || tx.Errors != 0 {
+		t.Fatalf("Unexpected tx metrics: %+v", tx)
+	}
+
+	spans := strings.Join(tracer.spans, ",")
+	if !strings.HasPrefix(spans, "database.open,users.insert,users.get,users.get "+ifaces.ErrNoSuchRecord.Error()+",users.update ") ||
+		!strings.HasSuffix(spans, ",users.get,database.tx") {
+		t.Fatalf("Unexpected spans: %s", spans)
+	}
+
+	var b bytes.Buffer
+	if err := WriteMetrics(&b, db.Metrics()); err != nil {
+		t.Fatalf("Write error: %s", err)
+	}
+	for _, line := range []string{
+		`mesap_db_calls_total{table="users",operation="get"} 3`,
+		`mesap_db_errors_total{table="users",operation="update"} 1`,
+		`mesap_db_call_duration_seconds_bucket{table="users",operation="get",le="+Inf"} 3`,
+		`mesap_db_call_duration_seconds_count{table="users",operation="get"} 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("No '%s' in:\n%s", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package instrumented_database
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram buckets,
+// the calls longer than the last bound are counted by the extra bucket.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Metrics of the operation of the table.
+type Metrics struct {
+	Table     string `json:"table"`
+	Operation string `json:"operation"`
+	Calls     uint64 `json:"calls"`
+	// Errors are the failed calls; missing records are not errors
+	Errors uint64 `json:"errors"`
+	// Latency are the numbers of the calls by LatencyBuckets, not
+	// cumulative; the last one counts the calls longer than all bounds
+	Latency []uint64      `json:"latency"`
+	Total   time.Duration `json:"total"`
+}
+
+type operation struct {
+	table     string
+	operation string
+}
+
+type counters struct {
+	calls   atomic.Uint64
+	errors  atomic.Uint64
+	total   atomic.Int64
+	latency []atomic.Uint64
+}
+
+// registry keeps the counters of the operations.
+type registry struct {
+	mutex      sync.RWMutex
+	operations map[operation]*counters
+}
+
+func newRegistry() *registry {
+	return &registry{operations: make(map[operation]*counters)}
+}
+
+func (r *registry) counters(table string, op string) *counters {
+	key := operation{table: table, operation: op}
+
+	r.mutex.RLock()
+	c, ok := r.operations[key]
+	r.mutex.RUnlock()
+	if ok {
+		return c
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if c, ok = r.operations[key]; !ok {
+		c = &counters{latency: make([]atomic.Uint64, len(LatencyBuckets)+1)}
+		r.operations[key] = c
+	}
+	return c
+}
+
+// failed reports whether err is the failure of the call, not the expected
+// outcome.
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, ifaces.ErrNoSuchRecord) && !errors.Is(err, ifaces.ErrEmptyTable)
+}
+
+func (r *registry) record(table string, op string, duration time.Duration, err error) {
+	c := r.counters(table, op)
+	c.calls.Add(1)
+	if failed(err) {
+		c.errors.Add(1)
+	}
+	c.total.Add(int64(duration))
+	bucket := sort.Search(len(LatencyBuckets), func(i int) bool { return duration <= LatencyBuckets[i] })
+	c.latency[bucket].Add(1)
+}
+
+// snapshot returns the metrics ordered by table and operation.
+func (r *registry) snapshot() []Metrics {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	metrics := make([]Metrics, 0, len(r.operations))
+	for key, c := range r.operations {
+		m := Metrics{
+			Table:     key.table,
+			Operation: key.operation,
+			Calls:     c.calls.Load(),
+			Errors:    c.errors.Load(),
+			Latency:   make([]uint64, len(c.latency)),
+			Total:     time.Duration(c.total.Load()),
+		}
+		for i := range c.latency {
+			m.Latency[i] = c.latency[i].Load()
+		}
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Table != metrics[j].Table {
+			return metrics[i].Table < metrics[j].Table
+		}
+		return metrics[i].Operation < metrics[j].Operation
+	})
+	return metrics
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format.
+func WriteMetrics(w io.Writer, metrics []Metrics) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintln(b, "# HELP mesap_db_calls_total Database calls by table and operation.")
+	fmt.Fprintln(b, "# TYPE mesap_db_calls_total counter")
+	for _, m := range metrics {
+		fmt.Fprintf(b, "mesap_db_calls_total{table=%q,operation=%q} %d\n", m.Table, m.Operation, m.Calls)
+	}
+
+	fmt.Fprintln(b, "# HELP mesap_db_errors_total Failed database calls by table and operation.")
+	fmt.Fprintln(b, "# TYPE mesap_db_errors_total counter")
+	for _, m := range metrics {
+		fmt.Fprintf(b, "mesap_db_errors_total{table=%q,operation=%q} %d\n", m.Table, m.Operation, m.Errors)
+	}
+
+	fmt.Fprintln(b, "# HELP mesap_db_call_duration_seconds Latency of the database calls.")
+	fmt.Fprintln(b, "# TYPE mesap_db_call_duration_seconds histogram")
+	for _, m := range metrics {
+		var count uint64
+		for i, n := range m.Latency {
+			count += n
+			le := "+Inf"
+			if i < len(LatencyBuckets) {
+				le = seconds(LatencyBuckets[i])
+			}
+			fmt.Fprintf(b, "mesap_db_call_duration_seconds_bucket{table=%q,operation=%q,le=%q} %d\n", m.Table, m.Operation, le, count)
+		}
+		fmt.Fprintf(b, "mesap_db_call_duration_seconds_sum{table=%q,operation=%q} %s\n", m.Table, m.Operation, seconds(m.Total))
+		fmt.Fprintf(b, "mesap_db_call_duration_seconds_count{table=%q,operation=%q} %d\n", m.Table, m.Operation, count)
+	}
+	return b.Flush()
+}
@@ -0,0 +1,144 @@
+package instrumented_database
+
+import (
+	"context"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// InstrumentedTable measures the calls of the table of the backend. Each
+// and Find calls include the time of the callbacks.
+type InstrumentedTable[M ifaces.Models] struct {
+	table ifaces.Table[M]
+	name  string
+	d     *InstrumentedDatabase
+}
+
+func wrap[M ifaces.Models](d *InstrumentedDatabase, name string, open func() (ifaces.Table[M], error)) (ifaces.Table[M], error) {
+	table, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &InstrumentedTable[M]{table: table, name: name, d: d}, nil
+}
+
+func (T *InstrumentedTable[M]) start(ctx context.Context, operation string) (context.Context, func(err error)) {
+	return T.d.start(ctx, T.name, operation)
+}
+
+func (T *InstrumentedTable[M]) GetContext(ctx context.Context, id models.IdData) (_ M, err error) {
+	ctx, done := T.start(ctx, "get")
+	defer func() { done(err) }()
+	return T.table.GetContext(ctx, id)
+}
+
+func (T *InstrumentedTable[M]) FindContext(ctx context.Context, callback func(record M) bool) (_ M, err error) {
+	ctx, done := T.start(ctx, "find")
+	defer func() { done(err) }()
+	return T.table.FindContext(ctx, callback)
+}
+
+func (T *InstrumentedTable[M]) EachContext(ctx context.Context, callback func(record M) bool) (err error) {
+	ctx, done := T.start(ctx, "each")
+	defer func() { done(err) }()
+	return T.table.EachContext(ctx, callback)
+}
+
+func (T *InstrumentedTable[M]) InsertContext(ctx context.Context, record M) (_ models.IdData, err error) {
+	ctx, done := T.start(ctx, "insert")
+	defer func() { done(err) }()
+	return T.table.InsertContext(ctx, record)
+}
+
+func (T *InstrumentedTable[M]) UpdateContext(ctx context.Context, record M) (err error) {
+	ctx, done := T.start(ctx, "update")
+	defer func() { done(err) }()
+	return T.table.UpdateContext(ctx, record)
+}
+
+func (T *InstrumentedTable[M]) DeleteContext(ctx context.Context, id models.IdData) (err error) {
+	ctx, done := T.start(ctx, "delete")
+	defer func() { done(err) }()
+	return T.table.DeleteContext(ctx, id)
+}
+
+func (T *InstrumentedTable[M]) RestoreContext(ctx context.Context, id models.IdData) (err error) {
+	ctx, done := T.start(ctx, "restore")
+	defer func() { done(err) }()
+	return T.table.RestoreContext(ctx, id)
+}
+
+func (T *InstrumentedTable[M]) ImportContext(ctx context.Context, record M) (err error) {
+	ctx, done := T.start(ctx, "import")
+	defer func() { done(err) }()
+	return T.table.ImportContext(ctx, record)
+}
+
+func (T *InstrumentedTable[M]) ReplaceContext(ctx context.Context, record M) (err error) {
+	ctx, done := T.start(ctx, "replace")
+	defer func() { done(err) }()
+	return T.table.ReplaceContext(ctx, record)
+}
+
+func (T *InstrumentedTable[M]) HistoryContext(ctx context.Context, id models.IdData) (_ []ifaces.Revision[M], err error) {
+	ctx, done := T.start(ctx, "history")
+	defer func() { done(err) }()
+	return T.table.HistoryContext(ctx, id)
+}
+
+func (T *InstrumentedTable[M]) AtContext(ctx context.Context, id models.IdData, at time.Time) (_ M, err error) {
+	ctx, done := T.start(ctx, "at")
+	defer func() { done(err) }()
+	return T.table.AtContext(ctx, id, at)
+}
+
+func (T *InstrumentedTable[M]) RevisionsContext(ctx context.Context, callback func(revision ifaces.Revision[M]) bool) (err error) {
+	ctx, done := T.start(ctx, "revisions")
+	defer func() { done(err) }()
+	return T.table.RevisionsContext(ctx, callback)
+}
+
+func (T *InstrumentedTable[M]) ImportHistoryContext(ctx context.Context, revisions []ifaces.Revision[M]) (err error) {
+	ctx, done := T.start(ctx, "import_history")
+	defer func() { done(err) }()
+	return T.table.ImportHistoryContext(ctx, revisions)
+}
+
+func (T *InstrumentedTable[M]) LookupContext(ctx context.Context, index string, key string) (_ []M, err error) {
+	ctx, done := T.start(ctx, "lookup")
+	defer func() { done(err) }()
+	return T.table.LookupContext(ctx, index, key)
+}
+
+func (T *InstrumentedTable[M]) QueryContext(ctx context.Context, query *ifaces.Query) (_ ifaces.Page[M], err error) {
+	ctx, done := T.start(ctx, "query")
+	defer func() { done(err) }()
+	return T.table.QueryContext(ctx, query)
+}
+
+// Watch measures the subscription only, the events are passed as is.
+func (T *InstrumentedTable[M]) Watch(ctx context.Context, after uint64) (_ ifaces.Watcher[M], err error) {
+	ctx, done := T.start(ctx, "watch")
+	defer func() { done(err) }()
+	return T.table.Watch(ctx, after)
+}
+
+func (T *InstrumentedTable[M]) InsertManyContext(ctx context.Context, records []M) (_ []ifaces.Result, err error) {
+	ctx, done := T.start(ctx, "insert_many")
+	defer func() { done(err) }()
+	return T.table.InsertManyContext(ctx, records)
+}
+
+func (T *InstrumentedTable[M]) UpdateManyContext(ctx context.Context, records []M) (_ []ifaces.Result, err error) {
+	ctx, done := T.start(ctx, "update_many")
+	defer func() { done(err) }()
+	return T.table.UpdateManyContext(ctx, records)
+}
+
+func (T *InstrumentedTable[M]) DeleteWhereContext(ctx context.Context, query *ifaces.Query) (_ []ifaces.Result, err error) {
+	ctx, done := T.start(ctx, "delete_where")
+	defer func() { done(err) }()
+	return T.table.DeleteWhereContext(ctx, query)
+}
@@ -0,0 +1,62 @@
+package instrumented_database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Span is the traced database call.
+type Span interface {
+	// End finishes the span, err is the error of the call or nil.
+	End(err error)
+}
+
+// Tracer starts the spans of the database calls, e.g. adapting the
+// OpenTelemetry tracer. The context returned is passed to the backend, so
+// the spans of the backend are nested.
+type Tracer interface {
+	Start(ctx context.Context, table string, operation string) (context.Context, Span)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(err error) {}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, table string, operation string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+// LogTracer logs the database calls to the logger at debug level.
+type LogTracer struct {
+	Logger *slog.Logger
+}
+
+func (t LogTracer) Start(ctx context.Context, table string, operation string) (context.Context, Span) {
+	if !t.Logger.Enabled(ctx, slog.LevelDebug) {
+		return ctx, nopSpan{}
+	}
+	return ctx, &logSpan{ctx: ctx, logger: t.Logger, table: table, operation: operation, start: time.Now()}
+}
+
+type logSpan struct {
+	ctx       context.Context
+	logger    *slog.Logger
+	table     string
+	operation string
+	start     time.Time
+}
+
+func (s *logSpan) End(err error) {
+	attrs := []slog.Attr{
+		slog.String("table", s.table),
+		slog.String("operation", s.operation),
+		slog.Duration("duration", time.Since(s.start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	s.logger.LogAttrs(s.ctx, slog.LevelDebug, "Database call", attrs...)
+}
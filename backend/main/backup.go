@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/diakovliev/mesap/backend/backup"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/migrations"
+)
+
+const (
+	commandBackup  = "backup"
+	commandRestore = "restore"
+)
+
+// migrateRestored migrates the restored archive of the older schema.
+func migrateRestored(ctx context.Context, db ifaces.Database) error {
+	report, err := migrations.Default().Migrate(ctx, db, false)
+	log.Print(report)
+	return err
+}
+
+// runBackup is the backup command: writes archive of the database to the
+// file or to stdout.
+func runBackup(args []string) {
+	flags := flag.NewFlagSet(commandBackup, flag.ExitOnError)
+	output := flags.String("o", "", "Archive file, stdout if empty")
+	flags.Parse(args)
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		log.Panicf("Fatal: can't open database: %s", err)
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Panicf("Fatal: can't create archive: %s", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	report, err := backup.Dump(context.Background(), db, w)
+	if err != nil {
+		log.Printf("Backup error: %s", err)
+		db.Close()
+		os.Exit(1)
+	}
+	log.Print(report)
+}
+
+// runRestore is the restore command: loads archive from the file or from
+// stdin to the empty database and migrates it.
+func runRestore(args []string) {
+	flags := flag.NewFlagSet(commandRestore, flag.ExitOnError)
+	input := flags.String("i", "", "Archive file, stdin if empty")
+	flags.Parse(args)
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		log.Panicf("Fatal: can't open database: %s", err)
+	}
+	defer db.Close()
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Panicf("Fatal: can't open archive: %s", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	ctx := context.Background()
+	report, err := backup.Restore(ctx, db, r)
+	if err == nil {
+		log.Print(report)
+		err = migrateRestored(ctx, db)
+	}
+	if err != nil {
+		log.Printf("Restore error: %s", err)
+		db.Close()
+		os.Exit(1)
+	}
+}
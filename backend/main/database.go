@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/diakovliev/mesap/backend/cached_database"
+	"github.com/diakovliev/mesap/backend/encrypted_database"
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/file_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/instrumented_database"
+	"github.com/diakovliev/mesap/backend/sql_database"
+
+	// database/sql drivers
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	databaseFake = "fake"
+	databaseFile = "file"
+	databaseSql  = "sql"
+)
+
+// idStrategies is implemented by the databases with configurable ids.
+type idStrategies interface {
+	SetIdStrategies(strategies ifaces.IdStrategies) error
+}
+
+// unwrapper is implemented by the databases wrapping the other ones.
+type unwrapper interface {
+	Unwrap() ifaces.Database
+}
+
+// findDatabase returns db or the database wrapped by it of type D.
+func findDatabase[D ifaces.Database](db ifaces.Database) (D, bool) {
+	for {
+		if found, ok := db.(D); ok {
+			return found, true
+		}
+		wrapper, ok := db.(unwrapper)
+		if !ok {
+			var none D
+			return none, false
+		}
+		db = wrapper.Unwrap()
+	}
+}
+
+// openDatabase opens the database of the tenant, or of the deployment if
+// the tenant is empty. Every tenant has its own memory database, files
+// directory in -db-dir, or sql database named by -db-dsn.
+func openDatabase(tenant string) (ifaces.Database, error) {
+	var db ifaces.Database
+
+	switch *database {
+	case databaseFake:
+		log.Print("Database: in memory")
+		db = fake_database.NewDatabase()
+	case databaseFile:
+		dir := *databaseDir
+		if tenant != "" {
+			dir = filepath.Join(dir, tenant)
+		}
+		log.Printf("Database: files in '%s', snapshot interval: %s", dir, *snapshotInterval)
+		db = file_database.NewDatabase(dir, *snapshotInterval)
+	case databaseSql:
+		source := *databaseDSN
+		if tenant != "" {
+			if !strings.Contains(source, tenantPlaceholder) {
+				return nil, fmt.Errorf("sql database DSN of the tenants must contain '%s'", tenantPlaceholder)
+			}
+			source = strings.ReplaceAll(source, tenantPlaceholder, tenant)
+		}
+		dialect, dsn, err := sql_database.ParseDSN(source)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Database: %s", dialect.Name)
+		db = sql_database.NewDatabase(dialect, dsn)
+	default:
+		return nil, fmt.Errorf("unknown database backend '%s'", *database)
+	}
+
+	strategies, err := ifaces.ParseIdStrategies(*databaseIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(strategies) > 0 {
+		log.Printf("Database: id strategies '%s'", *databaseIds)
+		if err := db.(idStrategies).SetIdStrategies(strategies); err != nil {
+			return nil, err
+		}
+	}
+
+	// Cache keeps the encrypted records, so the key rotation updates
+	// them through the cache
+	if *cacheSize > 0 {
+		log.Printf("Database: cache of %d entries per table, TTL %s", *cacheSize, *cacheTTL)
+		db = cached_database.NewDatabase(db, cached_database.Config{Size: *cacheSize, TTL: *cacheTTL})
+	}
+
+	if *databaseKeys != "" {
+		keys, err := encrypted_database.LoadKeyring(*databaseKeys)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Database: encrypted, current master key '%s'", keys.Current())
+		db = encrypted_database.NewDatabase(db, keys)
+	}
+
+	// Calls are measured as the API sees them, with the cache hits and
+	// the encryption
+	if *databaseMetrics || *databaseTrace {
+		var tracer instrumented_database.Tracer
+		if *databaseTrace {
+			log.Print("Database: calls are logged at debug level")
+			tracer = instrumented_database.LogTracer{Logger: slog.Default()}
+		}
+		db = instrumented_database.NewDatabase(db, tracer)
+	}
+
+	if err := db.Open(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// rotateKeys returns function reloading the master keys of the encrypted
+// database and re-wrapping the records by the current key, nil if the
+// database is not encrypted.
+func rotateKeys(db ifaces.Database) func(ctx context.Context) (int, error) {
+	encrypted, ok := findDatabase[*encrypted_database.EncryptedDatabase](db)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context) (int, error) {
+		keys, err := encrypted_database.LoadKeyring(*databaseKeys)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("Database: current master key '%s'", keys.Current())
+		encrypted.SetKeyring(keys)
+		return encrypted.Rewrap(ctx)
+	}
+}
+
+// writeMetrics returns function writing the metrics of the instrumented
+// database, nil if the database is not instrumented.
+func writeMetrics(db ifaces.Database) func(w io.Writer) error {
+	instrumented, ok := findDatabase[*instrumented_database.InstrumentedDatabase](db)
+	if !ok {
+		return nil
+	}
+	return func(w io.Writer) error {
+		return instrumented_database.WriteMetrics(w, instrumented.Metrics())
+	}
+}
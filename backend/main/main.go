@@ -1,26 +1,48 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/diakovliev/mesap/backend/controllers"
-	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/tenants"
 )
 
 const (
 	//defaultListenAddressTLS  = ":8443"
 	defaultListenAddress     = ":8080"
+	shutdownTimeout          = 10 * time.Second
 	defaultKeyFile           = ""
 	defaultCertFile          = ""
 	defaultStaticContent     = ""
 	defaultStaticContentRoot = "/"
+	defaultLogLevel          = "info"
+	defaultDatabase          = databaseFake
+	defaultDatabaseDir       = "data"
+	defaultSnapshotInterval  = time.Minute
+	defaultDatabaseDSN       = "sqlite://mesap.db"
+	defaultDatabaseIds       = ""
+	defaultDatabaseKeys      = ""
+	defaultCacheSize         = 0
+	defaultCacheTTL          = time.Minute
+	defaultWatchTokens       = ""
+	defaultAdminTokens       = ""
+	defaultTenants           = ""
+	defaultTenantHeader      = ""
 )
 
 var (
@@ -31,6 +53,28 @@ var (
 
 	staticContent     *string
 	staticContentRoot *string
+
+	logLevel      *string
+	traceProtocol *bool
+
+	database         *string
+	databaseDir      *string
+	snapshotInterval *time.Duration
+	databaseDSN      *string
+	databaseMigrate  *bool
+	databaseIds      *string
+	databaseKeys     *string
+	cacheSize        *int
+	cacheTTL         *time.Duration
+	databaseMetrics  *bool
+	databaseTrace    *bool
+
+	watchTokens *string
+	adminTokens *string
+
+	tenantsFile  *string
+	tenantHeader *string
+	tenant       *string
 )
 
 func init() {
@@ -41,9 +85,29 @@ func init() {
 	listenAddress = flag.String("listen", defaultListenAddress, "Listen address")
 	certFile = flag.String("cert", defaultCertFile, "Server certificate")
 	keyFile = flag.String("key", defaultKeyFile, "Server certificate key")
+	logLevel = flag.String("log-level", defaultLogLevel, "Log level: debug, info, warn or error")
+	traceProtocol = flag.Bool("trace-protocol", false, "Log SRP protocol secrets in plaintext (development only, requires debug log level)")
+	database = flag.String("db", defaultDatabase, "Database backend: fake (in memory), file or sql")
+	databaseDir = flag.String("db-dir", defaultDatabaseDir, "Directory of the file database")
+	snapshotInterval = flag.Duration("db-snapshot-interval", defaultSnapshotInterval, "Snapshot interval of the file database")
+	databaseDSN = flag.String("db-dsn", defaultDatabaseDSN, "DSN of the sql database: sqlite://<file> or postgres://<user>:<password>@<host>/<database>")
+	databaseIds = flag.String("db-ids", defaultDatabaseIds, "Id strategies of the tables: comma separated table=strategy pairs, strategy is sequential (default), random or time")
+	databaseKeys = flag.String("db-keys", defaultDatabaseKeys, "File with master keys encrypting sensitive fields, one 'id:base64' per line, the first is current; encryption is off if empty")
+	cacheSize = flag.Int("db-cache-size", defaultCacheSize, "Number of the records and index lookups cached per table; cache is off if 0")
+	cacheTTL = flag.Duration("db-cache-ttl", defaultCacheTTL, "Time the cached entries are kept, changes of the other instances are seen after it if the database has no change feeds; unlimited if 0")
+	databaseMetrics = flag.Bool("db-metrics", false, "Measure database calls by table and operation, served by the admin metrics endpoint")
+	databaseTrace = flag.Bool("db-trace", false, "Log every database call with its duration (requires debug log level)")
+	databaseMigrate = flag.Bool("db-migrate", true, "Apply pending data migrations on startup, otherwise refuse to start if there are any (see '"+commandMigrate+"' command)")
+	watchTokens = flag.String("watch-tokens", defaultWatchTokens, "File with bearer tokens of the change feed clients, one '[name] token' per line, with '"+tenantPlaceholder+"' replaced by the id of the tenant; change feeds are off if empty")
+	adminTokens = flag.String("admin-tokens", defaultAdminTokens, "File with bearer tokens of the administrators, one '[name] token' per line, with '"+tenantPlaceholder+"' replaced by the id of the tenant; admin endpoints and peoples records are off if empty")
+	tenantsFile = flag.String("tenants", defaultTenants, "File with tenants, one 'id host...' per line; every tenant has its own database: directory in -db-dir, or sql database of -db-dsn with '"+tenantPlaceholder+"' replaced by the id; tenants are off if empty")
+	tenantHeader = flag.String("tenant-header", defaultTenantHeader, "Request header selecting the tenant on the hosts not listed in -tenants, e.g. X-Tenant; off if empty")
+	tenant = flag.String("tenant", "", "Tenant of the '"+commandMigrate+"', '"+commandBackup+"' and '"+commandRestore+"' commands")
 
 	flag.Parse()
 
+	setupLogger()
+
 	if *staticContent != "" {
 		log.Printf("Static content directory: '%s'", *staticContent)
 		log.Printf("Static content root: '%s'", *staticContentRoot)
@@ -65,25 +129,155 @@ func init() {
 
 func main() {
 
+	if flag.Arg(0) == commandMigrate {
+		runMigrate(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == commandBackup {
+		runBackup(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == commandRestore {
+		runRestore(flag.Args()[1:])
+		return
+	}
+
 	r := chi.NewRouter()
 
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 
-	r.Route("/api", func(r chi.Router) {
-		r.Mount("/auth", controllers.NewAuthController(fake_database.NewDatabase()).Controller())
-	})
+	apiConfig := func(tenant string, db ifaces.Database) controllers.APIConfig {
+		tokens, err := loadTokens(*watchTokens, tenant, "Change feeds")
+		if err != nil {
+			log.Panicf("Fatal: can't load watch tokens: %s", err)
+		}
+		admins, err := loadTokens(*adminTokens, tenant, "Admin endpoints")
+		if err != nil {
+			log.Panicf("Fatal: can't load admin tokens: %s", err)
+		}
+		return controllers.APIConfig{
+			WatchTokens: tokens,
+			AdminTokens: admins,
+			Restored:    migrateRestored,
+			RotateKeys:  rotateKeys(db),
+			Metrics:     writeMetrics(db),
+		}
+	}
+
+	list, err := loadTenants()
+	if err != nil {
+		log.Panicf("Fatal: can't load tenants: %s", err)
+	}
+	if list != nil {
+		resolver, err := tenants.NewResolver(list, *tenantHeader)
+		if err != nil {
+			log.Panicf("Fatal: can't load tenants: %s", err)
+		}
+		registry, err := openTenants(list)
+		if err != nil {
+			log.Panicf("Fatal: can't open database: %s", err)
+		}
+		defer registry.Close()
+
+		router, err := controllers.NewTenantRouter(registry, resolver, apiConfig)
+		if err != nil {
+			log.Panicf("Fatal: can't open database: %s", err)
+		}
+		r.Mount("/api", router)
+	} else {
+		db, err := openDatabase("")
+		if err != nil {
+			log.Panicf("Fatal: can't open database: %s", err)
+		}
+		defer db.Close()
+
+		if err := migrateOnStartup(db); err != nil {
+			log.Panicf("Fatal: can't migrate database: %s", err)
+		}
+
+		r.Mount("/api", controllers.NewAPIRouter(db, apiConfig("", db)))
+	}
 
 	FileServer(r)
 
-	if *enableTls {
-		if err := http.ListenAndServeTLS(*listenAddress, *certFile, *keyFile, r); err != nil {
-			log.Panicf("Fatal: %s", err)
+	server := &http.Server{Addr: *listenAddress, Handler: r}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		log.Print("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown error: %s", err)
 		}
+	}()
+
+	if *enableTls {
+		err = server.ListenAndServeTLS(*certFile, *keyFile)
 	} else {
-		if err := http.ListenAndServe(*listenAddress, r); err != nil {
-			log.Panicf("Fatal: %s", err)
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Panicf("Fatal: %s", err)
+	}
+}
+
+func setupLogger() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Panicf("Fatal: wrong log level '%s': %s", *logLevel, err)
+	}
+
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler))
+	controllers.SetLogger(slog.Default())
+
+	if *traceProtocol {
+		if level > slog.LevelDebug {
+			slog.Warn("Protocol tracing requires debug log level, ignored")
+		} else {
+			controllers.EnableProtocolTrace(true)
+		}
+	}
+}
+
+// loadTokens reads the tokens file of the feature, see
+// controllers.APIConfig. Every tenant has its own tokens file, named by the
+// path with tenantPlaceholder replaced by the tenant id, so the clients of
+// one tenant are not authorized by the others. Empty lines and lines
+// starting with '#' are skipped.
+func loadTokens(path string, tenant string, feature string) ([]string, error) {
+	if path == "" {
+		log.Printf("%s: OFF", feature)
+		return nil, nil
+	}
+	if tenant != "" {
+		if !strings.Contains(path, tenantPlaceholder) {
+			return nil, fmt.Errorf("tokens file of the tenants must contain '%s'", tenantPlaceholder)
+		}
+		path = strings.ReplaceAll(path, tenantPlaceholder, tenant)
+		feature = fmt.Sprintf("Tenant '%s': %s", tenant, feature)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
 		}
+		tokens = append(tokens, line)
 	}
+	log.Printf("%s: %d client tokens", feature, len(tokens))
+	return tokens, nil
 }
 
 // FileServer is serving static files.
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/migrations"
+)
+
+const commandMigrate = "migrate"
+
+// migrateOnStartup applies the pending migrations if enabled, otherwise
+// refuses to serve the database of the older schema.
+func migrateOnStartup(db ifaces.Database) error {
+	migrator := migrations.Default()
+
+	if !*databaseMigrate {
+		current, pending, err := migrator.Pending(context.Background(), db)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("schema version %d is behind %d, run '%s' command", current, migrator.Latest(), commandMigrate)
+		}
+		return nil
+	}
+
+	report, err := migrator.Migrate(context.Background(), db, false)
+	log.Print(report)
+	return err
+}
+
+// runMigrate is the migrate command: applies the pending migrations or
+// reports them with -dry-run.
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet(commandMigrate, flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Validate and report pending migrations without applying them")
+	flags.Parse(args)
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		log.Panicf("Fatal: can't open database: %s", err)
+	}
+	defer db.Close()
+
+	report, err := migrations.Default().Migrate(context.Background(), db, *dryRun)
+	fmt.Println(report)
+	if err != nil {
+		log.Printf("Migration error: %s", err)
+		db.Close()
+		os.Exit(1)
+	}
+}
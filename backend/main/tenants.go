@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/tenants"
+)
+
+// tenantPlaceholder is replaced by the tenant id in the sql database DSN
+// and the tokens files.
+const tenantPlaceholder = "{tenant}"
+
+// loadTenants returns the tenants of the deployment, nil if it has no
+// tenants.
+func loadTenants() ([]tenants.Tenant, error) {
+	if *tenantsFile == "" {
+		log.Print("Tenants: OFF")
+		return nil, nil
+	}
+
+	list, err := tenants.LoadTenants(*tenantsFile)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Tenants: %d from '%s'", len(list), *tenantsFile)
+	return list, nil
+}
+
+// openCommandDatabase opens the database of the command, the database of
+// -tenant if the deployment has tenants. The file database open by the
+// running server is not opened, see file_database.ErrLocked.
+func openCommandDatabase() (ifaces.Database, error) {
+	list, err := loadTenants()
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		if *tenant != "" {
+			return nil, errors.New("-tenant requires -tenants")
+		}
+		return openDatabase("")
+	}
+
+	if *tenant == "" {
+		return nil, errors.New("-tenant is required with -tenants")
+	}
+	if !slices.ContainsFunc(list, func(t tenants.Tenant) bool { return t.Id == *tenant }) {
+		return nil, fmt.Errorf("%w '%s'", tenants.ErrUnknownTenant, *tenant)
+	}
+	return openDatabase(*tenant)
+}
+
+// openTenants opens and migrates the databases of the tenants.
+func openTenants(list []tenants.Tenant) (*tenants.Registry, error) {
+	return tenants.Open(context.Background(), list, func(ctx context.Context, id string) (ifaces.Database, error) {
+		log.Printf("Tenant '%s'", id)
+		db, err := openDatabase(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := migrateOnStartup(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	})
+}
@@ -0,0 +1,167 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// All are the migrations of the application. New migration is appended
+// with the next version; applied migrations are never changed.
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(context.Context, ifaces.Tx) error {
+			// Schema of the databases created before the migrations
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "record versions",
+		Up: func(ctx context.Context, tx ifaces.Tx) error {
+			// Records stored before the versions have version 0, the
+			// update assigns the first one
+			return touchAll(ctx, tx, func(record ifaces.Version) bool {
+				return record.GetVersion() == 0
+			})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "contact tables",
+		Up:      moveContacts,
+	},
+}
+
+// Default returns migrator of All.
+func Default() *Migrator {
+	m, err := NewMigrator(All...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// touchAll touches the records selected by filter in all tables.
+func touchAll(ctx context.Context, tx ifaces.Tx, filter func(record ifaces.Version) bool) error {
+	users, err := tx.Users()
+	if err != nil {
+		return err
+	}
+	peoples, err := tx.Peoples()
+	if err != nil {
+		return err
+	}
+	roles, err := tx.Roles()
+	if err != nil {
+		return err
+	}
+
+	if err := touch(ctx, users, filter); err != nil {
+		return err
+	}
+	if err := touch(ctx, peoples, filter); err != nil {
+		return err
+	}
+	return touch(ctx, roles, filter)
+}
+
+// touch updates the records selected by filter without changes, so they
+// get the next version and the revision.
+func touch[M ifaces.Models](ctx context.Context, table ifaces.Table[M], filter func(record ifaces.Version) bool) error {
+	var selected []M
+	err := table.EachContext(ctx, func(record M) bool {
+		var r interface{} = &record
+		if filter(r.(ifaces.Version)) {
+			selected = append(selected, record)
+		}
+		return true
+	})
+	if err != nil && !errors.Is(err, ifaces.ErrEmptyTable) {
+		return err
+	}
+
+	for _, record := range selected {
+		if err := table.UpdateContext(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveContacts moves the contacts embedded into the live People records to
+// their own tables, see models.LegacyContacts. The records get the next
+// version without the contacts; the history keeps them.
+func moveContacts(ctx context.Context, tx ifaces.Tx) error {
+	peoples, err := tx.Peoples()
+	if err != nil {
+		return err
+	}
+	phones, err := tx.Phones()
+	if err != nil {
+		return err
+	}
+	addresses, err := tx.Addresses()
+	if err != nil {
+		return err
+	}
+	emails, err := tx.Emails()
+	if err != nil {
+		return err
+	}
+	bankAccounts, err := tx.BankAccounts()
+	if err != nil {
+		return err
+	}
+
+	var selected []models.People
+	err = peoples.EachContext(ctx, func(record models.People) bool {
+		if !record.LegacyContacts.Empty() {
+			selected = append(selected, record)
+		}
+		return true
+	})
+	if err != nil && !errors.Is(err, ifaces.ErrEmptyTable) {
+		return err
+	}
+
+	for _, record := range selected {
+		owner := record.GetId()
+		if err := insertOwned(ctx, phones, owner, record.Phones); err != nil {
+			return err
+		}
+		if err := insertOwned(ctx, addresses, owner, record.Addresses); err != nil {
+			return err
+		}
+		if err := insertOwned(ctx, emails, owner, record.Emails); err != nil {
+			return err
+		}
+		if err := insertOwned(ctx, bankAccounts, owner, record.BankAccounts); err != nil {
+			return err
+		}
+
+		record.LegacyContacts = models.LegacyContacts{}
+		if err := peoples.UpdateContext(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertOwned inserts the records owned by owner. Ids and versions of the
+// records are allocated by the table.
+func insertOwned[M ifaces.Models](ctx context.Context, table ifaces.Table[M], owner models.IdData, records []M) error {
+	for _, record := range records {
+		var r interface{} = &record
+		r.(ifaces.Owned).SetOwner(owner)
+		if _, err := table.InsertContext(ctx, record); err != nil {
+			return fmt.Errorf("Owner %d: %w", owner, err)
+		}
+	}
+	return nil
+}
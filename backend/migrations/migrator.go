@@ -0,0 +1,175 @@
+// Package migrations upgrades the persisted data to the current models.
+// Migrations are numbered, the database keeps the version of the last
+// applied one (see ifaces.Tx.SchemaVersion), so every migration is applied
+// once and in order.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+var (
+	ErrSchemaTooNew = errors.New("Database schema is newer than the application!")
+	ErrBadMigration = errors.New("Bad migration!")
+	errDryRun       = errors.New("Dry run!")
+)
+
+// Migration upgrades data of the previous schema version to Version.
+type Migration struct {
+	Version int
+	Name    string
+	// Up migrates the data in the transaction. The schema version is set
+	// in the same transaction.
+	Up func(ctx context.Context, tx ifaces.Tx) error
+}
+
+// Migrator applies the migrations to the database.
+type Migrator struct {
+	migrations []Migration
+}
+
+// NewMigrator returns migrator of the migrations. Versions of the
+// migrations must be positive and unique.
+func NewMigrator(migrations ...Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, migration := range sorted {
+		if migration.Version <= 0 || migration.Up == nil {
+			return nil, fmt.Errorf("%w Version: %d, name: '%s'", ErrBadMigration, migration.Version, migration.Name)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("%w Duplicate version: %d", ErrBadMigration, migration.Version)
+		}
+	}
+	return &Migrator{migrations: sorted}, nil
+}
+
+// Latest returns the schema version of the application.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Step is a migration applied by Migrate.
+type Step struct {
+	Version int
+	Name    string
+}
+
+// Report describes the migration of the database.
+type Report struct {
+	// From is the schema version before the migration.
+	From int
+	// To is the schema version after the migration. The dry run reports
+	// the version the database would have.
+	To     int
+	Steps  []Step
+	DryRun bool
+}
+
+func (r Report) String() string {
+	if len(r.Steps) == 0 {
+		return fmt.Sprintf("Schema version %d is up to date", r.From)
+	}
+
+	var b strings.Builder
+	action := "Migrated"
+	if r.DryRun {
+		action = "Would migrate"
+	}
+	fmt.Fprintf(&b, "%s schema from version %d to %d:", action, r.From, r.To)
+	for _, step := range r.Steps {
+		fmt.Fprintf(&b, "\n  %d %s", step.Version, step.Name)
+	}
+	return b.String()
+}
+
+// Pending returns the schema version of the database and the migrations
+// to apply. Returns ErrSchemaTooNew if the database is migrated by the
+// newer application.
+func (m *Migrator) Pending(ctx context.Context, db ifaces.Database) (current int, pending []Migration, err error) {
+	err = db.Tx(ctx, func(tx ifaces.Tx) (err error) {
+		current, err = tx.SchemaVersion(ctx)
+		return
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if current > m.Latest() {
+		return current, nil, fmt.Errorf("%w Database: %d, application: %d", ErrSchemaTooNew, current, m.Latest())
+	}
+	for _, migration := range m.migrations {
+		if migration.Version > current {
+			pending = append(pending, migration)
+		}
+	}
+	return current, pending, nil
+}
+
+// Migrate applies the pending migrations, each in its own transaction, so
+// the failed migration leaves the database at the previous version. The
+// dry run applies all migrations in the single transaction which is
+// rolled back, so the migrations are validated against the data without
+// changing it.
+func (m *Migrator) Migrate(ctx context.Context, db ifaces.Database, dryRun bool) (Report, error) {
+	current, pending, err := m.Pending(ctx, db)
+	report := Report{From: current, To: current, DryRun: dryRun}
+	if err != nil || len(pending) == 0 {
+		return report, err
+	}
+
+	done := func(migration Migration) {
+		report.To = migration.Version
+		report.Steps = append(report.Steps, Step{Version: migration.Version, Name: migration.Name})
+	}
+
+	if dryRun {
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			for _, migration := range pending {
+				if err := apply(ctx, tx, migration); err != nil {
+					return err
+				}
+				done(migration)
+			}
+			return errDryRun
+		})
+		if errors.Is(err, errDryRun) {
+			err = nil
+		}
+		return report, err
+	}
+
+	for _, migration := range pending {
+		err := db.Tx(ctx, func(tx ifaces.Tx) error {
+			return apply(ctx, tx, migration)
+		})
+		if err != nil {
+			return report, err
+		}
+		done(migration)
+	}
+	return report, nil
+}
+
+// apply runs the migration, its changes are made by the actor
+// "migration <version>" unless ctx has one.
+func apply(ctx context.Context, tx ifaces.Tx, migration Migration) error {
+	if ifaces.ActorFrom(ctx) == "" {
+		ctx = ifaces.WithActor(ctx, fmt.Sprintf("migration %d", migration.Version))
+	}
+	if err := migration.Up(ctx, tx); err != nil {
+		return fmt.Errorf("Migration %d '%s': %w", migration.Version, migration.Name, err)
+	}
+	return tx.SetSchemaVersion(ctx, migration.Version)
+}
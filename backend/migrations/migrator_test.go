@@ -0,0 +1,268 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+var errBroken = errors.New("Broken!")
+
+func insertRole(name string) func(ctx context.Context, tx ifaces.Tx) error {
+	return func(ctx context.Context, tx ifaces.Tx) error {
+		roles, err := tx.Roles()
+		if err != nil {
+			return err
+		}
+		_, err = roles.InsertContext(ctx, models.Role{Name: name})
+		return err
+	}
+}
+
+func schemaVersion(t *testing.T, db ifaces.Database) int {
+	t.Helper()
+
+	m, _ := NewMigrator()
+	current, _, err := m.Pending(context.Background(), db)
+	if !errors.Is(err, ErrSchemaTooNew) && err != nil {
+		t.Fatalf("Pending error: %s", err)
+	}
+	return current
+}
+
+func countRoles(t *testing.T, db ifaces.Database) int {
+	t.Helper()
+
+	roles, _ := db.Roles()
+	n := 0
+	err := roles.Each(func(models.Role) bool {
+		n++
+		return true
+	})
+	if err != nil && !errors.Is(err, ifaces.ErrEmptyTable) {
+		t.Fatalf("Each error: %s", err)
+	}
+	return n
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := fake_database.NewDatabase()
+
+	// Registration order does not matter
+	m, err := NewMigrator(
+		Migration{Version: 3, Name: "third", Up: insertRole("third")},
+		Migration{Version: 1, Name: "first", Up: insertRole("first")},
+		Migration{Version: 2, Name: "second", Up: insertRole("second")},
+	)
+	if err != nil {
+		t.Fatalf("NewMigrator error: %s", err)
+	}
+
+	report, err := m.Migrate(ctx, db, true)
+	if err != nil {
+		t.Fatalf("Dry run error: %s", err)
+	}
+	if !report.DryRun || report.From != 0 || report.To != 3 || len(report.Steps) != 3 || report.Steps[0].Name != "first" {
+		t.Fatalf("Unexpected dry run report: %+v", report)
+	}
+	if version, n := schemaVersion(t, db), countRoles(t, db); version != 0 || n != 0 {
+		t.Fatalf("Dry run changed the database: version %d, %d roles", version, n)
+	}
+
+	report, err = m.Migrate(ctx, db, false)
+	if err != nil {
+		t.Fatalf("Migrate error: %s", err)
+	}
+	if report.DryRun || report.From != 0 || report.To != 3 || len(report.Steps) != 3 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if version, n := schemaVersion(t, db), countRoles(t, db); version != 3 || n != 3 {
+		t.Fatalf("Unexpected database: version %d, %d roles", version, n)
+	}
+	roles, _ := db.Roles()
+	second, err := roles.Find(func(record models.Role) bool { return record.Name == "second" })
+	if err != nil {
+		t.Fatalf("Find error: %s", err)
+	}
+	if revisions, err := roles.HistoryContext(ctx, second.GetId()); err != nil || revisions[0].Actor != "migration 2" {
+		t.Fatalf("Unexpected history: %+v, error: %v", revisions, err)
+	}
+
+	// Migrations are applied once
+	report, err = m.Migrate(ctx, db, false)
+	if err != nil || len(report.Steps) != 0 || report.To != 3 {
+		t.Fatalf("Unexpected report: %+v, error: %v", report, err)
+	}
+	if n := countRoles(t, db); n != 3 {
+		t.Fatalf("Migrations are applied again: %d roles", n)
+	}
+}
+
+func TestMigrateFailure(t *testing.T) {
+	ctx := context.Background()
+	db := fake_database.NewDatabase()
+
+	m, _ := NewMigrator(
+		Migration{Version: 1, Name: "first", Up: insertRole("first")},
+		Migration{Version: 2, Name: "broken", Up: func(ctx context.Context, tx ifaces.Tx) error {
+			if err := insertRole("second")(ctx, tx); err != nil {
+				return err
+			}
+			return errBroken
+		}},
+		Migration{Version: 3, Name: "third", Up: insertRole("third")},
+	)
+
+	// Dry run reports the failure and changes nothing
+	report, err := m.Migrate(ctx, db, true)
+	if !errors.Is(err, errBroken) || len(report.Steps) != 1 {
+		t.Fatalf("Unexpected dry run result: %+v, error: %v", report, err)
+	}
+	if version := schemaVersion(t, db); version != 0 {
+		t.Fatalf("Dry run changed the version: %d", version)
+	}
+
+	// Failed migration is rolled back, the previous ones are kept
+	report, err = m.Migrate(ctx, db, false)
+	if !errors.Is(err, errBroken) || report.To != 1 || len(report.Steps) != 1 {
+		t.Fatalf("Unexpected result: %+v, error: %v", report, err)
+	}
+	if version, n := schemaVersion(t, db), countRoles(t, db); version != 1 || n != 1 {
+		t.Fatalf("Unexpected database: version %d, %d roles", version, n)
+	}
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	ctx := context.Background()
+	db := fake_database.NewDatabase()
+
+	newer, _ := NewMigrator(Migration{Version: 5, Name: "newer", Up: insertRole("newer")})
+	if _, err := newer.Migrate(ctx, db, false); err != nil {
+		t.Fatalf("Migrate error: %s", err)
+	}
+
+	_, err := Default().Migrate(ctx, db, false)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestBadMigrations(t *testing.T) {
+	up := insertRole("role")
+	for i, migrations := range [][]Migration{
+		{{Version: 0, Up: up}},
+		{{Version: 1}},
+		{{Version: 1, Up: up}, {Version: 2, Up: up}, {Version: 1, Up: up}},
+	} {
+		if _, err := NewMigrator(migrations...); !errors.Is(err, ErrBadMigration) {
+			t.Fatalf("Migrations %d: unexpected error: %v", i, err)
+		}
+	}
+
+	if _, err := NewMigrator(All...); err != nil {
+		t.Fatalf("Bad application migrations: %s", err)
+	}
+}
+
+func TestRecordVersions(t *testing.T) {
+	fake := fake_database.NewJournaledDatabase(nil)
+	fake.Locked(func() error {
+		return fake.RolesTable().Load(fake_database.TableData[models.Role]{
+			Records: []models.Role{{Id: models.MakeId(1), Name: "legacy"}},
+			NextId:  2,
+		})
+	})
+
+	report, err := Default().Migrate(context.Background(), fake, false)
+	if err != nil {
+		t.Fatalf("Migrate error: %s", err)
+	}
+	if fmt.Sprint(report) == "" || report.To != Default().Latest() {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	roles, _ := fake.Roles()
+	role, err := roles.Get(1)
+	if err != nil || role.GetVersion() != models.FIRST_VERSION || role.Name != "legacy" {
+		t.Fatalf("Unexpected record: %+v, error: %v", role, err)
+	}
+}
+
+func TestContactTables(t *testing.T) {
+	fake := fake_database.NewJournaledDatabase(nil)
+	fake.Locked(func() error {
+		return fake.PeoplesTable().Load(fake_database.TableData[models.People]{
+			Records: []models.People{
+				{
+					Id:   models.MakeId(1),
+					Name: "John",
+					LegacyContacts: models.LegacyContacts{
+						Phones:       []models.Phone{{Active: true, Phone: "+1 555 0001"}, {Phone: "+1 555 0002"}},
+						Addresses:    []models.Address{{City: "City"}},
+						Emails:       []models.Email{{Active: true, Mail: "john@example.com"}},
+						BankAccounts: []models.BankAccount{{Active: true}},
+					},
+				},
+				{Id: models.MakeId(2), Name: "Jane"},
+			},
+			NextId: 3,
+		})
+	})
+
+	// Records of the schema version 1 get the first version, then the
+	// next one without the contacts
+	if _, err := Default().Migrate(context.Background(), fake, false); err != nil {
+		t.Fatalf("Migrate error: %s", err)
+	}
+
+	peoples, _ := fake.Peoples()
+	john, err := peoples.Get(1)
+	if err != nil || !john.LegacyContacts.Empty() || john.Name != "John" || john.GetVersion() != models.FIRST_VERSION+1 {
+		t.Fatalf("Unexpected record: %+v, error: %v", john, err)
+	}
+	history, err := peoples.History(1)
+	if err != nil || len(history) != 2 || !reflect.DeepEqual(history[1].Diff, []string{"Phones", "Addresses", "Emails", "BankAccounts"}) {
+		t.Fatalf("Unexpected history: %+v, error: %v", history, err)
+	}
+	jane, err := peoples.Get(2)
+	if err != nil || jane.GetVersion() != models.FIRST_VERSION {
+		t.Fatalf("Unexpected record: %+v, error: %v", jane, err)
+	}
+
+	phones, _ := fake.Phones()
+	owned, err := ifaces.OwnedBy(context.Background(), phones, 1)
+	if err != nil || len(owned) != 2 || owned[0].Phone != "+1 555 0001" || !owned[0].Active || owned[1].Phone != "+1 555 0002" {
+		t.Fatalf("Unexpected phones: %+v, error: %v", owned, err)
+	}
+	emails, _ := fake.Emails()
+	email, err := ifaces.GetBy(emails, models.EmailIndex, "john@example.com")
+	if err != nil || email.GetOwner() != 1 {
+		t.Fatalf("Unexpected email: %+v, error: %v", email, err)
+	}
+	for name, n := range map[string]int{"addresses": countOwned(t, fake.Addresses), "bank accounts": countOwned(t, fake.BankAccounts)} {
+		if n != 1 {
+			t.Fatalf("Expected 1 of %s, got %d", name, n)
+		}
+	}
+}
+
+func countOwned[M ifaces.Models](t *testing.T, open func() (ifaces.Table[M], error)) int {
+	t.Helper()
+
+	table, err := open()
+	if err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	owned, err := ifaces.OwnedBy(context.Background(), table, 1)
+	if err != nil {
+		t.Fatalf("OwnedBy error: %s", err)
+	}
+	return len(owned)
+}
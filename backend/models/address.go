@@ -2,7 +2,8 @@ package models
 
 type Address struct {
 	Id
-	owner      IdData
+	Version
+	Ownership
 	Active     bool
 	Postcode   string
 	Region     string
@@ -2,7 +2,8 @@ package models
 
 type BankAccount struct {
 	Id
-	owner  IdData
+	Version
+	Ownership
 	Active bool
 	// TODO:
 }
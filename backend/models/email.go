@@ -1,8 +1,12 @@
 package models
 
+// EmailIndex is the index of the emails by mail.
+const EmailIndex = "email"
+
 type Email struct {
 	Id
-	owner  IdData
+	Version
+	Ownership
 	Active bool
-	Mail   string
+	Mail   string `index:"email"`
 }
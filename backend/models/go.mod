@@ -1,3 +1,3 @@
 module github.com/diakovliev/mesap/backend/models
 
-go 1.18
+go 1.23
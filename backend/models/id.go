@@ -1,13 +1,26 @@
 package models
 
-type IdData = int64
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// IdData is the id of the record. Its JSON form is the number; the API
+// of the version 2 writes the external form of FormatId, as the ids exceed
+// the integers exact in JavaScript, so both forms are read.
+type IdData int64
 
 const BAD_ID = -1
 const FIRST_ID = 0
 
-// Abstract Id
+// Abstract Id. The id is not embedded, as the JSON method of IdData would
+// be promoted to the records.
 type Id struct {
-	IdData `json:"Id"`
+	IdData IdData `json:"Id"`
 }
 
 func (i *Id) SetId(id IdData) {
@@ -17,5 +30,118 @@ func (i Id) GetId() IdData {
 	return i.IdData
 }
 func MakeId(val IdData) Id {
-	return Id{val}
+	return Id{IdData: val}
+}
+
+// idAlphabet is Crockford's base32 alphabet, without I, L, O and U.
+const idAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// idPrefix tells the external form from the decimal ids.
+const idPrefix = "id-"
+
+// IdStringLength is the length of the external form of the ids.
+const IdStringLength = len(idPrefix) + 13
+
+var ErrBadId = errors.New("Bad id!")
+
+// FormatId returns external form of the id for URLs and JSON: prefix and
+// Crockford's base32 of fixed length, so the strings are ordered as the
+// ids.
+func FormatId(id IdData) string {
+	var b [IdStringLength]byte
+	copy(b[:], idPrefix)
+	v := uint64(id)
+	for i := IdStringLength - 1; i >= len(idPrefix); i-- {
+		b[i] = idAlphabet[v&0x1f]
+		v >>= 5
+	}
+	return string(b[:])
+}
+
+// ParseId parses the external form of the id, case insensitive; I and L
+// are read as 1, O as 0. Strings of digits are decimal ids, which were
+// used in URLs before.
+func ParseId(s string) (IdData, error) {
+	if len(s) < len(idPrefix) || !strings.EqualFold(s[:len(idPrefix)], idPrefix) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 || strings.HasPrefix(s, "+") {
+			return BAD_ID, fmt.Errorf("%w '%s'", ErrBadId, s)
+		}
+		return IdData(id), nil
+	}
+	if len(s) != IdStringLength {
+		return BAD_ID, fmt.Errorf("%w '%s'", ErrBadId, s)
+	}
+
+	var v uint64
+	for i := len(idPrefix); i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case 'i', 'I', 'l', 'L':
+			c = '1'
+		case 'o', 'O':
+			c = '0'
+		}
+		n := strings.IndexByte(idAlphabet, byte(unicode.ToUpper(rune(c))))
+		// Ids are not negative, so the first character is below 8
+		if n < 0 || i == len(idPrefix) && n > 7 {
+			return BAD_ID, fmt.Errorf("%w '%s'", ErrBadId, s)
+		}
+		v = v<<5 | uint64(n)
+	}
+	return IdData(v), nil
+}
+
+// UnmarshalJSON reads the number or the external form of the id.
+func (id *IdData) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] != '"' {
+		v, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w '%s'", ErrBadId, data)
+		}
+		*id = IdData(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseId(s)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+type VersionData = int64
+
+const FIRST_VERSION = 1
+
+// Version of the record. Version is assigned by the database on insert
+// and advanced by every update.
+type Version struct {
+	VersionData `json:"Version"`
+}
+
+func (v *Version) SetVersion(version VersionData) {
+	v.VersionData = version
+}
+func (v Version) GetVersion() VersionData {
+	return v.VersionData
+}
+
+// Ownership is the reference of the record to its owner People record.
+type Ownership struct {
+	Owner IdData
+}
+
+func (o *Ownership) SetOwner(owner IdData) {
+	o.Owner = owner
+}
+func (o Ownership) GetOwner() IdData {
+	return o.Owner
 }
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseId(t *testing.T) {
+	tests := []struct {
+		s  string
+		id IdData
+	}{
+		{"id-0000000000000", 0},
+		{"id-000000000001A", 42},
+		{"ID-000000000001a", 42},
+		{"id-00000000000LO", 32},
+		{"id-7ZZZZZZZZZZZZ", 1<<63 - 1},
+		// Decimal ids of 13 digits are not the external form
+		{"1234567890123", 1234567890123},
+		{"0000000000042", 42},
+		{"42", 42},
+	}
+	for _, test := range tests {
+		id, err := ParseId(test.s)
+		if err != nil || id != test.id {
+			t.Fatalf("'%s': expected %d, got %d, error: %v", test.s, test.id, id, err)
+		}
+	}
+
+	for _, s := range []string{"", "-1", "+1", "id-", "id-000000000001", "id-8000000000000", "id-000000000001U", "000000000001A"} {
+		if _, err := ParseId(s); !errors.Is(err, ErrBadId) {
+			t.Fatalf("'%s': expected ErrBadId, got %v", s, err)
+		}
+	}
+}
+
+func TestIdJSON(t *testing.T) {
+	encoded, err := json.Marshal(Id{IdData: 1234567890123})
+	if err != nil {
+		t.Fatalf("Encoding error: %s", err)
+	}
+	if string(encoded) != `{"Id":1234567890123}` {
+		t.Fatalf("Unexpected JSON: %s", encoded)
+	}
+
+	for _, data := range []string{string(encoded), `{"Id":"id-000013XRZP16B"}`, `{"Id":"1234567890123"}`} {
+		var decoded Id
+		if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+			t.Fatalf("%s: decoding error: %s", data, err)
+		}
+		if decoded.IdData != 1234567890123 {
+			t.Fatalf("%s: unexpected id %d", data, decoded.IdData)
+		}
+	}
+
+	var decoded Id
+	if err := json.Unmarshal([]byte(`{"Id":"id-ZZZZZZZZZZZZZ"}`), &decoded); !errors.Is(err, ErrBadId) {
+		t.Fatalf("Expected ErrBadId, got %v", err)
+	}
+}
@@ -83,25 +83,41 @@ type TaxInfo struct {
 	Code         string
 }
 
+// LegacyContacts are the contacts embedded into the People records before
+// they got their own tables. They are read from the stored records only to
+// be moved to the tables by the migration, see migrations.All, and are not
+// indexed.
+type LegacyContacts struct {
+	Phones       []Phone       `json:",omitempty" index:"-"`
+	Addresses    []Address     `json:",omitempty" index:"-"`
+	Emails       []Email       `json:",omitempty" index:"-"`
+	BankAccounts []BankAccount `json:",omitempty" index:"-" encrypted:"true"`
+}
+
+// Empty returns true if there are no contacts.
+func (c LegacyContacts) Empty() bool {
+	return len(c.Phones) == 0 && len(c.Addresses) == 0 && len(c.Emails) == 0 && len(c.BankAccounts) == 0
+}
+
 type People struct {
 	Id
+	Version
 
 	// Basic
 	Name       string
 	Surname    string
 	Patronymic string
-	Birth      time.Time
-	Photo      []byte // TODO:
+	Birth      time.Time `encrypted:"true"`
+	Photo      []byte    // TODO:
 
-	// References
-	Phones       []Phone       // TODO: reference to dictionary
-	Addresses    []Address     // TODO: reference to dictionary
-	Emails       []Email       // TODO: reference to dictionary
-	BankAccounts []BankAccount // TODO: reference to dictionary
+	// Contacts are kept in their own tables, see Ownership
+	LegacyContacts
 
 	// Optionals ->
-	Tax      []*TaxInfo       // TODO: reference to register
+	Tax      []*TaxInfo       `encrypted:"true"` // TODO: reference to register
 	Works    []*WorkingPeriod // TODO: reference to register
 	Position *PositionValue   // TODO: reference to register
 	Grade    *GradeValue      // TODO: reference to register
+
+	Sealed Sealed `json:",omitempty"`
 }
@@ -32,7 +32,8 @@ func PhoneTypeFromString(input string) PhoneType {
 
 type Phone struct {
 	Id
-	owner  IdData
+	Version
+	Ownership
 	Active bool
 	Type   PhoneType
 	Phone  string
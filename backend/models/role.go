@@ -1,6 +1,10 @@
 package models
 
+// RoleNameIndex is the unique index of the roles by name.
+const RoleNameIndex = "name"
+
 type Role struct {
 	Id
-	Name string
+	Version
+	Name string `index:"name,unique"`
 }
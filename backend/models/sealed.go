@@ -0,0 +1,7 @@
+package models
+
+// Sealed keeps the fields of the record tagged `encrypted:"true"` in the
+// encrypted form. The encrypted database stores the zero values in the
+// tagged fields and their encrypted values in the Sealed field; the
+// records read through it have the fields restored and no Sealed data.
+type Sealed []byte
@@ -1,8 +1,12 @@
 package models
 
+// UserLoginIndex is the unique index of the users by login.
+const UserLoginIndex = "login"
+
 type User struct {
 	Id
-	Login    string
+	Version
+	Login    string `index:"login,unique"`
 	Salt     string
 	Verifier string
 }
@@ -0,0 +1,75 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// bulk runs the bulk operation in the single transaction by the table
+// bound to it.
+func (T *SqlTable[M]) bulk(ctx context.Context, op func(tx *sql.Tx, bound *SqlTable[M]) ([]ifaces.Result, error)) (results []ifaces.Result, err error) {
+	err = T.run(ctx, func(tx *sql.Tx) error {
+		results, err = op(tx, T.withTx(tx))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// savepoint runs the operation of the single record of the bulk
+// operation. The changes of the failed operation are rolled back, and the
+// transaction is usable after the failed statement.
+func savepoint(ctx context.Context, tx *sql.Tx, op func() (models.IdData, error)) (models.IdData, error) {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT bulk_record"); err != nil {
+		return models.BAD_ID, err
+	}
+	id, err := op()
+	if err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT bulk_record"); rollbackErr != nil {
+			return id, rollbackErr
+		}
+	}
+	if _, releaseErr := tx.ExecContext(ctx, "RELEASE SAVEPOINT bulk_record"); releaseErr != nil {
+		return id, releaseErr
+	}
+	return id, err
+}
+
+func (T *SqlTable[M]) InsertManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	return T.bulk(ctx, func(tx *sql.Tx, bound *SqlTable[M]) ([]ifaces.Result, error) {
+		return ifaces.Bulk(records, func(record M) (models.IdData, error) {
+			return savepoint(ctx, tx, func() (models.IdData, error) {
+				return bound.InsertContext(ctx, record)
+			})
+		})
+	})
+}
+
+func (T *SqlTable[M]) UpdateManyContext(ctx context.Context, records []M) ([]ifaces.Result, error) {
+	return T.bulk(ctx, func(tx *sql.Tx, bound *SqlTable[M]) ([]ifaces.Result, error) {
+		return ifaces.Bulk(records, func(record M) (models.IdData, error) {
+			return savepoint(ctx, tx, func() (models.IdData, error) {
+				return ifaces.IdOf(&record), bound.UpdateContext(ctx, record)
+			})
+		})
+	})
+}
+
+func (T *SqlTable[M]) DeleteWhereContext(ctx context.Context, query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.bulk(ctx, func(tx *sql.Tx, bound *SqlTable[M]) ([]ifaces.Result, error) {
+		page, err := bound.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return ifaces.Bulk(ifaces.Ids(page.Records), func(id models.IdData) (models.IdData, error) {
+			return savepoint(ctx, tx, func() (models.IdData, error) {
+				return id, bound.delete(ctx, tx, id)
+			})
+		})
+	})
+}
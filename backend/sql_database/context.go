@@ -0,0 +1,66 @@
+package sql_database
+
+import (
+	"context"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// Operations without context run with context.Background().
+
+func (T *SqlTable[M]) Get(id models.IdData) (M, error) {
+	return T.GetContext(context.Background(), id)
+}
+func (T *SqlTable[M]) Find(callback func(record M) bool) (M, error) {
+	return T.FindContext(context.Background(), callback)
+}
+func (T *SqlTable[M]) Each(callback func(record M) bool) error {
+	return T.EachContext(context.Background(), callback)
+}
+func (T *SqlTable[M]) Insert(record M) (models.IdData, error) {
+	return T.InsertContext(context.Background(), record)
+}
+func (T *SqlTable[M]) Update(record M) error {
+	return T.UpdateContext(context.Background(), record)
+}
+func (T *SqlTable[M]) Delete(id models.IdData) error {
+	return T.DeleteContext(context.Background(), id)
+}
+func (T *SqlTable[M]) Restore(id models.IdData) error {
+	return T.RestoreContext(context.Background(), id)
+}
+func (T *SqlTable[M]) Import(record M) error {
+	return T.ImportContext(context.Background(), record)
+}
+func (T *SqlTable[M]) Replace(record M) error {
+	return T.ReplaceContext(context.Background(), record)
+}
+func (T *SqlTable[M]) History(id models.IdData) ([]ifaces.Revision[M], error) {
+	return T.HistoryContext(context.Background(), id)
+}
+func (T *SqlTable[M]) At(id models.IdData, at time.Time) (M, error) {
+	return T.AtContext(context.Background(), id, at)
+}
+func (T *SqlTable[M]) Revisions(callback func(revision ifaces.Revision[M]) bool) error {
+	return T.RevisionsContext(context.Background(), callback)
+}
+func (T *SqlTable[M]) ImportHistory(revisions []ifaces.Revision[M]) error {
+	return T.ImportHistoryContext(context.Background(), revisions)
+}
+func (T *SqlTable[M]) Lookup(index string, key string) ([]M, error) {
+	return T.LookupContext(context.Background(), index, key)
+}
+func (T *SqlTable[M]) Query(query *ifaces.Query) (ifaces.Page[M], error) {
+	return T.QueryContext(context.Background(), query)
+}
+func (T *SqlTable[M]) InsertMany(records []M) ([]ifaces.Result, error) {
+	return T.InsertManyContext(context.Background(), records)
+}
+func (T *SqlTable[M]) UpdateMany(records []M) ([]ifaces.Result, error) {
+	return T.UpdateManyContext(context.Background(), records)
+}
+func (T *SqlTable[M]) DeleteWhere(query *ifaces.Query) ([]ifaces.Result, error) {
+	return T.DeleteWhereContext(context.Background(), query)
+}
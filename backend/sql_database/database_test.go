@@ -0,0 +1,223 @@
+package sql_database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/diakovliev/mesap/backend/dbtest"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func openTestDatabase(t *testing.T) *SqlDatabase {
+	dialect, dsn, err := ParseDSN("sqlite::memory:")
+	if err != nil {
+		t.Fatalf("DSN error: %s", err)
+	}
+	db := NewDatabase(dialect, dsn)
+	if err := db.Open(); err != nil {
+		t.Fatalf("Can't open database: %s", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestSchema(t *testing.T) {
+	statements := strings.Join(newSqlTable[models.People](PeoplesTableName, Postgres).schema.createStatements(Postgres), ";\n")
+
+	for _, expected := range []string{
+		`CREATE TABLE IF NOT EXISTS "peoples" ("id" BIGSERIAL PRIMARY KEY, "version" BIGINT NOT NULL, "name" TEXT NOT NULL`,
+		`"birth" TIMESTAMPTZ NOT NULL, "photo" BYTEA, `,
+		`"position" BIGINT, "grade" BIGINT, "sealed" BYTEA)`,
+		`CREATE TABLE IF NOT EXISTS "peoples_phones" ("owner_id" BIGINT NOT NULL REFERENCES "peoples" ("id") ON DELETE CASCADE, "position" BIGINT NOT NULL, "id" BIGINT NOT NULL, "version" BIGINT NOT NULL, "owner" BIGINT NOT NULL, "active" BOOLEAN NOT NULL, "type" BIGINT NOT NULL, "phone" TEXT NOT NULL, PRIMARY KEY ("owner_id", "position"))`,
+		`CREATE TABLE IF NOT EXISTS "peoples_bank_accounts"`,
+		`CREATE TABLE IF NOT EXISTS "peoples_tax" (`,
+		`"since" TIMESTAMPTZ, "till" TIMESTAMPTZ`,
+	} {
+		if !strings.Contains(statements, expected) {
+			t.Fatalf("Schema does not contain:\n%s\n\n%s", expected, statements)
+		}
+	}
+	// Legacy contacts are not indexed
+	if strings.Contains(statements, "CREATE INDEX") {
+		t.Fatalf("Unexpected index:\n%s", statements)
+	}
+
+	statements = strings.Join(newSqlTable[models.Email](EmailsTableName, Postgres).schema.createStatements(Postgres), ";\n")
+	if expected := `CREATE INDEX IF NOT EXISTS "emails_email_idx" ON "emails" ("mail")`; !strings.Contains(statements, expected) {
+		t.Fatalf("Schema does not contain:\n%s\n\n%s", expected, statements)
+	}
+}
+
+func TestOwnedSchema(t *testing.T) {
+	peoples := newSqlTable[models.People](PeoplesTableName, Postgres)
+	statements := strings.Join(newOwnedTable[models.Phone](PhonesTableName, Postgres, peoples).schema.createStatements(Postgres), ";\n")
+
+	expected := `CREATE TABLE IF NOT EXISTS "phones" ("id" BIGSERIAL PRIMARY KEY, "version" BIGINT NOT NULL, "owner" BIGINT NOT NULL REFERENCES "peoples" ("id"), "active" BOOLEAN NOT NULL`
+	if !strings.Contains(statements, expected) {
+		t.Fatalf("Schema does not contain:\n%s\n\n%s", expected, statements)
+	}
+	if len(peoples.cascade) != 1 {
+		t.Fatalf("Unexpected cascades: %d", len(peoples.cascade))
+	}
+}
+
+func TestUniqueIndexSchema(t *testing.T) {
+	statements := strings.Join(newSqlTable[models.User](UsersTableName, SQLite).schema.createStatements(SQLite), ";\n")
+
+	expected := `CREATE UNIQUE INDEX IF NOT EXISTS "users_login_idx" ON "users" ("login")`
+	if !strings.Contains(statements, expected) {
+		t.Fatalf("Schema does not contain:\n%s\n\n%s", expected, statements)
+	}
+}
+
+func TestUniqueViolation(t *testing.T) {
+	db, err := sql.Open(SQLite.Driver, ":memory:")
+	if err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	defer db.Close()
+
+	statements := []string{
+		`CREATE TABLE "t" ("id" INTEGER PRIMARY KEY, "key" TEXT UNIQUE, "value" TEXT NOT NULL)`,
+		`INSERT INTO "t" VALUES (1, 'a', 'v')`,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Exec error: %s", err)
+		}
+	}
+	tests := []struct {
+		dialect   *Dialect
+		err       error
+		violation violation
+		index     string
+	}{
+		{SQLite, execErr(db, `INSERT INTO "t" VALUES (2, 'a', 'v')`), uniqueViolation, "t.key"},
+		{SQLite, execErr(db, `INSERT INTO "t" VALUES (1, 'b', 'v')`), primaryKeyViolation, ""},
+		{SQLite, execErr(db, `INSERT INTO "t" VALUES (3, 'c', NULL)`), noViolation, ""},
+		{SQLite, errors.New("UNIQUE constraint failed"), noViolation, ""},
+		{SQLite, nil, noViolation, ""},
+		{Postgres, fmt.Errorf("Insert: %w", &pq.Error{Code: "23505", Constraint: "users_login_idx"}), uniqueViolation, "users_login_idx"},
+		{Postgres, &pq.Error{Code: "23505", Constraint: "users_pkey"}, primaryKeyViolation, ""},
+		{Postgres, &pq.Error{Code: "23503"}, noViolation, ""},
+		{Postgres, errors.New("duplicate key value violates unique constraint"), noViolation, ""},
+	}
+	for _, test := range tests {
+		if v, index := test.dialect.violation(test.err); v != test.violation || index != test.index {
+			t.Fatalf("%s: '%v' violates %d '%s'", test.dialect.Name, test.err, v, index)
+		}
+	}
+
+	// Primary key collision is not reported as the unique index one
+	users := newSqlTable[models.User](UsersTableName, SQLite)
+	user := models.User{Login: "alice"}
+	err = users.uniqueViolation(execErr(db, `INSERT INTO "t" VALUES (1, 'b', 'v')`), &user)
+	if !errors.Is(err, ifaces.ErrConflict) || errors.Is(err, ifaces.ErrUniqueViolation) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	users = newSqlTable[models.User](UsersTableName, Postgres)
+	err = users.uniqueViolation(&pq.Error{Code: "23505", Constraint: "users_login_idx"}, &user)
+	var violation *ifaces.UniqueViolationError
+	if !errors.As(err, &violation) || violation.Index != models.UserLoginIndex || violation.Key != "alice" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func execErr(db *sql.DB, statement string) error {
+	_, err := db.Exec(statement)
+	return err
+}
+
+func TestPeopleRoundTrip(t *testing.T) {
+	db := openTestDatabase(t)
+
+	peoples, err := db.Peoples()
+	if err != nil {
+		t.Fatalf("Can't access table: %s", err)
+	}
+
+	since := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	position := models.Developer
+	people := models.People{
+		Name:    "John",
+		Surname: "Doe",
+		Birth:   time.Date(1990, 5, 6, 0, 0, 0, 0, time.UTC),
+		Photo:   []byte{1, 2, 3},
+		// Legacy contacts are read to be moved by the migration
+		LegacyContacts: models.LegacyContacts{
+			Phones: []models.Phone{
+				{Id: models.MakeId(7), Active: true, Type: models.MobilePhone, Phone: "+1 555"},
+				{Type: models.CityPhone, Phone: "123"},
+			},
+			Emails:       []models.Email{{Mail: "john@example.com", Active: true}},
+			BankAccounts: []models.BankAccount{{Active: true}},
+		},
+		Tax:      []*models.TaxInfo{{RegisterDate: since, Code: "42"}},
+		Works:    []*models.WorkingPeriod{{Since: &since}},
+		Position: &position,
+	}
+
+	id, err := peoples.Insert(people)
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+	people.SetId(id)
+	people.SetVersion(models.FIRST_VERSION)
+
+	stored, err := peoples.Get(id)
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	if !reflect.DeepEqual(stored, people) {
+		t.Fatalf("Stored record differs:\n got: %+v\nwant: %+v", stored, people)
+	}
+
+	people.Phones = people.Phones[1:]
+	people.Position = nil
+	if err := peoples.Update(people); err != nil {
+		t.Fatalf("Update error: %s", err)
+	}
+	people.SetVersion(models.FIRST_VERSION + 1)
+
+	found, err := peoples.Find(func(p models.People) bool { return p.Name == "John" })
+	if err != nil {
+		t.Fatalf("Find error: %s", err)
+	}
+	if !reflect.DeepEqual(found, people) {
+		t.Fatalf("Updated record differs:\n got: %+v\nwant: %+v", found, people)
+	}
+
+	if err := peoples.Delete(id); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+	if _, err := peoples.Get(id); err != ifaces.ErrNoSuchRecord {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := peoples.Each(func(models.People) bool { return true }); err != ifaces.ErrEmptyTable {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var count int
+	db.DB().QueryRow(`SELECT COUNT(*) FROM "peoples_phones"`).Scan(&count)
+	if count != 0 {
+		t.Fatalf("Child rows are not deleted: %d", count)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) ifaces.Database {
+		dialect, dsn, err := ParseDSN("sqlite::memory:")
+		if err != nil {
+			t.Fatalf("DSN error: %s", err)
+		}
+		return NewDatabase(dialect, dsn)
+	})
+}
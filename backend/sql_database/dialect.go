@@ -0,0 +1,160 @@
+package sql_database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLSTATE of the unique index violation.
+const pgUniqueViolation = "23505"
+
+// violation is the constraint violated by the driver error.
+type violation int
+
+const (
+	noViolation violation = iota
+	uniqueViolation
+	primaryKeyViolation
+)
+
+type columnType int
+
+const (
+	columnText columnType = iota
+	columnInteger
+	columnBoolean
+	columnReal
+	columnTimestamp
+	columnBlob
+)
+
+// Dialect describes differences of the SQL databases.
+type Dialect struct {
+	Name string
+	// database/sql driver name. Drivers of SQLite and Postgres are
+	// registered by this package, it checks their errors.
+	Driver string
+	// Limit of the open connections, 0 - unlimited.
+	MaxOpenConns int
+
+	idColumn    string
+	types       map[columnType]string
+	placeholder func(n int) string
+	// Returns the constraint violated by the driver error and, for the
+	// unique index, its name or its "table.column"
+	violated func(err error) (violation, string)
+	// Collation of the text comparisons, must order strings bytewise as Go
+	collate string
+	// Isolation of the transactions of ifaces.WithSnapshot
+	snapshot sql.IsolationLevel
+	// Moves the id sequence of the table (%[1]s) past the imported ids of
+	// the records and the history (%[2]s), empty if the database does it
+	// itself
+	sequenceSQL string
+}
+
+var (
+	SQLite = &Dialect{
+		Name:         "sqlite",
+		Driver:       "sqlite",
+		MaxOpenConns: 1,
+		idColumn:     "INTEGER PRIMARY KEY AUTOINCREMENT",
+		types: map[columnType]string{
+			columnText:      "TEXT",
+			columnInteger:   "INTEGER",
+			columnBoolean:   "BOOLEAN",
+			columnReal:      "REAL",
+			columnTimestamp: "TIMESTAMP",
+			columnBlob:      "BLOB",
+		},
+		placeholder: func(int) string {
+			return "?"
+		},
+		violated: func(err error) (violation, string) {
+			var e *sqlite.Error
+			if !errors.As(err, &e) {
+				return noViolation, ""
+			}
+			switch e.Code() {
+			case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+				return primaryKeyViolation, ""
+			case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+				// Message names the columns only:
+				// "UNIQUE constraint failed: table.column (2067)"
+				_, columns, _ := strings.Cut(e.Error(), "UNIQUE constraint failed: ")
+				columns, _, _ = strings.Cut(columns, " (")
+				return uniqueViolation, columns
+			}
+			return noViolation, ""
+		},
+	}
+
+	Postgres = &Dialect{
+		Name:     "postgres",
+		Driver:   "postgres",
+		idColumn: "BIGSERIAL PRIMARY KEY",
+		types: map[columnType]string{
+			columnText:      "TEXT",
+			columnInteger:   "BIGINT",
+			columnBoolean:   "BOOLEAN",
+			columnReal:      "DOUBLE PRECISION",
+			columnTimestamp: "TIMESTAMPTZ",
+			columnBlob:      "BYTEA",
+		},
+		placeholder: func(n int) string {
+			return fmt.Sprintf("$%d", n)
+		},
+		violated: func(err error) (violation, string) {
+			var e *pq.Error
+			if !errors.As(err, &e) || e.Code != pgUniqueViolation {
+				return noViolation, ""
+			}
+			if strings.HasSuffix(e.Constraint, "_pkey") {
+				return primaryKeyViolation, ""
+			}
+			return uniqueViolation, e.Constraint
+		},
+		collate: ` COLLATE "C"`,
+		// Read committed transactions see the changes committed after
+		// they are started
+		snapshot:    sql.LevelRepeatableRead,
+		sequenceSQL: `SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), (SELECT MAX("id") FROM (SELECT "id" FROM %[1]s UNION ALL SELECT "id" FROM %[2]s) ids))`,
+	}
+)
+
+// violation returns the constraint violated by the driver error, see
+// Dialect.violated.
+func (d *Dialect) violation(err error) (violation, string) {
+	if err == nil {
+		return noViolation, ""
+	}
+	return d.violated(err)
+}
+
+func (d *Dialect) quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// placeholders returns comma separated placeholders for values
+// first..first+count-1 (numbered from 1).
+func (d *Dialect) placeholders(first int, count int) string {
+	ret := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		ret = append(ret, d.placeholder(first+i))
+	}
+	return strings.Join(ret, ", ")
+}
+
+func (d *Dialect) quoteAll(names []string) string {
+	ret := make([]string, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, d.quote(name))
+	}
+	return strings.Join(ret, ", ")
+}
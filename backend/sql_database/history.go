@@ -0,0 +1,204 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// loadOne loads the record by id in the transaction.
+func (T *SqlTable[M]) loadOne(ctx context.Context, tx *sql.Tx, id models.IdData) (M, error) {
+	var res M
+
+	records, err := T.load(ctx, tx, T.idCondition(), "", id)
+	if err != nil {
+		return res, err
+	}
+	if len(records) == 0 {
+		return res, ifaces.ErrNoSuchRecord
+	}
+	return records[0], nil
+}
+
+// revise writes revision of the change of previous state of the record
+// (nil for the new one) to record. Revision of the same version written
+// by the concurrent transaction is reported as ifaces.ErrConflict.
+func (T *SqlTable[M]) revise(ctx context.Context, tx *sql.Tx, kind ifaces.RevisionKind, previous *M, record *M) error {
+	revision, err := ifaces.NewRevision(ctx, kind, previous, record)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, T.revisionInsertSQL, revision.Id, revision.Version, revision.Time.UnixMicro(), string(data))
+	if v, _ := T.dialect.violation(err); v == primaryKeyViolation {
+		return ifaces.ErrConflict
+	}
+	return err
+}
+
+// revisions loads revisions of the record ordered by version. cond is
+// appended to the condition selecting revisions by id.
+func (T *SqlTable[M]) revisions(ctx context.Context, tx *sql.Tx, id models.IdData, cond string, args ...any) ([]ifaces.Revision[M], error) {
+	query := fmt.Sprintf("%s%s ORDER BY %s", T.revisionSelectSQL, cond, T.dialect.quote(versionColumn))
+
+	rows, err := tx.QueryContext(ctx, query, append([]any{id}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRevisions[M](rows)
+}
+
+// scanRevisions reads the revisions of the rows and closes them.
+func scanRevisions[M ifaces.Models](rows *sql.Rows) ([]ifaces.Revision[M], error) {
+	defer rows.Close()
+
+	var ret []ifaces.Revision[M]
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var revision ifaces.Revision[M]
+		if err := json.Unmarshal([]byte(data), &revision); err != nil {
+			return nil, err
+		}
+		ret = append(ret, revision)
+	}
+	return ret, rows.Err()
+}
+
+// RestoreContext inserts the record deleted by the last revision with its
+// id. Revision is written first, so concurrent restores conflict on it.
+func (T *SqlTable[M]) RestoreContext(ctx context.Context, id models.IdData) error {
+	return T.run(ctx, func(tx *sql.Tx) error {
+		revisions, err := T.revisions(ctx, tx, id, "")
+		if err != nil {
+			return err
+		}
+		deleted, ok := ifaces.Deleted(revisions)
+		if !ok {
+			return ifaces.ErrNoSuchRecord
+		}
+		record := deleted
+		if err := ifaces.NextVersion(&deleted, &record); err != nil {
+			return err
+		}
+
+		if err := T.revise(ctx, tx, ifaces.RevisionRestore, &deleted, &record); err != nil {
+			return err
+		}
+
+		if err := T.checkOwner(ctx, tx, &record); err != nil {
+			return err
+		}
+		if err := T.checkUnique(ctx, tx, id, &record); err != nil {
+			return err
+		}
+		rv := reflect.ValueOf(&record).Elem()
+		if _, err := tx.ExecContext(ctx, T.restoreSQL, append([]any{id}, T.values(rv, T.schema.columns)...)...); err != nil {
+			return T.uniqueViolation(err, &record)
+		}
+		return T.storeChildren(ctx, tx, id, rv)
+	})
+}
+
+func (T *SqlTable[M]) HistoryContext(ctx context.Context, id models.IdData) (revisions []ifaces.Revision[M], err error) {
+	err = T.run(ctx, func(tx *sql.Tx) error {
+		if revisions, err = T.revisions(ctx, tx, id, ""); err != nil || len(revisions) > 0 {
+			return err
+		}
+		// Records inserted before the history was kept have no revisions
+		_, err = T.loadOne(ctx, tx, id)
+		return err
+	})
+	return
+}
+
+func (T *SqlTable[M]) AtContext(ctx context.Context, id models.IdData, at time.Time) (M, error) {
+	var revisions []ifaces.Revision[M]
+	err := T.run(ctx, func(tx *sql.Tx) (err error) {
+		cond := fmt.Sprintf(" AND %s <= %s", T.dialect.quote(timeColumn), T.dialect.placeholder(2))
+		revisions, err = T.revisions(ctx, tx, id, cond, at.UnixMicro())
+		return
+	})
+	if err != nil {
+		var res M
+		return res, err
+	}
+	return ifaces.StateAt(revisions, at)
+}
+
+// RevisionsContext loads all revisions before the first call, as
+// EachContext does.
+func (T *SqlTable[M]) RevisionsContext(ctx context.Context, callback func(revision ifaces.Revision[M]) bool) error {
+	var revisions []ifaces.Revision[M]
+	err := T.run(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, T.revisionsSQL)
+		if err != nil {
+			return err
+		}
+		revisions, err = scanRevisions[M](rows)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !callback(revision) {
+			break
+		}
+	}
+	return nil
+}
+
+// ImportHistoryContext replaces the stored revisions of the same version
+// by deleting them first.
+func (T *SqlTable[M]) ImportHistoryContext(ctx context.Context, revisions []ifaces.Revision[M]) error {
+	for i := range revisions {
+		if err := ifaces.ImportedRevision(&revisions[i]); err != nil {
+			return err
+		}
+	}
+
+	return T.run(ctx, func(tx *sql.Tx) error {
+		for _, revision := range revisions {
+			data, err := json.Marshal(revision)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, T.revisionDeleteSQL, revision.Id, revision.Version); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, T.revisionInsertSQL, revision.Id, revision.Version, revision.Time.UnixMicro(), string(data)); err != nil {
+				return err
+			}
+		}
+		if T.sequenceSQL != "" && len(revisions) > 0 {
+			if _, err := tx.ExecContext(ctx, T.sequenceSQL); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Watch is not supported: changes made by the other connections to the
+// database are not seen by the table.
+func (T *SqlTable[M]) Watch(ctx context.Context, after uint64) (ifaces.Watcher[M], error) {
+	return nil, ifaces.ErrNotSupported
+}
@@ -0,0 +1,34 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// newId allocates id of the new record by the strategy of the table. Ids
+// of the existing and deleted records are not reused.
+func (T *SqlTable[M]) newId(ctx context.Context, tx *sql.Tx) (models.IdData, error) {
+	var max sql.NullInt64
+	if err := tx.QueryRowContext(ctx, T.maxIdSQL).Scan(&max); err != nil {
+		return models.BAD_ID, err
+	}
+	next := models.IdData(models.FIRST_ID)
+	if max.Valid {
+		next = models.IdData(max.Int64) + 1
+	}
+
+	var err error
+	id := ifaces.AllocateId(T.ids, next, func(id models.IdData) bool {
+		var used bool
+		// Allocation is stopped by the error, as the id is not used
+		used, err = T.used(ctx, tx, id)
+		return used
+	})
+	if err != nil {
+		return models.BAD_ID, err
+	}
+	return id, nil
+}
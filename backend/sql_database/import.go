@@ -0,0 +1,57 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func (T *SqlTable[M]) ImportContext(ctx context.Context, record M) error {
+	id, err := ifaces.Imported(&record)
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(&record).Elem()
+
+	return T.run(ctx, func(tx *sql.Tx) error {
+		if used, err := T.used(ctx, tx, id); err != nil {
+			return err
+		} else if used {
+			return ifaces.ErrConflict
+		}
+
+		if err := T.checkOwner(ctx, tx, &record); err != nil {
+			return err
+		}
+		if err := T.checkUnique(ctx, tx, id, &record); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, T.restoreSQL, append([]any{id}, T.values(rv, T.schema.columns)...)...); err != nil {
+			return T.uniqueViolation(err, &record)
+		}
+		if err := T.storeChildren(ctx, tx, id, rv); err != nil {
+			return err
+		}
+		if T.sequenceSQL != "" {
+			if _, err := tx.ExecContext(ctx, T.sequenceSQL); err != nil {
+				return err
+			}
+		}
+		return T.revise(ctx, tx, ifaces.RevisionInsert, nil, &record)
+	})
+}
+
+// used reports whether the id is taken by the live or deleted record.
+func (T *SqlTable[M]) used(ctx context.Context, tx *sql.Tx, id models.IdData) (bool, error) {
+	var used int
+	err := tx.QueryRowContext(ctx, T.usedIdSQL, id, id).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
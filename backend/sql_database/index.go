@@ -0,0 +1,100 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func (T *SqlTable[M]) index(name string) (*tableIndex, error) {
+	for i := range T.schema.indexes {
+		if T.schema.indexes[i].Name == name {
+			return &T.schema.indexes[i], nil
+		}
+	}
+	return nil, ifaces.ErrNoSuchIndex
+}
+
+// indexCondition returns condition selecting records having the key passed
+// as n-th argument.
+func (T *SqlTable[M]) indexCondition(index *tableIndex, n int) string {
+	d := T.dialect
+	cond := fmt.Sprintf("%s = %s", d.quote(index.column), d.placeholder(n))
+	if !index.child {
+		return cond
+	}
+	return fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s)",
+		d.quote(idColumn), d.quote(ownerColumn), d.quote(index.table), cond)
+}
+
+// checkUnique returns ifaces.UniqueViolationError if other record has the
+// same key of the unique index. The database unique indexes make it
+// atomic, see uniqueViolation.
+func (T *SqlTable[M]) checkUnique(ctx context.Context, tx *sql.Tx, id models.IdData, record *M) error {
+	for i := range T.schema.indexes {
+		index := &T.schema.indexes[i]
+		if !index.Unique {
+			continue
+		}
+
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s AND %s <> %s LIMIT 1",
+			T.dialect.quote(idColumn), T.dialect.quote(T.schema.name), T.indexCondition(index, 1),
+			T.dialect.quote(idColumn), T.dialect.placeholder(2))
+
+		for _, key := range index.Keys(record) {
+			var other models.IdData
+			err := tx.QueryRowContext(ctx, query, key, id).Scan(&other)
+			if err == nil {
+				return &ifaces.UniqueViolationError{Index: index.Name, Key: key}
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errIdTaken is the primary key violation of the record: the concurrent
+// transaction has stored the record of the same id.
+var errIdTaken = fmt.Errorf("%w Id is taken", ifaces.ErrConflict)
+
+// idAttempts is the number of the attempts to insert the record, when its
+// id is taken by the concurrent transaction.
+const idAttempts = 3
+
+// uniqueViolation converts driver error of the unique index of the record
+// to ifaces.UniqueViolationError, and of the primary key to errIdTaken.
+// Concurrent transactions may pass checkUnique both, so the database index
+// rejects one of them.
+func (T *SqlTable[M]) uniqueViolation(err error, record *M) error {
+	v, name := T.dialect.violation(err)
+	switch v {
+	case primaryKeyViolation:
+		return errIdTaken
+	case uniqueViolation:
+		for i := range T.schema.indexes {
+			index := &T.schema.indexes[i]
+			if index.Unique && !index.child && (name == index.name || name == index.table+"."+index.column) {
+				ret := &ifaces.UniqueViolationError{Index: index.Name}
+				if keys := index.Keys(record); len(keys) > 0 {
+					ret.Key = keys[0]
+				}
+				return ret
+			}
+		}
+	}
+	return err
+}
+
+func (T *SqlTable[M]) LookupContext(ctx context.Context, name string, key string) ([]M, error) {
+	index, err := T.index(name)
+	if err != nil {
+		return nil, err
+	}
+	return T.loadAll(ctx, T.indexCondition(index, 1), key)
+}
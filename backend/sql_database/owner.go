@@ -0,0 +1,88 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+// newOwnedTable creates table of the records owned by the peoples, see
+// ifaces.Owned. The owner column references the owners table, deleting
+// the owner deletes its records in the same transaction.
+func newOwnedTable[M ifaces.Models](name string, dialect *Dialect, owners *SqlTable[models.People]) *SqlTable[M] {
+	T := newSqlTable[M](name, dialect)
+
+	var record M
+	field, ok := reflect.TypeOf(record).FieldByName(ifaces.OwnerField)
+	if !ok {
+		panic(fmt.Errorf("Model %T has no owner", record))
+	}
+	for i := range T.schema.columns {
+		if reflect.DeepEqual(T.schema.columns[i].index, field.Index) {
+			T.schema.columns[i].references = owners.schema.name
+
+			d := dialect
+			T.ownerSQL = owners.existsSQL
+			T.ownedSQL = fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s ORDER BY %s",
+				d.quote(idColumn), d.quote(name), d.quote(T.schema.columns[i].name), d.placeholder(1), d.quote(idColumn))
+		}
+	}
+
+	owners.cascade = append(owners.cascade, T.deleteOwned)
+	return T
+}
+
+// checkOwner returns ifaces.ErrNoOwner if the owner of the owned record
+// does not exist.
+func (T *SqlTable[M]) checkOwner(ctx context.Context, tx *sql.Tx, record *M) error {
+	if T.ownerSQL == "" {
+		return nil
+	}
+
+	var i interface{} = record
+	owned, ok := i.(ifaces.Owned)
+	if !ok {
+		return ifaces.ErrWrongRecord
+	}
+
+	var exists int
+	err := tx.QueryRowContext(ctx, T.ownerSQL, owned.GetOwner()).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w Owner: %d", ifaces.ErrNoOwner, owned.GetOwner())
+	}
+	return err
+}
+
+// deleteOwned deletes the records of the owner in the transaction.
+func (T *SqlTable[M]) deleteOwned(ctx context.Context, tx *sql.Tx, owner models.IdData) error {
+	rows, err := tx.QueryContext(ctx, T.ownedSQL, owner)
+	if err != nil {
+		return err
+	}
+
+	var ids []models.IdData
+	for rows.Next() {
+		var id models.IdData
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := T.delete(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
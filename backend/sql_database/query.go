@@ -0,0 +1,161 @@
+package sql_database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+// maxLimit is used for the offset without limit, as SQLite does not
+// support OFFSET without LIMIT.
+const maxLimit = int64(^uint64(0) >> 1)
+
+var sqlOperators = map[ifaces.Operator]string{
+	ifaces.Eq: "=",
+	ifaces.Ne: "<>",
+	ifaces.Lt: "<",
+	ifaces.Le: "<=",
+	ifaces.Gt: ">",
+	ifaces.Ge: ">=",
+}
+
+// queryBuilder collects arguments of the query.
+type queryBuilder struct {
+	dialect *Dialect
+	schema  *tableSchema
+	args    []any
+}
+
+func (b *queryBuilder) arg(value any) string {
+	b.args = append(b.args, plainValue(value))
+	return b.dialect.placeholder(len(b.args))
+}
+
+// column returns expression of the field column.
+func (b *queryBuilder) column(field *ifaces.QueryField) string {
+	if field.Name == ifaces.IdField {
+		return b.dialect.quote(idColumn)
+	}
+	for _, col := range b.schema.columns {
+		if reflect.DeepEqual(col.index, field.Index) {
+			if col.kind == columnText {
+				return b.dialect.quote(col.name) + b.dialect.collate
+			}
+			return b.dialect.quote(col.name)
+		}
+	}
+	panic(fmt.Errorf("No column of the field '%s'", field.Name))
+}
+
+func (b *queryBuilder) predicate(p ifaces.PreparedPredicate) string {
+	col := b.column(p.Field)
+
+	switch {
+	case p.Value == nil && p.Op == ifaces.Eq:
+		return col + " IS NULL"
+	case p.Value == nil:
+		return col + " IS NOT NULL"
+	case p.Op == ifaces.Prefix:
+		prefix := reflect.ValueOf(p.Value).String()
+		return fmt.Sprintf("substr(%s, 1, %d) = %s", col, utf8.RuneCountInString(prefix), b.arg(prefix))
+	}
+
+	return fmt.Sprintf("%s %s %s", col, sqlOperators[p.Op], b.arg(p.Value))
+}
+
+// after returns keyset condition selecting records after the cursor.
+func (b *queryBuilder) after(q *ifaces.PreparedQuery) string {
+	var alternatives []string
+	for i, o := range q.Sort {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = %s", b.column(q.Sort[j].Field), b.arg(q.After[j])))
+		}
+		op := ">"
+		if o.Desc {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", b.column(o.Field), op, b.arg(q.After[i])))
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// plainValue converts the value of the named type to the driver value.
+func plainValue(value any) any {
+	if t, ok := value.(time.Time); ok {
+		return t.UTC()
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return value
+}
+
+func (T *SqlTable[M]) QueryContext(ctx context.Context, query *ifaces.Query) (ifaces.Page[M], error) {
+	var page ifaces.Page[M]
+
+	q, err := ifaces.Prepare[M](query)
+	if err != nil {
+		return page, err
+	}
+
+	b := &queryBuilder{dialect: T.dialect, schema: T.schema}
+
+	var conditions []string
+	for _, p := range q.Filters {
+		conditions = append(conditions, b.predicate(p))
+	}
+	if q.After != nil {
+		conditions = append(conditions, b.after(q))
+	}
+
+	var orders []string
+	for _, o := range q.Sort {
+		if o.Desc {
+			orders = append(orders, b.column(o.Field)+" DESC")
+		} else {
+			orders = append(orders, b.column(o.Field))
+		}
+	}
+	tail := "ORDER BY " + strings.Join(orders, ", ")
+
+	// One more record tells that the next page exists
+	if q.Limit > 0 {
+		tail += fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	} else if q.Offset > 0 {
+		tail += fmt.Sprintf(" LIMIT %d", maxLimit)
+	}
+	if q.Offset > 0 {
+		tail += fmt.Sprintf(" OFFSET %d", q.Offset)
+	}
+
+	records, err := T.loadPage(ctx, strings.Join(conditions, " AND "), tail, b.args...)
+	if err != nil {
+		return page, err
+	}
+
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+		page.Next = q.Cursor(reflect.ValueOf(&records[len(records)-1]).Elem())
+	}
+	page.Records = records
+
+	return page, nil
+}
@@ -0,0 +1,269 @@
+package sql_database
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+const (
+	idColumn       = "id"
+	versionColumn  = "version"
+	ownerColumn    = "owner_id"
+	positionColumn = "position"
+
+	// Table of the record revisions, see ifaces.Revision
+	historySuffix  = "_history"
+	timeColumn     = "time"
+	revisionColumn = "revision"
+)
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte{})
+)
+
+// column maps struct field to the table column.
+type column struct {
+	name     string
+	index    []int
+	kind     columnType
+	nullable bool
+	// Table referenced by the column, if any
+	references string
+}
+
+// childTable keeps elements of the slice of structures field, e.g.
+// People.Phones. Rows are ordered by the position in the slice.
+type childTable struct {
+	name    string
+	index   []int
+	pointer bool
+	columns []column
+}
+
+// tableIndex maps ifaces.Index to the column of the table or of the child
+// table. Unique indexes on the child tables are checked by SqlTable only,
+// as several rows of the same record may have the same key.
+type tableIndex struct {
+	*ifaces.Index
+	name   string
+	table  string
+	column string
+	child  bool
+}
+
+// tableSchema maps model type to the table and its child tables. The id
+// column is not listed in the columns, the version column is.
+type tableSchema struct {
+	name         string
+	idIndex      []int
+	versionIndex []int
+	columns      []column
+	children     []childTable
+	indexes      []tableIndex
+}
+
+// newTableSchema builds schema of the model structure: scalar fields are
+// mapped to columns, slices of structures to the child tables. Panics on
+// unsupported field types, as it is a programming error.
+func newTableSchema(name string, t reflect.Type, indexes []*ifaces.Index) *tableSchema {
+	schema := &tableSchema{name: name}
+
+	for _, field := range reflect.VisibleFields(t) {
+		// Fields of the embedded structures are visited as promoted
+		if !field.IsExported() || field.Anonymous && field.Type.Kind() == reflect.Struct {
+			continue
+		}
+		if field.Name == "IdData" {
+			schema.idIndex = field.Index
+			continue
+		}
+
+		if col, ok := newColumn(field); ok {
+			if field.Name == "VersionData" {
+				col.name = versionColumn
+				schema.versionIndex = field.Index
+			}
+			schema.columns = append(schema.columns, col)
+			continue
+		}
+
+		elem := field.Type.Elem()
+		pointer := false
+		if field.Type.Kind() == reflect.Slice && elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+			pointer = true
+		}
+		if field.Type.Kind() != reflect.Slice || elem.Kind() != reflect.Struct || elem == timeType {
+			panic(fmt.Errorf("Unsupported field type: %s.%s %s", t.Name(), field.Name, field.Type))
+		}
+
+		child := childTable{
+			name:    name + "_" + snakeCase(field.Name),
+			index:   field.Index,
+			pointer: pointer,
+		}
+		for _, f := range reflect.VisibleFields(elem) {
+			if !f.IsExported() || f.Anonymous && f.Type.Kind() == reflect.Struct {
+				continue
+			}
+			col, ok := newColumn(f)
+			if !ok {
+				panic(fmt.Errorf("Unsupported field type: %s.%s %s", elem.Name(), f.Name, f.Type))
+			}
+			switch f.Name {
+			case "IdData":
+				col.name = idColumn
+			case "VersionData":
+				col.name = versionColumn
+			}
+			child.columns = append(child.columns, col)
+		}
+		schema.children = append(schema.children, child)
+	}
+
+	if schema.idIndex == nil {
+		panic(fmt.Errorf("Model %s has no id", t.Name()))
+	}
+	if schema.versionIndex == nil {
+		panic(fmt.Errorf("Model %s has no version", t.Name()))
+	}
+
+	for _, index := range indexes {
+		ti := tableIndex{
+			Index:  index,
+			name:   name + "_" + snakeCase(index.Name) + "_idx",
+			table:  name,
+			column: snakeCase(index.Fields[0]),
+		}
+		if len(index.Fields) > 1 {
+			ti.table = name + "_" + snakeCase(index.Fields[0])
+			ti.column = snakeCase(index.Fields[1])
+			ti.child = true
+		}
+		schema.indexes = append(schema.indexes, ti)
+	}
+
+	return schema
+}
+
+func newColumn(field reflect.StructField) (column, bool) {
+	t := field.Type
+	col := column{name: snakeCase(field.Name), index: field.Index}
+
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		col.nullable = true
+	}
+
+	switch {
+	case t == timeType:
+		col.kind = columnTimestamp
+		return col, true
+	case t.Kind() == reflect.Slice && t.Elem() == bytesType.Elem():
+		col.kind = columnBlob
+		col.nullable = true
+		return col, true
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		col.kind = columnText
+	case reflect.Bool:
+		col.kind = columnBoolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		col.kind = columnInteger
+	case reflect.Float32, reflect.Float64:
+		col.kind = columnReal
+	default:
+		return col, false
+	}
+	return col, true
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func columnNames(columns []column) []string {
+	ret := make([]string, 0, len(columns))
+	for _, col := range columns {
+		ret = append(ret, col.name)
+	}
+	return ret
+}
+
+func (d *Dialect) columnDefinition(col column) string {
+	definition := d.quote(col.name) + " " + d.types[col.kind]
+	if !col.nullable {
+		definition += " NOT NULL"
+	}
+	if col.references != "" {
+		definition += fmt.Sprintf(" REFERENCES %s (%s)", d.quote(col.references), d.quote(idColumn))
+	}
+	return definition
+}
+
+// createStatements returns DDL of the table and its child tables.
+func (s *tableSchema) createStatements(d *Dialect) []string {
+	definitions := []string{d.quote(idColumn) + " " + d.idColumn}
+	for _, col := range s.columns {
+		definitions = append(definitions, d.columnDefinition(col))
+	}
+
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", d.quote(s.name), strings.Join(definitions, ", ")),
+		// Revisions are kept after the record is deleted, so there is no
+		// reference to the table. Time is in microseconds since epoch.
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s %s NOT NULL, %s %s NOT NULL, %s %s NOT NULL, %s %s NOT NULL, PRIMARY KEY (%s, %s))",
+			d.quote(s.name+historySuffix),
+			d.quote(idColumn), d.types[columnInteger],
+			d.quote(versionColumn), d.types[columnInteger],
+			d.quote(timeColumn), d.types[columnInteger],
+			d.quote(revisionColumn), d.types[columnText],
+			d.quote(idColumn), d.quote(versionColumn)),
+	}
+
+	for _, child := range s.children {
+		definitions := []string{
+			fmt.Sprintf("%s %s NOT NULL REFERENCES %s (%s) ON DELETE CASCADE",
+				d.quote(ownerColumn), d.types[columnInteger], d.quote(s.name), d.quote(idColumn)),
+			fmt.Sprintf("%s %s NOT NULL", d.quote(positionColumn), d.types[columnInteger]),
+		}
+		for _, col := range child.columns {
+			definitions = append(definitions, d.columnDefinition(col))
+		}
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s, %s)", d.quote(ownerColumn), d.quote(positionColumn)))
+
+		statements = append(statements,
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", d.quote(child.name), strings.Join(definitions, ", ")))
+	}
+
+	for _, index := range s.indexes {
+		unique := ""
+		if index.Unique && !index.child {
+			unique = "UNIQUE "
+		}
+		statements = append(statements, fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s)",
+			unique, d.quote(index.name), d.quote(index.table), d.quote(index.column)))
+	}
+
+	return statements
+}
@@ -0,0 +1,40 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersionTableName is the table of the single row with the schema
+// version.
+const SchemaVersionTableName = "schema_version"
+
+func createSchemaVersion(ctx context.Context, tx *sql.Tx, d *Dialect) error {
+	statement := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s %s NOT NULL)",
+		d.quote(SchemaVersionTableName), d.quote(versionColumn), d.types[columnInteger])
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("%w: %s", err, statement)
+	}
+	return nil
+}
+
+func (tx *SqlTx) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := tx.tx.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM %s",
+		tx.dialect.quote(versionColumn), tx.dialect.quote(SchemaVersionTableName))).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (tx *SqlTx) SetSchemaVersion(ctx context.Context, version int) error {
+	if _, err := tx.tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", tx.dialect.quote(SchemaVersionTableName))); err != nil {
+		return err
+	}
+	_, err := tx.tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		tx.dialect.quote(SchemaVersionTableName), tx.dialect.quote(versionColumn), tx.dialect.placeholder(1)), version)
+	return err
+}
@@ -0,0 +1,579 @@
+package sql_database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+type childStatements struct {
+	insertSQL string
+	selectSQL string
+	deleteSQL string
+}
+
+// SqlTable implements ifaces.Table on top of database/sql. Every
+// operation is executed in its own transaction, unless the table is bound
+// to the transaction of SqlDatabase.Tx.
+type SqlTable[M ifaces.Models] struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect *Dialect
+	schema  *tableSchema
+
+	selectSQL  string
+	insertSQL  string
+	updateSQL  string
+	existsSQL  string
+	deleteSQL  string
+	restoreSQL string
+	children   []childStatements
+
+	revisionInsertSQL string
+	revisionSelectSQL string
+	revisionDeleteSQL string
+	revisionsSQL      string
+
+	// Strategy of the ids, nil for the database sequence
+	ids       ifaces.IdStrategy
+	maxIdSQL  string
+	usedIdSQL string
+	// Empty if the database moves the sequence itself
+	sequenceSQL string
+
+	// Owned records only, see newOwnedTable
+	ownerSQL string
+	ownedSQL string
+	// Deletes the records owned by the deleted one
+	cascade []func(ctx context.Context, tx *sql.Tx, owner models.IdData) error
+}
+
+func newSqlTable[M ifaces.Models](name string, dialect *Dialect) *SqlTable[M] {
+	var record M
+	schema := newTableSchema(name, reflect.TypeOf(record), ifaces.Indexes[M]())
+
+	d := dialect
+	columns := columnNames(schema.columns)
+
+	assignments := make([]string, 0, len(columns))
+	for i, name := range columns {
+		assignments = append(assignments, fmt.Sprintf("%s = %s", d.quote(name), d.placeholder(i+1)))
+	}
+
+	T := &SqlTable[M]{
+		dialect: dialect,
+		schema:  schema,
+		selectSQL: fmt.Sprintf("SELECT %s FROM %s",
+			d.quoteAll(append([]string{idColumn}, columns...)), d.quote(name)),
+		insertSQL: fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s",
+			d.quote(name), d.quoteAll(columns), d.placeholders(1, len(columns)), d.quote(idColumn)),
+		updateSQL: fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s AND %s = %s",
+			d.quote(name), strings.Join(assignments, ", "),
+			d.quote(idColumn), d.placeholder(len(columns)+1), d.quote(versionColumn), d.placeholder(len(columns)+2)),
+		existsSQL: fmt.Sprintf("SELECT 1 FROM %s WHERE %s = %s",
+			d.quote(name), d.quote(idColumn), d.placeholder(1)),
+		deleteSQL: fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s",
+			d.quote(name), d.quote(idColumn), d.placeholder(1), d.quote(versionColumn), d.placeholder(2)),
+		restoreSQL: fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			d.quote(name), d.quoteAll(append([]string{idColumn}, columns...)), d.placeholders(1, len(columns)+1)),
+		revisionInsertSQL: fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			d.quote(name+historySuffix), d.quoteAll([]string{idColumn, versionColumn, timeColumn, revisionColumn}), d.placeholders(1, 4)),
+		revisionSelectSQL: fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s",
+			d.quote(revisionColumn), d.quote(name+historySuffix), d.quote(idColumn), d.placeholder(1)),
+		revisionDeleteSQL: fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s",
+			d.quote(name+historySuffix), d.quote(idColumn), d.placeholder(1), d.quote(versionColumn), d.placeholder(2)),
+		revisionsSQL: fmt.Sprintf("SELECT %s FROM %s ORDER BY %s, %s",
+			d.quote(revisionColumn), d.quote(name+historySuffix), d.quote(idColumn), d.quote(versionColumn)),
+		maxIdSQL: fmt.Sprintf("SELECT MAX(%s) FROM (SELECT %s FROM %s UNION ALL SELECT %s FROM %s) ids",
+			d.quote(idColumn), d.quote(idColumn), d.quote(name), d.quote(idColumn), d.quote(name+historySuffix)),
+		usedIdSQL: fmt.Sprintf("SELECT 1 FROM %s WHERE %s = %s UNION ALL SELECT 1 FROM %s WHERE %s = %s",
+			d.quote(name), d.quote(idColumn), d.placeholder(1), d.quote(name+historySuffix), d.quote(idColumn), d.placeholder(2)),
+	}
+
+	if d.sequenceSQL != "" {
+		T.sequenceSQL = fmt.Sprintf(d.sequenceSQL, d.quote(name), d.quote(name+historySuffix))
+	}
+
+	for _, child := range schema.children {
+		childColumns := append([]string{ownerColumn, positionColumn}, columnNames(child.columns)...)
+		T.children = append(T.children, childStatements{
+			insertSQL: fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+				d.quote(child.name), d.quoteAll(childColumns), d.placeholders(1, len(childColumns))),
+			selectSQL: fmt.Sprintf("SELECT %s FROM %s",
+				d.quoteAll(childColumns), d.quote(child.name)),
+			deleteSQL: fmt.Sprintf("DELETE FROM %s WHERE %s = %s",
+				d.quote(child.name), d.quote(ownerColumn), d.placeholder(1)),
+		})
+	}
+
+	return T
+}
+
+// withTx returns copy of the table bound to the transaction.
+func (T *SqlTable[M]) withTx(tx *sql.Tx) *SqlTable[M] {
+	ret := *T
+	ret.tx = tx
+	return &ret
+}
+
+// run executes callback in the bound transaction or in the new one.
+func (T *SqlTable[M]) run(ctx context.Context, callback func(tx *sql.Tx) error) error {
+	if T.tx != nil {
+		err := callback(T.tx)
+		if errors.Is(err, sql.ErrTxDone) {
+			return ifaces.ErrTxDone
+		}
+		return err
+	}
+
+	tx, err := T.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := callback(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (T *SqlTable[M]) create(ctx context.Context, tx *sql.Tx) error {
+	for _, statement := range T.schema.createStatements(T.dialect) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%w: %s", err, statement)
+		}
+	}
+	return nil
+}
+
+func (T *SqlTable[M]) values(record reflect.Value, columns []column) []any {
+	ret := make([]any, 0, len(columns))
+	for _, col := range columns {
+		ret = append(ret, toDriverValue(record, col))
+	}
+	return ret
+}
+
+// idCondition returns condition selecting the record by id.
+func (T *SqlTable[M]) idCondition() string {
+	return fmt.Sprintf("%s = %s", T.dialect.quote(idColumn), T.dialect.placeholder(1))
+}
+
+// load reads records with children. If cond is not empty, only records
+// matching it are loaded. Records are ordered by id, unless tail (ORDER
+// BY and LIMIT clauses) is given.
+func (T *SqlTable[M]) load(ctx context.Context, tx *sql.Tx, cond string, tail string, args ...any) ([]M, error) {
+	where := ""
+	if cond != "" {
+		where = " WHERE " + cond
+	}
+
+	// Selects ids of the loaded records for the child tables
+	owners := ""
+	if cond != "" || tail != "" {
+		owners = fmt.Sprintf("SELECT %s FROM %s%s %s", T.dialect.quote(idColumn), T.dialect.quote(T.schema.name), where, tail)
+	}
+
+	if tail == "" {
+		tail = fmt.Sprintf("ORDER BY %s", T.dialect.quote(idColumn))
+	}
+
+	query := T.selectSQL + where + " " + tail
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []M
+	for rows.Next() {
+		raw := make([]any, len(T.schema.columns)+1)
+		dest := make([]any, len(raw))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		var record M
+		rv := reflect.ValueOf(&record).Elem()
+		if err := fromDriverValue(rv, column{index: T.schema.idIndex, kind: columnInteger}, raw[0]); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for i, col := range T.schema.columns {
+			if err := fromDriverValue(rv, col, raw[i+1]); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		records = append(records, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 || len(T.children) == 0 {
+		return records, nil
+	}
+
+	byId := make(map[models.IdData]reflect.Value, len(records))
+	for i := range records {
+		rv := reflect.ValueOf(&records[i]).Elem()
+		byId[models.IdData(rv.FieldByIndex(T.schema.idIndex).Int())] = rv
+	}
+
+	for i, child := range T.schema.children {
+		if err := T.loadChildren(ctx, tx, child, T.children[i], byId, owners, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// loadChildren loads child rows of the records selected by owners query,
+// or of all records if owners is empty.
+func (T *SqlTable[M]) loadChildren(ctx context.Context, tx *sql.Tx, child childTable, statements childStatements, byId map[models.IdData]reflect.Value, owners string, args ...any) error {
+	query := statements.selectSQL
+	if owners != "" {
+		query += fmt.Sprintf(" WHERE %s IN (%s)", T.dialect.quote(ownerColumn), owners)
+	}
+	query += fmt.Sprintf(" ORDER BY %s, %s", T.dialect.quote(ownerColumn), T.dialect.quote(positionColumn))
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		raw := make([]any, len(child.columns)+2)
+		dest := make([]any, len(raw))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		owner, err := asInt(raw[0])
+		if err != nil {
+			return err
+		}
+		parent, ok := byId[models.IdData(owner)]
+		if !ok {
+			continue
+		}
+
+		slice := parent.FieldByIndex(child.index)
+		elemType := slice.Type().Elem()
+		if child.pointer {
+			elemType = elemType.Elem()
+		}
+
+		elem := reflect.New(elemType)
+		for i, col := range child.columns {
+			if err := fromDriverValue(elem.Elem(), col, raw[i+2]); err != nil {
+				return err
+			}
+		}
+
+		if child.pointer {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+
+	return rows.Err()
+}
+
+// storeChildren replaces children rows of the record. Nil elements of the
+// pointer slices are not stored.
+func (T *SqlTable[M]) storeChildren(ctx context.Context, tx *sql.Tx, id models.IdData, record reflect.Value) error {
+	for i, child := range T.schema.children {
+		statements := T.children[i]
+
+		if _, err := tx.ExecContext(ctx, statements.deleteSQL, id); err != nil {
+			return err
+		}
+
+		slice := record.FieldByIndex(child.index)
+		position := 0
+		for j := 0; j < slice.Len(); j++ {
+			elem := slice.Index(j)
+			if child.pointer {
+				if elem.IsNil() {
+					continue
+				}
+				elem = elem.Elem()
+			}
+
+			args := append([]any{id, position}, T.values(elem, child.columns)...)
+			if _, err := tx.ExecContext(ctx, statements.insertSQL, args...); err != nil {
+				return err
+			}
+			position++
+		}
+	}
+	return nil
+}
+
+func (T *SqlTable[M]) InsertContext(ctx context.Context, record M) (models.IdData, error) {
+	var i interface{} = &record
+
+	id, ok := i.(ifaces.Id)
+	if !ok {
+		return models.BAD_ID, ifaces.ErrWrongRecord
+	}
+
+	if err := ifaces.FirstVersion(&record); err != nil {
+		return models.BAD_ID, err
+	}
+
+	rv := reflect.ValueOf(&record).Elem()
+
+	var newId models.IdData
+	insert := func(tx *sql.Tx) error {
+		if err := T.checkOwner(ctx, tx, &record); err != nil {
+			return err
+		}
+		if err := T.checkUnique(ctx, tx, models.BAD_ID, &record); err != nil {
+			return err
+		}
+		if T.ids == nil {
+			if err := tx.QueryRowContext(ctx, T.insertSQL, T.values(rv, T.schema.columns)...).Scan(&newId); err != nil {
+				return T.uniqueViolation(err, &record)
+			}
+		} else {
+			var err error
+			if newId, err = T.newId(ctx, tx); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, T.restoreSQL, append([]any{newId}, T.values(rv, T.schema.columns)...)...); err != nil {
+				return T.uniqueViolation(err, &record)
+			}
+		}
+		id.SetId(newId)
+
+		if err := T.storeChildren(ctx, tx, newId, rv); err != nil {
+			return err
+		}
+		return T.revise(ctx, tx, ifaces.RevisionInsert, nil, &record)
+	}
+	err := T.run(ctx, insert)
+	// Id allocated by the concurrent transaction is retried in the own
+	// transaction only, as the failed statement aborts the outer one
+	for attempt := 1; T.tx == nil && errors.Is(err, errIdTaken) && attempt < idAttempts; attempt++ {
+		err = T.run(ctx, insert)
+	}
+	if err != nil {
+		return models.BAD_ID, err
+	}
+
+	return newId, nil
+}
+
+func (T *SqlTable[M]) UpdateContext(ctx context.Context, record M) error {
+	return T.update(ctx, record, true)
+}
+
+// ReplaceContext stores the record as Update does, without the next
+// version and the revision.
+func (T *SqlTable[M]) ReplaceContext(ctx context.Context, record M) error {
+	return T.update(ctx, record, false)
+}
+
+// update replaces the stored record of the same version; the revised
+// record gets the next version and the revision.
+func (T *SqlTable[M]) update(ctx context.Context, record M, revised bool) error {
+	var i interface{} = &record
+
+	id, ok := i.(ifaces.Id)
+	if !ok {
+		return ifaces.ErrWrongRecord
+	}
+	version, ok := i.(ifaces.Version)
+	if !ok {
+		return ifaces.ErrWrongRecord
+	}
+
+	// Stored record is replaced only if it has the expected version
+	expected := version.GetVersion()
+	if revised {
+		version.SetVersion(expected + 1)
+	}
+
+	rv := reflect.ValueOf(&record).Elem()
+
+	return T.run(ctx, func(tx *sql.Tx) error {
+		old, err := T.loadOne(ctx, tx, id.GetId())
+		if err != nil {
+			return err
+		}
+		if err := T.checkOwner(ctx, tx, &record); err != nil {
+			return err
+		}
+		if err := T.checkUnique(ctx, tx, id.GetId(), &record); err != nil {
+			return err
+		}
+		args := append(T.values(rv, T.schema.columns), id.GetId(), expected)
+		result, err := tx.ExecContext(ctx, T.updateSQL, args...)
+		if err != nil {
+			return T.uniqueViolation(err, &record)
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return T.notUpdated(ctx, tx, id.GetId())
+		}
+
+		if err := T.storeChildren(ctx, tx, id.GetId(), rv); err != nil {
+			return err
+		}
+		if !revised {
+			return nil
+		}
+		return T.revise(ctx, tx, ifaces.RevisionUpdate, &old, &record)
+	})
+}
+
+// notUpdated returns error of the update which did not match any row:
+// ErrNoSuchRecord if there is no record with the id, ErrConflict if the
+// record has another version.
+func (T *SqlTable[M]) notUpdated(ctx context.Context, tx *sql.Tx, id models.IdData) error {
+	var exists int
+	err := tx.QueryRowContext(ctx, T.existsSQL, id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ifaces.ErrNoSuchRecord
+	}
+	if err != nil {
+		return err
+	}
+	return ifaces.ErrConflict
+}
+
+func (T *SqlTable[M]) DeleteContext(ctx context.Context, id models.IdData) error {
+	return T.run(ctx, func(tx *sql.Tx) error {
+		return T.delete(ctx, tx, id)
+	})
+}
+
+// delete deletes the record and the records it owns in the transaction.
+func (T *SqlTable[M]) delete(ctx context.Context, tx *sql.Tx, id models.IdData) error {
+	old, err := T.loadOne(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	deleted := old
+	if err := ifaces.NextVersion(&old, &deleted); err != nil {
+		return err
+	}
+
+	for _, cascade := range T.cascade {
+		if err := cascade(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range T.children {
+		if _, err := tx.ExecContext(ctx, child.deleteSQL, id); err != nil {
+			return err
+		}
+	}
+
+	// Record may be changed by the concurrent transaction after it is loaded
+	version := reflect.ValueOf(&old).Elem().FieldByIndex(T.schema.versionIndex).Int()
+	result, err := tx.ExecContext(ctx, T.deleteSQL, id, version)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return T.notUpdated(ctx, tx, id)
+	}
+
+	return T.revise(ctx, tx, ifaces.RevisionDelete, &old, &deleted)
+}
+
+func (T *SqlTable[M]) loadAll(ctx context.Context, cond string, args ...any) ([]M, error) {
+	return T.loadPage(ctx, cond, "", args...)
+}
+
+func (T *SqlTable[M]) loadPage(ctx context.Context, cond string, tail string, args ...any) (records []M, err error) {
+	err = T.run(ctx, func(tx *sql.Tx) error {
+		records, err = T.load(ctx, tx, cond, tail, args...)
+		return err
+	})
+	return
+}
+
+func (T *SqlTable[M]) GetContext(ctx context.Context, id models.IdData) (M, error) {
+	var res M
+
+	records, err := T.loadAll(ctx, T.idCondition(), id)
+	if err != nil {
+		return res, err
+	}
+	if len(records) == 0 {
+		return res, ifaces.ErrNoSuchRecord
+	}
+
+	return records[0], nil
+}
+
+// Each calls callback for all records ordered by id. Records are loaded
+// before the first call, so the callback may access the database.
+func (T *SqlTable[M]) EachContext(ctx context.Context, callback func(record M) bool) error {
+	records, err := T.loadAll(ctx, "")
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return ifaces.ErrEmptyTable
+	}
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !callback(record) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (T *SqlTable[M]) FindContext(ctx context.Context, callback func(record M) bool) (M, error) {
+	var res M
+
+	records, err := T.loadAll(ctx, "")
+	if err != nil {
+		return res, err
+	}
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if callback(record) {
+			return record, nil
+		}
+	}
+
+	return res, ifaces.ErrNoSuchRecord
+}
@@ -0,0 +1,160 @@
+package sql_database
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	timeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+)
+
+// toDriverValue converts record field to the value passed to the driver.
+func toDriverValue(record reflect.Value, col column) any {
+	field := record.FieldByIndex(col.index)
+
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return nil
+		}
+		field = field.Elem()
+	}
+
+	switch col.kind {
+	case columnTimestamp:
+		return field.Interface().(time.Time).UTC()
+	case columnBlob:
+		if field.IsNil() {
+			return nil
+		}
+		return field.Bytes()
+	case columnText:
+		return field.String()
+	case columnBoolean:
+		return field.Bool()
+	case columnReal:
+		return field.Float()
+	case columnInteger:
+		if field.CanInt() {
+			return field.Int()
+		}
+		return int64(field.Uint())
+	}
+
+	panic(fmt.Errorf("Unexpected column type: %d", col.kind))
+}
+
+// fromDriverValue sets record field from the value scanned from the driver.
+func fromDriverValue(record reflect.Value, col column, raw any) error {
+	field := record.FieldByIndex(col.index)
+
+	if raw == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	if field.Kind() == reflect.Pointer {
+		ptr := reflect.New(field.Type().Elem())
+		field.Set(ptr)
+		field = ptr.Elem()
+	}
+
+	var err error
+	switch col.kind {
+	case columnTimestamp:
+		var t time.Time
+		t, err = asTime(raw)
+		field.Set(reflect.ValueOf(t))
+	case columnBlob:
+		var b []byte
+		b, err = asBytes(raw)
+		field.SetBytes(b)
+	case columnText:
+		var s []byte
+		s, err = asBytes(raw)
+		field.SetString(string(s))
+	case columnBoolean:
+		var i int64
+		if b, ok := raw.(bool); ok {
+			field.SetBool(b)
+		} else if i, err = asInt(raw); err == nil {
+			field.SetBool(i != 0)
+		}
+	case columnReal:
+		switch v := raw.(type) {
+		case float64:
+			field.SetFloat(v)
+		case int64:
+			field.SetFloat(float64(v))
+		default:
+			var f float64
+			f, err = strconv.ParseFloat(fmt.Sprint(v), 64)
+			field.SetFloat(f)
+		}
+	case columnInteger:
+		var i int64
+		if i, err = asInt(raw); err == nil {
+			if field.CanInt() {
+				field.SetInt(i)
+			} else {
+				field.SetUint(uint64(i))
+			}
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Column '%s': %w", col.name, err)
+	}
+	return nil
+}
+
+func asInt(raw any) (int64, error) {
+	switch v := raw.(type) {
+	case int64:
+		return v, nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("Unexpected integer value: %T", raw)
+}
+
+func asBytes(raw any) ([]byte, error) {
+	switch v := raw.(type) {
+	case []byte:
+		return append([]byte{}, v...), nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("Unexpected text value: %T", raw)
+}
+
+func asTime(raw any) (time.Time, error) {
+	var s string
+	switch v := raw.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return time.Time{}, fmt.Errorf("Unexpected time value: %T", raw)
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unexpected time format: '%s'", s)
+}
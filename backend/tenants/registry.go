@@ -0,0 +1,63 @@
+package tenants
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/diakovliev/mesap/backend/ifaces"
+)
+
+// Registry keeps the open databases of the tenants.
+type Registry struct {
+	ids       []string
+	databases map[string]ifaces.Database
+}
+
+// Open opens the databases of the tenants by open, which must return the
+// database of the tenant not shared with the others. The databases opened
+// are closed on error.
+func Open(ctx context.Context, tenants []Tenant, open func(ctx context.Context, id string) (ifaces.Database, error)) (*Registry, error) {
+	if len(tenants) == 0 {
+		return nil, ErrNoTenants
+	}
+
+	r := &Registry{databases: make(map[string]ifaces.Database)}
+	for _, tenant := range tenants {
+		if err := ValidId(tenant.Id); err != nil {
+			r.Close()
+			return nil, err
+		}
+		if _, ok := r.databases[tenant.Id]; ok {
+			r.Close()
+			return nil, fmt.Errorf("%w Duplicate id '%s'", ErrBadTenant, tenant.Id)
+		}
+		db, err := open(ctx, tenant.Id)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("Tenant '%s': %w", tenant.Id, err)
+		}
+		r.ids = append(r.ids, tenant.Id)
+		r.databases[tenant.Id] = db
+	}
+	return r, nil
+}
+
+// Tenants returns the tenant ids in the order of Open.
+func (r *Registry) Tenants() []string {
+	return r.ids
+}
+
+// Database returns the database of the tenant, or ErrUnknownTenant.
+func (r *Registry) Database(id string) (ifaces.Database, error) {
+	db, ok := r.databases[id]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownTenant, id)
+	}
+	return db, nil
+}
+
+func (r *Registry) Close() {
+	for _, db := range r.databases {
+		db.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package tenants
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver resolves the tenant of the request by its host name. The
+// requests of the other hosts select the tenant by the header, if it is
+// set.
+type Resolver struct {
+	header string
+	hosts  map[string]string
+	ids    map[string]bool
+}
+
+// normalizeHost returns the host name without the port, in lower case.
+func normalizeHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// NewResolver returns resolver of the tenants. The header is ignored if
+// empty. Returns ErrBadTenant if the tenants are not valid, or the id or
+// the host is used twice.
+func NewResolver(tenants []Tenant, header string) (*Resolver, error) {
+	if len(tenants) == 0 {
+		return nil, ErrNoTenants
+	}
+
+	r := &Resolver{header: header, hosts: make(map[string]string), ids: make(map[string]bool)}
+	for _, tenant := range tenants {
+		if err := ValidId(tenant.Id); err != nil {
+			return nil, err
+		}
+		if r.ids[tenant.Id] {
+			return nil, fmt.Errorf("%w Duplicate id '%s'", ErrBadTenant, tenant.Id)
+		}
+		r.ids[tenant.Id] = true
+
+		for _, host := range tenant.Hosts {
+			host = normalizeHost(host)
+			if other, ok := r.hosts[host]; ok {
+				return nil, fmt.Errorf("%w Host '%s' of '%s' and '%s'", ErrBadTenant, host, other, tenant.Id)
+			}
+			r.hosts[host] = tenant.Id
+		}
+	}
+	return r, nil
+}
+
+// Resolve returns the tenant of the request, or ErrUnknownTenant. The
+// host name of the tenant wins over the header, so the client of the
+// tenant host can't reach the others.
+func (r *Resolver) Resolve(req *http.Request) (string, error) {
+	if id, ok := r.hosts[normalizeHost(req.Host)]; ok {
+		return id, nil
+	}
+	if r.header != "" {
+		if id := req.Header.Get(r.header); r.ids[id] {
+			return id, nil
+		}
+	}
+	return "", ErrUnknownTenant
+}
@@ -0,0 +1,69 @@
+// Package tenants hosts several tenants on one deployment. Every tenant
+// has its own database, see Registry, so the users, roles and people of
+// the tenant are never seen by the others. The tenant of the request is
+// resolved by its host name or header, see Resolver.
+package tenants
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrBadTenant     = errors.New("Bad tenant!")
+	ErrNoTenants     = errors.New("No tenants!")
+	ErrUnknownTenant = errors.New("Unknown tenant!")
+)
+
+// idPattern limits the tenant ids to the names safe in the file paths, the
+// database names and the host names.
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidId returns ErrBadTenant if id is not valid tenant id.
+func ValidId(id string) error {
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("%w Id '%s': lowercase letters, digits, '-' and '_' are allowed", ErrBadTenant, id)
+	}
+	return nil
+}
+
+// Tenant of the deployment.
+type Tenant struct {
+	Id string
+	// Hosts are the host names serving the tenant
+	Hosts []string
+}
+
+// LoadTenants reads the tenants file: one tenant per line, the id and the
+// host names separated by spaces, e.g.
+//
+//	acme acme.example.com www.acme.example.com
+//	globex globex.example.com
+//
+// Empty lines and lines starting with '#' are skipped.
+func LoadTenants(path string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenants []Tenant
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if err := ValidId(fields[0]); err != nil {
+			return nil, fmt.Errorf("%w Line %d", err, n+1)
+		}
+		tenants = append(tenants, Tenant{Id: fields[0], Hosts: fields[1:]})
+	}
+	if len(tenants) == 0 {
+		return nil, ErrNoTenants
+	}
+	return tenants, nil
+}
@@ -0,0 +1,137 @@
+package tenants
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/diakovliev/mesap/backend/fake_database"
+	"github.com/diakovliev/mesap/backend/ifaces"
+	"github.com/diakovliev/mesap/backend/models"
+)
+
+func TestLoadTenants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants")
+	content := "# tenants\n" +
+		"acme acme.example.com www.acme.example.com\n" +
+		"\n" +
+		"globex\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Write error: %s", err)
+	}
+
+	tenants, err := LoadTenants(path)
+	if err != nil {
+		t.Fatalf("Load error: %s", err)
+	}
+	expected := []Tenant{
+		{Id: "acme", Hosts: []string{"acme.example.com", "www.acme.example.com"}},
+		{Id: "globex", Hosts: []string{}},
+	}
+	if !reflect.DeepEqual(tenants, expected) {
+		t.Fatalf("Unexpected tenants: %+v", tenants)
+	}
+
+	for name, content := range map[string]string{
+		"empty":     "# no tenants\n",
+		"upper":     "Acme acme.example.com\n",
+		"traversal": "../acme\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Write error: %s", err)
+		}
+		if _, err := LoadTenants(path); !errors.Is(err, ErrBadTenant) && !errors.Is(err, ErrNoTenants) {
+			t.Fatalf("Tenants '%s': unexpected error: %v", name, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	resolver, err := NewResolver([]Tenant{
+		{Id: "acme", Hosts: []string{"acme.example.com"}},
+		{Id: "globex", Hosts: []string{"globex.example.com"}},
+	}, "X-Tenant")
+	if err != nil {
+		t.Fatalf("Resolver error: %s", err)
+	}
+
+	for _, test := range []struct {
+		host   string
+		header string
+		tenant string
+	}{
+		{host: "acme.example.com", tenant: "acme"},
+		{host: "ACME.example.com.:8080", tenant: "acme"},
+		// Host of the tenant wins over the header
+		{host: "acme.example.com", header: "globex", tenant: "acme"},
+		{host: "localhost:8080", header: "globex", tenant: "globex"},
+		{host: "localhost:8080", header: "initech"},
+		{host: "localhost:8080"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = test.host
+		if test.header != "" {
+			req.Header.Set("X-Tenant", test.header)
+		}
+		tenant, err := resolver.Resolve(req)
+		if test.tenant == "" && !errors.Is(err, ErrUnknownTenant) || tenant != test.tenant {
+			t.Fatalf("Host '%s', header '%s': %s, %v", test.host, test.header, tenant, err)
+		}
+	}
+
+	if _, err := NewResolver([]Tenant{{Id: "a", Hosts: []string{"a.test"}}, {Id: "b", Hosts: []string{"A.test"}}}, ""); !errors.Is(err, ErrBadTenant) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry, err := Open(context.Background(), []Tenant{{Id: "acme"}, {Id: "globex"}},
+		func(ctx context.Context, id string) (ifaces.Database, error) {
+			db := fake_database.NewDatabase()
+			return db, db.OpenContext(ctx)
+		})
+	if err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	defer registry.Close()
+
+	acme, _ := registry.Database("acme")
+	globex, _ := registry.Database("globex")
+	if _, err := registry.Database("initech"); !errors.Is(err, ErrUnknownTenant) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	users, _ := acme.Users()
+	id, err := users.Insert(models.User{Login: "alice", Salt: "salt", Verifier: "verifier"})
+	if err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+
+	// Other tenant sees neither the record nor its login
+	other, _ := globex.Users()
+	if _, err := other.Get(id); !errors.Is(err, ifaces.ErrNoSuchRecord) {
+		t.Fatalf("Record of the other tenant: %v", err)
+	}
+	if _, err := ifaces.GetBy(other, models.UserLoginIndex, "alice"); !errors.Is(err, ifaces.ErrNoSuchRecord) {
+		t.Fatalf("Login of the other tenant: %v", err)
+	}
+	if _, err := other.Insert(models.User{Login: "alice", Salt: "salt", Verifier: "verifier"}); err != nil {
+		t.Fatalf("Insert error: %s", err)
+	}
+
+	failed := errors.New("Failed!")
+	_, err = Open(context.Background(), []Tenant{{Id: "acme"}, {Id: "globex"}},
+		func(ctx context.Context, id string) (ifaces.Database, error) {
+			if id == "globex" {
+				return nil, failed
+			}
+			return fake_database.NewDatabase(), nil
+		})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
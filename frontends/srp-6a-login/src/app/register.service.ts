@@ -18,32 +18,64 @@ export interface IRegisterRequest {
 }
 
 export interface IRegisteredUserData {
-  UserId: number
+  userId: number
 }
 
 export interface ILoginRequestData {
-  Login: string
-  Secret1: string
+  login: string
+  secret1: string
 }
 
 export interface ILoginResponseData {
-  Server: string
-  Secret2: string
+  server: string
+  secret2: string
 }
 
 export interface ILogin2RequestData {
-  Server: string
-  Secret3: string
+  server: string
+  secret3: string
 }
 
 export interface ILogin2ResponseData {
-	Secret4: string
+  secret4: string
 }
 
 export interface ILoginResult {
   SessionId: string
 }
 
+export interface IFieldError {
+  field: string
+  problem: string
+}
+
+// Error envelope returned by the backend for every failed request
+export interface IErrorResponse {
+  code: string
+  message: string
+  fields?: IFieldError[]
+  requestId?: string
+}
+
+export class ApiError extends Error {
+  constructor(
+    message: string,
+    public readonly code: string,
+    public readonly status: number,
+    public readonly fields: IFieldError[] = [],
+    public readonly requestId?: string,
+  ) {
+    super(message)
+  }
+}
+
+const ERROR_MESSAGES: { [code: string]: string } = {
+  "login_taken": "Login already taken.",
+  "login_failed": "Wrong login or password.",
+  "validation_failed": "Please check the entered data.",
+  "unknown_session": "Login session expired; please try again.",
+}
+
 
 @Injectable({
   providedIn: 'root'
@@ -56,7 +88,7 @@ export class RegisterService {
 
   private _client?: SrpClient
 
-  API_ROOT = "/api/auth"
+  API_ROOT = "/api/v1/auth"
   HTTP_OPTIONS = {
     headers: new HttpHeaders({ "Content-Type": "application/json" }),
   }
@@ -64,18 +96,25 @@ export class RegisterService {
 
   constructor(private _http: HttpClient) { }
 
-  private handleError(error: HttpErrorResponse) {
+  private handleError(error: HttpErrorResponse | Error) {
+    if (!(error instanceof HttpErrorResponse)) {
+      // A client-side error occurred (SRP computation etc.).
+      console.error('An error occurred:', error);
+      return throwError(() => new ApiError('Something bad happened; please try again later.', 'client_error', 0));
+    }
     if (error.status === 0) {
-      // A client-side or network error occurred. Handle it accordingly.
+      // A network error occurred.
       console.error('An error occurred:', error.error);
-    } else {
-      // The backend returned an unsuccessful response code.
-      // The response body may contain clues as to what went wrong.
-      console.error(
-        `Backend returned code ${error.status}, body was: `, error.error);
+      return throwError(() => new ApiError('Server is not reachable; please try again later.', 'network_error', 0));
+    }
+    // The backend returned an unsuccessful response code with the error envelope.
+    const body = error.error as IErrorResponse | null
+    console.error(`Backend returned code ${error.status}, body was: `, body);
+    if (body && body.code) {
+      const message = ERROR_MESSAGES[body.code] ?? 'Server error; please try again later.'
+      return throwError(() => new ApiError(message, body.code, error.status, body.fields ?? [], body.requestId));
     }
-    // Return an observable with a user-facing error message.
-    return throwError(() => new Error('Something bad happened; please try again later.'));
+    return throwError(() => new ApiError('Server error; please try again later.', 'internal_error', error.status));
   }
 
   private newSalt(): Observable<Buffer> {
@@ -111,8 +150,8 @@ export class RegisterService {
         map(salt => [ salt, SRP.computeVerifier(this.SRP_PARAMS, salt, Buffer.from(data.login), Buffer.from(data.password)) ] ),
         map(([s, v]) => ({ login: Buffer.from(data.login).toString(this.ENCODING), salt: s.toString(this.ENCODING), verifier: v.toString(this.ENCODING) } as IRegisterRequest) ),
         tap(r => console.log("[register request] " + JSON.stringify(r))),
-        catchError(this.handleError),
         switchMap(request => this._http.post<IRegisteredUserData>(`${this.API_ROOT}/register`, request, { responseType: 'json' })),
+        catchError(error => this.handleError(error)),
       )
 
   }
@@ -122,19 +161,19 @@ export class RegisterService {
     console.log("[loginUser] called")
 
     return this.newClient(data).pipe(
-      map(A => ({ Login: Buffer.from(data.login).toString(this.ENCODING), Secret1: Buffer.from(A).toString(this.ENCODING) } as ILoginRequestData)),
+      map(A => ({ login: Buffer.from(data.login).toString(this.ENCODING), secret1: Buffer.from(A).toString(this.ENCODING) } as ILoginRequestData)),
       tap(r => console.log("[login request] " + JSON.stringify(r))),
-      catchError(this.handleError),
       switchMap(request => this._http.post<ILoginResponseData>(`${this.API_ROOT}/login`, request, { responseType: 'json' })),
       map(response => {
-        this._client!.setB(Buffer.from(response.Secret2, this.ENCODING))
-        return { Server: response.Server, Secret3: Buffer.from(this._client!.computeM1()).toString(this.ENCODING) } as ILogin2RequestData
+        this._client!.setB(Buffer.from(response.secret2, this.ENCODING))
+        return { server: response.server, secret3: Buffer.from(this._client!.computeM1()).toString(this.ENCODING) } as ILogin2RequestData
       }),
       switchMap(request => this._http.post<ILogin2ResponseData>(`${this.API_ROOT}/login2`, request, { responseType: 'json' })),
       map(response => {
-        this._client!.checkM2(Buffer.from(response.Secret4, this.ENCODING))
+        this._client!.checkM2(Buffer.from(response.secret4, this.ENCODING))
         return { SessionId: Buffer.from(this._client!.computeK()).toString(this.ENCODING) } as ILoginResult
       }),
+      catchError(error => this.handleError(error)),
     )
   }
 }
@@ -1,4 +1,4 @@
-import { RegisterService, IRegisteredUserData, IRegisterData } from './../register.service';
+import { RegisterService, IRegisteredUserData, IRegisterData, ApiError } from './../register.service';
 import { Component, OnInit } from '@angular/core';
 
 @Component({
@@ -14,19 +14,29 @@ export class RegisterComponent implements OnInit {
     password: "1234",
   }
 
+  public error?: ApiError
+
   constructor(private _register: RegisterService) { }
 
   ngOnInit(): void {
   }
 
   registerUser() {
+    this.error = undefined
     this._register.registerUser(this.data)
-      .subscribe(registeredUser => console.log(`Registered user id: ${registeredUser.UserId}`))
+      .subscribe({
+        next: registeredUser => console.log(`Registered user id: ${registeredUser.userId}`),
+        error: (error: ApiError) => this.error = error,
+      })
   }
 
   loginUser() {
+    this.error = undefined
     this._register.loginUser(this.data)
-      .subscribe(loginData => console.log(`Login data: ${JSON.stringify(loginData)}`))
+      .subscribe({
+        next: loginData => console.log(`Login data: ${JSON.stringify(loginData)}`),
+        error: (error: ApiError) => this.error = error,
+      })
   }
 
 }